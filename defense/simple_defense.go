@@ -36,17 +36,17 @@ var ErrInDefense = errors.New("error of in defense")
 
 // SimpleDefense 简单防御实现结构
 type SimpleDefense struct {
-	redis    *redis.Client
+	redis    redis.UniversalClient
 	duration time.Duration
 	times    int64
 	mu       sync.Mutex
 }
 
 // New 创建一个简单实现的防暴力破解实例
-// @param redis go-redis/redis v8 对象示例
+// @param redis go-redis/redis v9 客户端，支持 *redis.Client、*redis.ClusterClient、哨兵 FailoverClient 等 redis.UniversalClient 实现
 // @param defenseDuration 默认防御间隔时长设置，譬如：1分钟内最大尝试次数不得超过5次，此处传值 1 * time.Minute
 // @param defenseTimes    默认防御间隔次数设置，譬如：1分钟内最大尝试次数不得超过5次，此处传值 5
-func New(redis *redis.Client, defenseDuration time.Duration, defenseTimes int64) *SimpleDefense {
+func New(redis redis.UniversalClient, defenseDuration time.Duration, defenseTimes int64) *SimpleDefense {
 	return &SimpleDefense{
 		redis:    redis,
 		duration: defenseDuration,
@@ -7,12 +7,18 @@ import (
 	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Item struct {
 	Object     interface{}
 	Expiration int64
+	// Idle Is the idle timeout of an item written with SetSliding(), in
+	// nanoseconds: Expiration moves to Idle after each access, but never past
+	// Deadline if that is set.
+	Idle     int64
+	Deadline int64
 }
 
 // Returns true if the item has expired.
@@ -42,7 +48,18 @@ type cache struct {
 	items             map[string]Item
 	mu                sync.RWMutex
 	onEvicted         func(string, interface{})
+	onEvictedReason   func(string, interface{}, EvictionReason)
+	bound             *bound[string, interface{}]
+	load              LoadOptions
+	loading           loading
+	persist           *persister
+	stats             *cacheStats
+	index             *index
+	expiry            *expiryQueue[string]
 	janitor           *janitor
+	// size Is the estimated memory used by the items, see estimateSize().
+	// Increment() and Decrement() don't change it, as they keep the type.
+	size int64
 }
 
 // Set Add an item to the cache, replacing any existing item. If the duration is 0
@@ -58,16 +75,19 @@ func (c *cache) Set(k string, x interface{}, d time.Duration) {
 		e = time.Now().Add(d).UnixNano()
 	}
 	c.mu.Lock()
-	c.items[k] = Item{
+	evicted := c.store(k, Item{
 		Object:     x,
 		Expiration: e,
-	}
+	})
 	// TODO: Calls to mu.Unlock are currently not deferred because defer
 	// adds ~200 ns (as of go1.)
 	c.mu.Unlock()
+	if evicted != nil {
+		c.evict(evicted)
+	}
 }
 
-func (c *cache) set(k string, x interface{}, d time.Duration) {
+func (c *cache) set(k string, x interface{}, d time.Duration) []keyAndValue {
 	var e int64
 	if d == DefaultExpiration {
 		d = c.defaultExpiration
@@ -75,10 +95,87 @@ func (c *cache) set(k string, x interface{}, d time.Duration) {
 	if d > 0 {
 		e = time.Now().Add(d).UnixNano()
 	}
-	c.items[k] = Item{
+	return c.store(k, Item{
 		Object:     x,
 		Expiration: e,
+	})
+}
+
+// store Writes item under k, keeping a bounded cache within its bounds. Returns
+// the items that were replaced or evicted, for which the eviction callbacks
+// must be called once the lock is released.
+func (c *cache) store(k string, item Item) []keyAndValue {
+	return c.storeTagged(k, item, nil)
+}
+
+// storeTagged Is store, attaching tags to the item.
+func (c *cache) storeTagged(k string, item Item, tags []string) []keyAndValue {
+	old, found := c.items[k]
+	c.items[k] = item
+	c.size += estimateSize(k, item.Object)
+	if found {
+		c.size -= estimateSize(k, old.Object)
+	}
+	if item.Expiration > 0 || found {
+		c.expiry.set(k, item.Expiration)
+	}
+	if c.index != nil || len(tags) > 0 {
+		if c.index == nil {
+			c.index = newIndex(false)
+		}
+		c.index.add(k, tags)
+	}
+	c.persist.logSet(k, item, tags)
+	c.loading.forget(k)
+	atomic.AddUint64(&c.stats.sets, 1)
+	reason := EvictionReplaced
+	if found {
+		if old.Expired() {
+			reason = EvictionExpired
+		}
+		c.stats.replaced(reason == EvictionExpired)
+	}
+	if c.bound == nil && c.onEvictedReason == nil {
+		return nil
 	}
+
+	var evicted []keyAndValue
+	if found && c.onEvictedReason != nil {
+		evicted = append(evicted, keyAndValue{k, old.Object, reason})
+	}
+	if c.bound == nil {
+		return evicted
+	}
+
+	c.bound.stored(k, item.Object, found)
+	listening := c.onEvicted != nil || c.onEvictedReason != nil
+	for c.bound.over(len(c.items)) {
+		victim, ok := c.bound.victim()
+		if !ok {
+			break
+		}
+		v, exists := c.items[victim]
+		delete(c.items, victim)
+		c.bound.removed(victim)
+		if !exists {
+			continue
+		}
+		c.size -= estimateSize(victim, v.Object)
+		c.expiry.remove(victim)
+		if c.index != nil {
+			c.index.remove(victim)
+		}
+		c.persist.logDelete(victim)
+		reason := EvictionCapacity
+		if v.Expired() {
+			reason = EvictionExpired
+		}
+		c.stats.evicted(reason)
+		if listening {
+			evicted = append(evicted, keyAndValue{victim, v.Object, reason})
+		}
+	}
+	return evicted
 }
 
 // SetDefault Add an item to the cache, replacing any existing item, using the default
@@ -96,8 +193,9 @@ func (c *cache) Add(k string, x interface{}, d time.Duration) error {
 		c.mu.Unlock()
 		return fmt.Errorf("Item %s already exists", k)
 	}
-	c.set(k, x, d)
+	evicted := c.set(k, x, d)
 	c.mu.Unlock()
+	c.evict(evicted)
 	return nil
 }
 
@@ -110,8 +208,9 @@ func (c *cache) Replace(k string, x interface{}, d time.Duration) error {
 		c.mu.Unlock()
 		return fmt.Errorf("Item %s doesn't exist", k)
 	}
-	c.set(k, x, d)
+	evicted := c.set(k, x, d)
 	c.mu.Unlock()
+	c.evict(evicted)
 	return nil
 }
 
@@ -123,15 +222,21 @@ func (c *cache) Get(k string) (interface{}, bool) {
 	item, found := c.items[k]
 	if !found {
 		c.mu.RUnlock()
+		atomic.AddUint64(&c.stats.misses, 1)
 		return nil, false
 	}
 	if item.Expiration > 0 {
 		if time.Now().UnixNano() > item.Expiration {
 			c.mu.RUnlock()
+			atomic.AddUint64(&c.stats.misses, 1)
 			return nil, false
 		}
 	}
 	c.mu.RUnlock()
+	atomic.AddUint64(&c.stats.hits, 1)
+	if c.bound != nil {
+		c.bound.accessed(k)
+	}
 	return item.Object, true
 }
 
@@ -145,23 +250,33 @@ func (c *cache) GetWithExpiration(k string) (interface{}, time.Time, bool) {
 	item, found := c.items[k]
 	if !found {
 		c.mu.RUnlock()
+		atomic.AddUint64(&c.stats.misses, 1)
 		return nil, time.Time{}, false
 	}
 
 	if item.Expiration > 0 {
 		if time.Now().UnixNano() > item.Expiration {
 			c.mu.RUnlock()
+			atomic.AddUint64(&c.stats.misses, 1)
 			return nil, time.Time{}, false
 		}
 
 		// Return the item and the expiration time
 		c.mu.RUnlock()
+		atomic.AddUint64(&c.stats.hits, 1)
+		if c.bound != nil {
+			c.bound.accessed(k)
+		}
 		return item.Object, time.Unix(0, item.Expiration), true
 	}
 
 	// If expiration <= 0 (i.e. no expiration time set) then return the item
 	// and a zeroed time.Time
 	c.mu.RUnlock()
+	atomic.AddUint64(&c.stats.hits, 1)
+	if c.bound != nil {
+		c.bound.accessed(k)
+	}
 	return item.Object, time.Time{}, true
 }
 
@@ -222,7 +337,7 @@ func (c *cache) Increment(k string, n int64) error {
 		c.mu.Unlock()
 		return fmt.Errorf("The value for %s is not an integer", k)
 	}
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nil
 }
@@ -248,7 +363,7 @@ func (c *cache) IncrementFloat(k string, n float64) error {
 		c.mu.Unlock()
 		return fmt.Errorf("The value for %s does not have type float32 or float64", k)
 	}
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nil
 }
@@ -270,7 +385,7 @@ func (c *cache) IncrementInt(k string, n int) (int, error) {
 	}
 	nv := rv + n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -292,7 +407,7 @@ func (c *cache) IncrementInt8(k string, n int8) (int8, error) {
 	}
 	nv := rv + n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -314,7 +429,7 @@ func (c *cache) IncrementInt16(k string, n int16) (int16, error) {
 	}
 	nv := rv + n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -336,7 +451,7 @@ func (c *cache) IncrementInt32(k string, n int32) (int32, error) {
 	}
 	nv := rv + n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -358,7 +473,7 @@ func (c *cache) IncrementInt64(k string, n int64) (int64, error) {
 	}
 	nv := rv + n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -380,7 +495,7 @@ func (c *cache) IncrementUint(k string, n uint) (uint, error) {
 	}
 	nv := rv + n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -402,7 +517,7 @@ func (c *cache) IncrementUintptr(k string, n uintptr) (uintptr, error) {
 	}
 	nv := rv + n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -424,7 +539,7 @@ func (c *cache) IncrementUint8(k string, n uint8) (uint8, error) {
 	}
 	nv := rv + n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -446,7 +561,7 @@ func (c *cache) IncrementUint16(k string, n uint16) (uint16, error) {
 	}
 	nv := rv + n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -468,7 +583,7 @@ func (c *cache) IncrementUint32(k string, n uint32) (uint32, error) {
 	}
 	nv := rv + n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -490,7 +605,7 @@ func (c *cache) IncrementUint64(k string, n uint64) (uint64, error) {
 	}
 	nv := rv + n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -512,7 +627,7 @@ func (c *cache) IncrementFloat32(k string, n float32) (float32, error) {
 	}
 	nv := rv + n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -534,7 +649,7 @@ func (c *cache) IncrementFloat64(k string, n float64) (float64, error) {
 	}
 	nv := rv + n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -584,7 +699,7 @@ func (c *cache) Decrement(k string, n int64) error {
 		c.mu.Unlock()
 		return fmt.Errorf("The value for %s is not an integer", k)
 	}
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nil
 }
@@ -610,7 +725,7 @@ func (c *cache) DecrementFloat(k string, n float64) error {
 		c.mu.Unlock()
 		return fmt.Errorf("The value for %s does not have type float32 or float64", k)
 	}
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nil
 }
@@ -632,7 +747,7 @@ func (c *cache) DecrementInt(k string, n int) (int, error) {
 	}
 	nv := rv - n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -654,7 +769,7 @@ func (c *cache) DecrementInt8(k string, n int8) (int8, error) {
 	}
 	nv := rv - n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -676,7 +791,7 @@ func (c *cache) DecrementInt16(k string, n int16) (int16, error) {
 	}
 	nv := rv - n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -698,7 +813,7 @@ func (c *cache) DecrementInt32(k string, n int32) (int32, error) {
 	}
 	nv := rv - n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -720,7 +835,7 @@ func (c *cache) DecrementInt64(k string, n int64) (int64, error) {
 	}
 	nv := rv - n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -742,7 +857,7 @@ func (c *cache) DecrementUint(k string, n uint) (uint, error) {
 	}
 	nv := rv - n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -764,7 +879,7 @@ func (c *cache) DecrementUintptr(k string, n uintptr) (uintptr, error) {
 	}
 	nv := rv - n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -786,7 +901,7 @@ func (c *cache) DecrementUint8(k string, n uint8) (uint8, error) {
 	}
 	nv := rv - n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -808,7 +923,7 @@ func (c *cache) DecrementUint16(k string, n uint16) (uint16, error) {
 	}
 	nv := rv - n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -830,7 +945,7 @@ func (c *cache) DecrementUint32(k string, n uint32) (uint32, error) {
 	}
 	nv := rv - n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -852,7 +967,7 @@ func (c *cache) DecrementUint64(k string, n uint64) (uint64, error) {
 	}
 	nv := rv - n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -874,7 +989,7 @@ func (c *cache) DecrementFloat32(k string, n float32) (float32, error) {
 	}
 	nv := rv - n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -896,7 +1011,7 @@ func (c *cache) DecrementFloat64(k string, n float64) (float64, error) {
 	}
 	nv := rv - n
 	v.Object = nv
-	c.items[k] = v
+	c.update(k, v)
 	c.mu.Unlock()
 	return nv, nil
 }
@@ -904,46 +1019,64 @@ func (c *cache) DecrementFloat64(k string, n float64) (float64, error) {
 // Delete an item from the cache. Does nothing if the key is not in the cache.
 func (c *cache) Delete(k string) {
 	c.mu.Lock()
-	v, evicted := c.delete(k)
+	v, evicted := c.delete(k, EvictionDeleted)
 	c.mu.Unlock()
 	if evicted {
-		c.onEvicted(k, v)
+		c.evict([]keyAndValue{{k, v, EvictionDeleted}})
 	}
 }
 
-func (c *cache) delete(k string) (interface{}, bool) {
-	if c.onEvicted != nil {
-		if v, found := c.items[k]; found {
-			delete(c.items, k)
-			return v.Object, true
+// update Writes back an item modified in place, e.g. incremented.
+func (c *cache) update(k string, v Item) {
+	c.items[k] = v
+	if c.persist != nil {
+		var tags []string
+		if c.index != nil {
+			tags = c.index.keyTags[k]
 		}
+		c.persist.logSet(k, v, tags)
+	}
+}
+
+// delete Removes k for the given reason. Returns its value and whether the
+// eviction callbacks must be called for it.
+func (c *cache) delete(k string, reason EvictionReason) (interface{}, bool) {
+	c.loading.forget(k)
+	v, found := c.items[k]
+	if !found {
+		return nil, false
 	}
 	delete(c.items, k)
-	return nil, false
+	c.size -= estimateSize(k, v.Object)
+	if c.bound != nil {
+		c.bound.removed(k)
+	}
+	c.expiry.remove(k)
+	if c.index != nil {
+		c.index.remove(k)
+	}
+	c.persist.logDelete(k)
+	c.stats.evicted(reason)
+	return v.Object, c.onEvicted != nil || c.onEvictedReason != nil
 }
 
 type keyAndValue struct {
-	key   string
-	value interface{}
+	key    string
+	value  interface{}
+	reason EvictionReason
 }
 
-// DeleteExpired Delete all expired items from the cache.
-func (c *cache) DeleteExpired() {
-	var evictedItems []keyAndValue
-	now := time.Now().UnixNano()
-	c.mu.Lock()
-	for k, v := range c.items {
-		// "Inlining" of expired
-		if v.Expiration > 0 && now > v.Expiration {
-			ov, evicted := c.delete(k)
-			if evicted {
-				evictedItems = append(evictedItems, keyAndValue{k, ov})
-			}
+// evict Calls the eviction callbacks for the given items. OnEvicted isn't
+// called for items that were overwritten. Must be called without holding the
+// lock.
+func (c *cache) evict(items []keyAndValue) {
+	for _, v := range items {
+		if c.onEvicted != nil && v.reason != EvictionReplaced {
+			c.onEvicted(v.key, v.value)
+		}
+		if c.onEvictedReason != nil {
+			c.onEvictedReason(v.key, v.value, v.reason)
 		}
-	}
-	c.mu.Unlock()
-	for _, v := range evictedItems {
-		c.onEvicted(v.key, v.value)
 	}
 }
 
@@ -956,6 +1089,16 @@ func (c *cache) OnEvicted(f func(string, interface{})) {
 	c.mu.Unlock()
 }
 
+// OnEvictedWithReason Sets an (optional) function that is called with the key, value
+// and EvictionReason whenever an item leaves the cache, including when it is
+// overwritten (EvictionReplaced) or evicted to keep a bounded cache within its
+// bounds (EvictionCapacity). Set to nil to disable.
+func (c *cache) OnEvictedWithReason(f func(string, interface{}, EvictionReason)) {
+	c.mu.Lock()
+	c.onEvictedReason = f
+	c.mu.Unlock()
+}
+
 // Save Write the cache's items (using Gob) to an io.Writer.
 //
 // NOTE: This method is deprecated in favor of c.Items() and NewFrom() (see the
@@ -994,8 +1137,9 @@ func (c *cache) SaveFile(fname string) error {
 	return fp.Close()
 }
 
-// Load Add (Gob-serialized) cache items from an io.Reader, excluding any items with
-// keys that already exist (and haven't expired) in the current cache.
+// Load Add (Gob-serialized) cache items from an io.Reader, excluding expired items
+// and any items with keys that already exist (and haven't expired) in the
+// current cache.
 //
 // NOTE: This method is deprecated in favor of c.Items() and NewFrom() (see the
 // documentation for NewFrom().)
@@ -1004,18 +1148,29 @@ func (c *cache) Load(r io.Reader) error {
 	items := map[string]Item{}
 	err := dec.Decode(&items)
 	if err == nil {
-		c.mu.Lock()
-		defer c.mu.Unlock()
-		for k, v := range items {
-			ov, found := c.items[k]
-			if !found || ov.Expired() {
-				c.items[k] = v
-			}
-		}
+		c.loadItems(items)
 	}
 	return err
 }
 
+// loadItems Adds items, excluding items that have expired and any items with
+// keys that already exist (and haven't expired) in the current cache.
+func (c *cache) loadItems(items map[string]Item) {
+	var evicted []keyAndValue
+	c.mu.Lock()
+	for k, v := range items {
+		if v.Expired() {
+			continue
+		}
+		ov, found := c.items[k]
+		if !found || ov.Expired() {
+			evicted = append(evicted, c.store(k, v)...)
+		}
+	}
+	c.mu.Unlock()
+	c.evict(evicted)
+}
+
 // LoadFile Load and add cache items from the given filename, excluding any items with
 // keys that already exist in the current cache.
 //
@@ -1065,15 +1220,39 @@ func (c *cache) ItemCount() int {
 func (c *cache) Flush() {
 	c.mu.Lock()
 	c.items = map[string]Item{}
+	c.size = 0
+	c.expiry.reset()
+	if c.bound != nil {
+		c.bound.reset()
+	}
+	if c.index != nil {
+		c.index.reset()
+	}
+	c.persist.logFlush()
+	c.loading.reset()
 	c.mu.Unlock()
 }
 
 type janitor struct {
 	Interval time.Duration
+	delay    time.Duration
 	stop     chan bool
 }
 
-func (j *janitor) Run(c *cache) {
+// expirer is anything the janitor can periodically clean up: the plain cache
+// as well as the generic TypedCache share the same janitor.
+type expirer interface {
+	DeleteExpired()
+}
+
+func (j *janitor) Run(c expirer) {
+	if j.delay > 0 {
+		select {
+		case <-time.After(j.delay):
+		case <-j.stop:
+			return
+		}
+	}
 	ticker := time.NewTicker(j.Interval)
 	for {
 		select {
@@ -1091,12 +1270,19 @@ func stopJanitor(c *Cache) {
 }
 
 func runJanitor(c *cache, ci time.Duration) {
+	c.janitor = startJanitor(c, ci, 0)
+}
+
+// startJanitor Runs a janitor cleaning up c every ci, starting after the given
+// delay.
+func startJanitor(c expirer, ci, delay time.Duration) *janitor {
 	j := &janitor{
 		Interval: ci,
+		delay:    delay,
 		stop:     make(chan bool),
 	}
-	c.janitor = j
 	go j.Run(c)
+	return j
 }
 
 func newCache(de time.Duration, m map[string]Item) *cache {
@@ -1106,12 +1292,20 @@ func newCache(de time.Duration, m map[string]Item) *cache {
 	c := &cache{
 		defaultExpiration: de,
 		items:             m,
+		stats:             &cacheStats{},
+		expiry:            newItemExpiryQueue(m),
+	}
+	for k, v := range m {
+		c.size += estimateSize(k, v.Object)
 	}
 	return c
 }
 
 func newCacheWithJanitor(de time.Duration, ci time.Duration, m map[string]Item) *Cache {
-	c := newCache(de, m)
+	return withJanitor(newCache(de, m), ci)
+}
+
+func withJanitor(c *cache, ci time.Duration) *Cache {
 	// This trick ensures that the janitor goroutine (which--granted it
 	// was enabled--is running DeleteExpired on c forever) does not keep
 	// the returned C object from being garbage collected. When it is
@@ -1159,3 +1353,49 @@ func New(defaultExpiration, cleanupInterval time.Duration) *Cache {
 func NewFrom(defaultExpiration, cleanupInterval time.Duration, items map[string]Item) *Cache {
 	return newCacheWithJanitor(defaultExpiration, cleanupInterval, items)
 }
+
+// Options Configures a cache created with NewWithOptions.
+type Options struct {
+	// DefaultExpiration and CleanupInterval have the same meaning as the
+	// arguments of New().
+	DefaultExpiration time.Duration
+	CleanupInterval   time.Duration
+
+	// MaxEntries Is the maximum number of items the cache holds. Zero means
+	// unlimited.
+	MaxEntries int
+	// MaxCost Is the maximum summed cost of the items in the cache. Zero
+	// means unlimited.
+	MaxCost int64
+	// Cost Returns the cost of an item, typically its size in bytes. When
+	// nil and MaxCost is set, a rough estimate of the bytes used is taken.
+	Cost func(k string, x interface{}) int64
+	// Policy Chooses the items evicted when the cache exceeds MaxEntries or
+	// MaxCost.
+	Policy EvictionPolicy
+
+	// OnEvicted Is called whenever an item leaves the cache, see
+	// OnEvictedWithReason().
+	OnEvicted func(k string, x interface{}, reason EvictionReason)
+
+	// Load Configures GetOrLoad().
+	Load LoadOptions
+
+	// PrefixIndex Keeps the keys in a radix tree, so that DeletePrefix() only
+	// visits the matching keys instead of scanning all of them.
+	PrefixIndex bool
+}
+
+// NewWithOptions Return a new cache configured by o. Unlike New(), the cache can
+// be bounded by the number of items and/or their total cost, evicting items
+// according to o.Policy when a write exceeds a bound.
+func NewWithOptions(o Options) *Cache {
+	c := newCache(o.DefaultExpiration, make(map[string]Item))
+	c.bound = newBound(o)
+	c.onEvictedReason = o.OnEvicted
+	c.load = o.Load
+	if o.PrefixIndex {
+		c.index = newIndex(true)
+	}
+	return withJanitor(c, o.CleanupInterval)
+}
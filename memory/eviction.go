@@ -0,0 +1,520 @@
+package memory
+
+import (
+	"container/list"
+	"sync"
+	"unsafe"
+)
+
+// A bounded cache holds at most MaxEntries items and/or items whose summed
+// cost is at most MaxCost. Every write that pushes the cache over one of the
+// bounds evicts items chosen by the configured EvictionPolicy until the cache
+// fits again:
+//
+//   - EvictLRU evicts the least recently used item.
+//   - EvictLFU evicts the least frequently used item (ties broken by recency).
+//   - EvictTinyLFU is W-TinyLFU: new items enter a small LRU window; an item
+//     leaving the window is only admitted into the main segmented LRU if it
+//     has been requested more often than the item it would replace, according
+//     to an aging count-min sketch. This keeps one-hit wonders from flushing
+//     popular items and usually gives the best hit rate.
+//
+// Bookkeeping lives in a bound that is nil for unbounded caches, so caches
+// created with New() and NewFrom() pay nothing for it. Bounds and policies are
+// generic over the key type, so TypedCache shares them with Cache.
+
+// EvictionReason Tells an eviction callback why an item left the cache.
+type EvictionReason int
+
+const (
+	// EvictionExpired The item's expiration time passed.
+	EvictionExpired EvictionReason = iota + 1
+	// EvictionCapacity The item was evicted to keep the cache within MaxEntries/MaxCost.
+	EvictionCapacity
+	// EvictionDeleted The item was deleted with Delete().
+	EvictionDeleted
+	// EvictionReplaced The item was overwritten by Set() or Replace().
+	EvictionReplaced
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictionExpired:
+		return "expired"
+	case EvictionCapacity:
+		return "capacity"
+	case EvictionDeleted:
+		return "deleted"
+	case EvictionReplaced:
+		return "replaced"
+	}
+	return "unknown"
+}
+
+// EvictionPolicy Selects which item a bounded cache evicts when it is full.
+type EvictionPolicy int
+
+const (
+	// EvictLRU Evict the least recently used item. This is the default.
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU Evict the least frequently used item.
+	EvictLFU
+	// EvictTinyLFU Evict using W-TinyLFU admission in front of a segmented LRU.
+	EvictTinyLFU
+)
+
+// policy Tracks the keys of a bounded cache. Implementations ignore calls for
+// keys they don't know about.
+type policy[K comparable] interface {
+	add(k K)
+	access(k K)
+	remove(k K)
+	// victim returns the key that should be evicted next.
+	victim() (K, bool)
+	reset()
+}
+
+// newPolicy Returns the policy p; hash feeds the count-min sketch of
+// EvictTinyLFU.
+func newPolicy[K comparable](p EvictionPolicy, capacity int, hash Hasher[K]) policy[K] {
+	switch p {
+	case EvictLFU:
+		return newLFUPolicy[K]()
+	case EvictTinyLFU:
+		return newTinyLFUPolicy(capacity, hash)
+	}
+	return newLRUPolicy[K]()
+}
+
+// bound Holds the size limits and eviction state of a bounded cache. Item
+// bookkeeping is protected by the cache's lock, the policy by mu, since reads
+// record accesses while only holding the cache's read lock.
+type bound[K comparable, V any] struct {
+	maxEntries int
+	maxCost    int64
+	costFn     func(K, V) int64
+	cost       int64
+	costs      map[K]int64
+	mu         sync.Mutex
+	policy     policy[K]
+}
+
+func newBound(o Options) *bound[string, interface{}] {
+	return makeBound(o.MaxEntries, o.MaxCost, o.Cost, estimateSize, o.Policy, djb33)
+}
+
+// makeBound Returns the bound of a cache holding at most maxEntries items and
+// items of at most maxCost, nil if both are unlimited. costFn defaults to
+// estimate.
+func makeBound[K comparable, V any](maxEntries int, maxCost int64, costFn, estimate func(K, V) int64, p EvictionPolicy, hash Hasher[K]) *bound[K, V] {
+	if maxEntries <= 0 && maxCost <= 0 {
+		return nil
+	}
+	b := &bound[K, V]{
+		maxEntries: maxEntries,
+		maxCost:    maxCost,
+		costFn:     costFn,
+		policy:     newPolicy(p, maxEntries, hash),
+	}
+	if b.maxCost > 0 {
+		b.costs = map[K]int64{}
+		if b.costFn == nil {
+			b.costFn = estimate
+		}
+	}
+	return b
+}
+
+// stored Records that k was written with value x; found tells whether k was
+// already present.
+func (b *bound[K, V]) stored(k K, x V, found bool) {
+	b.mu.Lock()
+	if found {
+		b.policy.access(k)
+	} else {
+		b.policy.add(k)
+	}
+	b.mu.Unlock()
+	if b.maxCost > 0 {
+		cost := b.costFn(k, x)
+		b.cost += cost - b.costs[k]
+		b.costs[k] = cost
+	}
+}
+
+// accessed Records a read of k.
+func (b *bound[K, V]) accessed(k K) {
+	b.mu.Lock()
+	b.policy.access(k)
+	b.mu.Unlock()
+}
+
+// removed Forgets k.
+func (b *bound[K, V]) removed(k K) {
+	b.mu.Lock()
+	b.policy.remove(k)
+	b.mu.Unlock()
+	if b.maxCost > 0 {
+		b.cost -= b.costs[k]
+		delete(b.costs, k)
+	}
+}
+
+// over Reports whether the cache holding n items exceeds its bounds.
+func (b *bound[K, V]) over(n int) bool {
+	return (b.maxEntries > 0 && n > b.maxEntries) || (b.maxCost > 0 && b.cost > b.maxCost)
+}
+
+func (b *bound[K, V]) victim() (K, bool) {
+	b.mu.Lock()
+	k, ok := b.policy.victim()
+	b.mu.Unlock()
+	return k, ok
+}
+
+func (b *bound[K, V]) reset() {
+	b.mu.Lock()
+	b.policy.reset()
+	b.mu.Unlock()
+	b.cost = 0
+	if b.costs != nil {
+		b.costs = map[K]int64{}
+	}
+}
+
+// estimateSize Is the default cost function for MaxCost: a rough estimate of
+// the bytes used by a key and its value. Values of types it doesn't know are
+// counted by the size of their header only.
+func estimateSize(k string, x interface{}) int64 {
+	const overhead = int64(unsafe.Sizeof(Item{})) + int64(unsafe.Sizeof(""))
+	size := overhead + int64(len(k))
+	switch v := x.(type) {
+	case string:
+		size += int64(len(v))
+	case []byte:
+		size += int64(cap(v))
+	case []string:
+		for _, s := range v {
+			size += int64(unsafe.Sizeof(s)) + int64(len(s))
+		}
+	case bool, int8, uint8:
+		size++
+	case int16, uint16:
+		size += 2
+	case int32, uint32, float32:
+		size += 4
+	case int, int64, uint, uint64, uintptr, float64, complex64:
+		size += 8
+	case complex128:
+		size += 16
+	}
+	return size
+}
+
+// region LRU
+
+type lruPolicy[K comparable] struct {
+	ll      *list.List
+	entries map[K]*list.Element
+}
+
+func newLRUPolicy[K comparable]() *lruPolicy[K] {
+	return &lruPolicy[K]{ll: list.New(), entries: map[K]*list.Element{}}
+}
+
+func (p *lruPolicy[K]) add(k K) {
+	if e, ok := p.entries[k]; ok {
+		p.ll.MoveToFront(e)
+		return
+	}
+	p.entries[k] = p.ll.PushFront(k)
+}
+
+func (p *lruPolicy[K]) access(k K) {
+	if e, ok := p.entries[k]; ok {
+		p.ll.MoveToFront(e)
+	}
+}
+
+func (p *lruPolicy[K]) remove(k K) {
+	if e, ok := p.entries[k]; ok {
+		p.ll.Remove(e)
+		delete(p.entries, k)
+	}
+}
+
+func (p *lruPolicy[K]) victim() (K, bool) {
+	if e := p.ll.Back(); e != nil {
+		return e.Value.(K), true
+	}
+	var zero K
+	return zero, false
+}
+
+func (p *lruPolicy[K]) reset() {
+	p.ll.Init()
+	p.entries = map[K]*list.Element{}
+}
+
+// endregion
+
+// region LFU
+
+// lfuPolicy Is the O(1) LFU: a list of frequency buckets in ascending order,
+// each holding its keys from most to least recently used.
+type lfuPolicy[K comparable] struct {
+	buckets *list.List // *lfuBucket
+	entries map[K]*list.Element
+}
+
+type lfuBucket struct {
+	freq    int
+	entries *list.List // *lfuEntry
+}
+
+type lfuEntry[K comparable] struct {
+	key    K
+	bucket *list.Element
+}
+
+func newLFUPolicy[K comparable]() *lfuPolicy[K] {
+	return &lfuPolicy[K]{buckets: list.New(), entries: map[K]*list.Element{}}
+}
+
+func (p *lfuPolicy[K]) add(k K) {
+	if _, ok := p.entries[k]; ok {
+		p.access(k)
+		return
+	}
+	front := p.buckets.Front()
+	if front == nil || front.Value.(*lfuBucket).freq != 1 {
+		front = p.buckets.PushFront(&lfuBucket{freq: 1, entries: list.New()})
+	}
+	p.entries[k] = front.Value.(*lfuBucket).entries.PushFront(&lfuEntry[K]{key: k, bucket: front})
+}
+
+func (p *lfuPolicy[K]) access(k K) {
+	e, ok := p.entries[k]
+	if !ok {
+		return
+	}
+	entry := e.Value.(*lfuEntry[K])
+	current := entry.bucket
+	bucket := current.Value.(*lfuBucket)
+	next := current.Next()
+	if next == nil || next.Value.(*lfuBucket).freq != bucket.freq+1 {
+		next = p.buckets.InsertAfter(&lfuBucket{freq: bucket.freq + 1, entries: list.New()}, current)
+	}
+	bucket.entries.Remove(e)
+	if bucket.entries.Len() == 0 {
+		p.buckets.Remove(current)
+	}
+	entry.bucket = next
+	p.entries[k] = next.Value.(*lfuBucket).entries.PushFront(entry)
+}
+
+func (p *lfuPolicy[K]) remove(k K) {
+	e, ok := p.entries[k]
+	if !ok {
+		return
+	}
+	entry := e.Value.(*lfuEntry[K])
+	bucket := entry.bucket.Value.(*lfuBucket)
+	bucket.entries.Remove(e)
+	if bucket.entries.Len() == 0 {
+		p.buckets.Remove(entry.bucket)
+	}
+	delete(p.entries, k)
+}
+
+func (p *lfuPolicy[K]) victim() (K, bool) {
+	if front := p.buckets.Front(); front != nil {
+		return front.Value.(*lfuBucket).entries.Back().Value.(*lfuEntry[K]).key, true
+	}
+	var zero K
+	return zero, false
+}
+
+func (p *lfuPolicy[K]) reset() {
+	p.buckets.Init()
+	p.entries = map[K]*list.Element{}
+}
+
+// endregion
+
+// region W-TinyLFU
+
+const (
+	tinyWindow = iota
+	tinyProbation
+	tinyProtected
+)
+
+type tinyLFUPolicy[K comparable] struct {
+	sketch    *countMinSketch[K]
+	segments  [3]*list.List
+	entries   map[K]*list.Element
+	locations map[K]int
+}
+
+func newTinyLFUPolicy[K comparable](capacity int, hash Hasher[K]) *tinyLFUPolicy[K] {
+	return &tinyLFUPolicy[K]{
+		sketch:    newCountMinSketch(capacity, hash),
+		segments:  [3]*list.List{list.New(), list.New(), list.New()},
+		entries:   map[K]*list.Element{},
+		locations: map[K]int{},
+	}
+}
+
+func (p *tinyLFUPolicy[K]) add(k K) {
+	if _, ok := p.entries[k]; ok {
+		p.access(k)
+		return
+	}
+	p.sketch.increment(k)
+	p.entries[k] = p.segments[tinyWindow].PushFront(k)
+	p.locations[k] = tinyWindow
+
+	// The window holds about 1% of the keys, its oldest keys become the
+	// admission candidates at the front of the probation segment.
+	target := len(p.entries) / 100
+	if target < 1 {
+		target = 1
+	}
+	for window := p.segments[tinyWindow]; window.Len() > target; {
+		oldest := window.Back()
+		p.move(oldest.Value.(K), oldest, tinyProbation)
+	}
+}
+
+func (p *tinyLFUPolicy[K]) access(k K) {
+	e, ok := p.entries[k]
+	if !ok {
+		return
+	}
+	p.sketch.increment(k)
+	switch p.locations[k] {
+	case tinyProbation:
+		// A second hit promotes the key into the protected segment, which
+		// takes up to 80% of the main segment.
+		p.move(k, e, tinyProtected)
+		protected := p.segments[tinyProtected]
+		if main := protected.Len() + p.segments[tinyProbation].Len(); protected.Len() > main*8/10 {
+			demoted := protected.Back()
+			p.move(demoted.Value.(K), demoted, tinyProbation)
+		}
+	default:
+		p.segments[p.locations[k]].MoveToFront(e)
+	}
+}
+
+func (p *tinyLFUPolicy[K]) move(k K, e *list.Element, segment int) {
+	p.segments[p.locations[k]].Remove(e)
+	p.entries[k] = p.segments[segment].PushFront(k)
+	p.locations[k] = segment
+}
+
+func (p *tinyLFUPolicy[K]) remove(k K) {
+	if e, ok := p.entries[k]; ok {
+		p.segments[p.locations[k]].Remove(e)
+		delete(p.entries, k)
+		delete(p.locations, k)
+	}
+}
+
+// victim Lets the latest admission candidate, the newest key of the probation
+// segment, compete with its oldest key: the one requested less often according
+// to the sketch is evicted.
+func (p *tinyLFUPolicy[K]) victim() (K, bool) {
+	probation := p.segments[tinyProbation]
+	if probation.Len() > 1 {
+		candidate, victim := probation.Front().Value.(K), probation.Back().Value.(K)
+		if p.sketch.estimate(candidate) > p.sketch.estimate(victim) {
+			return victim, true
+		}
+		return candidate, true
+	}
+	for _, segment := range []int{tinyProbation, tinyProtected, tinyWindow} {
+		if e := p.segments[segment].Back(); e != nil {
+			return e.Value.(K), true
+		}
+	}
+	var zero K
+	return zero, false
+}
+
+func (p *tinyLFUPolicy[K]) reset() {
+	for _, l := range p.segments {
+		l.Init()
+	}
+	p.entries = map[K]*list.Element{}
+	p.locations = map[K]int{}
+	p.sketch.clear()
+}
+
+// countMinSketch Estimates how often a key was requested with four rows of
+// saturating 4-bit counters. All counters are halved after 10 * width
+// increments, so that the estimate favours recent popularity.
+type countMinSketch[K comparable] struct {
+	rows      [4][]uint8
+	seeds     [4]uint32
+	hash      Hasher[K]
+	mask      uint32
+	additions int
+	sample    int
+}
+
+func newCountMinSketch[K comparable](capacity int, hash Hasher[K]) *countMinSketch[K] {
+	if capacity < 1024 {
+		capacity = 1024
+	}
+	width := 1
+	for width < capacity {
+		width <<= 1
+	}
+	s := &countMinSketch[K]{hash: hash, mask: uint32(width - 1), sample: 10 * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+		s.seeds[i] = newSeed()
+	}
+	return s
+}
+
+func (s *countMinSketch[K]) increment(k K) {
+	for i := range s.rows {
+		idx := s.hash(s.seeds[i], k) & s.mask
+		if s.rows[i][idx] < 15 {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sample {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] >>= 1
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+func (s *countMinSketch[K]) estimate(k K) uint8 {
+	min := uint8(15)
+	for i := range s.rows {
+		if v := s.rows[i][s.hash(s.seeds[i], k)&s.mask]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+func (s *countMinSketch[K]) clear() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] = 0
+		}
+	}
+	s.additions = 0
+}
+
+// endregion
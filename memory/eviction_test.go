@@ -0,0 +1,203 @@
+package memory
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// victims Drains p, returning its keys in eviction order.
+func victims(p policy[string]) []string {
+	var keys []string
+	for {
+		k, ok := p.victim()
+		if !ok {
+			return keys
+		}
+		keys = append(keys, k)
+		p.remove(k)
+	}
+}
+
+func TestLRUPolicyOrder(t *testing.T) {
+	p := newLRUPolicy[string]()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		p.add(k)
+	}
+	p.access("a")
+	p.add("c") // re-adding counts as an access
+	p.access("unknown")
+	if got, want := victims(p), []string{"b", "d", "a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("eviction order %v, want %v", got, want)
+	}
+}
+
+func TestLFUPolicyOrder(t *testing.T) {
+	p := newLFUPolicy[string]()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		p.add(k)
+	}
+	for i := 0; i < 3; i++ {
+		p.access("a")
+	}
+	p.access("c")
+	p.access("b")
+	// d was used once; b and c twice, c less recently; a four times.
+	if got, want := victims(p), []string{"d", "c", "b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("eviction order %v, want %v", got, want)
+	}
+	if p.buckets.Len() != 0 {
+		t.Fatalf("%d empty frequency buckets left", p.buckets.Len())
+	}
+}
+
+func TestTinyLFUPolicyAdmission(t *testing.T) {
+	p := newTinyLFUPolicy(100, DJB33)
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		p.add(k)
+	}
+	// The window holds one key, the others went on to probation; a hit there
+	// promotes a key into the protected segment.
+	if p.locations["e"] != tinyWindow || p.locations["a"] != tinyProbation {
+		t.Fatalf("segments %v, want e in the window and a in probation", p.locations)
+	}
+	p.access("a")
+	if p.locations["a"] != tinyProtected {
+		t.Fatalf("a in segment %d after a hit in probation, want protected", p.locations["a"])
+	}
+
+	// Two admission candidates in probation: the one requested less often
+	// loses, whichever end of the segment it is at.
+	q := newTinyLFUPolicy(100, DJB33)
+	q.add("old")
+	q.add("new")
+	q.add("newest") // pushes new out of the window into probation
+	for i := 0; i < 5; i++ {
+		q.sketch.increment("old")
+	}
+	if k, _ := q.victim(); k != "new" {
+		t.Fatalf("victim %q, want the rarely requested candidate new", k)
+	}
+	for i := 0; i < 10; i++ {
+		q.sketch.increment("new")
+	}
+	if k, _ := q.victim(); k != "old" {
+		t.Fatalf("victim %q, want old once new is requested more often", k)
+	}
+}
+
+func TestCountMinSketch(t *testing.T) {
+	s := newCountMinSketch(1024, DJB33)
+	for i := 0; i < 20; i++ {
+		s.increment("hot")
+	}
+	s.increment("warm")
+	s.increment("warm")
+	if got := s.estimate("hot"); got != 15 {
+		t.Fatalf("estimate(hot) = %d, want the 4-bit maximum 15", got)
+	}
+	if got := s.estimate("warm"); got < 2 {
+		t.Fatalf("estimate(warm) = %d, want at least 2", got)
+	}
+	if got := s.estimate("cold"); got > 2 {
+		t.Fatalf("estimate(cold) = %d", got)
+	}
+
+	// The counters are halved after sample increments.
+	for i := 0; i < s.sample; i++ {
+		s.increment("other:" + strconv.Itoa(i))
+	}
+	if got := s.estimate("hot"); got > 8 {
+		t.Fatalf("estimate(hot) = %d after aging, want at most 8", got)
+	}
+	s.clear()
+	if got := s.estimate("hot"); got != 0 {
+		t.Fatalf("estimate(hot) = %d after clear", got)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	for _, policy := range []EvictionPolicy{EvictLRU, EvictLFU, EvictTinyLFU} {
+		c := NewWithOptions(Options{MaxEntries: 10, Policy: policy})
+		var capacity int
+		c.OnEvictedWithReason(func(k string, v interface{}, reason EvictionReason) {
+			if reason == EvictionCapacity {
+				capacity++
+			}
+		})
+		for i := 0; i < 100; i++ {
+			c.Set("key:"+strconv.Itoa(i), i, NoExpiration)
+			if n := c.ItemCount(); n > 10 {
+				t.Fatalf("policy %d: %d items after %d writes", policy, n, i+1)
+			}
+		}
+		if capacity != 90 {
+			t.Fatalf("policy %d: %d capacity evictions, want 90", policy, capacity)
+		}
+	}
+}
+
+func TestMaxEntriesEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewWithOptions(Options{MaxEntries: 3})
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 2, NoExpiration)
+	c.Set("c", 3, NoExpiration)
+	c.Get("a")
+	c.Set("d", 4, NoExpiration)
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("b survived, want it evicted as the least recently used")
+	}
+	for _, k := range []string{"a", "c", "d"} {
+		if _, ok := c.Get(k); !ok {
+			t.Fatalf("%s evicted", k)
+		}
+	}
+}
+
+func TestMaxCost(t *testing.T) {
+	c := NewWithOptions(Options{
+		MaxCost: 100,
+		Cost:    func(k string, x interface{}) int64 { return int64(len(x.(string))) },
+	})
+	c.Set("a", string(make([]byte, 40)), NoExpiration)
+	c.Set("b", string(make([]byte, 40)), NoExpiration)
+	c.Set("a", string(make([]byte, 10)), NoExpiration) // cost drops to 50
+	c.Set("c", string(make([]byte, 50)), NoExpiration)
+	if n := c.ItemCount(); n != 3 {
+		t.Fatalf("%d items at cost 100, want 3", n)
+	}
+	c.Set("d", string(make([]byte, 30)), NoExpiration)
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("b survived going over MaxCost")
+	}
+	if s := c.Stats(); s.EstimatedBytes > 100 {
+		t.Fatalf("cost %d over MaxCost", s.EstimatedBytes)
+	}
+
+	// Without Cost, the estimated size is used.
+	e := NewWithOptions(Options{MaxCost: 3 * estimateSize("k0", "value")})
+	for i := 0; i < 10; i++ {
+		e.Set("k"+strconv.Itoa(i), "value", NoExpiration)
+	}
+	if n := e.ItemCount(); n != 3 {
+		t.Fatalf("%d items, want 3 by estimated size", n)
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	base := estimateSize("", nil)
+	for _, tc := range []struct {
+		x    interface{}
+		want int64
+	}{
+		{"abcd", 4},
+		{make([]byte, 2, 16), 16},
+		{int32(1), 4},
+		{1.5, 8},
+		{struct{}{}, 0},
+	} {
+		if got := estimateSize("key", tc.x) - base - 3; got != tc.want {
+			t.Errorf("estimateSize(%T) = base + key + %d, want + %d", tc.x, got, tc.want)
+		}
+	}
+}
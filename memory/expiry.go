@@ -0,0 +1,144 @@
+package memory
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Items with an expiration are kept in a min-heap ordered by expiration, so
+// DeleteExpired() only visits the items that have expired instead of scanning
+// the whole items map. Writing an item with an expiration costs O(log n), and
+// items that never expire aren't in the heap at all.
+//
+// DeleteExpired() holds the lock for at most expiryBatch items at a time, so
+// a burst of keys expiring at once doesn't block readers and writers for the
+// whole cleanup. The heap is generic over the key type, so TypedCache shares it.
+
+// expiryBatch Is the number of expired items DeleteExpired() removes per lock.
+const expiryBatch = 1024
+
+type expiryEntry[K comparable] struct {
+	key        K
+	expiration int64
+}
+
+// expiryQueue Is the min-heap of the items that expire, protected by the
+// cache's lock. pos maps a key to its position in entries.
+type expiryQueue[K comparable] struct {
+	entries []expiryEntry[K]
+	pos     map[K]int
+}
+
+func newExpiryQueue[K comparable]() *expiryQueue[K] {
+	return &expiryQueue[K]{pos: map[K]int{}}
+}
+
+// newItemExpiryQueue Returns the heap of the items that expire.
+func newItemExpiryQueue(items map[string]Item) *expiryQueue[string] {
+	q := newExpiryQueue[string]()
+	for k, v := range items {
+		if v.Expiration > 0 {
+			q.pos[k] = len(q.entries)
+			q.entries = append(q.entries, expiryEntry[string]{k, v.Expiration})
+		}
+	}
+	heap.Init(q)
+	return q
+}
+
+func (q *expiryQueue[K]) Len() int { return len(q.entries) }
+
+func (q *expiryQueue[K]) Less(i, j int) bool {
+	return q.entries[i].expiration < q.entries[j].expiration
+}
+
+func (q *expiryQueue[K]) Swap(i, j int) {
+	q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+	q.pos[q.entries[i].key] = i
+	q.pos[q.entries[j].key] = j
+}
+
+func (q *expiryQueue[K]) Push(x interface{}) {
+	e := x.(expiryEntry[K])
+	q.pos[e.key] = len(q.entries)
+	q.entries = append(q.entries, e)
+}
+
+func (q *expiryQueue[K]) Pop() interface{} {
+	n := len(q.entries) - 1
+	e := q.entries[n]
+	q.entries[n] = expiryEntry[K]{}
+	q.entries = q.entries[:n]
+	delete(q.pos, e.key)
+	return e
+}
+
+// set Schedules k to expire at expiration, or unschedules it if expiration
+// is 0.
+func (q *expiryQueue[K]) set(k K, expiration int64) {
+	i, ok := q.pos[k]
+	switch {
+	case expiration <= 0:
+		if ok {
+			heap.Remove(q, i)
+		}
+	case ok:
+		q.entries[i].expiration = expiration
+		heap.Fix(q, i)
+	default:
+		heap.Push(q, expiryEntry[K]{k, expiration})
+	}
+}
+
+func (q *expiryQueue[K]) remove(k K) {
+	if i, ok := q.pos[k]; ok {
+		heap.Remove(q, i)
+	}
+}
+
+// next Returns the key expiring first if it expires before deadline.
+func (q *expiryQueue[K]) next(deadline int64) (K, bool) {
+	if len(q.entries) == 0 || q.entries[0].expiration >= deadline {
+		var zero K
+		return zero, false
+	}
+	return q.entries[0].key, true
+}
+
+func (q *expiryQueue[K]) reset() {
+	*q = expiryQueue[K]{pos: map[K]int{}}
+}
+
+// DeleteExpired Delete all expired items from the cache.
+func (c *cache) DeleteExpired() {
+	now := time.Now().UnixNano()
+	// Expired items are kept around for GetOrLoad to serve while they are
+	// reloaded.
+	deadline := now - int64(c.load.StaleWhileRevalidate)
+	c.loading.prune(now, c.load.maxBackoff())
+	for {
+		var evictedItems []keyAndValue
+		more := true
+		c.mu.Lock()
+		for i := 0; i < expiryBatch; i++ {
+			k, ok := c.expiry.next(deadline)
+			if !ok {
+				more = false
+				break
+			}
+			if _, found := c.items[k]; !found {
+				c.expiry.remove(k)
+				continue
+			}
+			ov, evicted := c.delete(k, EvictionExpired)
+			if evicted {
+				evictedItems = append(evictedItems, keyAndValue{k, ov, EvictionExpired})
+			}
+		}
+		c.mu.Unlock()
+		c.evict(evictedItems)
+		if !more {
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package memory
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// checkHeap Fails unless q is a valid min-heap whose pos index matches it.
+func checkHeap(t *testing.T, q *expiryQueue[string]) {
+	t.Helper()
+	if len(q.pos) != len(q.entries) {
+		t.Fatalf("%d positions for %d entries", len(q.pos), len(q.entries))
+	}
+	for i, e := range q.entries {
+		if q.pos[e.key] != i {
+			t.Fatalf("pos[%s] = %d, entry at %d", e.key, q.pos[e.key], i)
+		}
+		if i > 0 && q.entries[(i-1)/2].expiration > e.expiration {
+			t.Fatalf("entry %d expires before its parent", i)
+		}
+	}
+}
+
+func drain(q *expiryQueue[string], deadline int64) []string {
+	var keys []string
+	for {
+		k, ok := q.next(deadline)
+		if !ok {
+			return keys
+		}
+		keys = append(keys, k)
+		q.remove(k)
+	}
+}
+
+func TestExpiryQueue(t *testing.T) {
+	q := newExpiryQueue[string]()
+	for i, k := range []string{"e", "b", "d", "a", "c"} {
+		q.set(k, int64([]int{5, 2, 4, 1, 3}[i]))
+	}
+	checkHeap(t, q)
+
+	q.set("e", 0)  // unscheduled
+	q.set("a", 10) // overwritten, moves to the back
+	q.set("b", 2)  // unchanged
+	q.remove("d")
+	q.remove("missing")
+	checkHeap(t, q)
+
+	if k, ok := q.next(2); ok {
+		t.Fatalf("next(2) = %s, want nothing expiring before 2", k)
+	}
+	if got := drain(q, 100); len(got) != 3 || got[0] != "b" || got[1] != "c" || got[2] != "a" {
+		t.Fatalf("expiry order %v, want [b c a]", got)
+	}
+	q.set("x", 1)
+	q.reset()
+	if q.Len() != 0 || len(q.pos) != 0 {
+		t.Fatal("reset left entries behind")
+	}
+}
+
+func TestNewItemExpiryQueue(t *testing.T) {
+	items := map[string]Item{}
+	for i := 0; i < 100; i++ {
+		items[strconv.Itoa(i)] = Item{Expiration: int64(100 - i)}
+	}
+	items["forever"] = Item{}
+	q := newItemExpiryQueue(items)
+	checkHeap(t, q)
+	if q.Len() != 100 {
+		t.Fatalf("heap holds %d keys, want the 100 that expire", q.Len())
+	}
+	if k, _ := q.next(1000); k != "99" {
+		t.Fatalf("next = %s, want 99", k)
+	}
+}
+
+func TestCacheExpiryHeap(t *testing.T) {
+	c := New(NoExpiration, 0)
+	c.Set("a", 1, time.Hour)
+	c.Set("b", 2, time.Hour)
+	c.Set("c", 3, NoExpiration)
+	if c.expiry.Len() != 2 {
+		t.Fatalf("heap holds %d keys, want 2", c.expiry.Len())
+	}
+
+	c.Set("a", 1, NoExpiration) // overwriting without expiration unschedules
+	c.Delete("b")
+	if c.expiry.Len() != 0 {
+		t.Fatalf("heap holds %d keys after overwrite and delete", c.expiry.Len())
+	}
+
+	c.Set("c", 3, time.Millisecond)
+	c.Set("c", 3, time.Hour) // overwriting reschedules
+	time.Sleep(2 * time.Millisecond)
+	c.DeleteExpired()
+	if _, ok := c.Get("c"); !ok {
+		t.Fatal("rescheduled item deleted at its old expiration")
+	}
+	checkHeap(t, c.expiry)
+}
+
+func TestDeleteExpiredInBatches(t *testing.T) {
+	const expired, kept = 3*expiryBatch + 10, 5
+	c := New(NoExpiration, 0)
+	for i := 0; i < expired; i++ {
+		c.Set("expired:"+strconv.Itoa(i), i, time.Nanosecond)
+	}
+	for i := 0; i < kept; i++ {
+		c.Set("kept:"+strconv.Itoa(i), i, time.Hour)
+	}
+	time.Sleep(time.Millisecond)
+
+	var evicted, firstBatchLeft int
+	c.OnEvictedWithReason(func(k string, v interface{}, reason EvictionReason) {
+		if reason != EvictionExpired {
+			t.Errorf("%s evicted as %v", k, reason)
+		}
+		if evicted == 0 {
+			// The callbacks of a batch run without the lock, before the
+			// next batch is deleted.
+			firstBatchLeft = c.ItemCount()
+		}
+		evicted++
+	})
+	c.DeleteExpired()
+
+	if evicted != expired {
+		t.Fatalf("%d expired items evicted, want %d", evicted, expired)
+	}
+	if want := expired + kept - expiryBatch; firstBatchLeft != want {
+		t.Fatalf("%d items left after the first batch, want %d", firstBatchLeft, want)
+	}
+	if n := c.ItemCount(); n != kept {
+		t.Fatalf("%d items left, want %d", n, kept)
+	}
+	if c.expiry.Len() != kept {
+		t.Fatalf("heap holds %d keys, want %d", c.expiry.Len(), kept)
+	}
+}
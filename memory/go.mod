@@ -1,3 +1,10 @@
 module github.com/jjonline/go-lib-backend/memory
 
 go 1.18
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
@@ -0,0 +1,295 @@
+package memory
+
+import (
+	"strings"
+	"time"
+)
+
+// Secondary indexes let a cache delete groups of items without copying all
+// items with Items():
+//
+//   - Tags are attached with SetWithTags(). InvalidateTag() deletes all items
+//     carrying a tag, using a tag -> keys index that is only created once the
+//     first tagged item is written. Overwriting an item replaces its tags.
+//   - DeletePrefix() deletes all items whose key starts with a prefix. With
+//     Options.PrefixIndex the keys are kept in a radix tree, so only the
+//     matching keys are visited; otherwise all keys are scanned (under the
+//     lock, but without copying them).
+
+// index Holds the secondary indexes of a cache, protected by the cache's lock.
+type index struct {
+	tags    map[string]map[string]struct{} // tag -> keys
+	keyTags map[string][]string            // key -> tags
+	prefix  *radixNode                     // nil unless Options.PrefixIndex
+}
+
+func newIndex(prefix bool) *index {
+	idx := &index{
+		tags:    map[string]map[string]struct{}{},
+		keyTags: map[string][]string{},
+	}
+	if prefix {
+		idx.prefix = &radixNode{}
+	}
+	return idx
+}
+
+// add Indexes k, replacing the tags of a previous item under k.
+func (idx *index) add(k string, tags []string) {
+	idx.untag(k)
+	if len(tags) > 0 {
+		idx.keyTags[k] = tags
+		for _, tag := range tags {
+			keys, ok := idx.tags[tag]
+			if !ok {
+				keys = map[string]struct{}{}
+				idx.tags[tag] = keys
+			}
+			keys[k] = struct{}{}
+		}
+	}
+	if idx.prefix != nil {
+		idx.prefix.insert(k)
+	}
+}
+
+// remove Forgets k.
+func (idx *index) remove(k string) {
+	idx.untag(k)
+	if idx.prefix != nil {
+		idx.prefix.remove(k)
+	}
+}
+
+func (idx *index) untag(k string) {
+	for _, tag := range idx.keyTags[k] {
+		keys := idx.tags[tag]
+		delete(keys, k)
+		if len(keys) == 0 {
+			delete(idx.tags, tag)
+		}
+	}
+	delete(idx.keyTags, k)
+}
+
+func (idx *index) reset() {
+	*idx = *newIndex(idx.prefix != nil)
+}
+
+// SetWithTags Add an item to the cache like Set(), attaching the given tags to it
+// for InvalidateTag(). The tags replace those of an existing item.
+func (c *cache) SetWithTags(k string, x interface{}, d time.Duration, tags ...string) {
+	var e int64
+	if d == DefaultExpiration {
+		d = c.defaultExpiration
+	}
+	if d > 0 {
+		e = time.Now().Add(d).UnixNano()
+	}
+	tags = dedupTags(tags)
+	c.mu.Lock()
+	evicted := c.storeTagged(k, Item{
+		Object:     x,
+		Expiration: e,
+	}, tags)
+	c.mu.Unlock()
+	if evicted != nil {
+		c.evict(evicted)
+	}
+}
+
+// Tags Returns the tags of the item stored under k.
+func (c *cache) Tags(k string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.index == nil {
+		return nil
+	}
+	return append([]string(nil), c.index.keyTags[k]...)
+}
+
+// InvalidateTag Delete all items tagged with tag. Returns the number of items
+// deleted.
+func (c *cache) InvalidateTag(tag string) int {
+	c.mu.Lock()
+	if c.index == nil {
+		c.mu.Unlock()
+		return 0
+	}
+	keys := make([]string, 0, len(c.index.tags[tag]))
+	for k := range c.index.tags[tag] {
+		keys = append(keys, k)
+	}
+	return c.deleteKeys(keys)
+}
+
+// DeletePrefix Delete all items whose key starts with prefix. Returns the number
+// of items deleted.
+func (c *cache) DeletePrefix(prefix string) int {
+	var keys []string
+	c.mu.Lock()
+	if c.index != nil && c.index.prefix != nil {
+		c.index.prefix.walk(prefix, func(k string) {
+			keys = append(keys, k)
+		})
+	} else {
+		for k := range c.items {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	return c.deleteKeys(keys)
+}
+
+// deleteKeys Deletes keys, releasing the lock that must be held by the caller.
+func (c *cache) deleteKeys(keys []string) int {
+	var evicted []keyAndValue
+	n := 0
+	for _, k := range keys {
+		v, found := c.items[k]
+		ov, listening := c.delete(k, EvictionDeleted)
+		if !found {
+			continue
+		}
+		if !v.Expired() {
+			n++
+		}
+		if listening {
+			evicted = append(evicted, keyAndValue{k, ov, EvictionDeleted})
+		}
+	}
+	c.mu.Unlock()
+	c.evict(evicted)
+	return n
+}
+
+func dedupTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		if _, ok := seen[tag]; !ok {
+			seen[tag] = struct{}{}
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// radixNode Is a node of a radix tree (compressed trie) of keys: the key of a
+// node is the concatenation of the prefixes from the root down to it.
+type radixNode struct {
+	prefix   string
+	children []*radixNode
+	leaf     bool // whether the node's key is in the tree
+}
+
+func commonPrefixLen(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+func (n *radixNode) insert(k string) {
+	for {
+		if k == "" {
+			n.leaf = true
+			return
+		}
+		var (
+			child *radixNode
+			pos   int
+		)
+		for i, c := range n.children {
+			if c.prefix[0] == k[0] {
+				child, pos = c, i
+				break
+			}
+		}
+		if child == nil {
+			n.children = append(n.children, &radixNode{prefix: k, leaf: true})
+			return
+		}
+		l := commonPrefixLen(child.prefix, k)
+		if l < len(child.prefix) {
+			split := &radixNode{prefix: child.prefix[:l], children: []*radixNode{child}}
+			child.prefix = child.prefix[l:]
+			n.children[pos] = split
+			child = split
+		}
+		n, k = child, k[l:]
+	}
+}
+
+// remove Removes k, merging nodes that are left with a single child.
+func (n *radixNode) remove(k string) bool {
+	if k == "" {
+		if !n.leaf {
+			return false
+		}
+		n.leaf = false
+		return true
+	}
+	for i, c := range n.children {
+		if c.prefix[0] != k[0] {
+			continue
+		}
+		if !strings.HasPrefix(k, c.prefix) || !c.remove(k[len(c.prefix):]) {
+			return false
+		}
+		if !c.leaf {
+			switch len(c.children) {
+			case 0:
+				n.children = append(n.children[:i], n.children[i+1:]...)
+			case 1:
+				merged := c.children[0]
+				merged.prefix = c.prefix + merged.prefix
+				n.children[i] = merged
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// walk Calls fn for every key starting with prefix.
+func (n *radixNode) walk(prefix string, fn func(k string)) {
+	key := ""
+	for prefix != "" {
+		var next *radixNode
+		for _, c := range n.children {
+			if c.prefix[0] == prefix[0] {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(prefix, next.prefix):
+			prefix = prefix[len(next.prefix):]
+		case strings.HasPrefix(next.prefix, prefix):
+			prefix = ""
+		default:
+			return
+		}
+		key += next.prefix
+		n = next
+	}
+	n.each(key, fn)
+}
+
+func (n *radixNode) each(key string, fn func(k string)) {
+	if n.leaf {
+		fn(key)
+	}
+	for _, c := range n.children {
+		c.each(key+c.prefix, fn)
+	}
+}
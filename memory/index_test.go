@@ -0,0 +1,175 @@
+package memory
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newRadix(keys ...string) *radixNode {
+	n := &radixNode{}
+	for _, k := range keys {
+		n.insert(k)
+	}
+	return n
+}
+
+func walked(n *radixNode, prefix string) []string {
+	keys := []string{}
+	n.walk(prefix, func(k string) { keys = append(keys, k) })
+	sort.Strings(keys)
+	return keys
+}
+
+// shape Renders the tree as prefix(children...), with a * after the prefix
+// of the nodes whose key is in the tree.
+func shape(n *radixNode) string {
+	var b strings.Builder
+	b.WriteString(n.prefix)
+	if n.leaf {
+		b.WriteString("*")
+	}
+	if len(n.children) > 0 {
+		children := make([]string, len(n.children))
+		for i, c := range n.children {
+			children[i] = shape(c)
+		}
+		sort.Strings(children)
+		b.WriteString("(" + strings.Join(children, " ") + ")")
+	}
+	return b.String()
+}
+
+func TestRadixInsertSplits(t *testing.T) {
+	n := newRadix("romane", "romanus", "romulus", "rubens", "ruber", "rubicon", "rubicundus")
+	want := "(r(om(an(e* us*) ulus*) ub(e(ns* r*) ic(on* undus*))))"
+	if got := shape(n); got != want {
+		t.Fatalf("tree %s, want %s", got, want)
+	}
+
+	// A key ending inside an edge splits it and marks the split node.
+	n = newRadix("test", "te")
+	if got := shape(n); got != "(te*(st*))" {
+		t.Fatalf("tree %s", got)
+	}
+	n.insert("te")
+	if got := shape(n); got != "(te*(st*))" {
+		t.Fatalf("tree %s after inserting te again", got)
+	}
+}
+
+func TestRadixRemoveMerges(t *testing.T) {
+	n := newRadix("team", "test")
+	if !n.remove("team") {
+		t.Fatal("remove(team) = false")
+	}
+	if got := shape(n); got != "(test*)" {
+		t.Fatalf("tree %s, want the single child merged into test", got)
+	}
+
+	// Removing a key whose node has one child merges the child into it.
+	n = newRadix("te", "test", "toast")
+	n.remove("te")
+	if got := shape(n); got != "(t(est* oast*))" {
+		t.Fatalf("tree %s", got)
+	}
+
+	// A leaf that becomes childless is dropped, and its parent merged.
+	n = newRadix("romane", "romanus", "romulus")
+	n.remove("romulus")
+	if got := shape(n); got != "(roman(e* us*))" {
+		t.Fatalf("tree %s", got)
+	}
+	n.remove("romane")
+	if got := shape(n); got != "(romanus*)" {
+		t.Fatalf("tree %s", got)
+	}
+
+	for _, k := range []string{"roman", "romanusx", "x", ""} {
+		if n.remove(k) {
+			t.Errorf("remove(%q) of a key not in the tree = true", k)
+		}
+	}
+	if got := shape(n); got != "(romanus*)" {
+		t.Fatalf("tree %s after removing missing keys", got)
+	}
+}
+
+func TestRadixWalk(t *testing.T) {
+	n := newRadix("romane", "romanus", "romulus", "rubens", "ruber", "rubicon")
+	for prefix, want := range map[string][]string{
+		"":         {"romane", "romanus", "romulus", "rubens", "ruber", "rubicon"},
+		"r":        {"romane", "romanus", "romulus", "rubens", "ruber", "rubicon"},
+		"rom":      {"romane", "romanus", "romulus"},
+		"roma":     {"romane", "romanus"}, // ends inside the edge "an"
+		"rube":     {"rubens", "ruber"},
+		"rubi":     {"rubicon"},
+		"rubico":   {"rubicon"}, // ends inside the edge "con"
+		"rubicon":  {"rubicon"},
+		"rubicx":   {},
+		"romax":    {},
+		"x":        {},
+		"rubicon!": {},
+	} {
+		if got := walked(n, prefix); !reflect.DeepEqual(got, want) {
+			t.Errorf("walk(%q) = %v, want %v", prefix, got, want)
+		}
+	}
+}
+
+func TestInvalidateTagReplacesOldTags(t *testing.T) {
+	c := New(NoExpiration, 0)
+	c.SetWithTags("a", 1, NoExpiration, "old", "shared", "old")
+	c.SetWithTags("b", 2, NoExpiration, "shared")
+	if tags := c.Tags("a"); !reflect.DeepEqual(tags, []string{"old", "shared"}) {
+		t.Fatalf("Tags(a) = %v, want deduplicated tags", tags)
+	}
+
+	c.SetWithTags("a", 1, NoExpiration, "new")
+	if n := c.InvalidateTag("old"); n != 0 {
+		t.Fatalf("InvalidateTag(old) deleted %d items after a was retagged", n)
+	}
+	if _, ok := c.index.tags["old"]; ok {
+		t.Fatal("empty tag kept in the index")
+	}
+	if n := c.InvalidateTag("shared"); n != 1 {
+		t.Fatalf("InvalidateTag(shared) deleted %d items, want b only", n)
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a deleted with a tag it no longer carries")
+	}
+
+	// Overwriting without tags drops them.
+	c.Set("a", 1, NoExpiration)
+	if n := c.InvalidateTag("new"); n != 0 {
+		t.Fatalf("InvalidateTag(new) deleted %d items after a was overwritten", n)
+	}
+	if tags := c.Tags("a"); len(tags) != 0 {
+		t.Fatalf("Tags(a) = %v after Set", tags)
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		c := NewWithOptions(Options{PrefixIndex: indexed})
+		for i := 0; i < 10; i++ {
+			c.Set("user:"+strconv.Itoa(i), i, NoExpiration)
+			c.Set("order:"+strconv.Itoa(i), i, NoExpiration)
+		}
+		c.Set("user:expired", 0, time.Nanosecond)
+		time.Sleep(time.Millisecond)
+
+		if n := c.DeletePrefix("user:"); n != 10 {
+			t.Fatalf("indexed=%v: DeletePrefix deleted %d unexpired items, want 10", indexed, n)
+		}
+		if n := c.ItemCount(); n != 10 {
+			t.Fatalf("indexed=%v: %d items left, want the 10 orders", indexed, n)
+		}
+		if n := c.DeletePrefix("order:1"); n != 1 {
+			t.Fatalf("indexed=%v: DeletePrefix(order:1) deleted %d items", indexed, n)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GetOrLoad turns a cache into a read-through cache: a miss calls the loader
+// and stores its result, so callers no longer write "Get, miss, load, Set"
+// themselves.
+//
+//   - Concurrent loads of the same key are deduplicated: one load runs the
+//     loader, all callers wait for its result (or for their context). The load
+//     runs detached from the callers' contexts, bounded by LoadTimeout, so the
+//     first caller giving up doesn't fail the others.
+//   - RefreshAhead reloads a key in the background when it is about to expire,
+//     while the current value is still served.
+//   - StaleWhileRevalidate keeps serving an expired value for a while and
+//     reloads it in the background. Get() treats such a value as expired, the
+//     janitor only deletes it once the window has passed.
+//   - A loader returning ErrNotFound is cached for NegativeTTL, so repeated
+//     lookups of missing keys don't reach the backend.
+//   - After a loader error the key isn't loaded again before ErrorBackoff has
+//     passed, doubling up to MaxErrorBackoff for consecutive errors; the last
+//     error (or a stale value) is returned in the meantime. A load cancelled or
+//     timed out by its context isn't a loader error.
+//   - Set() and Delete() forget a key's negative entry and error backoff, and
+//     the janitor drops the ones that have run out. A load of the key in
+//     flight at that moment is stale: its result is returned to its callers
+//     but not stored, so it can't overwrite the newer value or deletion.
+
+// ErrNotFound Is returned by a Loader when the key doesn't exist in the backend.
+var ErrNotFound = errors.New("memory: not found")
+
+// DefaultLoadTimeout Bounds a load when LoadOptions.LoadTimeout isn't set.
+const DefaultLoadTimeout = 30 * time.Second
+
+// Loader Loads the value of a key missing from the cache.
+type Loader func(ctx context.Context, k string) (interface{}, error)
+
+// LoadOptions Configures GetOrLoad. The zero value only deduplicates loads.
+type LoadOptions struct {
+	// RefreshAhead Reload a key in the background when it expires within this duration.
+	RefreshAhead time.Duration
+	// StaleWhileRevalidate Serve an expired value for this long while it is reloaded in the background.
+	StaleWhileRevalidate time.Duration
+	// NegativeTTL Remember ErrNotFound for this long.
+	NegativeTTL time.Duration
+	// ErrorBackoff Don't call the loader again for this long after it failed.
+	ErrorBackoff time.Duration
+	// MaxErrorBackoff Caps the doubling backoff after consecutive errors. Defaults to 32 * ErrorBackoff.
+	MaxErrorBackoff time.Duration
+	// LoadTimeout Bounds the context a load runs with. Defaults to DefaultLoadTimeout.
+	LoadTimeout time.Duration
+}
+
+// loading Holds the in-flight loads, negative entries and loader failures of
+// a cache.
+type loading struct {
+	mu        sync.Mutex
+	calls     map[string]*loadCall
+	negatives map[string]int64
+	failures  map[string]*loadFailure
+	// remembered Is len(calls) + len(negatives) + len(failures), read without
+	// the lock so that Set() and Delete() only take it when there is something
+	// to forget.
+	remembered int32
+}
+
+type loadCall struct {
+	done  chan struct{}
+	val   interface{}
+	err   error
+	stale bool // the key was written or deleted during the load, guarded by loading.mu
+}
+
+type loadFailure struct {
+	count   int
+	retryAt int64
+	err     error
+}
+
+// GetOrLoad Return the value of k, loading it with loader and storing it for d
+// (see Set()) if it is missing. See LoadOptions for refresh-ahead,
+// stale-while-revalidate, negative caching and error backoff.
+func (c *cache) GetOrLoad(ctx context.Context, k string, loader Loader, d time.Duration) (interface{}, error) {
+	now := time.Now().UnixNano()
+	c.mu.RLock()
+	item, found := c.items[k]
+	c.mu.RUnlock()
+	if found {
+		if item.Expiration == 0 || now <= item.Expiration {
+			if item.Expiration > 0 && item.Expiration-now <= int64(c.load.RefreshAhead) {
+				c.refresh(k, loader, d)
+			}
+			if c.bound != nil {
+				c.bound.accessed(k)
+			}
+			atomic.AddUint64(&c.stats.hits, 1)
+			return item.Object, nil
+		}
+		if now <= item.Expiration+int64(c.load.StaleWhileRevalidate) {
+			c.refresh(k, loader, d)
+			atomic.AddUint64(&c.stats.hits, 1)
+			return item.Object, nil
+		}
+	}
+	atomic.AddUint64(&c.stats.misses, 1)
+
+	if err := c.loading.blocked(k, now); err != nil {
+		return nil, err
+	}
+	call, leader := c.loading.begin(k)
+	if leader {
+		go c.runLoad(k, call, loader, d)
+	}
+	select {
+	case <-call.done:
+		return call.val, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// refresh Reloads k in the background unless it is already being loaded or
+// its loader is backing off.
+func (c *cache) refresh(k string, loader Loader, d time.Duration) {
+	if c.loading.blocked(k, time.Now().UnixNano()) != nil {
+		return
+	}
+	call, leader := c.loading.begin(k)
+	if !leader {
+		return
+	}
+	go c.runLoad(k, call, loader, d)
+}
+
+// runLoad Runs the load of k registered as call with a context of its own, so
+// that it isn't cancelled with the caller that started it.
+func (c *cache) runLoad(k string, call *loadCall, loader Loader, d time.Duration) {
+	timeout := c.load.LoadTimeout
+	if timeout <= 0 {
+		timeout = DefaultLoadTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	val, err := c.callLoader(ctx, k, loader)
+	c.finishLoad(k, call, d, val, err)
+}
+
+// contextError Tells whether err comes from a cancelled or timed out context
+// rather than from the backend.
+func contextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// callLoader Calls loader, turning a panic into an error so that the callers
+// waiting for the load aren't blocked forever.
+func (c *cache) callLoader(ctx context.Context, k string, loader Loader) (val interface{}, err error) {
+	start := time.Now()
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("memory: loader for %s panicked: %v", k, x)
+		}
+		c.stats.loaded(time.Since(start), err)
+	}()
+	return loader(ctx, k)
+}
+
+// finishLoad Stores the result of a load unless it is stale and wakes up the
+// callers waiting for it.
+func (c *cache) finishLoad(k string, call *loadCall, d time.Duration, val interface{}, err error) {
+	var evicted []keyAndValue
+	c.mu.Lock()
+	c.loading.mu.Lock()
+	stale := call.stale
+	c.loading.mu.Unlock()
+	// The call stays registered while the result is stored, so that no other
+	// load of k can start and be marked stale by this write.
+	if !stale {
+		switch {
+		case err == nil:
+			evicted = c.set(k, val, d)
+		case errors.Is(err, ErrNotFound):
+			if v, ok := c.delete(k, EvictionDeleted); ok {
+				evicted = []keyAndValue{{k, v, EvictionDeleted}}
+			}
+		}
+	}
+
+	c.loading.mu.Lock()
+	now := time.Now().UnixNano()
+	delete(c.loading.calls, k)
+	if !stale {
+		delete(c.loading.negatives, k)
+		switch {
+		case err == nil:
+			delete(c.loading.failures, k)
+		case errors.Is(err, ErrNotFound):
+			delete(c.loading.failures, k)
+			if c.load.NegativeTTL > 0 {
+				c.loading.negatives[k] = now + int64(c.load.NegativeTTL)
+			}
+		case c.load.ErrorBackoff > 0 && !contextError(err):
+			failure, ok := c.loading.failures[k]
+			if !ok {
+				failure = &loadFailure{}
+				c.loading.failures[k] = failure
+			}
+			failure.count++
+			failure.err = err
+			failure.retryAt = now + int64(c.load.backoff(failure.count))
+		}
+	}
+	c.loading.count()
+	c.loading.mu.Unlock()
+	c.mu.Unlock()
+	if evicted != nil {
+		c.evict(evicted)
+	}
+
+	call.val, call.err = val, err
+	close(call.done)
+}
+
+// backoff Returns how long to wait after the given number of consecutive errors.
+func (o LoadOptions) backoff(failures int) time.Duration {
+	max := o.maxBackoff()
+	d := o.ErrorBackoff
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// maxBackoff Returns the cap of the backoff after consecutive errors.
+func (o LoadOptions) maxBackoff() time.Duration {
+	if o.MaxErrorBackoff > 0 {
+		return o.MaxErrorBackoff
+	}
+	return 32 * o.ErrorBackoff
+}
+
+// count Updates remembered. Must be called with the lock held.
+func (l *loading) count() {
+	atomic.StoreInt32(&l.remembered, int32(len(l.calls)+len(l.negatives)+len(l.failures)))
+}
+
+// forget Drops the negative entry and error backoff of k and marks its load in
+// flight stale, e.g. because a value was written for it.
+func (l *loading) forget(k string) {
+	if atomic.LoadInt32(&l.remembered) == 0 {
+		return
+	}
+	l.mu.Lock()
+	if call, ok := l.calls[k]; ok {
+		call.stale = true
+	}
+	delete(l.negatives, k)
+	delete(l.failures, k)
+	l.count()
+	l.mu.Unlock()
+}
+
+// reset Drops all negative entries and error backoffs and marks all loads in
+// flight stale.
+func (l *loading) reset() {
+	if atomic.LoadInt32(&l.remembered) == 0 {
+		return
+	}
+	l.mu.Lock()
+	for _, call := range l.calls {
+		call.stale = true
+	}
+	for k := range l.negatives {
+		delete(l.negatives, k)
+	}
+	for k := range l.failures {
+		delete(l.failures, k)
+	}
+	l.count()
+	l.mu.Unlock()
+}
+
+// prune Drops the negative entries that have run out and the error backoffs
+// of keys that haven't been retried for the maximum backoff, after which a
+// new error starts over at ErrorBackoff anyway.
+func (l *loading) prune(now int64, maxBackoff time.Duration) {
+	if atomic.LoadInt32(&l.remembered) == 0 {
+		return
+	}
+	l.mu.Lock()
+	for k, until := range l.negatives {
+		if now > until {
+			delete(l.negatives, k)
+		}
+	}
+	for k, failure := range l.failures {
+		if now >= failure.retryAt+int64(maxBackoff) {
+			delete(l.failures, k)
+		}
+	}
+	l.count()
+	l.mu.Unlock()
+}
+
+// blocked Returns ErrNotFound for a negatively cached key and the last loader
+// error for a key whose loader is backing off.
+func (l *loading) blocked(k string, now int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until, ok := l.negatives[k]; ok {
+		if now <= until {
+			return ErrNotFound
+		}
+		delete(l.negatives, k)
+		l.count()
+	}
+	if failure, ok := l.failures[k]; ok && now < failure.retryAt {
+		return failure.err
+	}
+	return nil
+}
+
+// begin Returns the in-flight load of k, registering a new one if there is
+// none. leader tells whether the caller registered it and must run the loader.
+func (l *loading) begin(k string) (call *loadCall, leader bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.calls == nil {
+		l.calls = map[string]*loadCall{}
+		l.negatives = map[string]int64{}
+		l.failures = map[string]*loadFailure{}
+	}
+	if call, ok := l.calls[k]; ok {
+		return call, false
+	}
+	call = &loadCall{done: make(chan struct{})}
+	l.calls[k] = call
+	l.count()
+	return call, true
+}
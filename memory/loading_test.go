@@ -0,0 +1,278 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingLoader Counts its calls and returns value once release is closed.
+type blockingLoader struct {
+	calls   int32
+	started chan struct{}
+	release chan struct{}
+	value   interface{}
+	err     error
+	ctxErr  error // the error of the load's context when released
+}
+
+func newBlockingLoader(value interface{}, err error) *blockingLoader {
+	return &blockingLoader{started: make(chan struct{}, 16), release: make(chan struct{}), value: value, err: err}
+}
+
+func (l *blockingLoader) load(ctx context.Context, k string) (interface{}, error) {
+	atomic.AddInt32(&l.calls, 1)
+	l.started <- struct{}{}
+	<-l.release
+	l.ctxErr = ctx.Err()
+	return l.value, l.err
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetOrLoadDeduplicates(t *testing.T) {
+	c := New(NoExpiration, 0)
+	l := newBlockingLoader("value", nil)
+
+	var wg sync.WaitGroup
+	results := make([]interface{}, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := c.GetOrLoad(context.Background(), "k", l.load, DefaultExpiration)
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = v
+		}(i)
+	}
+	<-l.started
+	waitFor(t, func() bool { return c.Stats().Misses == 10 })
+	close(l.release)
+	wg.Wait()
+
+	if l.calls != 1 {
+		t.Fatalf("loader called %d times, want 1", l.calls)
+	}
+	for i, v := range results {
+		if v != "value" {
+			t.Fatalf("caller %d got %v", i, v)
+		}
+	}
+	if v, ok := c.Get("k"); !ok || v != "value" {
+		t.Fatalf("Get = %v, %v", v, ok)
+	}
+}
+
+func TestGetOrLoadCallerCancelDoesNotCancelLoad(t *testing.T) {
+	c := New(NoExpiration, 0)
+	l := newBlockingLoader("value", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := c.GetOrLoad(ctx, "k", l.load, DefaultExpiration)
+		first <- err
+	}()
+	<-l.started
+	second := make(chan interface{}, 1)
+	go func() {
+		v, _ := c.GetOrLoad(context.Background(), "k", l.load, DefaultExpiration)
+		second <- v
+	}()
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller got %v", err)
+	}
+	close(l.release)
+	if v := <-second; v != "value" {
+		t.Fatalf("other caller got %v", v)
+	}
+	if l.ctxErr != nil {
+		t.Fatalf("load context failed with %v", l.ctxErr)
+	}
+	if _, ok := c.Get("k"); !ok {
+		t.Fatal("loaded value not stored")
+	}
+}
+
+func TestGetOrLoadStaleWhileRevalidate(t *testing.T) {
+	c := NewWithOptions(Options{Load: LoadOptions{StaleWhileRevalidate: time.Hour}})
+	c.Set("k", "old", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	l := newBlockingLoader("new", nil)
+	v, err := c.GetOrLoad(context.Background(), "k", l.load, time.Hour)
+	if err != nil || v != "old" {
+		t.Fatalf("GetOrLoad = %v, %v, want the stale value", v, err)
+	}
+	if _, ok := c.Get("k"); ok {
+		t.Fatal("Get served the stale value")
+	}
+	// The janitor keeps the stale value during the window.
+	c.DeleteExpired()
+	<-l.started
+	if v, _ := c.GetOrLoad(context.Background(), "k", l.load, time.Hour); v != "old" {
+		t.Fatalf("second GetOrLoad = %v, want the stale value", v)
+	}
+	close(l.release)
+	waitFor(t, func() bool {
+		v, ok := c.Get("k")
+		return ok && v == "new"
+	})
+	if l.calls != 1 {
+		t.Fatalf("loader called %d times, want 1", l.calls)
+	}
+}
+
+func TestGetOrLoadNegativeTTL(t *testing.T) {
+	c := NewWithOptions(Options{Load: LoadOptions{NegativeTTL: 20 * time.Millisecond}})
+	var calls int32
+	missing := func(ctx context.Context, k string) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, ErrNotFound
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetOrLoad(context.Background(), "k", missing, DefaultExpiration); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetOrLoad = %v, want ErrNotFound", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("loader called %d times within NegativeTTL, want 1", calls)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	_, _ = c.GetOrLoad(context.Background(), "k", missing, DefaultExpiration)
+	if calls != 2 {
+		t.Fatalf("loader called %d times after NegativeTTL, want 2", calls)
+	}
+
+	// Set forgets the negative entry.
+	c.Set("k", "value", NoExpiration)
+	c.Delete("k")
+	_, _ = c.GetOrLoad(context.Background(), "k", missing, DefaultExpiration)
+	if calls != 3 {
+		t.Fatalf("loader called %d times after Set, want 3", calls)
+	}
+}
+
+func TestLoadBackoff(t *testing.T) {
+	o := LoadOptions{ErrorBackoff: 10 * time.Millisecond, MaxErrorBackoff: 35 * time.Millisecond}
+	for failures, want := range map[int]time.Duration{1: 10, 2: 20, 3: 35, 10: 35} {
+		if got := o.backoff(failures); got != want*time.Millisecond {
+			t.Errorf("backoff(%d) = %v, want %v", failures, got, want*time.Millisecond)
+		}
+	}
+	if got := (LoadOptions{ErrorBackoff: time.Second}).maxBackoff(); got != 32*time.Second {
+		t.Errorf("default maxBackoff = %v, want 32s", got)
+	}
+}
+
+func TestGetOrLoadErrorBackoff(t *testing.T) {
+	c := NewWithOptions(Options{Load: LoadOptions{ErrorBackoff: 50 * time.Millisecond}})
+	failure := errors.New("backend down")
+	var calls int32
+	failing := func(ctx context.Context, k string) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, failure
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetOrLoad(context.Background(), "k", failing, DefaultExpiration); err != failure {
+			t.Fatalf("GetOrLoad = %v, want the loader error", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("loader called %d times during the backoff, want 1", calls)
+	}
+
+	// The second failure doubles the backoff.
+	time.Sleep(60 * time.Millisecond)
+	_, _ = c.GetOrLoad(context.Background(), "k", failing, DefaultExpiration)
+	time.Sleep(60 * time.Millisecond)
+	_, _ = c.GetOrLoad(context.Background(), "k", failing, DefaultExpiration)
+	if calls != 2 {
+		t.Fatalf("loader called %d times within the doubled backoff, want 2", calls)
+	}
+	time.Sleep(50 * time.Millisecond)
+	_, _ = c.GetOrLoad(context.Background(), "k", failing, DefaultExpiration)
+	if calls != 3 {
+		t.Fatalf("loader called %d times after the doubled backoff, want 3", calls)
+	}
+	if s := c.Stats(); s.Loads != 3 || s.LoadErrors != 3 {
+		t.Fatalf("Loads = %d, LoadErrors = %d", s.Loads, s.LoadErrors)
+	}
+}
+
+func TestSetDuringLoadWins(t *testing.T) {
+	c := New(NoExpiration, 0)
+	l := newBlockingLoader("loaded", nil)
+	result := make(chan interface{}, 1)
+	go func() {
+		v, _ := c.GetOrLoad(context.Background(), "k", l.load, DefaultExpiration)
+		result <- v
+	}()
+	<-l.started
+	c.Set("k", "written", NoExpiration)
+	close(l.release)
+
+	if v := <-result; v != "loaded" {
+		t.Fatalf("caller got %v, want the loaded value", v)
+	}
+	if v, _ := c.Get("k"); v != "written" {
+		t.Fatalf("Get = %v, the stale load overwrote the Set", v)
+	}
+}
+
+func TestDeleteDuringLoadWins(t *testing.T) {
+	c := New(NoExpiration, 0)
+	l := newBlockingLoader("loaded", nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = c.GetOrLoad(context.Background(), "k", l.load, DefaultExpiration)
+	}()
+	<-l.started
+	c.Delete("k")
+	close(l.release)
+	<-done
+
+	if _, ok := c.Get("k"); ok {
+		t.Fatal("the stale load resurrected a deleted key")
+	}
+	// The next load isn't stale.
+	v, err := c.GetOrLoad(context.Background(), "k", func(ctx context.Context, k string) (interface{}, error) {
+		return "reloaded", nil
+	}, DefaultExpiration)
+	if err != nil || v != "reloaded" {
+		t.Fatalf("GetOrLoad = %v, %v", v, err)
+	}
+	if v, _ := c.Get("k"); v != "reloaded" {
+		t.Fatalf("Get = %v", v)
+	}
+}
+
+func TestGetOrLoadRecoversPanics(t *testing.T) {
+	c := New(NoExpiration, 0)
+	_, err := c.GetOrLoad(context.Background(), "k", func(ctx context.Context, k string) (interface{}, error) {
+		panic("boom")
+	}, DefaultExpiration)
+	if err == nil {
+		t.Fatal("panicking loader returned no error")
+	}
+}
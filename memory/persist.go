@@ -0,0 +1,641 @@
+package memory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A persistent cache (see NewPersistent()) survives restarts and crashes:
+//
+//   - Snapshots: the unexpired items are written to PersistOptions.Path every
+//     Interval and on Close(). A snapshot is written to a temporary file,
+//     synced and atomically renamed over the previous one, so a crash never
+//     leaves a half-written snapshot behind. The items are copied under the
+//     lock and encoded after releasing it, so writers are only blocked for
+//     the copy, not for the whole dump.
+//   - Append-only log: with AppendOnly, every change is also appended to
+//     Path + ".aof" as a checksummed record, synced every SyncInterval.
+//     Changes are queued under the lock in the order they are made; a writer
+//     goroutine encodes and appends them, so writers don't wait for the
+//     encoding or the disk. Each
+//     snapshot rotates the log: changes made while the snapshot is written go
+//     to a new log, and the old one (Path + ".aof.1") is removed once the
+//     snapshot is in place.
+//   - Restore: on startup the snapshot is loaded and the logs are replayed.
+//     Records hold the resulting item (never "increment by n"), so replaying
+//     a log that is already part of the snapshot is harmless. A torn record
+//     at the end of a log, left by a crash, ends the replay.
+//
+// Expired items are neither snapshotted nor restored. Snapshots are encoded
+// with a Codec: GobCodec (default, keeps concrete types that are registered
+// with gob.Register()), JSONCodec or MsgpackCodec, the latter two readable by
+// other tools but restoring values as generic maps, slices, strings, numbers
+// and booleans.
+
+const snapshotVersion = 1
+
+// PersistOptions Configures the persistence of a cache created by NewPersistent().
+type PersistOptions struct {
+	// Path Is the snapshot file.
+	Path string
+	// Interval Is the time between background snapshots, 0 means only Close()
+	// and Snapshot() write one.
+	Interval time.Duration
+	// Codec Encodes snapshots and log records, defaults to GobCodec.
+	Codec Codec
+	// AppendOnly Logs every change to Path + ".aof" between snapshots.
+	AppendOnly bool
+	// SyncInterval Is the time between syncs of the log to disk, defaults to
+	// one second. A negative value syncs as soon as changes are appended.
+	SyncInterval time.Duration
+}
+
+// snapshot Is the content of a snapshot file.
+type snapshot struct {
+	Version   int            `json:"version" msgpack:"version"`
+	CreatedAt int64          `json:"created_at" msgpack:"created_at"`
+	Items     []snapshotItem `json:"items" msgpack:"items"`
+}
+
+type snapshotItem struct {
+	Key        string      `json:"key" msgpack:"key"`
+	Object     interface{} `json:"object,omitempty" msgpack:"object,omitempty"`
+	Expiration int64       `json:"expiration" msgpack:"expiration"`
+	Idle       int64       `json:"idle,omitempty" msgpack:"idle,omitempty"`
+	Deadline   int64       `json:"deadline,omitempty" msgpack:"deadline,omitempty"`
+	Tags       []string    `json:"tags,omitempty" msgpack:"tags,omitempty"`
+}
+
+const (
+	aofSet uint8 = iota + 1
+	aofDelete
+	aofFlush
+)
+
+// aofRecord Is one change in the append-only log.
+type aofRecord struct {
+	Op         uint8       `json:"op" msgpack:"op"`
+	Key        string      `json:"key,omitempty" msgpack:"key,omitempty"`
+	Object     interface{} `json:"object,omitempty" msgpack:"object,omitempty"`
+	Expiration int64       `json:"expiration,omitempty" msgpack:"expiration,omitempty"`
+	Idle       int64       `json:"idle,omitempty" msgpack:"idle,omitempty"`
+	Deadline   int64       `json:"deadline,omitempty" msgpack:"deadline,omitempty"`
+	Tags       []string    `json:"tags,omitempty" msgpack:"tags,omitempty"`
+}
+
+// persister Writes the snapshots and the append-only log of a cache.
+type persister struct {
+	options  PersistOptions
+	snapshot sync.Mutex // serializes snapshots
+	appends  sync.Mutex // serializes appending the pending records
+	mu       sync.Mutex // protects the log and the pending records
+	pending  []aofRecord
+	file     *os.File
+	writer   *bufio.Writer
+	dirty    bool
+	err      error // first error writing the log
+	wake     chan struct{}
+	stop     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewPersistent Return a new cache configured by o (see NewWithOptions()) whose
+// items are restored from, and kept persisted to, p.Path. Call Close() to
+// write a final snapshot and stop persisting.
+func NewPersistent(o Options, p PersistOptions) (*Cache, error) {
+	if p.Path == "" {
+		return nil, errors.New("memory: PersistOptions.Path is required")
+	}
+	if p.Codec == nil {
+		p.Codec = GobCodec{}
+	}
+	if p.SyncInterval == 0 {
+		p.SyncInterval = time.Second
+	}
+
+	C := NewWithOptions(o)
+	c := C.cache
+	if err := c.restore(p); err != nil {
+		return nil, err
+	}
+
+	ps := &persister{options: p, wake: make(chan struct{}, 1), stop: make(chan struct{})}
+	if p.AppendOnly {
+		if err := ps.open(); err != nil {
+			return nil, err
+		}
+		ps.wg.Add(1)
+		go ps.runLog()
+	}
+	c.mu.Lock()
+	c.persist = ps
+	c.mu.Unlock()
+
+	if p.Interval > 0 || (p.AppendOnly && p.SyncInterval > 0) {
+		ps.wg.Add(1)
+		go ps.run(c)
+	}
+	return C, nil
+}
+
+// Snapshot Writes a snapshot of the cache's unexpired items now. It is a no-op
+// for caches created without NewPersistent().
+func (c *cache) Snapshot() error {
+	c.mu.RLock()
+	ps := c.persist
+	c.mu.RUnlock()
+	if ps == nil {
+		return nil
+	}
+	return ps.write(c)
+}
+
+// Close Stops persisting the cache after writing a final snapshot. It is a
+// no-op for caches created without NewPersistent() and after the first call.
+func (c *cache) Close() error {
+	c.mu.RLock()
+	ps := c.persist
+	c.mu.RUnlock()
+	if ps == nil {
+		return nil
+	}
+
+	var err error
+	ps.once.Do(func() {
+		close(ps.stop)
+		ps.wg.Wait()
+		err = ps.write(c)
+
+		c.mu.Lock()
+		c.persist = nil
+		c.mu.Unlock()
+
+		// Append the changes made since the snapshot's rotation.
+		ps.appends.Lock()
+		defer ps.appends.Unlock()
+		ps.appendPending()
+		ps.mu.Lock()
+		defer ps.mu.Unlock()
+		if ps.file != nil {
+			if cerr := ps.closeFile(); err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+// restore Loads the snapshot and replays the logs, skipping expired items.
+func (c *cache) restore(p PersistOptions) error {
+	data, err := os.ReadFile(p.Path)
+	switch {
+	case err == nil:
+		var s snapshot
+		if err = p.Codec.Unmarshal(data, &s); err != nil {
+			return fmt.Errorf("memory: decode snapshot %s: %w", p.Path, err)
+		}
+		now := time.Now().UnixNano()
+		c.mu.Lock()
+		for _, v := range s.Items {
+			if v.Expiration == 0 || v.Expiration > now {
+				c.storeTagged(v.Key, Item{Object: v.Object, Expiration: v.Expiration, Idle: v.Idle, Deadline: v.Deadline}, v.Tags)
+			}
+		}
+		c.mu.Unlock()
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	for _, name := range []string{p.Path + ".aof.1", p.Path + ".aof"} {
+		if err = c.replay(name, p.Codec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replay Applies the records of a log, stopping at the first torn record.
+func (c *cache) replay(name string, codec Codec) error {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var offset int64
+	for {
+		data, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		var rec aofRecord
+		if err != nil || codec.Unmarshal(data, &rec) != nil {
+			// A record torn by a crash: everything before it has been
+			// applied, cut it off so that new records aren't appended
+			// after it.
+			return os.Truncate(name, offset)
+		}
+		offset += recordSize(len(data))
+		switch rec.Op {
+		case aofSet:
+			if rec.Expiration == 0 || rec.Expiration > time.Now().UnixNano() {
+				c.storeTagged(rec.Key, Item{Object: rec.Object, Expiration: rec.Expiration, Idle: rec.Idle, Deadline: rec.Deadline}, rec.Tags)
+			} else {
+				c.delete(rec.Key, EvictionExpired)
+			}
+		case aofDelete:
+			c.delete(rec.Key, EvictionDeleted)
+		case aofFlush:
+			c.items = map[string]Item{}
+			c.size = 0
+			c.expiry.reset()
+			if c.bound != nil {
+				c.bound.reset()
+			}
+			if c.index != nil {
+				c.index.reset()
+			}
+		}
+	}
+}
+
+// run Writes the periodic snapshots and syncs the log.
+func (ps *persister) run(c *cache) {
+	defer ps.wg.Done()
+
+	var snapshots, syncs <-chan time.Time
+	if ps.options.Interval > 0 {
+		t := time.NewTicker(ps.options.Interval)
+		defer t.Stop()
+		snapshots = t.C
+	}
+	if ps.options.AppendOnly && ps.options.SyncInterval > 0 {
+		t := time.NewTicker(ps.options.SyncInterval)
+		defer t.Stop()
+		syncs = t.C
+	}
+	for {
+		select {
+		case <-snapshots:
+			_ = ps.write(c)
+		case <-syncs:
+			ps.flush()
+			ps.mu.Lock()
+			_ = ps.sync()
+			ps.mu.Unlock()
+		case <-ps.stop:
+			return
+		}
+	}
+}
+
+// runLog Appends the pending records to the log whenever records are queued,
+// until the persister stops.
+func (ps *persister) runLog() {
+	defer ps.wg.Done()
+	for {
+		select {
+		case <-ps.wake:
+			ps.flush()
+		case <-ps.stop:
+			ps.flush()
+			return
+		}
+	}
+}
+
+// write Writes a snapshot of c: items are copied and the log rotated under
+// the lock, the encoding and the file are written without holding it.
+func (ps *persister) write(c *cache) error {
+	ps.snapshot.Lock()
+	defer ps.snapshot.Unlock()
+
+	s := snapshot{Version: snapshotVersion}
+	now := time.Now().UnixNano()
+	c.mu.RLock()
+	s.Items = make([]snapshotItem, 0, len(c.items))
+	for k, v := range c.items {
+		if v.Expiration > 0 && now > v.Expiration {
+			continue
+		}
+		item := snapshotItem{Key: k, Object: v.Object, Expiration: v.Expiration, Idle: v.Idle, Deadline: v.Deadline}
+		if c.index != nil {
+			item.Tags = c.index.keyTags[k]
+		}
+		s.Items = append(s.Items, item)
+	}
+	// Rotating while still holding the lock guarantees that every change not
+	// in the copy ends up in the new log.
+	var err error
+	if ps.options.AppendOnly {
+		err = ps.rotate()
+	}
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	s.CreatedAt = now
+
+	data, err := ps.marshal(&s, func() {
+		for _, v := range s.Items {
+			if v.Object != nil {
+				gob.Register(v.Object)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(ps.options.Path, data); err != nil {
+		return err
+	}
+	if ps.options.AppendOnly {
+		if err = os.Remove(ps.options.Path + ".aof.1"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshal Encodes v, registering the types of interface values with gob first
+// when needed.
+func (ps *persister) marshal(v interface{}, register func()) (data []byte, err error) {
+	if _, ok := ps.options.Codec.(GobCodec); ok {
+		defer func() {
+			if x := recover(); x != nil {
+				err = fmt.Errorf("Error registering item types with Gob library")
+			}
+		}()
+		register()
+	}
+	return ps.options.Codec.Marshal(v)
+}
+
+// writeFileAtomic Replaces name with data: the data is written to a temporary
+// file in the same directory, synced, and renamed over name.
+func writeFileAtomic(name string, data []byte) error {
+	dir := filepath.Dir(name)
+	tmp, err := os.CreateTemp(dir, filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	// Sync the directory so the rename itself survives a crash.
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// open Opens the log for appending.
+func (ps *persister) open() error {
+	f, err := os.OpenFile(ps.options.Path+".aof", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	ps.file = f
+	ps.writer = bufio.NewWriter(f)
+	return nil
+}
+
+func (ps *persister) sync() error {
+	if ps.file == nil || !ps.dirty {
+		return nil
+	}
+	if err := ps.writer.Flush(); err != nil {
+		return err
+	}
+	ps.dirty = false
+	return ps.file.Sync()
+}
+
+func (ps *persister) closeFile() error {
+	err := ps.sync()
+	if cerr := ps.file.Close(); err == nil {
+		err = cerr
+	}
+	ps.file, ps.writer = nil, nil
+	return err
+}
+
+// rotate Moves the current log to Path + ".aof.1" and starts a new one. If the
+// previous snapshot failed, the current log is appended to the old one, which
+// is still needed. The pending records are appended to the current log first.
+func (ps *persister) rotate() error {
+	ps.appends.Lock()
+	defer ps.appends.Unlock()
+	ps.appendPending()
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if err := ps.closeFile(); err != nil {
+		return err
+	}
+	current, old := ps.options.Path+".aof", ps.options.Path+".aof.1"
+	if _, err := os.Stat(old); err == nil {
+		if err = appendFile(old, current); err != nil {
+			return err
+		}
+		if err = os.Remove(current); err != nil {
+			return err
+		}
+	} else if err = os.Rename(current, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return ps.open()
+}
+
+func appendFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// log Queues a record for the writer goroutine. It is called with the cache's
+// lock held, so records are in the same order as the changes; the encoding
+// happens without holding it.
+func (ps *persister) log(rec aofRecord) {
+	if ps == nil || !ps.options.AppendOnly {
+		return
+	}
+	ps.mu.Lock()
+	ps.pending = append(ps.pending, rec)
+	ps.mu.Unlock()
+	select {
+	case ps.wake <- struct{}{}:
+	default:
+	}
+}
+
+// flush Appends the pending records to the log.
+func (ps *persister) flush() {
+	ps.appends.Lock()
+	defer ps.appends.Unlock()
+	ps.appendPending()
+}
+
+// appendPending Encodes the pending records and appends them to the log, in
+// order. It is called with ps.appends held, so the records taken by one call
+// are written before those of the next.
+func (ps *persister) appendPending() {
+	ps.mu.Lock()
+	records := ps.pending
+	ps.pending = nil
+	ps.mu.Unlock()
+	if len(records) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	var err error
+	for i := range records {
+		rec := &records[i]
+		data, merr := ps.marshal(rec, func() {
+			if rec.Object != nil {
+				gob.Register(rec.Object)
+			}
+		})
+		if merr != nil {
+			if err == nil {
+				err = merr
+			}
+			continue
+		}
+		_ = writeRecord(&buf, data) // writing to a bytes.Buffer never fails
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.writer != nil && buf.Len() > 0 {
+		_, werr := ps.writer.Write(buf.Bytes())
+		ps.dirty = true
+		if werr == nil && ps.options.SyncInterval < 0 {
+			werr = ps.sync()
+		}
+		if err == nil {
+			err = werr
+		}
+	}
+	if err != nil && ps.err == nil {
+		ps.err = err
+	}
+}
+
+func (ps *persister) logSet(k string, item Item, tags []string) {
+	ps.log(aofRecord{Op: aofSet, Key: k, Object: item.Object, Expiration: item.Expiration, Idle: item.Idle, Deadline: item.Deadline, Tags: tags})
+}
+
+func (ps *persister) logDelete(k string) {
+	ps.log(aofRecord{Op: aofDelete, Key: k})
+}
+
+func (ps *persister) logFlush() {
+	ps.log(aofRecord{Op: aofFlush})
+}
+
+// PersistError Returns the first error that occurred appending to the log, if
+// any. Changes made after such an error may not survive a crash until the next
+// snapshot.
+func (c *cache) PersistError() error {
+	c.mu.RLock()
+	ps := c.persist
+	c.mu.RUnlock()
+	if ps == nil {
+		return nil
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.err
+}
+
+// writeRecord Frames data as: uvarint length, CRC-32 of data, data.
+func writeRecord(w io.Writer, data []byte) error {
+	var header [binary.MaxVarintLen64 + 4]byte
+	n := binary.PutUvarint(header[:], uint64(len(data)))
+	binary.LittleEndian.PutUint32(header[n:], crc32.ChecksumIEEE(data))
+	if _, err := w.Write(header[:n+4]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// maxRecordSize Guards against allocating a huge buffer for a corrupt length.
+const maxRecordSize = 1 << 30
+
+var errTornRecord = errors.New("memory: torn append-only log record")
+
+func recordSize(n int) int64 {
+	var header [binary.MaxVarintLen64]byte
+	return int64(binary.PutUvarint(header[:], uint64(n)) + 4 + n)
+}
+
+func readRecord(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil || size > maxRecordSize {
+		return nil, errTornRecord
+	}
+	var sum [4]byte
+	if _, err = io.ReadFull(r, sum[:]); err != nil {
+		return nil, errTornRecord
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, errTornRecord
+	}
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(sum[:]) {
+		return nil, errTornRecord
+	}
+	return data, nil
+}
@@ -0,0 +1,236 @@
+package memory
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestPersistent(t *testing.T, path string, p PersistOptions) *Cache {
+	t.Helper()
+	p.Path = path
+	c, err := NewPersistent(Options{}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// crashCopy Appends the pending log records and copies the persisted files
+// to a new directory, as if the process had crashed.
+func crashCopy(t *testing.T, c *Cache, path string) string {
+	t.Helper()
+	c.persist.flush()
+	c.persist.mu.Lock()
+	_ = c.persist.sync()
+	c.persist.mu.Unlock()
+
+	dst := filepath.Join(t.TempDir(), filepath.Base(path))
+	for _, suffix := range []string{"", ".aof", ".aof.1"} {
+		data, err := os.ReadFile(path + suffix)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(dst+suffix, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dst
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for name, codec := range map[string]Codec{"gob": GobCodec{}, "json": JSONCodec{}, "msgpack": MsgpackCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cache.snap")
+			c := newTestPersistent(t, path, PersistOptions{Codec: codec})
+			c.Set("plain", "a", NoExpiration)
+			c.Set("expiring", "b", time.Hour)
+			c.Set("expired", "c", time.Millisecond)
+			c.SetWithTags("tagged", "d", NoExpiration, "t1", "t2")
+			time.Sleep(5 * time.Millisecond)
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r := newTestPersistent(t, path, PersistOptions{Codec: codec})
+			defer r.Close()
+			for k, want := range map[string]string{"plain": "a", "expiring": "b", "tagged": "d"} {
+				if v, ok := r.Get(k); !ok || v != want {
+					t.Errorf("Get(%q) = %v, %v, want %q", k, v, ok, want)
+				}
+			}
+			if _, ok := r.Get("expired"); ok {
+				t.Error("expired item restored")
+			}
+			if _, e, _ := r.GetWithExpiration("expiring"); e.IsZero() {
+				t.Error("expiration not restored")
+			}
+			if tags := r.Tags("tagged"); !reflect.DeepEqual(tags, []string{"t1", "t2"}) {
+				t.Errorf("Tags = %v", tags)
+			}
+		})
+	}
+}
+
+func TestAppendOnlyLogRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	c := newTestPersistent(t, path, PersistOptions{AppendOnly: true, SyncInterval: -1})
+	defer c.Close()
+
+	c.Set("gone", 1, NoExpiration)
+	c.Flush()
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 2, NoExpiration)
+	c.Delete("b")
+	if err := c.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	// Changes after the snapshot only live in the log.
+	c.SetWithTags("c", 3, NoExpiration, "t")
+	if err := c.Increment("a", 10); err != nil {
+		t.Fatal(err)
+	}
+	c.Delete("missing")
+
+	r := newTestPersistent(t, crashCopy(t, c, path), PersistOptions{AppendOnly: true})
+	defer r.Close()
+	want := map[string]interface{}{"a": 11, "c": 3}
+	if got := itemObjects(r); !reflect.DeepEqual(got, want) {
+		t.Fatalf("restored %v, want %v", got, want)
+	}
+	if tags := r.Tags("c"); !reflect.DeepEqual(tags, []string{"t"}) {
+		t.Fatalf("Tags = %v", tags)
+	}
+	if err := c.PersistError(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAppendOnlyLogTruncatesTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	c := newTestPersistent(t, path, PersistOptions{AppendOnly: true, SyncInterval: -1})
+	defer c.Close()
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 2, NoExpiration)
+	copied := crashCopy(t, c, path)
+
+	info, err := os.Stat(copied + ".aof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(copied+".aof", os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The start of a record whose data never made it to disk.
+	_, _ = f.Write([]byte{0x20, 1, 2, 3, 4, 5})
+	_ = f.Close()
+
+	r := newTestPersistent(t, copied, PersistOptions{AppendOnly: true, SyncInterval: -1})
+	if got := itemObjects(r); !reflect.DeepEqual(got, map[string]interface{}{"a": 1, "b": 2}) {
+		t.Fatalf("restored %v", got)
+	}
+	truncated, err := os.Stat(copied + ".aof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated.Size() != info.Size() {
+		t.Fatalf("log size %d after restore, want %d", truncated.Size(), info.Size())
+	}
+
+	// Records appended after the truncation are replayed.
+	r.Set("c", 3, NoExpiration)
+	again := newTestPersistent(t, crashCopy(t, r, copied), PersistOptions{AppendOnly: true})
+	defer again.Close()
+	if got := itemObjects(again); !reflect.DeepEqual(got, map[string]interface{}{"a": 1, "b": 2, "c": 3}) {
+		t.Fatalf("restored %v", got)
+	}
+	_ = r.Close()
+}
+
+func TestRecordFraming(t *testing.T) {
+	var buf bytes.Buffer
+	records := [][]byte{[]byte("first"), {}, bytes.Repeat([]byte("x"), 300)}
+	for _, data := range records {
+		if err := writeRecord(&buf, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var size int64
+	for _, data := range records {
+		size += recordSize(len(data))
+	}
+	if size != int64(buf.Len()) {
+		t.Fatalf("recordSize sums to %d, framed %d bytes", size, buf.Len())
+	}
+
+	framed := buf.Bytes()
+	r := bufio.NewReader(bytes.NewReader(framed))
+	for _, want := range records {
+		data, err := readRecord(r)
+		if err != nil || !bytes.Equal(data, want) {
+			t.Fatalf("readRecord = %q, %v, want %q", data, err, want)
+		}
+	}
+	if _, err := readRecord(r); err != io.EOF {
+		t.Fatalf("readRecord at end = %v, want io.EOF", err)
+	}
+
+	corrupt := append([]byte(nil), framed...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	torn := framed[:len(framed)-1]
+	for name, data := range map[string][]byte{"checksum": corrupt, "short": torn} {
+		r := bufio.NewReader(bytes.NewReader(data))
+		var err error
+		for err == nil {
+			_, err = readRecord(r)
+		}
+		if err != errTornRecord {
+			t.Errorf("%s: readRecord = %v, want errTornRecord", name, err)
+		}
+	}
+}
+
+func TestCloseIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	c := newTestPersistent(t, path, PersistOptions{AppendOnly: true, Interval: time.Hour})
+	c.Set("a", 1, NoExpiration)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Close(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestPersistent(t, path, PersistOptions{})
+	defer r.Close()
+	if v, ok := r.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get = %v, %v", v, ok)
+	}
+}
+
+func itemObjects(c *Cache) map[string]interface{} {
+	objects := map[string]interface{}{}
+	for k, v := range c.Items() {
+		objects[k] = v.Object
+	}
+	return objects
+}
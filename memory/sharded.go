@@ -1,33 +1,47 @@
 package memory
 
 import (
+	"context"
 	"crypto/rand"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/maphash"
+	"io"
 	"math"
 	"math/big"
 	insecurity "math/rand"
 	"os"
 	"runtime"
 	"time"
+
+	"github.com/cespare/xxhash/v2"
 )
 
-// This is an experimental and unexported (for now) attempt at making a cache
-// with better algorithmic complexity than the standard one, namely by
-// preventing write locks of the entire cache when an item is added. As of the
-// time of writing, the overhead of selecting buckets results in cache
-// operations being about twice as slow as for the standard cache with small
-// total cache sizes, and faster for larger ones.
+// ShardedCache spreads its items over several independently locked caches
+// (shards), namely by preventing write locks of the entire cache when an item
+// is added. The overhead of selecting a shard makes operations a little
+// slower than those of the standard cache for small, uncontended caches;
+// under concurrent writes it scales much better.
+//
+// The shard of a key is picked by a Hasher: DJB33 (the default), MapHash,
+// XXHash, or any other function. Each shard has its own
+// janitor, and the janitors are staggered over the cleanup interval, so that
+// DeleteExpired never locks all the shards at once.
 //
-// See cache_test.go for a few benchmarks.
+// ShardedCache has the same methods as Cache, with bounds (see
+// NewWithOptions()) applying per shard.
 
-type unexportedShardedCache struct {
+type ShardedCache struct {
 	*shardedCache
+	// See the comment at the bottom of New() for why this wrapper exists.
 }
 
 type shardedCache struct {
-	seed    uint32
-	m       uint32
-	cs      []*cache
-	janitor *shardedJanitor
+	seed uint32
+	m    uint32
+	hash Hasher[string]
+	cs   []*cache
 }
 
 // djb2 with better shuffling. 5x faster than FNV with the hash.Hash overhead.
@@ -62,14 +76,64 @@ func djb33(seed uint32, k string) uint32 {
 	return d ^ (d >> 16)
 }
 
+// Hasher Maps a key to a 32-bit hash used to pick the shard a key lives in.
+// The seed is chosen randomly when the cache is created; a Hasher should feed
+// it into the hash rather than combine it with the result, or keys colliding
+// under one seed collide under every seed.
+type Hasher[K comparable] func(seed uint32, k K) uint32
+
+// DJB33 Is the default string Hasher used by the sharded caches.
+func DJB33(seed uint32, k string) uint32 {
+	return djb33(seed, k)
+}
+
+var mapHashSeed = maphash.MakeSeed()
+
+// MapHash Is a string Hasher backed by hash/maphash, which is slower than DJB33
+// for short keys but of better quality for long or similar keys. The seed is
+// hashed ahead of the key.
+func MapHash(seed uint32, k string) uint32 {
+	var h maphash.Hash
+	h.SetSeed(mapHashSeed)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], seed)
+	h.Write(b[:])
+	h.WriteString(k)
+	sum := h.Sum64()
+	return uint32(sum>>32) ^ uint32(sum)
+}
+
+// XXHash Is a string Hasher backed by xxhash, which like MapHash is slower
+// than DJB33 for short keys but spreads long or similar keys better. The seed
+// is xxhash's own seed.
+func XXHash(seed uint32, k string) uint32 {
+	var d xxhash.Digest
+	d.ResetWithSeed(uint64(seed))
+	d.WriteString(k)
+	sum := d.Sum64()
+	return uint32(sum>>32) ^ uint32(sum)
+}
+
 func (sc *shardedCache) bucket(k string) *cache {
-	return sc.cs[djb33(sc.seed, k)%sc.m]
+	return sc.cs[sc.hash(sc.seed, k)%sc.m]
 }
 
 func (sc *shardedCache) Set(k string, x interface{}, d time.Duration) {
 	sc.bucket(k).Set(k, x, d)
 }
 
+func (sc *shardedCache) SetDefault(k string, x interface{}) {
+	sc.bucket(k).SetDefault(k, x)
+}
+
+func (sc *shardedCache) SetSliding(k string, x interface{}, idle time.Duration) {
+	sc.bucket(k).SetSliding(k, x, idle)
+}
+
+func (sc *shardedCache) SetSlidingWithExpiration(k string, x interface{}, idle, d time.Duration) {
+	sc.bucket(k).SetSlidingWithExpiration(k, x, idle, d)
+}
+
 func (sc *shardedCache) Add(k string, x interface{}, d time.Duration) error {
 	return sc.bucket(k).Add(k, x, d)
 }
@@ -82,6 +146,22 @@ func (sc *shardedCache) Get(k string) (interface{}, bool) {
 	return sc.bucket(k).Get(k)
 }
 
+func (sc *shardedCache) GetWithExpiration(k string) (interface{}, time.Time, bool) {
+	return sc.bucket(k).GetWithExpiration(k)
+}
+
+func (sc *shardedCache) GetAndTouch(k string) (interface{}, bool) {
+	return sc.bucket(k).GetAndTouch(k)
+}
+
+func (sc *shardedCache) Touch(k string) bool {
+	return sc.bucket(k).Touch(k)
+}
+
+func (sc *shardedCache) GetOrLoad(ctx context.Context, k string, loader Loader, d time.Duration) (interface{}, error) {
+	return sc.bucket(k).GetOrLoad(ctx, k, loader, d)
+}
+
 func (sc *shardedCache) Increment(k string, n int64) error {
 	return sc.bucket(k).Increment(k, n)
 }
@@ -94,6 +174,142 @@ func (sc *shardedCache) Decrement(k string, n int64) error {
 	return sc.bucket(k).Decrement(k, n)
 }
 
+func (sc *shardedCache) DecrementFloat(k string, n float64) error {
+	return sc.bucket(k).DecrementFloat(k, n)
+}
+
+func (sc *shardedCache) IncrementInt(k string, n int) (int, error) {
+	return sc.bucket(k).IncrementInt(k, n)
+}
+
+func (sc *shardedCache) IncrementInt8(k string, n int8) (int8, error) {
+	return sc.bucket(k).IncrementInt8(k, n)
+}
+
+func (sc *shardedCache) IncrementInt16(k string, n int16) (int16, error) {
+	return sc.bucket(k).IncrementInt16(k, n)
+}
+
+func (sc *shardedCache) IncrementInt32(k string, n int32) (int32, error) {
+	return sc.bucket(k).IncrementInt32(k, n)
+}
+
+func (sc *shardedCache) IncrementInt64(k string, n int64) (int64, error) {
+	return sc.bucket(k).IncrementInt64(k, n)
+}
+
+func (sc *shardedCache) IncrementUint(k string, n uint) (uint, error) {
+	return sc.bucket(k).IncrementUint(k, n)
+}
+
+func (sc *shardedCache) IncrementUintptr(k string, n uintptr) (uintptr, error) {
+	return sc.bucket(k).IncrementUintptr(k, n)
+}
+
+func (sc *shardedCache) IncrementUint8(k string, n uint8) (uint8, error) {
+	return sc.bucket(k).IncrementUint8(k, n)
+}
+
+func (sc *shardedCache) IncrementUint16(k string, n uint16) (uint16, error) {
+	return sc.bucket(k).IncrementUint16(k, n)
+}
+
+func (sc *shardedCache) IncrementUint32(k string, n uint32) (uint32, error) {
+	return sc.bucket(k).IncrementUint32(k, n)
+}
+
+func (sc *shardedCache) IncrementUint64(k string, n uint64) (uint64, error) {
+	return sc.bucket(k).IncrementUint64(k, n)
+}
+
+func (sc *shardedCache) IncrementFloat32(k string, n float32) (float32, error) {
+	return sc.bucket(k).IncrementFloat32(k, n)
+}
+
+func (sc *shardedCache) IncrementFloat64(k string, n float64) (float64, error) {
+	return sc.bucket(k).IncrementFloat64(k, n)
+}
+
+func (sc *shardedCache) DecrementInt(k string, n int) (int, error) {
+	return sc.bucket(k).DecrementInt(k, n)
+}
+
+func (sc *shardedCache) DecrementInt8(k string, n int8) (int8, error) {
+	return sc.bucket(k).DecrementInt8(k, n)
+}
+
+func (sc *shardedCache) DecrementInt16(k string, n int16) (int16, error) {
+	return sc.bucket(k).DecrementInt16(k, n)
+}
+
+func (sc *shardedCache) DecrementInt32(k string, n int32) (int32, error) {
+	return sc.bucket(k).DecrementInt32(k, n)
+}
+
+func (sc *shardedCache) DecrementInt64(k string, n int64) (int64, error) {
+	return sc.bucket(k).DecrementInt64(k, n)
+}
+
+func (sc *shardedCache) DecrementUint(k string, n uint) (uint, error) {
+	return sc.bucket(k).DecrementUint(k, n)
+}
+
+func (sc *shardedCache) DecrementUintptr(k string, n uintptr) (uintptr, error) {
+	return sc.bucket(k).DecrementUintptr(k, n)
+}
+
+func (sc *shardedCache) DecrementUint8(k string, n uint8) (uint8, error) {
+	return sc.bucket(k).DecrementUint8(k, n)
+}
+
+func (sc *shardedCache) DecrementUint16(k string, n uint16) (uint16, error) {
+	return sc.bucket(k).DecrementUint16(k, n)
+}
+
+func (sc *shardedCache) DecrementUint32(k string, n uint32) (uint32, error) {
+	return sc.bucket(k).DecrementUint32(k, n)
+}
+
+func (sc *shardedCache) DecrementUint64(k string, n uint64) (uint64, error) {
+	return sc.bucket(k).DecrementUint64(k, n)
+}
+
+func (sc *shardedCache) DecrementFloat32(k string, n float32) (float32, error) {
+	return sc.bucket(k).DecrementFloat32(k, n)
+}
+
+func (sc *shardedCache) DecrementFloat64(k string, n float64) (float64, error) {
+	return sc.bucket(k).DecrementFloat64(k, n)
+}
+
+func (sc *shardedCache) SetWithTags(k string, x interface{}, d time.Duration, tags ...string) {
+	sc.bucket(k).SetWithTags(k, x, d, tags...)
+}
+
+func (sc *shardedCache) Tags(k string) []string {
+	return sc.bucket(k).Tags(k)
+}
+
+// InvalidateTag Delete all items tagged with tag from all shards. Returns the
+// number of items deleted.
+func (sc *shardedCache) InvalidateTag(tag string) int {
+	n := 0
+	for _, v := range sc.cs {
+		n += v.InvalidateTag(tag)
+	}
+	return n
+}
+
+// DeletePrefix Delete all items whose key starts with prefix from all shards.
+// Returns the number of items deleted.
+func (sc *shardedCache) DeletePrefix(prefix string) int {
+	n := 0
+	for _, v := range sc.cs {
+		n += v.DeletePrefix(prefix)
+	}
+	return n
+}
+
 func (sc *shardedCache) Delete(k string) {
 	sc.bucket(k).Delete(k)
 }
@@ -104,88 +320,183 @@ func (sc *shardedCache) DeleteExpired() {
 	}
 }
 
-// Returns the items in the cache. This may include items that have expired,
-// but have not yet been cleaned up. If this is significant, the Expiration
-// fields of the items should be checked. Note that explicit synchronization
-// is needed to use a cache and its corresponding Items() return values at
-// the same time, as the maps are shared.
-func (sc *shardedCache) Items() []map[string]Item {
-	res := make([]map[string]Item, len(sc.cs))
-	for i, v := range sc.cs {
-		res[i] = v.Items()
+func (sc *shardedCache) OnEvicted(f func(string, interface{})) {
+	for _, v := range sc.cs {
+		v.OnEvicted(f)
 	}
-	return res
 }
 
-func (sc *shardedCache) Flush() {
+func (sc *shardedCache) OnEvictedWithReason(f func(string, interface{}, EvictionReason)) {
 	for _, v := range sc.cs {
-		v.Flush()
+		v.OnEvictedWithReason(f)
 	}
 }
 
-type shardedJanitor struct {
-	Interval time.Duration
-	stop     chan bool
+// Save Write the items of all shards (using Gob) to an io.Writer, in the same
+// format as Cache.Save(), so the two can load each other's output.
+func (sc *shardedCache) Save(w io.Writer) (err error) {
+	enc := gob.NewEncoder(w)
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("Error registering item types with Gob library")
+		}
+	}()
+	items := sc.Items()
+	for _, v := range items {
+		gob.Register(v.Object)
+	}
+	err = enc.Encode(&items)
+	return
 }
 
-func (j *shardedJanitor) Run(sc *shardedCache) {
-	j.stop = make(chan bool)
-	tick := time.Tick(j.Interval)
-	for {
-		select {
-		case <-tick:
-			sc.DeleteExpired()
-		case <-j.stop:
-			return
+func (sc *shardedCache) SaveFile(fname string) error {
+	fp, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	err = sc.Save(fp)
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// Load Add (Gob-serialized) cache items from an io.Reader, excluding any items with
+// keys that already exist (and haven't expired) in the current cache.
+func (sc *shardedCache) Load(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+	items := map[string]Item{}
+	if err := dec.Decode(&items); err != nil {
+		return err
+	}
+	shards := make([]map[string]Item, len(sc.cs))
+	for k, v := range items {
+		i := sc.hash(sc.seed, k) % sc.m
+		if shards[i] == nil {
+			shards[i] = map[string]Item{}
+		}
+		shards[i][k] = v
+	}
+	for i, m := range shards {
+		if m != nil {
+			sc.cs[i].loadItems(m)
+		}
+	}
+	return nil
+}
+
+func (sc *shardedCache) LoadFile(fname string) error {
+	fp, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	err = sc.Load(fp)
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// Items Copies all unexpired items of all shards into a new map and returns it.
+func (sc *shardedCache) Items() map[string]Item {
+	m := make(map[string]Item, sc.ItemCount())
+	for _, v := range sc.cs {
+		for k, item := range v.Items() {
+			m[k] = item
 		}
 	}
+	return m
 }
 
-func stopShardedJanitor(sc *unexportedShardedCache) {
-	sc.janitor.stop <- true
+// ItemCount Returns the number of items in all shards. This may include items
+// that have expired, but have not yet been cleaned up.
+func (sc *shardedCache) ItemCount() int {
+	n := 0
+	for _, v := range sc.cs {
+		n += v.ItemCount()
+	}
+	return n
+}
+
+func (sc *shardedCache) Flush() {
+	for _, v := range sc.cs {
+		v.Flush()
+	}
 }
 
-func runShardedJanitor(sc *shardedCache, ci time.Duration) {
-	j := &shardedJanitor{
-		Interval: ci,
+func stopShardedJanitor(sc *ShardedCache) {
+	for _, c := range sc.cs {
+		c.janitor.stop <- true
 	}
-	sc.janitor = j
-	go j.Run(sc)
 }
 
-func newShardedCache(n int, de time.Duration) *shardedCache {
+// newSeed Returns a random hash seed, so that shard selection can't be
+// predicted (and abused) by whoever controls the keys.
+func newSeed() uint32 {
 	max := big.NewInt(0).SetUint64(uint64(math.MaxUint32))
 	rnd, err := rand.Int(rand.Reader, max)
-	var seed uint32
 	if err != nil {
 		os.Stderr.Write([]byte("WARNING: go-cache's newShardedCache failed to read from the system CSPRNG (/dev/urandom or equivalent.) Your system's security may be compromised. Continuing with an insecure seed.\n"))
-		seed = insecurity.Uint32()
-	} else {
-		seed = uint32(rnd.Uint64())
+		return insecurity.Uint32()
+	}
+	return uint32(rnd.Uint64())
+}
+
+func newShardedCache(n int, o Options, hash Hasher[string]) *shardedCache {
+	if hash == nil {
+		hash = DJB33
 	}
 	sc := &shardedCache{
-		seed: seed,
+		seed: newSeed(),
 		m:    uint32(n),
+		hash: hash,
 		cs:   make([]*cache, n),
 	}
+	// Bounds are split evenly over the shards, each shard evicting on its own.
+	if o.MaxEntries > 0 {
+		o.MaxEntries = (o.MaxEntries + n - 1) / n
+	}
+	if o.MaxCost > 0 {
+		o.MaxCost = (o.MaxCost + int64(n) - 1) / int64(n)
+	}
 	for i := 0; i < n; i++ {
-		c := &cache{
-			defaultExpiration: de,
-			items:             map[string]Item{},
+		c := newCache(o.DefaultExpiration, map[string]Item{})
+		c.bound = newBound(o)
+		c.onEvictedReason = o.OnEvicted
+		c.load = o.Load
+		if o.PrefixIndex {
+			c.index = newIndex(true)
 		}
 		sc.cs[i] = c
 	}
 	return sc
 }
 
-func unexportedNewSharded(defaultExpiration, cleanupInterval time.Duration, shards int) *unexportedShardedCache {
-	if defaultExpiration == 0 {
-		defaultExpiration = -1
+// NewSharded Return a new cache with a given default expiration duration and
+// cleanup interval (see New()), split into the given number of shards that
+// are picked with DJB33.
+func NewSharded(defaultExpiration, cleanupInterval time.Duration, shards int) *ShardedCache {
+	return NewShardedWithOptions(Options{
+		DefaultExpiration: defaultExpiration,
+		CleanupInterval:   cleanupInterval,
+	}, shards, nil)
+}
+
+// NewShardedWithOptions Return a new cache configured by o (see NewWithOptions()),
+// split into the given number of shards that are picked with hash. MaxEntries
+// and MaxCost are split evenly over the shards. A nil hash means DJB33.
+func NewShardedWithOptions(o Options, shards int, hash Hasher[string]) *ShardedCache {
+	if shards < 1 {
+		shards = 1
 	}
-	sc := newShardedCache(shards, defaultExpiration)
-	SC := &unexportedShardedCache{sc}
-	if cleanupInterval > 0 {
-		runShardedJanitor(sc, cleanupInterval)
+	sc := newShardedCache(shards, o, hash)
+	SC := &ShardedCache{sc}
+	if ci := o.CleanupInterval; ci > 0 {
+		for i, c := range sc.cs {
+			c.janitor = startJanitor(c, ci, ci*time.Duration(i)/time.Duration(shards))
+		}
 		runtime.SetFinalizer(SC, stopShardedJanitor)
 	}
 	return SC
@@ -0,0 +1,84 @@
+package memory
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Contention benchmarks of the single-lock Cache against ShardedCache, run with
+// go test -bench Contention -cpu 1,4,16
+const benchKeys = 1 << 12
+
+func benchKeySet() []string {
+	keys := make([]string, benchKeys)
+	for i := range keys {
+		keys[i] = "bench:key:" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+type benchStore interface {
+	Set(k string, x interface{}, d time.Duration)
+	Get(k string) (interface{}, bool)
+}
+
+// benchContention Does one write every writeEvery operations and reads otherwise.
+func benchContention(b *testing.B, s benchStore, writeEvery int) {
+	keys := benchKeySet()
+	for _, k := range keys {
+		s.Set(k, 1, DefaultExpiration)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			k := keys[i%benchKeys]
+			if i%writeEvery == 0 {
+				s.Set(k, i, DefaultExpiration)
+			} else {
+				s.Get(k)
+			}
+			i++
+		}
+	})
+}
+
+func benchContentionMatrix(b *testing.B, writeEvery int) {
+	b.Run("Cache", func(b *testing.B) {
+		benchContention(b, New(time.Minute, 0), writeEvery)
+	})
+	for _, h := range []struct {
+		name string
+		hash Hasher[string]
+	}{{"DJB33", DJB33}, {"MapHash", MapHash}, {"XXHash", XXHash}} {
+		b.Run("Sharded/"+h.name, func(b *testing.B) {
+			benchContention(b, NewShardedWithOptions(Options{DefaultExpiration: time.Minute}, 32, h.hash), writeEvery)
+		})
+	}
+}
+
+func BenchmarkContentionReadMostly(b *testing.B) {
+	benchContentionMatrix(b, 10)
+}
+
+func BenchmarkContentionWriteHeavy(b *testing.B) {
+	benchContentionMatrix(b, 2)
+}
+
+func BenchmarkContentionWriteOnly(b *testing.B) {
+	benchContentionMatrix(b, 1)
+}
+
+func benchHasher(b *testing.B, hash Hasher[string]) {
+	keys := benchKeySet()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hash(7, keys[i%benchKeys])
+	}
+}
+
+func BenchmarkHasherDJB33(b *testing.B)   { benchHasher(b, DJB33) }
+func BenchmarkHasherMapHash(b *testing.B) { benchHasher(b, MapHash) }
+func BenchmarkHasherXXHash(b *testing.B)  { benchHasher(b, XXHash) }
@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestShardedCache(t *testing.T) {
+	for name, hash := range map[string]Hasher[string]{"djb33": DJB33, "maphash": MapHash, "xxhash": XXHash} {
+		t.Run(name, func(t *testing.T) {
+			sc := NewShardedWithOptions(Options{DefaultExpiration: time.Hour}, 8, hash)
+			const n = 1000
+			for i := 0; i < n; i++ {
+				sc.Set("key:"+strconv.Itoa(i), i, DefaultExpiration)
+			}
+			if got := sc.ItemCount(); got != n {
+				t.Fatalf("ItemCount = %d, want %d", got, n)
+			}
+			for i := 0; i < n; i++ {
+				if v, ok := sc.Get("key:" + strconv.Itoa(i)); !ok || v != i {
+					t.Fatalf("Get(key:%d) = %v, %v", i, v, ok)
+				}
+			}
+			for i, c := range sc.cs {
+				if c.ItemCount() == 0 {
+					t.Errorf("shard %d is empty", i)
+				}
+			}
+			if items := sc.Items(); len(items) != n || items["key:7"].Object != 7 {
+				t.Fatalf("Items holds %d items, key:7 = %v", len(items), items["key:7"].Object)
+			}
+
+			if err := sc.Add("key:1", 0, DefaultExpiration); err == nil {
+				t.Error("Add of an existing key succeeded")
+			}
+			if _, err := sc.IncrementInt("key:2", 40); err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := sc.Get("key:2"); v != 42 {
+				t.Errorf("incremented key:2 = %v, want 42", v)
+			}
+			sc.Delete("key:3")
+			if _, ok := sc.Get("key:3"); ok {
+				t.Error("deleted key found")
+			}
+			sc.Flush()
+			if got := sc.ItemCount(); got != 0 {
+				t.Fatalf("ItemCount after Flush = %d", got)
+			}
+		})
+	}
+}
+
+func TestShardedCacheTagsAndPrefixes(t *testing.T) {
+	sc := NewShardedWithOptions(Options{PrefixIndex: true}, 4, DJB33)
+	for i := 0; i < 20; i++ {
+		k := "user:" + strconv.Itoa(i)
+		if i%2 == 0 {
+			sc.SetWithTags(k, i, NoExpiration, "even")
+		} else {
+			sc.Set(k, i, NoExpiration)
+		}
+	}
+	sc.Set("order:1", 1, NoExpiration)
+	if got := sc.InvalidateTag("even"); got != 10 {
+		t.Fatalf("InvalidateTag removed %d items, want 10", got)
+	}
+	if got := sc.DeletePrefix("user:"); got != 10 {
+		t.Fatalf("DeletePrefix removed %d items, want 10", got)
+	}
+	if got := sc.ItemCount(); got != 1 {
+		t.Fatalf("ItemCount = %d, want 1", got)
+	}
+}
+
+func TestShardedCacheBoundsPerShard(t *testing.T) {
+	sc := NewShardedWithOptions(Options{MaxEntries: 100}, 4, XXHash)
+	for i := 0; i < 1000; i++ {
+		sc.Set("key:"+strconv.Itoa(i), i, NoExpiration)
+	}
+	for i, c := range sc.cs {
+		if got := c.ItemCount(); got > 25 {
+			t.Errorf("shard %d holds %d items, want at most 25", i, got)
+		}
+	}
+}
+
+func TestShardedCacheSaveLoad(t *testing.T) {
+	sc := NewSharded(NoExpiration, 0, 4)
+	sc.Set("a", 1, NoExpiration)
+	sc.Set("b", "two", NoExpiration)
+	var buf bytes.Buffer
+	if err := sc.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewSharded(NoExpiration, 0, 8)
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := loaded.Get("b"); !ok || v != "two" {
+		t.Fatalf("Get(b) = %v, %v", v, ok)
+	}
+}
+
+// Hashers mixing the seed into their result keep the keys that collide under
+// one seed colliding under every seed: h(s, a) ^ h(s, b) doesn't depend on s.
+func TestHashersAreSeeded(t *testing.T) {
+	for name, hash := range map[string]Hasher[string]{"djb33": DJB33, "maphash": MapHash, "xxhash": XXHash} {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				a, b := "key:"+strconv.Itoa(i), "key:"+strconv.Itoa(i+100)
+				if hash(1, a)^hash(1, b) != hash(2, a)^hash(2, b) {
+					return
+				}
+			}
+			t.Fatal("the seed doesn't change which keys collide")
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Items written with SetSliding() expire after they haven't been accessed for
+// their idle timeout, e.g. sessions. Their Expiration always holds the
+// effective expiration time, the earlier of the last access plus the idle
+// timeout and the absolute Deadline (if any), so that Get(), the janitor,
+// GetWithExpiration() and the expiry heap treat them like any other item.
+//
+// Only GetAndTouch() and Touch() count as an access: Get() stays a read-locked
+// lookup that doesn't write to the cache.
+
+// slide Returns the item with its idle timeout restarted at now.
+func (item Item) slide(now int64) Item {
+	if item.Idle <= 0 {
+		return item
+	}
+	item.Expiration = now + item.Idle
+	if item.Deadline > 0 && item.Deadline < item.Expiration {
+		item.Expiration = item.Deadline
+	}
+	return item
+}
+
+// SetSliding Add an item to the cache, replacing any existing item, which expires
+// once it hasn't been accessed with GetAndTouch() or Touch() for idle.
+func (c *cache) SetSliding(k string, x interface{}, idle time.Duration) {
+	c.SetSlidingWithExpiration(k, x, idle, NoExpiration)
+}
+
+// SetSlidingWithExpiration Add an item to the cache like SetSliding(), which also
+// expires after d however often it is accessed. d has the same meaning as for
+// Set(): DefaultExpiration uses the cache's default expiration time and
+// NoExpiration leaves only the idle timeout.
+func (c *cache) SetSlidingWithExpiration(k string, x interface{}, idle, d time.Duration) {
+	if idle <= 0 {
+		c.Set(k, x, d)
+		return
+	}
+	now := time.Now().UnixNano()
+	if d == DefaultExpiration {
+		d = c.defaultExpiration
+	}
+	item := Item{Object: x, Idle: int64(idle)}
+	if d > 0 {
+		item.Deadline = now + int64(d)
+	}
+	c.mu.Lock()
+	evicted := c.store(k, item.slide(now))
+	c.mu.Unlock()
+	if evicted != nil {
+		c.evict(evicted)
+	}
+}
+
+// Touch Restart the idle timeout of the item stored under k. Returns false if
+// the key wasn't found or has expired.
+func (c *cache) Touch(k string) bool {
+	_, found := c.GetAndTouch(k)
+	return found
+}
+
+// GetAndTouch Get an item from the cache like Get(), restarting its idle timeout
+// if it was written with SetSliding().
+func (c *cache) GetAndTouch(k string) (interface{}, bool) {
+	now := time.Now().UnixNano()
+	c.mu.Lock()
+	item, found := c.items[k]
+	if !found || (item.Expiration > 0 && now > item.Expiration) {
+		c.mu.Unlock()
+		atomic.AddUint64(&c.stats.misses, 1)
+		return nil, false
+	}
+	if item.Idle > 0 {
+		item = item.slide(now)
+		c.update(k, item)
+		c.expiry.set(k, item.Expiration)
+	}
+	c.mu.Unlock()
+	atomic.AddUint64(&c.stats.hits, 1)
+	if c.bound != nil {
+		c.bound.accessed(k)
+	}
+	return item.Object, true
+}
@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetAndTouchSlides(t *testing.T) {
+	c := New(NoExpiration, 0)
+	c.SetSliding("session", "s", 200*time.Millisecond)
+	_, first, _ := c.GetWithExpiration("session")
+
+	for i := 0; i < 4; i++ {
+		time.Sleep(60 * time.Millisecond)
+		if v, ok := c.GetAndTouch("session"); !ok || v != "s" {
+			t.Fatalf("GetAndTouch after %d accesses = %v, %v", i, v, ok)
+		}
+	}
+	_, moved, ok := c.GetWithExpiration("session")
+	if !ok || !moved.After(first) {
+		t.Fatalf("expiration %v, want it moved past %v", moved, first)
+	}
+	if i, ok := c.expiry.pos["session"]; !ok || c.expiry.entries[i].expiration != moved.UnixNano() {
+		t.Fatal("expiry heap not rescheduled")
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if _, ok := c.GetAndTouch("session"); ok {
+		t.Fatal("item found after its idle timeout")
+	}
+	if c.Touch("session") {
+		t.Fatal("Touch of an expired item = true")
+	}
+}
+
+func TestGetDoesNotSlide(t *testing.T) {
+	c := New(NoExpiration, 0)
+	c.SetSliding("session", "s", 100*time.Millisecond)
+	_, before, _ := c.GetWithExpiration("session")
+	time.Sleep(50 * time.Millisecond)
+	c.Get("session")
+	if _, after, _ := c.GetWithExpiration("session"); !after.Equal(before) {
+		t.Fatalf("Get moved the expiration from %v to %v", before, after)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := c.Get("session"); ok {
+		t.Fatal("item read with Get outlived its idle timeout")
+	}
+}
+
+func TestTouchRespectsDeadline(t *testing.T) {
+	c := New(NoExpiration, 0)
+	c.SetSlidingWithExpiration("session", "s", time.Hour, 30*time.Millisecond)
+	_, e, _ := c.GetWithExpiration("session")
+	if time.Until(e) > 30*time.Millisecond {
+		t.Fatalf("expiration %v beyond the deadline", time.Until(e))
+	}
+	if !c.Touch("session") {
+		t.Fatal("Touch = false")
+	}
+	if _, after, _ := c.GetWithExpiration("session"); !after.Equal(e) {
+		t.Fatalf("Touch moved the expiration past the deadline: %v", time.Until(after))
+	}
+	time.Sleep(40 * time.Millisecond)
+	if c.Touch("session") {
+		t.Fatal("Touch after the deadline = true")
+	}
+}
+
+func TestTouchNonSlidingItem(t *testing.T) {
+	c := New(NoExpiration, 0)
+	c.Set("plain", 1, time.Hour)
+	_, before, _ := c.GetWithExpiration("plain")
+	if v, ok := c.GetAndTouch("plain"); !ok || v != 1 {
+		t.Fatalf("GetAndTouch = %v, %v", v, ok)
+	}
+	if _, after, _ := c.GetWithExpiration("plain"); !after.Equal(before) {
+		t.Fatal("Touch changed the expiration of an item without idle timeout")
+	}
+	if c.Touch("missing") {
+		t.Fatal("Touch of a missing key = true")
+	}
+	// A zero idle timeout is a plain Set.
+	c.SetSliding("zero", 1, 0)
+	if _, e, ok := c.GetWithExpiration("zero"); !ok || !e.IsZero() {
+		t.Fatalf("SetSliding with no idle timeout: expiration %v, found %v", e, ok)
+	}
+}
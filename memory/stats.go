@@ -0,0 +1,239 @@
+package memory
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Every cache (and every shard of a sharded cache) counts its hits, misses,
+// writes, evictions and loads with atomic counters of its own, so counting
+// doesn't add lock contention. Stats() adds them up into a snapshot, which
+// WritePrometheus() and PrometheusHandler() expose in the Prometheus text
+// format without depending on the Prometheus client library.
+
+// cacheStats Are the counters of a cache. It is allocated on its own so that
+// the 64-bit counters are aligned for atomic access on 32-bit platforms.
+type cacheStats struct {
+	hits       uint64
+	misses     uint64
+	sets       uint64
+	overwrites uint64
+	evictions  [EvictionReplaced]uint64 // by reason, overwrites aren't evictions
+	loads      uint64
+	loadErrors uint64
+	loadTime   uint64 // nanoseconds
+}
+
+func (s *cacheStats) evicted(reason EvictionReason) {
+	atomic.AddUint64(&s.evictions[reason], 1)
+}
+
+// replaced Counts an item overwritten by a write: an expired one was evicted
+// by expiration, an unexpired one was overwritten.
+func (s *cacheStats) replaced(expired bool) {
+	if expired {
+		s.evicted(EvictionExpired)
+	} else {
+		atomic.AddUint64(&s.overwrites, 1)
+	}
+}
+
+func (s *cacheStats) loaded(d time.Duration, err error) {
+	atomic.AddUint64(&s.loads, 1)
+	atomic.AddUint64(&s.loadTime, uint64(d))
+	if err != nil && !errors.Is(err, ErrNotFound) && !contextError(err) {
+		atomic.AddUint64(&s.loadErrors, 1)
+	}
+}
+
+// snapshot Returns the counters as Stats, without Entries and EstimatedBytes.
+func (s *cacheStats) snapshot() Stats {
+	stats := Stats{
+		Hits:       atomic.LoadUint64(&s.hits),
+		Misses:     atomic.LoadUint64(&s.misses),
+		Sets:       atomic.LoadUint64(&s.sets),
+		Overwrites: atomic.LoadUint64(&s.overwrites),
+		Evictions:  map[EvictionReason]uint64{},
+		Loads:      atomic.LoadUint64(&s.loads),
+		LoadErrors: atomic.LoadUint64(&s.loadErrors),
+		LoadTime:   time.Duration(atomic.LoadUint64(&s.loadTime)),
+	}
+	for reason := EvictionExpired; reason < EvictionReplaced; reason++ {
+		stats.Evictions[reason] = atomic.LoadUint64(&s.evictions[reason])
+	}
+	return stats
+}
+
+// Stats Is a snapshot of the statistics of a cache.
+type Stats struct {
+	// Hits Is the number of reads that found an unexpired item, including
+	// stale items served by GetOrLoad.
+	Hits uint64
+	// Misses Is the number of reads that found no unexpired item.
+	Misses uint64
+	// Sets Is the number of items written.
+	Sets uint64
+	// Overwrites Is the number of writes that replaced an unexpired item.
+	// They are counted apart from the evictions, which they would inflate.
+	Overwrites uint64
+	// Evictions Is the number of items that left the cache, by reason:
+	// expired (including expired items overwritten), evicted for capacity or
+	// deleted. The EvictionReplaced entry is absent, see Overwrites.
+	Evictions map[EvictionReason]uint64
+	// Loads Is the number of loader calls made by GetOrLoad, LoadErrors the
+	// number of those that failed (ErrNotFound isn't a failure) and LoadTime
+	// the total time spent in them.
+	Loads      uint64
+	LoadErrors uint64
+	LoadTime   time.Duration
+	// Entries Is the number of items in the cache, including expired items
+	// that haven't been cleaned up yet.
+	Entries int
+	// EstimatedBytes Is the estimated memory used by the items: their summed
+	// cost for caches bounded by MaxCost, otherwise a rough estimate.
+	EstimatedBytes int64
+}
+
+// HitRatio Returns the share of reads that were hits, between 0 and 1.
+func (s Stats) HitRatio() float64 {
+	if total := s.Hits + s.Misses; total > 0 {
+		return float64(s.Hits) / float64(total)
+	}
+	return 0
+}
+
+// AverageLoadTime Returns the mean duration of a loader call.
+func (s Stats) AverageLoadTime() time.Duration {
+	if s.Loads == 0 {
+		return 0
+	}
+	return s.LoadTime / time.Duration(s.Loads)
+}
+
+func (s *Stats) add(o Stats) {
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.Sets += o.Sets
+	s.Overwrites += o.Overwrites
+	if s.Evictions == nil {
+		s.Evictions = map[EvictionReason]uint64{}
+	}
+	for reason, n := range o.Evictions {
+		s.Evictions[reason] += n
+	}
+	s.Loads += o.Loads
+	s.LoadErrors += o.LoadErrors
+	s.LoadTime += o.LoadTime
+	s.Entries += o.Entries
+	s.EstimatedBytes += o.EstimatedBytes
+}
+
+// Stats Returns a snapshot of the cache's statistics. The memory used is
+// kept up to date as items are written and removed, so Stats() doesn't walk
+// the items.
+func (c *cache) Stats() Stats {
+	s := c.stats.snapshot()
+	c.mu.RLock()
+	s.Entries = len(c.items)
+	if c.bound != nil && c.bound.maxCost > 0 {
+		s.EstimatedBytes = c.bound.cost
+	} else {
+		s.EstimatedBytes = c.size
+	}
+	c.mu.RUnlock()
+	return s
+}
+
+// Stats Returns the statistics of all shards added up.
+func (sc *shardedCache) Stats() Stats {
+	var s Stats
+	for _, c := range sc.cs {
+		s.add(c.Stats())
+	}
+	return s
+}
+
+// StatsProvider Is a cache whose statistics can be exported, i.e. *Cache,
+// *ShardedCache or *TypedCache.
+type StatsProvider interface {
+	Stats() Stats
+}
+
+// WritePrometheus Writes the statistics of the given caches, keyed by a name
+// used as the "cache" label, in the Prometheus text exposition format.
+func WritePrometheus(w io.Writer, caches map[string]StatsProvider) error {
+	names := make([]string, 0, len(caches))
+	for name := range caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stats := make([]Stats, len(names))
+	for i, name := range names {
+		stats[i] = caches[name].Stats()
+	}
+
+	bw := bufio.NewWriter(w)
+	metric := func(name, kind, help string, value func(s Stats) string) {
+		fmt.Fprintf(bw, "# HELP memory_cache_%s %s\n# TYPE memory_cache_%s %s\n", name, help, name, kind)
+		for i, cache := range names {
+			fmt.Fprintf(bw, "memory_cache_%s{cache=\"%s\"} %s\n", name, escapeLabel(cache), value(stats[i]))
+		}
+	}
+	metric("hits_total", "counter", "Number of cache reads that found an item.", func(s Stats) string {
+		return fmt.Sprint(s.Hits)
+	})
+	metric("misses_total", "counter", "Number of cache reads that found no item.", func(s Stats) string {
+		return fmt.Sprint(s.Misses)
+	})
+	metric("sets_total", "counter", "Number of items written.", func(s Stats) string {
+		return fmt.Sprint(s.Sets)
+	})
+	metric("overwrites_total", "counter", "Number of writes that replaced an unexpired item.", func(s Stats) string {
+		return fmt.Sprint(s.Overwrites)
+	})
+	fmt.Fprint(bw, "# HELP memory_cache_evictions_total Number of items that left the cache, by reason.\n# TYPE memory_cache_evictions_total counter\n")
+	for i, cache := range names {
+		for reason := EvictionExpired; reason < EvictionReplaced; reason++ {
+			fmt.Fprintf(bw, "memory_cache_evictions_total{cache=\"%s\",reason=\"%s\"} %d\n", escapeLabel(cache), reason, stats[i].Evictions[reason])
+		}
+	}
+	metric("loads_total", "counter", "Number of loader calls.", func(s Stats) string {
+		return fmt.Sprint(s.Loads)
+	})
+	metric("load_errors_total", "counter", "Number of failed loader calls.", func(s Stats) string {
+		return fmt.Sprint(s.LoadErrors)
+	})
+	metric("load_duration_seconds_total", "counter", "Total time spent in loader calls.", func(s Stats) string {
+		return fmt.Sprint(s.LoadTime.Seconds())
+	})
+	metric("entries", "gauge", "Number of items in the cache.", func(s Stats) string {
+		return fmt.Sprint(s.Entries)
+	})
+	metric("estimated_bytes", "gauge", "Estimated memory used by the items.", func(s Stats) string {
+		return fmt.Sprint(s.EstimatedBytes)
+	})
+	return bw.Flush()
+}
+
+// PrometheusHandler Returns an http.Handler serving the statistics of the
+// given caches in the Prometheus text exposition format, to be scraped
+// directly or mounted next to an existing /metrics endpoint.
+func PrometheusHandler(caches map[string]StatsProvider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WritePrometheus(w, caches)
+	})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
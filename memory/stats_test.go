@@ -0,0 +1,133 @@
+package memory
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// walkedSize Is the estimated size of c's items, found by walking them.
+func walkedSize(c *Cache) int64 {
+	var size int64
+	for k, v := range c.Items() {
+		size += estimateSize(k, v.Object)
+	}
+	return size
+}
+
+func TestStatsEstimatedBytes(t *testing.T) {
+	c := NewWithOptions(Options{MaxEntries: 20})
+	check := func(step string) {
+		t.Helper()
+		if got, want := c.Stats().EstimatedBytes, walkedSize(c); got != want {
+			t.Fatalf("%s: EstimatedBytes = %d, want %d", step, got, want)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		c.Set("k"+strconv.Itoa(i), strings.Repeat("x", i), NoExpiration)
+	}
+	check("set")
+	c.Set("k1", strings.Repeat("x", 100), NoExpiration)
+	c.Set("k2", "", NoExpiration)
+	check("overwrite")
+	c.Delete("k3")
+	c.Delete("missing")
+	check("delete")
+	for i := 10; i < 40; i++ {
+		c.Set("k"+strconv.Itoa(i), i, NoExpiration)
+	}
+	if n := c.ItemCount(); n != 20 {
+		t.Fatalf("%d items, want MaxEntries", n)
+	}
+	check("capacity eviction")
+	c.Set("short", 1, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	c.DeleteExpired()
+	check("expiration")
+	c.Flush()
+	if got := c.Stats().EstimatedBytes; got != 0 {
+		t.Fatalf("EstimatedBytes = %d after Flush", got)
+	}
+}
+
+func TestStatsOverwritesAreNotEvictions(t *testing.T) {
+	c := New(NoExpiration, 0)
+	c.Set("a", 1, NoExpiration)
+	c.Set("a", 2, NoExpiration)
+	c.Set("b", 1, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	c.Set("b", 2, NoExpiration) // the expired b is evicted, not overwritten
+	c.Delete("a")
+
+	s := c.Stats()
+	if s.Sets != 4 || s.Overwrites != 1 {
+		t.Fatalf("Sets = %d, Overwrites = %d, want 4 and 1", s.Sets, s.Overwrites)
+	}
+	if s.Evictions[EvictionExpired] != 1 || s.Evictions[EvictionDeleted] != 1 {
+		t.Fatalf("Evictions = %v", s.Evictions)
+	}
+	if _, ok := s.Evictions[EvictionReplaced]; ok {
+		t.Fatalf("Evictions = %v, overwrites counted as evictions", s.Evictions)
+	}
+
+	tc := NewTyped[string, int](NoExpiration, 0)
+	tc.Set("a", 1, NoExpiration)
+	tc.Set("a", 2, NoExpiration)
+	if s := tc.Stats(); s.Overwrites != 1 || s.Evictions[EvictionExpired] != 0 {
+		t.Fatalf("typed: Overwrites = %d, Evictions = %v", s.Overwrites, s.Evictions)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	users := New(NoExpiration, 0)
+	users.Set("a", "value", NoExpiration)
+	users.Set("a", "value", NoExpiration)
+	users.Get("a")
+	users.Get("b")
+	users.Delete("a")
+	odd := New(NoExpiration, 0)
+
+	var b strings.Builder
+	caches := map[string]StatsProvider{"users": users, "a \"b\"\\\n": odd}
+	if err := WritePrometheus(&b, caches); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, line := range []string{
+		"# HELP memory_cache_hits_total Number of cache reads that found an item.",
+		"# TYPE memory_cache_hits_total counter",
+		`memory_cache_hits_total{cache="users"} 1`,
+		`memory_cache_misses_total{cache="users"} 1`,
+		`memory_cache_sets_total{cache="users"} 2`,
+		`memory_cache_overwrites_total{cache="users"} 1`,
+		`memory_cache_evictions_total{cache="users",reason="deleted"} 1`,
+		`memory_cache_evictions_total{cache="users",reason="expired"} 0`,
+		"# TYPE memory_cache_entries gauge",
+		`memory_cache_entries{cache="users"} 0`,
+		`memory_cache_estimated_bytes{cache="users"} 0`,
+		`memory_cache_hits_total{cache="a \"b\"\\\n"} 0`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("output lacks %q", line)
+		}
+	}
+	if strings.Contains(out, `reason="replaced"`) {
+		t.Error("overwrites exported as evictions")
+	}
+	// Caches are sorted by name.
+	if strings.Index(out, `hits_total{cache="a `) > strings.Index(out, `hits_total{cache="users"}`) {
+		t.Error("caches not sorted by name")
+	}
+
+	rec := httptest.NewRecorder()
+	PrometheusHandler(caches).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	if rec.Body.String() != out {
+		t.Fatal("handler output differs from WritePrometheus")
+	}
+}
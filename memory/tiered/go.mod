@@ -0,0 +1,17 @@
+module github.com/jjonline/go-lib-backend/memory/tiered
+
+go 1.18
+
+require (
+	github.com/alicebob/miniredis/v2 v2.37.0
+	github.com/jjonline/go-lib-backend/memory v0.0.0-20261018170517-8166ab99a7ea
+	github.com/redis/go-redis/v9 v9.13.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/vmihailenco/msgpack/v5 v5.3.5 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+)
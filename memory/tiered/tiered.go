@@ -0,0 +1,349 @@
+// Package tiered implements a two-level cache: a process-local memory.Cache
+// (L1) in front of Redis (L2), shared by all instances of a service.
+//
+// Get reads L1, then Redis, then calls the loader and fills both levels. Set
+// and Delete write through to Redis and publish the changed keys on a Redis
+// pub/sub channel; every other instance evicts its L1 copy of those keys, so
+// they read the new value from Redis on their next Get.
+//
+// A value loaded after a miss is only written to Redis if the key is still
+// missing there (SET NX) and wasn't set, deleted or invalidated in this
+// instance while it was loaded; the L1 copy of such a stale load is dropped as
+// well. Another instance deleting the key between the check and the write can
+// still leave the loaded value in Redis until L2TTL.
+//
+// Pub/sub is fire-and-forget: invalidations published while an instance is
+// disconnected from Redis are lost, so L1TTL bounds how long such an instance
+// may serve an outdated value.
+package tiered
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jjonline/go-lib-backend/memory"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultL1TTL The default lifetime of L1 copies.
+	DefaultL1TTL = time.Minute
+	// DefaultChannel The default pub/sub channel for invalidation messages.
+	DefaultChannel = "memory:tiered:invalidate"
+)
+
+// ErrClosed Is returned by Set and Delete after Close.
+var ErrClosed = errors.New("tiered: cache closed")
+
+// ErrType Is returned by Get when L1 holds a value of another type under the
+// key, e.g. because L1 is shared with other code.
+var ErrType = errors.New("tiered: unexpected value type in L1")
+
+// Loader Loads the value of a key that is neither in L1 nor in Redis. Return
+// memory.ErrNotFound when the key doesn't exist.
+type Loader[V any] func(ctx context.Context, key string) (V, error)
+
+// Options Configures a tiered cache.
+type Options struct {
+	// L1 Is the local cache, e.g. one created by memory.NewWithOptions() with
+	// bounds and memory.LoadOptions. Defaults to memory.New(L1TTL, L1TTL).
+	L1 *memory.Cache
+	// L1TTL Is the lifetime of L1 copies, defaults to DefaultL1TTL.
+	L1TTL time.Duration
+	// L2TTL Is the lifetime of values in Redis, 0 means no expiration.
+	L2TTL time.Duration
+	// Codec Serializes values stored in Redis, defaults to memory.JSONCodec.
+	Codec memory.Codec
+	// KeyPrefix Is prepended to the keys in Redis.
+	KeyPrefix string
+	// Channel Is the pub/sub channel for invalidation messages, defaults to
+	// DefaultChannel. Caches sharing Redis but holding different data should
+	// use different channels.
+	Channel string
+}
+
+// Cache Is a two-level cache of values of type V.
+type Cache[V any] struct {
+	client  redis.UniversalClient
+	l1      *memory.Cache
+	options Options
+	origin  string // identifies this instance's own invalidation messages
+	pubsub  *redis.PubSub
+	done    chan struct{}
+	once    sync.Once
+
+	mu    sync.Mutex
+	fills map[string]*fill // loads in flight, by key
+}
+
+// fill Tracks the loads of a key in flight. epoch is bumped whenever the key
+// is set, deleted or invalidated, which makes the loads started before stale.
+type fill struct {
+	loads int
+	epoch uint64
+}
+
+// invalidation Is the pub/sub message telling the other instances to evict keys.
+type invalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys"`
+}
+
+// New Creates a two-level cache using client, which may be the same
+// single-node, sentinel or cluster client used by queue and defense, and
+// starts listening for invalidation messages. Close stops listening.
+func New[V any](client redis.UniversalClient, options Options) (*Cache[V], error) {
+	if options.L1TTL <= 0 {
+		options.L1TTL = DefaultL1TTL
+	}
+	if options.L1 == nil {
+		options.L1 = memory.New(options.L1TTL, options.L1TTL)
+	}
+	if options.Codec == nil {
+		options.Codec = memory.JSONCodec{}
+	}
+	if options.Channel == "" {
+		options.Channel = DefaultChannel
+	}
+
+	origin := make([]byte, 8)
+	if _, err := rand.Read(origin); err != nil {
+		return nil, err
+	}
+
+	c := &Cache[V]{
+		client:  client,
+		l1:      options.L1,
+		options: options,
+		origin:  hex.EncodeToString(origin),
+		done:    make(chan struct{}),
+		fills:   map[string]*fill{},
+	}
+
+	// Wait for the subscription to be confirmed, so that no invalidation
+	// published after New returns is missed.
+	ctx := context.Background()
+	c.pubsub = client.Subscribe(ctx, options.Channel)
+	if _, err := c.pubsub.Receive(ctx); err != nil {
+		_ = c.pubsub.Close()
+		return nil, err
+	}
+	go c.listen()
+	return c, nil
+}
+
+// Get Returns the value of key from L1, Redis or loader, in that order. Values
+// found in Redis are copied to L1; loaded values are written to both levels.
+// Concurrent misses of one key in this instance share one lookup.
+func (c *Cache[V]) Get(ctx context.Context, key string, loader Loader[V]) (V, error) {
+	var zero V
+	v, err := c.l1.GetOrLoad(ctx, key, func(ctx context.Context, key string) (interface{}, error) {
+		return c.load(ctx, key, loader)
+	}, c.options.L1TTL)
+	if err != nil {
+		return zero, err
+	}
+	value, ok := v.(V)
+	if !ok {
+		return zero, fmt.Errorf("%w: %s holds %T", ErrType, key, v)
+	}
+	return value, nil
+}
+
+// load Reads key from Redis, falling back to loader on a miss. When Redis is
+// unavailable the value is still loaded, so Redis outages degrade to loading
+// from the backend instead of failing.
+func (c *Cache[V]) load(ctx context.Context, key string, loader Loader[V]) (interface{}, error) {
+	if v, ok := c.get(ctx, key); ok {
+		return v, nil
+	}
+
+	f, epoch := c.beginFill(key)
+	defer c.endFill(key, f)
+	v, err := loader(ctx, key)
+	if err != nil || !c.fresh(f, epoch) {
+		return v, err
+	}
+	data, err := c.options.Codec.Marshal(v)
+	if err != nil {
+		return v, nil
+	}
+	stored, err := c.client.SetNX(ctx, c.redisKey(key), data, c.options.L2TTL).Result()
+	if err == nil && !stored {
+		// Another instance stored the key meanwhile, its value is newer.
+		if current, ok := c.get(ctx, key); ok {
+			return current, nil
+		}
+	}
+	return v, nil
+}
+
+// get Reads and decodes key from Redis.
+func (c *Cache[V]) get(ctx context.Context, key string) (V, bool) {
+	var v V
+	data, err := c.client.Get(ctx, c.redisKey(key)).Bytes()
+	if err != nil {
+		return v, false
+	}
+	if c.options.Codec.Unmarshal(data, &v) != nil {
+		return v, false
+	}
+	return v, true
+}
+
+// beginFill Registers a load of key, returning the epoch it starts in.
+func (c *Cache[V]) beginFill(key string) (*fill, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.fills[key]
+	if !ok {
+		f = &fill{}
+		c.fills[key] = f
+	}
+	f.loads++
+	return f, f.epoch
+}
+
+// endFill Unregisters a load of key.
+func (c *Cache[V]) endFill(key string, f *fill) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f.loads--
+	if f.loads == 0 {
+		delete(c.fills, key)
+	}
+}
+
+// fresh Tells whether the key of a load started in epoch hasn't changed since.
+func (c *Cache[V]) fresh(f *fill, epoch uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return f.epoch == epoch
+}
+
+// stale Makes the loads of keys in flight stale.
+func (c *Cache[V]) stale(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if f, ok := c.fills[key]; ok {
+			f.epoch++
+		}
+	}
+}
+
+// evict Drops the L1 copies, negative entries and loads in flight of keys.
+func (c *Cache[V]) evict(keys ...string) {
+	c.stale(keys...)
+	for _, key := range keys {
+		c.l1.Delete(key)
+	}
+}
+
+// Set Writes value to Redis and L1, and tells the other instances to evict
+// their L1 copy of key.
+func (c *Cache[V]) Set(ctx context.Context, key string, value V) error {
+	if c.closed() {
+		return ErrClosed
+	}
+	data, err := c.options.Codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	c.stale(key)
+	if err = c.client.Set(ctx, c.redisKey(key), data, c.options.L2TTL).Err(); err != nil {
+		return err
+	}
+	c.l1.Set(key, value, c.options.L1TTL)
+	return c.publish(ctx, key)
+}
+
+// Delete Removes keys from Redis and from the L1 of every instance.
+func (c *Cache[V]) Delete(ctx context.Context, keys ...string) error {
+	if c.closed() {
+		return ErrClosed
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// One DEL per key, so that keys of different cluster slots can be deleted
+	// in one round trip.
+	c.stale(keys...)
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, c.redisKey(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.evict(keys...)
+	return c.publish(ctx, keys...)
+}
+
+// Invalidate Evicts keys from the L1 of every instance, keeping them in Redis,
+// e.g. after Redis was updated by another program.
+func (c *Cache[V]) Invalidate(ctx context.Context, keys ...string) error {
+	if c.closed() {
+		return ErrClosed
+	}
+	c.evict(keys...)
+	return c.publish(ctx, keys...)
+}
+
+// L1 Returns the local cache.
+func (c *Cache[V]) L1() *memory.Cache {
+	return c.l1
+}
+
+// Close Stops listening for invalidation messages.
+func (c *Cache[V]) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.done)
+		err = c.pubsub.Close()
+	})
+	return err
+}
+
+func (c *Cache[V]) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Cache[V]) redisKey(key string) string {
+	return c.options.KeyPrefix + key
+}
+
+func (c *Cache[V]) publish(ctx context.Context, keys ...string) error {
+	message, err := json.Marshal(invalidation{Origin: c.origin, Keys: keys})
+	if err != nil {
+		return err
+	}
+	return c.client.Publish(ctx, c.options.Channel, message).Err()
+}
+
+// listen Evicts the keys of invalidation messages published by other
+// instances from L1, along with their negative entries, and makes their loads
+// in flight stale. go-redis resubscribes by itself after reconnecting.
+func (c *Cache[V]) listen() {
+	for message := range c.pubsub.Channel() {
+		var inv invalidation
+		if json.Unmarshal([]byte(message.Payload), &inv) != nil || inv.Origin == c.origin {
+			continue
+		}
+		c.evict(inv.Keys...)
+	}
+}
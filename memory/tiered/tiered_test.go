@@ -0,0 +1,215 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jjonline/go-lib-backend/memory"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, redis.UniversalClient) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return mr, client
+}
+
+func newTestCache(t *testing.T, client redis.UniversalClient, options Options) *Cache[int] {
+	t.Helper()
+	c, err := New[int](client, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+// countingLoader Returns value and counts its calls.
+func countingLoader(calls *int32, value int) Loader[int] {
+	return func(ctx context.Context, key string) (int, error) {
+		atomic.AddInt32(calls, 1)
+		return value, nil
+	}
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGetLoadsAndFillsBothLevels(t *testing.T) {
+	mr, client := newTestRedis(t)
+	c := newTestCache(t, client, Options{KeyPrefix: "t:"})
+	ctx := context.Background()
+
+	var calls int32
+	for i := 0; i < 2; i++ {
+		v, err := c.Get(ctx, "k", countingLoader(&calls, 42))
+		if err != nil || v != 42 {
+			t.Fatalf("Get = %v, %v", v, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("loader called %d times, want 1", calls)
+	}
+	if got, err := mr.Get("t:k"); err != nil || got != "42" {
+		t.Fatalf("redis holds %q, %v", got, err)
+	}
+	if v, ok := c.L1().Get("k"); !ok || v != 42 {
+		t.Fatalf("L1 holds %v, %v", v, ok)
+	}
+}
+
+func TestGetReadsRedisBeforeLoading(t *testing.T) {
+	mr, client := newTestRedis(t)
+	c := newTestCache(t, client, Options{})
+	_ = mr.Set("k", "7")
+
+	var calls int32
+	v, err := c.Get(context.Background(), "k", countingLoader(&calls, 42))
+	if err != nil || v != 7 {
+		t.Fatalf("Get = %v, %v", v, err)
+	}
+	if calls != 0 {
+		t.Fatalf("loader called %d times, want 0", calls)
+	}
+	if v, ok := c.L1().Get("k"); !ok || v != 7 {
+		t.Fatalf("L1 holds %v, %v", v, ok)
+	}
+}
+
+func TestGetKeepsValueStoredMeanwhile(t *testing.T) {
+	mr, client := newTestRedis(t)
+	c := newTestCache(t, client, Options{})
+
+	// Another instance stores the key while this one loads it.
+	v, err := c.Get(context.Background(), "k", func(ctx context.Context, key string) (int, error) {
+		_ = mr.Set(key, "2")
+		return 1, nil
+	})
+	if err != nil || v != 2 {
+		t.Fatalf("Get = %v, %v, want the stored value 2", v, err)
+	}
+	if got, _ := mr.Get("k"); got != "2" {
+		t.Fatalf("redis holds %q, want 2", got)
+	}
+}
+
+func TestStaleLoadIsNotWrittenBack(t *testing.T) {
+	mr, client := newTestRedis(t)
+	c := newTestCache(t, client, Options{})
+	ctx := context.Background()
+
+	// The key is deleted while it is loaded, so the loaded value is outdated.
+	_, err := c.Get(ctx, "k", func(ctx context.Context, key string) (int, error) {
+		if err := c.Delete(ctx, key); err != nil {
+			t.Error(err)
+		}
+		return 1, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("k") {
+		t.Fatal("stale load written to redis")
+	}
+	if _, ok := c.L1().Get("k"); ok {
+		t.Fatal("stale load kept in L1")
+	}
+}
+
+func TestSetInvalidatesOtherInstances(t *testing.T) {
+	_, client := newTestRedis(t)
+	a := newTestCache(t, client, Options{})
+	b := newTestCache(t, client, Options{})
+	ctx := context.Background()
+
+	var calls int32
+	if v, err := b.Get(ctx, "k", countingLoader(&calls, 1)); err != nil || v != 1 {
+		t.Fatalf("Get = %v, %v", v, err)
+	}
+	if err := a.Set(ctx, "k", 2); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool {
+		_, ok := b.L1().Get("k")
+		return !ok
+	})
+	if v, err := b.Get(ctx, "k", countingLoader(&calls, 3)); err != nil || v != 2 {
+		t.Fatalf("Get = %v, %v, want 2 from redis", v, err)
+	}
+	// a's own message doesn't evict its L1 copy.
+	if v, ok := a.L1().Get("k"); !ok || v != 2 {
+		t.Fatalf("a's L1 holds %v, %v", v, ok)
+	}
+}
+
+func TestSetClearsNegativeEntriesOfOtherInstances(t *testing.T) {
+	_, client := newTestRedis(t)
+	a := newTestCache(t, client, Options{})
+	b := newTestCache(t, client, Options{L1: memory.NewWithOptions(memory.Options{
+		DefaultExpiration: time.Minute,
+		Load:              memory.LoadOptions{NegativeTTL: time.Minute},
+	})})
+	ctx := context.Background()
+
+	var calls int32
+	missing := func(ctx context.Context, key string) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 0, memory.ErrNotFound
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := b.Get(ctx, "k", missing); !errors.Is(err, memory.ErrNotFound) {
+			t.Fatalf("Get error = %v, want ErrNotFound", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("loader called %d times, want 1 (negative entry)", calls)
+	}
+
+	if err := a.Set(ctx, "k", 5); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool {
+		v, err := b.Get(ctx, "k", missing)
+		return err == nil && v == 5
+	})
+}
+
+func TestGetReportsForeignTypeInL1(t *testing.T) {
+	_, client := newTestRedis(t)
+	l1 := memory.New(time.Minute, 0)
+	c := newTestCache(t, client, Options{L1: l1})
+	l1.Set("k", "not an int", time.Minute)
+
+	var calls int32
+	if _, err := c.Get(context.Background(), "k", countingLoader(&calls, 1)); !errors.Is(err, ErrType) {
+		t.Fatalf("Get error = %v, want ErrType", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	_, client := newTestRedis(t)
+	c := newTestCache(t, client, Options{})
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("second Close = %v", err)
+	}
+	if err := c.Set(context.Background(), "k", 1); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Set after Close = %v, want ErrClosed", err)
+	}
+}
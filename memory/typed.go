@@ -0,0 +1,591 @@
+package memory
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// TypedCache is the generic, type-safe counterpart of Cache. Keys may be of
+// any comparable type and values are stored as V, so no type assertions are
+// needed on the way out.
+//
+// Numeric values are incremented with the package-level Increment and
+// Decrement functions, which are constrained on Number instead of having one
+// method per numeric type.
+//
+// A TypedCache is split into one or more shards, each with its own lock. A
+// cache created with NewTyped has a single shard; NewTypedSharded spreads the
+// keys over several shards using a Hasher, like the sharded string cache.
+// Each shard keeps its expiring keys in the same expiry heap as Cache, can be
+// bounded with the same eviction policies (see NewTypedWithOptions()) and
+// counts the same statistics.
+
+// Number Is the set of value types Increment and Decrement work with.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// TypedItem Is the generic counterpart of Item.
+type TypedItem[V any] struct {
+	Object     V
+	Expiration int64
+}
+
+// Expired Returns true if the item has expired.
+func (item TypedItem[V]) Expired() bool {
+	if item.Expiration == 0 {
+		return false
+	}
+	return time.Now().UnixNano() > item.Expiration
+}
+
+// TypedOptions Configures a cache created by NewTypedWithOptions(). The fields
+// shared with Options have the same meaning.
+type TypedOptions[K comparable, V any] struct {
+	DefaultExpiration time.Duration
+	CleanupInterval   time.Duration
+
+	// MaxEntries, MaxCost, Cost and Policy bound the cache like those of
+	// Options, with bounds applying per shard.
+	MaxEntries int
+	MaxCost    int64
+	Cost       func(k K, x V) int64
+	Policy     EvictionPolicy
+
+	// Shards Is the number of shards, each with its own lock. Defaults to 1.
+	Shards int
+	// Hash Picks the shard of a key and feeds the EvictTinyLFU sketch. It
+	// defaults to a hash of the key's value found by reflection (DJB33 for
+	// string keys), which is slower than a Hasher written for K.
+	Hash Hasher[K]
+}
+
+type TypedCache[K comparable, V any] struct {
+	*typedCache[K, V]
+	// See the comment at the bottom of New() for why this wrapper exists.
+}
+
+type typedCache[K comparable, V any] struct {
+	defaultExpiration time.Duration
+	seed              uint32
+	hash              Hasher[K]
+	shards            []*typedShard[K, V]
+	janitor           *janitor
+}
+
+type typedShard[K comparable, V any] struct {
+	items     map[K]TypedItem[V]
+	mu        sync.RWMutex
+	onEvicted func(K, V)
+	bound     *bound[K, V]
+	expiry    *expiryQueue[K]
+	stats     *cacheStats
+	// size Is the estimated memory used by the items, see estimateSize().
+	size int64
+}
+
+// typedEviction Is an item to pass to the OnEvicted callback once the lock is
+// released.
+type typedEviction[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func (c *typedCache[K, V]) shard(k K) *typedShard[K, V] {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	return c.shards[c.hash(c.seed, k)%uint32(len(c.shards))]
+}
+
+func (c *typedCache[K, V]) expiration(d time.Duration) int64 {
+	if d == DefaultExpiration {
+		d = c.defaultExpiration
+	}
+	if d > 0 {
+		return time.Now().Add(d).UnixNano()
+	}
+	return 0
+}
+
+// Set Add an item to the cache, replacing any existing item. If the duration is 0
+// (DefaultExpiration), the cache's default expiration time is used. If it is -1
+// (NoExpiration), the item never expires.
+func (c *typedCache[K, V]) Set(k K, x V, d time.Duration) {
+	item := TypedItem[V]{
+		Object:     x,
+		Expiration: c.expiration(d),
+	}
+	s := c.shard(k)
+	s.mu.Lock()
+	evicted := s.store(k, item)
+	onEvicted := s.onEvicted
+	s.mu.Unlock()
+	notifyEvicted(onEvicted, evicted)
+}
+
+// SetDefault Add an item to the cache, replacing any existing item, using the default
+// expiration.
+func (c *typedCache[K, V]) SetDefault(k K, x V) {
+	c.Set(k, x, DefaultExpiration)
+}
+
+// Add an item to the cache only if an item doesn't already exist for the given
+// key, or if the existing item has expired. Returns an error otherwise.
+func (c *typedCache[K, V]) Add(k K, x V, d time.Duration) error {
+	item := TypedItem[V]{
+		Object:     x,
+		Expiration: c.expiration(d),
+	}
+	s := c.shard(k)
+	s.mu.Lock()
+	if _, found := s.get(k); found {
+		s.mu.Unlock()
+		return fmt.Errorf("Item %v already exists", k)
+	}
+	evicted := s.store(k, item)
+	onEvicted := s.onEvicted
+	s.mu.Unlock()
+	notifyEvicted(onEvicted, evicted)
+	return nil
+}
+
+// Replace Set a new value for the cache key only if it already exists, and the existing
+// item hasn't expired. Returns an error otherwise.
+func (c *typedCache[K, V]) Replace(k K, x V, d time.Duration) error {
+	item := TypedItem[V]{
+		Object:     x,
+		Expiration: c.expiration(d),
+	}
+	s := c.shard(k)
+	s.mu.Lock()
+	if _, found := s.get(k); !found {
+		s.mu.Unlock()
+		return fmt.Errorf("Item %v doesn't exist", k)
+	}
+	evicted := s.store(k, item)
+	onEvicted := s.onEvicted
+	s.mu.Unlock()
+	notifyEvicted(onEvicted, evicted)
+	return nil
+}
+
+// Get an item from the cache. Returns the item or the zero value of V, and a
+// bool indicating whether the key was found.
+func (c *typedCache[K, V]) Get(k K) (V, bool) {
+	var zero V
+	s := c.shard(k)
+	s.mu.RLock()
+	item, found := s.items[k]
+	s.mu.RUnlock()
+	if !found || item.Expired() {
+		atomic.AddUint64(&s.stats.misses, 1)
+		return zero, false
+	}
+	s.accessed(k)
+	return item.Object, true
+}
+
+// GetWithExpiration returns an item and its expiration time from the cache.
+// It returns the item or the zero value of V, the expiration time if one is
+// set (if the item never expires a zero value for time.Time is returned), and
+// a bool indicating whether the key was found.
+func (c *typedCache[K, V]) GetWithExpiration(k K) (V, time.Time, bool) {
+	var zero V
+	s := c.shard(k)
+	s.mu.RLock()
+	item, found := s.items[k]
+	s.mu.RUnlock()
+	if !found || item.Expired() {
+		atomic.AddUint64(&s.stats.misses, 1)
+		return zero, time.Time{}, false
+	}
+	s.accessed(k)
+	if item.Expiration > 0 {
+		return item.Object, time.Unix(0, item.Expiration), true
+	}
+	return item.Object, time.Time{}, true
+}
+
+// get Returns the unexpired item stored under k, without counting the read.
+func (s *typedShard[K, V]) get(k K) (V, bool) {
+	item, found := s.items[k]
+	if !found || item.Expired() {
+		var zero V
+		return zero, false
+	}
+	return item.Object, true
+}
+
+// accessed Counts a hit of k and records it with the eviction policy.
+func (s *typedShard[K, V]) accessed(k K) {
+	atomic.AddUint64(&s.stats.hits, 1)
+	if s.bound != nil {
+		s.bound.accessed(k)
+	}
+}
+
+// store Writes item under k, keeping a bounded shard within its bounds.
+// Returns the items evicted to do so if OnEvicted is set.
+func (s *typedShard[K, V]) store(k K, item TypedItem[V]) []typedEviction[K, V] {
+	old, found := s.items[k]
+	s.items[k] = item
+	s.size += estimateTyped(k, item.Object)
+	if found {
+		s.size -= estimateTyped(k, old.Object)
+		s.stats.replaced(old.Expired())
+	}
+	if item.Expiration > 0 || found {
+		s.expiry.set(k, item.Expiration)
+	}
+	atomic.AddUint64(&s.stats.sets, 1)
+	if s.bound == nil {
+		return nil
+	}
+
+	var evicted []typedEviction[K, V]
+	s.bound.stored(k, item.Object, found)
+	for s.bound.over(len(s.items)) {
+		victim, ok := s.bound.victim()
+		if !ok {
+			break
+		}
+		v, exists := s.items[victim]
+		if !exists {
+			s.bound.removed(victim)
+			continue
+		}
+		reason := EvictionCapacity
+		if v.Expired() {
+			reason = EvictionExpired
+		}
+		s.delete(victim, reason)
+		if s.onEvicted != nil {
+			evicted = append(evicted, typedEviction[K, V]{victim, v.Object})
+		}
+	}
+	return evicted
+}
+
+// delete Removes k for the given reason. Returns its value and whether it was
+// found.
+func (s *typedShard[K, V]) delete(k K, reason EvictionReason) (V, bool) {
+	s.expiry.remove(k)
+	item, found := s.items[k]
+	if !found {
+		var zero V
+		return zero, false
+	}
+	delete(s.items, k)
+	s.size -= estimateTyped(k, item.Object)
+	if s.bound != nil {
+		s.bound.removed(k)
+	}
+	s.stats.evicted(reason)
+	return item.Object, true
+}
+
+func notifyEvicted[K comparable, V any](onEvicted func(K, V), evicted []typedEviction[K, V]) {
+	for _, e := range evicted {
+		onEvicted(e.key, e.value)
+	}
+}
+
+// Delete an item from the cache. Does nothing if the key is not in the cache.
+func (c *typedCache[K, V]) Delete(k K) {
+	s := c.shard(k)
+	s.mu.Lock()
+	v, found := s.delete(k, EvictionDeleted)
+	onEvicted := s.onEvicted
+	s.mu.Unlock()
+	if found && onEvicted != nil {
+		onEvicted(k, v)
+	}
+}
+
+// DeleteExpired Delete all expired items from the cache.
+func (c *typedCache[K, V]) DeleteExpired() {
+	for _, s := range c.shards {
+		s.deleteExpired()
+	}
+}
+
+// deleteExpired Pops the expired items off the expiry heap, at most
+// expiryBatch of them per lock.
+func (s *typedShard[K, V]) deleteExpired() {
+	now := time.Now().UnixNano()
+	for {
+		var evicted []typedEviction[K, V]
+		more := true
+		s.mu.Lock()
+		onEvicted := s.onEvicted
+		for i := 0; i < expiryBatch; i++ {
+			k, ok := s.expiry.next(now)
+			if !ok {
+				more = false
+				break
+			}
+			if v, found := s.delete(k, EvictionExpired); found && onEvicted != nil {
+				evicted = append(evicted, typedEviction[K, V]{k, v})
+			}
+		}
+		s.mu.Unlock()
+		notifyEvicted(onEvicted, evicted)
+		if !more {
+			return
+		}
+	}
+}
+
+// OnEvicted Sets an (optional) function that is called with the key and value when an
+// item is evicted from the cache. (Including when it is deleted manually, but
+// not when it is overwritten.) Set to nil to disable.
+func (c *typedCache[K, V]) OnEvicted(f func(K, V)) {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.onEvicted = f
+		s.mu.Unlock()
+	}
+}
+
+// Items Copies all unexpired items in the cache into a new map and returns it.
+func (c *typedCache[K, V]) Items() map[K]TypedItem[V] {
+	m := make(map[K]TypedItem[V], c.ItemCount())
+	now := time.Now().UnixNano()
+	for _, s := range c.shards {
+		s.mu.RLock()
+		for k, v := range s.items {
+			if v.Expiration > 0 && now > v.Expiration {
+				continue
+			}
+			m[k] = v
+		}
+		s.mu.RUnlock()
+	}
+	return m
+}
+
+// ItemCount Returns the number of items in the cache. This may include items that have
+// expired, but have not yet been cleaned up.
+func (c *typedCache[K, V]) ItemCount() int {
+	n := 0
+	for _, s := range c.shards {
+		s.mu.RLock()
+		n += len(s.items)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// Flush Delete all items from the cache.
+func (c *typedCache[K, V]) Flush() {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.items = map[K]TypedItem[V]{}
+		s.size = 0
+		s.expiry.reset()
+		if s.bound != nil {
+			s.bound.reset()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Stats Returns the statistics of all shards added up.
+func (c *typedCache[K, V]) Stats() Stats {
+	var total Stats
+	for _, s := range c.shards {
+		stats := s.stats.snapshot()
+		s.mu.RLock()
+		stats.Entries = len(s.items)
+		if s.bound != nil && s.bound.maxCost > 0 {
+			stats.EstimatedBytes = s.bound.cost
+		} else {
+			stats.EstimatedBytes = s.size
+		}
+		s.mu.RUnlock()
+		total.add(stats)
+	}
+	return total
+}
+
+// Increment Add n to the numeric item stored under k and return the new value.
+// Returns an error if the item was not found. Pass a negative number (for
+// signed and floating point types) or use Decrement to decrease the value.
+func Increment[K comparable, V Number](c *TypedCache[K, V], k K, n V) (V, error) {
+	return c.update(k, func(v V) V { return v + n })
+}
+
+// Decrement Subtract n from the numeric item stored under k and return the new
+// value. Returns an error if the item was not found.
+func Decrement[K comparable, V Number](c *TypedCache[K, V], k K, n V) (V, error) {
+	return c.update(k, func(v V) V { return v - n })
+}
+
+// update Replaces the unexpired item stored under k with fn(item), keeping its
+// expiration. Like Increment() on Cache, it doesn't change the size estimate.
+func (c *typedCache[K, V]) update(k K, fn func(V) V) (V, error) {
+	s := c.shard(k)
+	s.mu.Lock()
+	item, found := s.items[k]
+	if !found || item.Expired() {
+		s.mu.Unlock()
+		var zero V
+		return zero, fmt.Errorf("Item %v not found", k)
+	}
+	item.Object = fn(item.Object)
+	s.items[k] = item
+	s.mu.Unlock()
+	return item.Object, nil
+}
+
+// estimateTyped Is estimateSize() for typed keys and values.
+func estimateTyped[K comparable, V any](k K, x V) int64 {
+	if s, ok := any(k).(string); ok {
+		return estimateSize(s, x)
+	}
+	return estimateSize("", x) + int64(unsafe.Sizeof(k))
+}
+
+// reflectHash Is the default Hasher of typed caches. It hashes the value of
+// any comparable key, field by field and element by element, so that equal
+// keys always get equal hashes.
+func reflectHash[K comparable](seed uint32, k K) uint32 {
+	if s, ok := any(k).(string); ok {
+		return djb33(seed, s)
+	}
+	h := hashValue(uint64(seed)+1, reflect.ValueOf(&k).Elem())
+	return uint32(h>>32) ^ uint32(h)
+}
+
+func hashValue(h uint64, v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.String:
+		return mixHash(h, uint64(djb33(uint32(h), v.String())))
+	case reflect.Bool:
+		if v.Bool() {
+			return mixHash(h, 1)
+		}
+		return mixHash(h, 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return mixHash(h, uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return mixHash(h, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return mixHash(h, floatBits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		return mixHash(mixHash(h, floatBits(real(c))), floatBits(imag(c)))
+	case reflect.Ptr, reflect.Chan, reflect.UnsafePointer:
+		return mixHash(h, uint64(v.Pointer()))
+	case reflect.Interface:
+		if v.IsNil() {
+			return mixHash(h, 0)
+		}
+		return hashValue(h, v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			h = hashValue(h, v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			h = hashValue(h, v.Field(i))
+		}
+	}
+	return h
+}
+
+// floatBits Returns the bits of f, with -0 hashed like 0 as they are equal.
+func floatBits(f float64) uint64 {
+	if f == 0 {
+		return 0
+	}
+	return math.Float64bits(f)
+}
+
+func mixHash(h, x uint64) uint64 {
+	h = (h ^ x) * 0x9e3779b97f4a7c15
+	return h ^ h>>32
+}
+
+func stopTypedJanitor[K comparable, V any](c *TypedCache[K, V]) {
+	c.janitor.stop <- true
+}
+
+func newTypedCache[K comparable, V any](o TypedOptions[K, V]) *TypedCache[K, V] {
+	de := o.DefaultExpiration
+	if de == 0 {
+		de = -1
+	}
+	n := o.Shards
+	if n < 1 {
+		n = 1
+	}
+	hash := o.Hash
+	if hash == nil {
+		hash = reflectHash[K]
+	}
+	// Bounds are split evenly over the shards, like those of ShardedCache.
+	maxEntries, maxCost := o.MaxEntries, o.MaxCost
+	if maxEntries > 0 {
+		maxEntries = (maxEntries + n - 1) / n
+	}
+	if maxCost > 0 {
+		maxCost = (maxCost + int64(n) - 1) / int64(n)
+	}
+	c := &typedCache[K, V]{
+		defaultExpiration: de,
+		seed:              newSeed(),
+		hash:              hash,
+		shards:            make([]*typedShard[K, V], n),
+	}
+	for i := range c.shards {
+		c.shards[i] = &typedShard[K, V]{
+			items:  map[K]TypedItem[V]{},
+			bound:  makeBound(maxEntries, maxCost, o.Cost, estimateTyped[K, V], o.Policy, hash),
+			expiry: newExpiryQueue[K](),
+			stats:  &cacheStats{},
+		}
+	}
+	// Same trick as in newCacheWithJanitor: the janitor only references the
+	// inner cache, so the finalizer on the outer one can stop it.
+	C := &TypedCache[K, V]{c}
+	if o.CleanupInterval > 0 {
+		c.janitor = startJanitor(c, o.CleanupInterval, 0)
+		runtime.SetFinalizer(C, stopTypedJanitor[K, V])
+	}
+	return C
+}
+
+// NewTyped Return a new type-safe cache with a given default expiration duration and
+// cleanup interval. The expiration and cleanup semantics are the same as for
+// New().
+func NewTyped[K comparable, V any](defaultExpiration, cleanupInterval time.Duration) *TypedCache[K, V] {
+	return newTypedCache(TypedOptions[K, V]{DefaultExpiration: defaultExpiration, CleanupInterval: cleanupInterval})
+}
+
+// NewTypedSharded Return a new type-safe cache split into the given number of
+// shards, each with its own lock. hash picks the shard of a key, e.g. DJB33
+// for string keys; nil picks the default of TypedOptions.Hash.
+func NewTypedSharded[K comparable, V any](defaultExpiration, cleanupInterval time.Duration, shards int, hash Hasher[K]) *TypedCache[K, V] {
+	return newTypedCache(TypedOptions[K, V]{
+		DefaultExpiration: defaultExpiration,
+		CleanupInterval:   cleanupInterval,
+		Shards:            shards,
+		Hash:              hash,
+	})
+}
+
+// NewTypedWithOptions Return a new type-safe cache configured by o, which may
+// be bounded by the number of items and/or their total cost.
+func NewTypedWithOptions[K comparable, V any](o TypedOptions[K, V]) *TypedCache[K, V] {
+	return newTypedCache(o)
+}
@@ -0,0 +1,193 @@
+package memory
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTypedCache(t *testing.T) {
+	c := NewTyped[string, int](DefaultExpiration, 0)
+	c.Set("a", 1, DefaultExpiration)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get = %v, %v", v, ok)
+	}
+	if v, ok := c.Get("missing"); ok || v != 0 {
+		t.Fatalf("Get(missing) = %v, %v", v, ok)
+	}
+	if err := c.Add("a", 2, DefaultExpiration); err == nil {
+		t.Error("Add of an existing key succeeded")
+	}
+	if err := c.Replace("missing", 2, DefaultExpiration); err == nil {
+		t.Error("Replace of a missing key succeeded")
+	}
+	if err := c.Replace("a", 2, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if v, e, ok := c.GetWithExpiration("a"); !ok || v != 2 || e.IsZero() {
+		t.Fatalf("GetWithExpiration = %v, %v, %v", v, e, ok)
+	}
+
+	if n, err := Increment(c, "a", 40); err != nil || n != 42 {
+		t.Fatalf("Increment = %v, %v", n, err)
+	}
+	if n, err := Decrement(c, "a", 2); err != nil || n != 40 {
+		t.Fatalf("Decrement = %v, %v", n, err)
+	}
+	if _, err := Increment(c, "missing", 1); err == nil {
+		t.Error("Increment of a missing key succeeded")
+	}
+
+	var evicted []string
+	c.OnEvicted(func(k string, v int) { evicted = append(evicted, k) })
+	c.Set("a", 3, DefaultExpiration) // overwrites aren't evictions
+	c.Delete("a")
+	c.Delete("a")
+	if len(evicted) != 1 || evicted[0] != "a" {
+		t.Fatalf("evicted %v, want [a]", evicted)
+	}
+}
+
+func TestTypedCacheExpiry(t *testing.T) {
+	c := NewTyped[int, string](DefaultExpiration, 0)
+	var evicted []int
+	c.OnEvicted(func(k int, v string) { evicted = append(evicted, k) })
+	c.Set(1, "short", time.Millisecond)
+	c.Set(2, "long", time.Hour)
+	c.Set(3, "forever", NoExpiration)
+	c.Set(4, "rewritten", time.Millisecond)
+	c.Set(4, "rewritten", NoExpiration)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := c.Get(1); ok {
+		t.Error("expired item found")
+	}
+	c.DeleteExpired()
+	if len(evicted) != 1 || evicted[0] != 1 {
+		t.Fatalf("evicted %v, want [1]", evicted)
+	}
+	if got := c.ItemCount(); got != 3 {
+		t.Fatalf("ItemCount = %d, want 3", got)
+	}
+	if n := c.shards[0].expiry.Len(); n != 1 {
+		t.Fatalf("expiry heap holds %d keys, want 1", n)
+	}
+	if got := c.Stats().Evictions[EvictionExpired]; got != 1 {
+		t.Fatalf("expired evictions = %d, want 1", got)
+	}
+}
+
+func TestTypedCacheBounds(t *testing.T) {
+	c := NewTypedWithOptions(TypedOptions[int, int]{MaxEntries: 3})
+	var evicted []int
+	c.OnEvicted(func(k, v int) { evicted = append(evicted, k) })
+	for i := 0; i < 3; i++ {
+		c.Set(i, i, NoExpiration)
+	}
+	c.Get(0)
+	c.Set(3, 3, NoExpiration)
+	if len(evicted) != 1 || evicted[0] != 1 {
+		t.Fatalf("evicted %v, want the least recently used key 1", evicted)
+	}
+	if got := c.ItemCount(); got != 3 {
+		t.Fatalf("ItemCount = %d, want 3", got)
+	}
+
+	costly := NewTypedWithOptions(TypedOptions[string, string]{
+		MaxCost: 10,
+		Cost:    func(k, v string) int64 { return int64(len(v)) },
+	})
+	costly.Set("a", "12345", NoExpiration)
+	costly.Set("b", "12345", NoExpiration)
+	costly.Set("c", "1", NoExpiration)
+	if _, ok := costly.Get("a"); ok {
+		t.Error("a not evicted over MaxCost")
+	}
+	if s := costly.Stats(); s.EstimatedBytes != 6 || s.Evictions[EvictionCapacity] != 1 {
+		t.Fatalf("EstimatedBytes = %d, capacity evictions = %d", s.EstimatedBytes, s.Evictions[EvictionCapacity])
+	}
+}
+
+func TestTypedCacheStats(t *testing.T) {
+	c := NewTypedSharded[string, string](DefaultExpiration, 0, 4, DJB33)
+	for i := 0; i < 10; i++ {
+		c.Set(strconv.Itoa(i), "value", NoExpiration)
+	}
+	c.Set("0", "value", NoExpiration)
+	c.Get("0")
+	c.Get("missing")
+	c.Delete("1")
+	s := c.Stats()
+	if s.Hits != 1 || s.Misses != 1 || s.Sets != 11 || s.Entries != 9 {
+		t.Fatalf("Stats = %+v", s)
+	}
+	if s.Evictions[EvictionDeleted] != 1 {
+		t.Fatalf("deleted evictions = %d, want 1", s.Evictions[EvictionDeleted])
+	}
+	if want := 9 * estimateSize("0", "value"); s.EstimatedBytes != want {
+		t.Fatalf("EstimatedBytes = %d, want %d", s.EstimatedBytes, want)
+	}
+	c.Flush()
+	if s := c.Stats(); s.Entries != 0 || s.EstimatedBytes != 0 {
+		t.Fatalf("Stats after Flush = %+v", s)
+	}
+}
+
+type compositeKey struct {
+	name  string
+	id    int
+	score float64
+	ptr   *int
+}
+
+func TestTypedShardedDefaultHash(t *testing.T) {
+	c := NewTypedSharded[compositeKey, int](DefaultExpiration, 0, 8, nil)
+	for i := 0; i < 100; i++ {
+		c.Set(compositeKey{name: "k", id: i}, i, NoExpiration)
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := c.Get(compositeKey{name: "k", id: i}); !ok || v != i {
+			t.Fatalf("Get(%d) = %v, %v", i, v, ok)
+		}
+	}
+	empty := 0
+	for _, s := range c.shards {
+		if len(s.items) == 0 {
+			empty++
+		}
+	}
+	if empty > 0 {
+		t.Errorf("%d of 8 shards are empty", empty)
+	}
+
+	// Equal keys hash alike, whatever their representation.
+	zero, negativeZero := compositeKey{score: 0}, compositeKey{score: math.Copysign(0, -1)}
+	if zero != negativeZero || reflectHash(7, zero) != reflectHash(7, negativeZero) {
+		t.Error("-0 and 0 keys hash differently")
+	}
+	if reflectHash(7, "key") != DJB33(7, "key") {
+		t.Error("string keys don't use DJB33")
+	}
+}
+
+func TestTypedTinyLFU(t *testing.T) {
+	c := NewTypedWithOptions(TypedOptions[int, int]{MaxEntries: 100, Policy: EvictTinyLFU})
+	for i := 0; i < 100; i++ {
+		c.Set(i, i, NoExpiration)
+		c.Get(i)
+		c.Get(i)
+	}
+	for i := 1000; i < 2000; i++ {
+		c.Set(i, i, NoExpiration)
+	}
+	kept := 0
+	for i := 0; i < 100; i++ {
+		if _, ok := c.Get(i); ok {
+			kept++
+		}
+	}
+	if kept < 90 {
+		t.Fatalf("only %d of the popular keys survived a scan", kept)
+	}
+}
@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// 大任务参数外置（claim-check）：
+// 一、原理
+//    投递任务时转换后的任务参数字节数大于 Config.BlobThreshold 则存储至 BlobStore，
+//    队列中的 Payload.Payload 置空，仅在 Payload.BlobRef 记录引用
+// 二、读取
+//    任务执行时在调用 Execute 之前从 BlobStore 加载，暂时无法读取的任务按重试规则重试，任务参数不存在的任务直接标记失败
+//    未采用 RawBody 读取时按需加载：RawBody 的 Int、String、Unmarshal 等方法无法返回加载错误，
+//    且加载失败应计入尝试次数按重试规则处理，而非交由各task自行处理
+// 三、清理
+//    任务执行成功后删除外置的任务参数，投递失败时同样删除已外置的任务参数
+//    最终失败的任务先将外置的任务参数写回任务结构再记录失败任务，随后删除，失败任务记录不引用已删除的对象
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+var (
+	// ErrBlobNotFound 外置任务参数不存在
+	ErrBlobNotFound = errors.New("queue.blob.not.found")
+	// ErrBlobStoreUnavailable 任务参数已外置但未配置 BlobStore
+	ErrBlobStoreUnavailable = errors.New("queue.blob.store.unavailable")
+)
+
+// BlobStore 大任务参数外部存储契约
+type BlobStore interface {
+	// Put 存储任务参数
+	//   - key  任务参数引用，格式：task名称/任务ID
+	//   - data 转换后的任务参数
+	Put(ctx context.Context, key string, data []byte) (err error)
+	// Get 读取任务参数，不存在时返回 ErrBlobNotFound
+	Get(ctx context.Context, key string) (data []byte, err error)
+	// Delete 删除任务参数，不存在时返回nil
+	Delete(ctx context.Context, key string) (err error)
+}
+
+// blobKey 生成外置任务参数的引用
+func blobKey(payload *Payload) string {
+	return payload.Name + "/" + payload.ID
+}
+
+// region 本地文件系统实现
+
+// fileBlobStore 基于本地文件系统实现的 BlobStore
+// implement BlobStore
+type fileBlobStore struct {
+	dir string // 存储根目录
+}
+
+// NewFileBlobStore 创建基于本地文件系统实现的 BlobStore
+//   - dir 存储根目录，不存在时自动创建
+//   - 仅适用于生产端与消费端可访问同一文件系统（单机或共享存储）的场景
+func NewFileBlobStore(dir string) (BlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileBlobStore{dir: dir}, nil
+}
+
+// path 获取任务参数引用对应的文件路径
+func (f *fileBlobStore) path(key string) (string, error) {
+	local := filepath.FromSlash(key)
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("blob key %s invalid", key)
+	}
+	return filepath.Join(f.dir, local), nil
+}
+
+// Put 写入临时文件后原子rename，避免读取到写入一半的任务参数
+func (f *fileBlobStore) Put(_ context.Context, key string, data []byte) (err error) {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".blob-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get 读取任务参数文件
+func (f *fileBlobStore) Get(_ context.Context, key string) (data []byte, err error) {
+	path, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return data, err
+}
+
+// Delete 删除任务参数文件
+func (f *fileBlobStore) Delete(_ context.Context, key string) (err error) {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// endregion
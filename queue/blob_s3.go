@@ -0,0 +1,250 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// 基于S3兼容对象存储实现的 BlobStore：
+// 一、原理
+//    使用 AWS Signature Version 4 签名的 PUT/GET/DELETE Object 请求读写对象，不依赖AWS SDK
+// 二、兼容
+//    适用于AWS S3以及MinIO、Ceph RGW、阿里云OSS（S3兼容模式）等实现了S3协议的对象存储
+//    本地开发调试可使用MinIO等作为替身，此时需开启 PathStyle
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+const (
+	s3Service         = "s3"               // 签名服务名
+	s3Algorithm       = "AWS4-HMAC-SHA256" // 签名算法
+	s3TimeFormat      = "20060102T150405Z" // 签名时间格式
+	s3DateFormat      = "20060102"         // 签名日期格式
+	s3DefaultRegion   = "us-east-1"        // 默认区域
+	s3DefaultTimeout  = 30 * time.Second   // 默认请求超时时长
+	s3HeaderDate      = "X-Amz-Date"
+	s3HeaderSha256    = "X-Amz-Content-Sha256"
+	s3HeaderSecurity  = "X-Amz-Security-Token"
+	s3HeaderAuthorize = "Authorization"
+)
+
+// S3Config S3兼容对象存储配置
+type S3Config struct {
+	Endpoint        string       // 服务地址，譬如：https://s3.us-east-1.amazonaws.com 或 http://127.0.0.1:9000
+	Region          string       // 区域，默认：us-east-1
+	Bucket          string       // 存储桶名称
+	AccessKeyID     string       // 访问密钥ID
+	SecretAccessKey string       // 访问密钥
+	SessionToken    string       // 临时凭证token，可选
+	Prefix          string       // 对象key前缀，可选，譬如：queue-blobs/
+	PathStyle       bool         // 是否使用路径风格访问：endpoint/bucket/key，默认使用虚拟主机风格：bucket.endpoint/key
+	HTTPClient      *http.Client // 自定义http客户端，可选，默认超时30秒
+}
+
+// s3BlobStore 基于S3兼容对象存储实现的 BlobStore
+// implement BlobStore
+type s3BlobStore struct {
+	config   S3Config // 配置
+	endpoint *url.URL // 解析后的服务地址
+}
+
+// NewS3BlobStore 创建基于S3兼容对象存储实现的 BlobStore
+func NewS3BlobStore(config S3Config) (BlobStore, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("s3 bucket can not be empty")
+	}
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3 endpoint %s invalid", config.Endpoint)
+	}
+
+	if config.Region == "" {
+		config.Region = s3DefaultRegion
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: s3DefaultTimeout}
+	}
+
+	return &s3BlobStore{config: config, endpoint: endpoint}, nil
+}
+
+// Put 上传对象
+func (s *s3BlobStore) Put(ctx context.Context, key string, data []byte) (err error) {
+	resp, err := s.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError(resp)
+	}
+	return nil
+}
+
+// Get 下载对象
+func (s *s3BlobStore) Get(ctx context.Context, key string) (data []byte, err error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, ErrBlobNotFound
+	default:
+		return nil, s.responseError(resp)
+	}
+}
+
+// Delete 删除对象，对象不存在时S3同样返回成功
+func (s *s3BlobStore) Delete(ctx context.Context, key string) (err error) {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s.responseError(resp)
+	}
+	return nil
+}
+
+// do 构造签名请求并发送
+func (s *s3BlobStore) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	objectURL := *s.endpoint
+	objectKey := s.config.Prefix + key
+	if s.config.PathStyle {
+		objectURL.Path = strings.TrimSuffix(objectURL.Path, "/") + "/" + s.config.Bucket + "/" + objectKey
+	} else {
+		objectURL.Host = s.config.Bucket + "." + objectURL.Host
+		objectURL.Path = strings.TrimSuffix(objectURL.Path, "/") + "/" + objectKey
+	}
+	objectURL.RawPath = s3URIEncode(objectURL.Path, false)
+
+	req, err := http.NewRequestWithContext(ctx, method, objectURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+
+	s.sign(req, body, time.Now().UTC())
+
+	return s.config.HTTPClient.Do(req)
+}
+
+// sign 使用 AWS Signature Version 4 为请求签名
+func (s *s3BlobStore) sign(req *http.Request, body []byte, now time.Time) {
+	var (
+		amzDate     = now.Format(s3TimeFormat)
+		date        = now.Format(s3DateFormat)
+		payloadHash = s3Sha256Hex(body)
+		scope       = date + "/" + s.config.Region + "/" + s3Service + "/aws4_request"
+	)
+
+	req.Header.Set(s3HeaderDate, amzDate)
+	req.Header.Set(s3HeaderSha256, payloadHash)
+	if s.config.SessionToken != "" {
+		req.Header.Set(s3HeaderSecurity, s.config.SessionToken)
+	}
+
+	// step1、规范请求：参与签名的请求头按小写名称排序
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	// step2、待签名字符串
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		amzDate,
+		scope,
+		s3Sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	// step3、派生签名密钥并计算签名
+	signingKey := s3HmacSha256([]byte("AWS4"+s.config.SecretAccessKey), date)
+	signingKey = s3HmacSha256(signingKey, s.config.Region)
+	signingKey = s3HmacSha256(signingKey, s3Service)
+	signingKey = s3HmacSha256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(s3HmacSha256(signingKey, stringToSign))
+
+	req.Header.Set(s3HeaderAuthorize, fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.config.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+// responseError 构造非预期响应的错误
+func (s *s3BlobStore) responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 %s %s failed: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, string(body))
+}
+
+// s3URIEncode 按S3签名规范对URI编码：除 A-Z a-z 0-9 - _ . ~ 外均编码，encodeSlash为false时保留斜杠
+func s3URIEncode(s string, encodeSlash bool) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			buf.WriteString(fmt.Sprintf("%%%02X", c))
+		}
+	}
+	return buf.String()
+}
+
+// s3Sha256Hex sha256摘要的十六进制字符串
+func s3Sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3HmacSha256 计算HMAC-SHA256
+func s3HmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,127 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// s3StandIn 本地S3替身：内存存储对象，校验签名请求头
+type s3StandIn struct {
+	t       *testing.T
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	sum := sha256.Sum256(body)
+	if got := r.Header.Get(s3HeaderSha256); got != hex.EncodeToString(sum[:]) {
+		s.t.Errorf("%s %s content sha256 = %q", r.Method, r.URL.Path, got)
+	}
+	if r.Header.Get(s3HeaderDate) == "" {
+		s.t.Errorf("%s %s missing %s", r.Method, r.URL.Path, s3HeaderDate)
+	}
+	authorization := r.Header.Get(s3HeaderAuthorize)
+	if !strings.HasPrefix(authorization, s3Algorithm+" Credential=AKID/") ||
+		!strings.Contains(authorization, "/us-east-1/s3/aws4_request") ||
+		!strings.Contains(authorization, "SignedHeaders=host;x-amz-content-sha256;x-amz-date") {
+		s.t.Errorf("%s %s authorization = %q", r.Method, r.URL.Path, authorization)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		s.objects[r.URL.Path] = body
+	case http.MethodGet:
+		data, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	case http.MethodDelete:
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3BlobStore(t *testing.T) {
+	standIn := &s3StandIn{t: t, objects: map[string][]byte{}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	store, err := NewS3BlobStore(S3Config{
+		Endpoint:        server.URL,
+		Bucket:          "bucket",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		Prefix:          "blobs/",
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3BlobStore() error = %v", err)
+	}
+
+	ctx := context.Background()
+	key := "task/job id"
+	data := bytes.Repeat([]byte("payload"), 1024)
+	if err = store.Put(ctx, key, data); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, ok := standIn.objects["/bucket/blobs/task/job id"]; !ok {
+		t.Fatalf("Put() stored objects = %v, want path style key", standIn.objects)
+	}
+
+	got, err := store.Get(ctx, key)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Get() = %d bytes, %v, want %d bytes", len(got), err, len(data))
+	}
+
+	if err = store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err = store.Get(ctx, key); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("Get() after Delete() error = %v, want ErrBlobNotFound", err)
+	}
+	if err = store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete() missing object error = %v", err)
+	}
+}
+
+func TestS3BlobStoreUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	store, err := NewS3BlobStore(S3Config{Endpoint: server.URL, Bucket: "bucket", PathStyle: true})
+	if err != nil {
+		t.Fatalf("NewS3BlobStore() error = %v", err)
+	}
+
+	if _, err = store.Get(context.Background(), "task/id"); err == nil || errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("Get() error = %v, want service unavailable", err)
+	}
+}
+
+func TestNewS3BlobStoreInvalidConfig(t *testing.T) {
+	if _, err := NewS3BlobStore(S3Config{Endpoint: "http://127.0.0.1:9000"}); err == nil {
+		t.Fatal("NewS3BlobStore() without bucket error = nil")
+	}
+	if _, err := NewS3BlobStore(S3Config{Endpoint: "127.0.0.1", Bucket: "bucket"}); err == nil {
+		t.Fatal("NewS3BlobStore() with invalid endpoint error = nil")
+	}
+}
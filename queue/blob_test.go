@@ -0,0 +1,137 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// flakyBlobStore 读取失败的 BlobStore
+type flakyBlobStore struct {
+	BlobStore
+	err error
+}
+
+func (s flakyBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, s.err
+}
+
+func TestManagerLoadBlob(t *testing.T) {
+	store, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileBlobStore() error = %v", err)
+	}
+	payload := &Payload{Name: "task", ID: "id", BlobRef: "task/id"}
+	if err = store.Put(context.Background(), payload.BlobRef, []byte(`"body"`)); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	m := &manager{config: Config{BlobStore: store}}
+	decoded, _, err := m.loadBlob(context.Background(), payload)
+	if err != nil || decoded.RawBody().String() != `"body"` || decoded.BlobRef != "" {
+		t.Fatalf("loadBlob() = %+v, %v", decoded, err)
+	}
+	if payload.BlobRef == "" {
+		t.Fatal("loadBlob() modified payload")
+	}
+
+	tests := []struct {
+		name  string
+		store BlobStore
+		retry bool
+	}{
+		{"unavailable", flakyBlobStore{err: errors.New("connection refused")}, true},
+		{"not found", flakyBlobStore{err: ErrBlobNotFound}, false},
+		{"no store", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &manager{config: Config{BlobStore: tt.store}}
+			if _, retry, err := m.loadBlob(context.Background(), payload); err == nil || retry != tt.retry {
+				t.Fatalf("loadBlob() retry = %v, error = %v, want retry %v", retry, err, tt.retry)
+			}
+		})
+	}
+}
+
+// failingTask 执行恒返回error的task
+type failingTask struct {
+	DefaultTaskSetting
+}
+
+func (task *failingTask) Name() string    { return "failing_task" }
+func (task *failingTask) MaxTries() int64 { return 1 }
+func (task *failingTask) Remark() string  { return "failing task" }
+func (task *failingTask) Execute(ctx context.Context, job *RawBody) error {
+	return errors.New("execute failed")
+}
+
+func TestFailedJobKeepsBlobBody(t *testing.T) {
+	store, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileBlobStore() error = %v", err)
+	}
+	service := New(Memory, nil, nopTestLogger{}, Config{
+		BlobStore:           store,
+		BlobThreshold:       16,
+		PayloadTransformers: []PayloadTransformer{NewGzipTransformer(1)},
+	})
+	task := &failingTask{}
+	if err = service.BootstrapOne(task); err != nil {
+		t.Fatalf("BootstrapOne() error = %v", err)
+	}
+	var failed *Payload
+	service.SetFailedJobHandler(func(payload *Payload, err error) error {
+		failed = payload
+		return nil
+	})
+
+	body := strings.Repeat("large body ", 64)
+	if err = service.Dispatch(task, body); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	m := service.manager
+	job, exist, _ := m.popJob(task.Name())
+	if !exist {
+		t.Fatal("popJob() exist = false, want true")
+	}
+	ref := job.Payload().BlobRef
+	if ref == "" {
+		t.Fatal("payload was not offloaded")
+	}
+	m.runJob(job, inlineWorkerID)
+
+	// 失败任务处理器收到还原后的任务参数
+	if failed == nil || failed.BlobRef != "" || failed.RawBody().String() != body {
+		t.Fatalf("failed payload = %+v, want inline body", failed)
+	}
+	// 记录至失败任务表的任务结构为转换后的任务参数，不再引用外置对象
+	stored := job.Payload()
+	if stored.BlobRef != "" || stored.Codec == "" || len(stored.Payload) == 0 {
+		t.Fatalf("stored payload = %+v, want inline transformed body", stored)
+	}
+	if decoded, err := m.decodePayload(stored); err != nil || decoded.RawBody().String() != body {
+		t.Fatalf("decodePayload() = %+v, %v", decoded, err)
+	}
+	// 写回后删除外置的任务参数
+	if _, err = store.Get(context.Background(), ref); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("blob Get() error = %v, want ErrBlobNotFound", err)
+	}
+}
+
+func TestFailedJobKeepsBlobWhenUnreadable(t *testing.T) {
+	store, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileBlobStore() error = %v", err)
+	}
+	m := newQueue(Memory, nil, nopTestLogger{}, Config{BlobStore: flakyBlobStore{BlobStore: store, err: errors.New("connection refused")}}).manager
+	job := &JobMemory{jobProperty: jobProperty{name: "task", payload: &Payload{Name: "task", ID: "id", BlobRef: "task/id"}}}
+
+	if m.inlineBlob(job) {
+		t.Fatal("inlineBlob() = true, want false")
+	}
+	if job.Payload().BlobRef != "task/id" {
+		t.Fatalf("BlobRef = %q, want kept", job.Payload().BlobRef)
+	}
+}
@@ -23,6 +23,13 @@ const (
 	DefaultMaxConcurrency        = 3                      // 默认单个task最大并发数
 	DefaultAutoScaleInterval     = 5 * time.Minute        // 默认自动扩缩容监测时长间隔
 	DefaultAutoScaleJobThreshold = 1000                   // 默认自动扩容job堆积数阈值
+	DefaultSemaphoreTTL          = 60 * time.Second       // 分布式信号量槽位在task超时时长基础上额外的持有时长
+	DefaultBlobThreshold         = 256 * 1024             // 默认大任务参数外置阈值：256KB
+	DefaultBlockingTimeout       = 30 * time.Second       // 默认阻塞等待任务的最大阻塞时长
+	DefaultJobHistoryTTL         = 7 * 24 * time.Hour     // 默认任务事件历史保留时长：7天
+	DefaultJobHistoryMaxEvents   = 50                     // 默认单个任务最多保留的事件条数
+	DefaultMySQLPrefetch         = 10                     // MySQL驱动默认单次事务保留的任务数
+	DefaultMySQLSweepInterval    = 5 * time.Second        // MySQL驱动默认回收超时保留任务的间隔
 )
 
 var (
@@ -63,6 +70,74 @@ type Config struct {
 	// 而自动缩容则是当堆积job小于等于可运行的task数时自动降低worker数至
 	// 扩容worker最大值为：最大worker数，参照 MaxConcurrency 的说明
 	AutoScaleJobThreshold int64
+	// Semaphore 跨节点分布式信号量实现，用于 DistributedConcurrencyTask 的并发上限控制
+	// 为nil时Redis、MySQL驱动默认使用同一连接器实现的信号量，Memory驱动不支持分布式并发控制
+	Semaphore Semaphore
+	// PayloadTransformers 任务参数转换器，投递时按顺序依次转换任务参数，执行前逆序还原
+	// 譬如先压缩再加密：[]PayloadTransformer{NewGzipTransformer(1024), aesTransformer}
+	// 生产端与消费端需配置相同的转换器，未配置时任务参数以明文存储
+	PayloadTransformers []PayloadTransformer
+	// BlobStore 大任务参数外部存储，为nil时不启用大任务参数外置
+	BlobStore BlobStore
+	// BlobThreshold 任务参数（转换后）字节数大于该值时存储至 BlobStore，队列中仅保存引用
+	// 默认值：DefaultBlobThreshold
+	BlobThreshold int
+	// BlockingPop 是否使用阻塞等待任务的事件驱动模式替代轮询，默认不开启
+	// 仅支持阻塞等待的驱动生效（目前为Redis驱动，需redis 6.2+），其他驱动仍使用轮询
+	BlockingPop bool
+	// BlockingTimeout 开启阻塞等待时单次最大阻塞时长，默认值：DefaultBlockingTimeout
+	BlockingTimeout time.Duration
+	// KeyPrefix Redis驱动key命名空间前缀，譬如 "prod:"、"order-service:"，为空表示不使用前缀
+	// 作用于队列、延迟、保留、分区、信号量、任务事件历史的key以及唤醒频道，共用同一Redis的多个环境、多个服务各自设置即可隔离
+	// 修改前缀后已有任务需使用 MigrateRedisKeys 迁移
+	KeyPrefix string
+	// RedisHashTag Redis驱动是否使用hash tag命名key，譬如 {task}、{task}:delayed，使task的全部key落在同一hash slot
+	// 连接器为 *redis.ClusterClient 时强制开启；开启前后key名称不同，已有任务需使用 MigrateRedisKeys 迁移
+	RedisHashTag bool
+	// JobHistory 是否记录任务事件历史，默认不开启，开启后可通过 Queue.JobHistory 按任务ID查询
+	// 每条事件均同步写入底层驱动，会增加投递与执行任务时的驱动请求量
+	JobHistory bool
+	// JobHistoryTTL 任务事件历史保留时长，最后一条事件写入后开始计时，默认值：DefaultJobHistoryTTL
+	JobHistoryTTL time.Duration
+	// JobHistoryMaxEvents 单个任务最多保留的事件条数，超出时丢弃最早的事件，默认值：DefaultJobHistoryMaxEvents
+	JobHistoryMaxEvents int64
+	// MySQLPrefetch MySQL驱动单次事务最多保留的任务数，多出的任务暂存于进程内预取缓冲区供后续 Pop 直接取出
+	// 默认值：DefaultMySQLPrefetch，设置为1即每次 Pop 仅保留1条任务
+	MySQLPrefetch int
+	// MySQLSweepInterval MySQL驱动回收执行超时仍未释放的保留任务的间隔，默认值：DefaultMySQLSweepInterval
+	MySQLSweepInterval time.Duration
+	// Clock 时钟，默认使用系统时钟
+	// 测试场景可注入可控时钟以快进延迟任务和重试任务，目前仅 Memory 驱动、Sync 驱动与队列管理者使用该时钟
+	Clock Clock
+}
+
+// endregion
+
+// region 时钟抽象
+
+// Clock 时钟契约
+type Clock interface {
+	Now() time.Time // 获取当前时刻
+}
+
+// Sleeper 可选实现的时钟休眠契约
+//   - Sync 驱动等待重试间隔与延迟时长时使用，未实现该接口的时钟使用 time.Sleep 等待
+//   - 可控时钟可将休眠实现为快进时钟，使同步执行的重试任务无需真实等待
+type Sleeper interface {
+	Sleep(d time.Duration) // 休眠指定时长
+}
+
+// systemClock 系统时钟
+type systemClock struct{}
+
+// Now 获取系统当前时刻
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep 休眠指定时长
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
 }
 
 // endregion
@@ -98,6 +173,20 @@ type QueueIFace interface {
 	GetConnection() (connection interface{}, err error)
 }
 
+// ContextQueueIFace 可选实现的支持context的队列驱动契约，Redis、MySQL、Memory驱动均已实现
+//   - 遵循ctx的超时与取消，并返回底层驱动的错误，便于区分队列为空与底层驱动异常
+//   - 未实现该契约的驱动，Queue 的 *Ctx 方法在ctx未结束时调用 QueueIFace 对应方法
+type ContextQueueIFace interface {
+	// SizeCtx 获取当前队列长度
+	SizeCtx(ctx context.Context, queue string) (size int64, err error)
+	// PushCtx 投递一条任务到队列
+	PushCtx(ctx context.Context, queue string, payload interface{}) (err error)
+	// LaterAtCtx 投递一条指定执行时间的延迟任务到队列
+	LaterAtCtx(ctx context.Context, queue string, timeAt time.Time, payload interface{}) (err error)
+	// PopCtx 取出一条待执行的任务，队列为空时exist返回false且err为nil
+	PopCtx(ctx context.Context, queue string) (job JobIFace, exist bool, err error)
+}
+
 // endregion
 
 // region job任务抽象
@@ -122,6 +211,40 @@ type JobIFace interface {
 
 // endregion
 
+// region 阻塞等待任务的队列抽象
+
+// blockingQueueIFace 支持阻塞等待任务的队列驱动契约
+type blockingQueueIFace interface {
+	// Wait 阻塞等待队列中出现可取出的任务，最长等待timeout时长
+	// 返回时不保证队列中一定有任务，调用方仍需 Pop 取出任务
+	Wait(queue string, timeout time.Duration)
+}
+
+// driverShutdown 优雅关闭队列时需要释放资源的队列驱动契约
+type driverShutdown interface {
+	shutdown()
+}
+
+// endregion
+
+// region 分布式信号量抽象
+
+// Semaphore 跨节点分布式信号量契约
+type Semaphore interface {
+	// Acquire 尝试获取一个信号量槽位，非阻塞
+	//   - name  信号量名称，一般为task名称
+	//   - limit 信号量最大槽位数
+	//   - ttl   槽位最长持有时长，超时未释放的槽位自动回收，防止进程崩溃导致槽位泄露
+	//   - 获取成功返回槽位token和true，槽位已满返回false
+	Acquire(name string, limit int64, ttl time.Duration) (token string, acquired bool, err error)
+	// Release 释放一个已获取的信号量槽位
+	//   - name  信号量名称
+	//   - token Acquire 返回的槽位token
+	Release(name, token string) (err error)
+}
+
+// endregion
+
 // region 日志接口定义
 
 // Logger 日志接口定义
@@ -150,10 +273,12 @@ type Logger interface {
 
 // RawBody 队列execute执行时传递给执行方法的参数Raw结构：job任务参数的包装器
 //   - ID 内部标记队列任务的唯一ID，使用UUID生成
+//   - 外置于 BlobStore 的任务参数在调用 Execute 之前即已加载，加载失败的job不会执行
 type RawBody struct {
-	queue   string // 队列名
-	payload []byte // 调度队列塞入的数据体
-	ID      string // 队列内部唯一标识符ID
+	queue     string // 队列名
+	payload   []byte // 调度队列塞入的数据体
+	ID        string // 队列内部唯一标识符ID
+	Partition string // 任务所属分区，为空表示默认分区
 }
 
 // Int 任务参数数据转int
@@ -200,20 +325,24 @@ func (rawBody *RawBody) Unmarshal(result interface{}) error {
 
 // Payload 存储于队列中的job任务结构
 type Payload struct {
-	Name          string `json:"Name"`          // 队列名称
-	ID            string `json:"ID"`            // 任务ID
-	MaxTries      int64  `json:"MaxTries"`      // 任务最大尝试次数，默认1
-	RetryInterval int64  `json:"RetryInterval"` // 当任务最大允许尝试次数大于0时，下次尝试之前的间隔时长，单位：秒
-	Attempts      int64  `json:"Attempts"`      // 任务已被尝试执行的的次数
-	Payload       []byte `json:"Payload"`       // 任务参数比特字面量，可decode成具体job被execute时的类型
-	PopTime       int64  `json:"PopTime"`       // 任务首次被取出执行的时间戳，取出的时候才去设置
-	Timeout       int64  `json:"Timeout"`       // 任务最大执行超时时长，单位：秒
-	TimeoutAt     int64  `json:"TimeoutAt"`     // 任务超时时刻时间戳，被执行时刻才会去设置
+	Name          string `json:"Name"`                // 队列名称
+	ID            string `json:"ID"`                  // 任务ID
+	MaxTries      int64  `json:"MaxTries"`            // 任务最大尝试次数，默认1
+	RetryInterval int64  `json:"RetryInterval"`       // 当任务最大允许尝试次数大于0时，下次尝试之前的间隔时长，单位：秒
+	Attempts      int64  `json:"Attempts"`            // 任务已被尝试执行的的次数
+	Payload       []byte `json:"Payload"`             // 任务参数比特字面量，可decode成具体job被execute时的类型
+	PopTime       int64  `json:"PopTime"`             // 任务首次被取出执行的时间戳，取出的时候才去设置
+	Timeout       int64  `json:"Timeout"`             // 任务最大执行超时时长，单位：秒
+	TimeoutAt     int64  `json:"TimeoutAt"`           // 任务超时时刻时间戳，被执行时刻才会去设置
+	Codec         string `json:"Codec,omitempty"`     // 任务参数转换标记，记录投递时依次生效的转换器，为空表示明文
+	BlobRef       string `json:"BlobRef,omitempty"`   // 外置于 BlobStore 的任务参数引用，不为空时Payload字段为空
+	Partition     string `json:"Partition,omitempty"` // 任务所属分区，为空表示默认分区
+	ExpireAt      int64  `json:"ExpireAt,omitempty"`  // 任务过期时刻时间戳，到达该时刻仍未执行成功的任务不再执行，为0表示永不过期
 }
 
 // RawBody PayLoad结构体获取载体实体
 func (payload *Payload) RawBody() *RawBody {
-	return &RawBody{queue: payload.Name, ID: payload.ID, payload: payload.Payload}
+	return &RawBody{queue: payload.Name, ID: payload.ID, Partition: payload.Partition, payload: payload.Payload}
 }
 
 // FailedJobHandler 失败任务记录|处理回调方法
@@ -235,6 +364,21 @@ type TaskIFace interface {
 	Remark() string                                  // 队列任务說明
 }
 
+// ConcurrencyTask 可选实现的task并发控制契约
+//   - 未实现该接口的task共享通用worker池，并发上限为 Config.MaxConcurrency 对应的worker数
+//   - 实现该接口的task在当前消费进程内最多同时执行 MaxConcurrency 个job
+//   - IsolatedPool 返回true时为该task启动 MaxConcurrency 个独立worker，不再与其他task争抢通用worker
+type ConcurrencyTask interface {
+	MaxConcurrency() int64 // 当前消费进程内该task的最大并发执行数，小于等于0表示不限制
+	IsolatedPool() bool    // 是否为该task启动独立的worker池，MaxConcurrency小于等于0时忽略
+}
+
+// DistributedConcurrencyTask 可选实现的task跨节点并发控制契约
+//   - 通过 Semaphore 在所有消费节点之间限定该task同时执行的job总数
+type DistributedConcurrencyTask interface {
+	DistributedConcurrency() int64 // 所有消费节点该task的最大并发执行数之和，小于等于0表示不限制
+}
+
 // DefaultTaskSetting 默认task设置struct：实现默认的最大尝试次数、尝试间隔时长、最大执行时长
 type DefaultTaskSetting struct{}
 
@@ -305,8 +449,10 @@ type WorkerStatistics struct {
 
 // JobStatistics job任务统计结构
 type JobStatistics struct {
-	TotalJobs      int64            `json:"total_jobs"`      // 待消费的job总数
-	JobsStatistics map[string]int64 `json:"jobs_statistics"` // job和待消费数map
+	TotalJobs            int64                       `json:"total_jobs"`            // 待消费的job总数
+	JobsStatistics       map[string]int64            `json:"jobs_statistics"`       // job和待消费数map，含各分区待消费数
+	PartitionsStatistics map[string]map[string]int64 `json:"partitions_statistics"` // job与各分区待消费数map，仅列出存在任务的分区
+	ExpiredJobs          int64                       `json:"expired_jobs"`          // 当前进程启动以来因过期被丢弃的job数
 }
 
 // Statistics 统计信息
@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// 任务过期（deadline）：
+// 一、原理
+//    投递任务时通过 WithDeadline、WithTTL 设置任务的过期时刻，记录于 Payload.ExpireAt 字段
+// 二、执行
+//    任务每次尝试执行之前检查是否已过期，已过期的任务直接删除不再执行也不再重试，
+//    不视为失败任务：不触发失败处理器，task实现了 ExpirableTask 时回调其 OnExpired 方法
+// 三、统计
+//    当前进程丢弃的过期任务数记录于 JobStatistics.ExpiredJobs
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// ErrJobExpired 任务已过期
+var ErrJobExpired = errors.New("queue.job.expired")
+
+// ExpirableTask 可选实现的task任务过期回调契约
+type ExpirableTask interface {
+	// OnExpired 任务因过期被丢弃时回调，任务参数还原失败时job为nil
+	OnExpired(ctx context.Context, job *RawBody)
+}
+
+// DispatchOption 投递任务可选项
+type DispatchOption func(options *dispatchOptions)
+
+// dispatchOptions 投递任务可选项集合
+type dispatchOptions struct {
+	deadline time.Time     // 任务过期时刻
+	ttl      time.Duration // 任务自投递时刻起的有效时长
+}
+
+// WithDeadline 设置任务的过期时刻，到达该时刻仍未执行成功的任务不再执行
+func WithDeadline(deadline time.Time) DispatchOption {
+	return func(options *dispatchOptions) {
+		options.deadline = deadline
+	}
+}
+
+// WithTTL 设置任务自投递时刻起的有效时长，延迟任务同样自投递时刻起计算
+func WithTTL(ttl time.Duration) DispatchOption {
+	return func(options *dispatchOptions) {
+		options.ttl = ttl
+	}
+}
+
+// expireAt 计算任务过期时刻的时间戳，未设置时返回0，同时设置时取较早者
+func (options dispatchOptions) expireAt(now time.Time) int64 {
+	var expireAt time.Time
+	if !options.deadline.IsZero() {
+		expireAt = options.deadline
+	}
+	if options.ttl > 0 {
+		if at := now.Add(options.ttl); expireAt.IsZero() || at.Before(expireAt) {
+			expireAt = at
+		}
+	}
+	if expireAt.IsZero() {
+		return 0
+	}
+	return expireAt.Unix()
+}
+
+// isJobExpired 检查任务是否已过期
+func (m *manager) isJobExpired(job JobIFace) bool {
+	expireAt := job.Payload().ExpireAt
+	return expireAt > 0 && m.config.Clock.Now().Unix() >= expireAt
+}
+
+// expireJob 丢弃已过期的任务：删除任务、记录日志与事件、回调task的 OnExpired 方法
+func (m *manager) expireJob(ctx context.Context, task TaskIFace, job JobIFace, workerID int64) {
+	_ = job.Delete()
+	atomic.AddInt64(&m.expiredJobs, 1)
+
+	m.logger.Warn(
+		ErrJobExpired.Error(),
+		"queue", job.GetName(),
+		"worker_id", IFaceToString(workerID),
+		"payload", IFaceToString(job.Payload()),
+		"expire_at", time.Unix(job.Payload().ExpireAt, 0).String(),
+	)
+	m.jobEvent(job, JobExpired, workerID, ErrJobExpired)
+
+	if expirable, ok := task.(ExpirableTask); ok {
+		rawBody, _, err := m.rawBody(ctx, job)
+		if err != nil {
+			rawBody = nil
+		}
+		expirable.OnExpired(ctx, rawBody)
+	}
+
+	m.forgetBlob(job)
+}
@@ -0,0 +1,176 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// expiringTask 记录执行与过期回调的task，failures 次之前执行返回error
+type expiringTask struct {
+	DefaultTaskSetting
+	failures int
+	executed int
+	expired  []*RawBody
+}
+
+func (task *expiringTask) Name() string         { return "expiring_task" }
+func (task *expiringTask) MaxTries() int64      { return 3 }
+func (task *expiringTask) RetryInterval() int64 { return 30 }
+func (task *expiringTask) Remark() string       { return "expiring task" }
+func (task *expiringTask) Execute(ctx context.Context, job *RawBody) error {
+	task.executed++
+	if task.executed <= task.failures {
+		return errors.New("execute failed")
+	}
+	return nil
+}
+func (task *expiringTask) OnExpired(ctx context.Context, job *RawBody) {
+	task.expired = append(task.expired, job)
+}
+
+// newExpiryTestQueue 使用可快进时钟、外置大任务参数的Memory驱动队列
+func newExpiryTestQueue(t *testing.T, task *expiringTask) (*Queue, *sleepClock, BlobStore) {
+	t.Helper()
+	store, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileBlobStore() error = %v", err)
+	}
+	clock := &sleepClock{now: time.Now()}
+	service := New(Memory, nil, nopTestLogger{}, Config{Clock: clock, BlobStore: store, BlobThreshold: 16, JobHistory: true})
+	if err = service.BootstrapOne(task); err != nil {
+		t.Fatalf("BootstrapOne() error = %v", err)
+	}
+	service.SetFailedJobHandler(func(payload *Payload, err error) error {
+		t.Errorf("failed job handler called for %s: %v", payload.ID, err)
+		return nil
+	})
+	return service, clock, store
+}
+
+// runNext 取出并执行一个任务
+func runNext(t *testing.T, service *Queue, name string) JobIFace {
+	t.Helper()
+	job, exist, _ := service.manager.popJob(name)
+	if !exist {
+		t.Fatal("popJob() exist = false, want true")
+	}
+	service.manager.runJob(job, inlineWorkerID)
+	return job
+}
+
+func TestDispatchOptionsExpireAt(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name    string
+		options dispatchOptions
+		want    int64
+	}{
+		{"none", dispatchOptions{}, 0},
+		{"deadline", dispatchOptions{deadline: now.Add(time.Hour)}, now.Add(time.Hour).Unix()},
+		{"ttl", dispatchOptions{ttl: time.Minute}, now.Add(time.Minute).Unix()},
+		{"earlier ttl", dispatchOptions{deadline: now.Add(time.Hour), ttl: time.Minute}, now.Add(time.Minute).Unix()},
+		{"earlier deadline", dispatchOptions{deadline: now.Add(time.Second), ttl: time.Minute}, now.Add(time.Second).Unix()},
+	}
+	for _, tt := range tests {
+		if got := tt.options.expireAt(now); got != tt.want {
+			t.Errorf("%s: expireAt() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpiredJobDropped(t *testing.T) {
+	task := &expiringTask{}
+	service, clock, store := newExpiryTestQueue(t, task)
+
+	body := strings.Repeat("large body ", 8)
+	if err := service.Dispatch(task, body, WithTTL(time.Minute)); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	clock.Sleep(2 * time.Minute)
+	job := runNext(t, service, task.Name())
+	ref := job.Payload().BlobRef
+	if ref == "" {
+		t.Fatal("payload was not offloaded")
+	}
+
+	if task.executed != 0 {
+		t.Fatalf("expired job executed %d times", task.executed)
+	}
+	if len(task.expired) != 1 || task.expired[0] == nil || task.expired[0].String() != body {
+		t.Fatalf("OnExpired() called with %v, want the job body once", task.expired)
+	}
+	if size := service.queue.Size(task.Name()); size != 0 {
+		t.Fatalf("queue size = %d, want the expired job deleted", size)
+	}
+	if n := atomic.LoadInt64(&service.manager.expiredJobs); n != 1 {
+		t.Fatalf("expiredJobs = %d, want 1", n)
+	}
+	if _, err := store.Get(context.Background(), ref); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("blob Get() error = %v, want the blob removed", err)
+	}
+	events, _ := service.JobHistory(job.Payload().ID)
+	if len(events) == 0 || events[len(events)-1].Type != JobExpired {
+		t.Fatalf("JobHistory() = %+v, want the expired event last", events)
+	}
+}
+
+func TestUnexpiredJobExecuted(t *testing.T) {
+	task := &expiringTask{}
+	service, clock, _ := newExpiryTestQueue(t, task)
+
+	if err := service.Dispatch(task, "body", WithDeadline(clock.Now().Add(time.Minute))); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	runNext(t, service, task.Name())
+	if task.executed != 1 || len(task.expired) != 0 {
+		t.Fatalf("executed %d times, expired %d times", task.executed, len(task.expired))
+	}
+}
+
+func TestExpiredJobNotRetried(t *testing.T) {
+	task := &expiringTask{failures: 1}
+	service, clock, _ := newExpiryTestQueue(t, task)
+
+	if err := service.Dispatch(task, "body", WithTTL(time.Minute)); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	runNext(t, service, task.Name())
+	if task.executed != 1 {
+		t.Fatalf("executed %d times, want 1", task.executed)
+	}
+
+	// 重试时刻晚于过期时刻：重试前丢弃
+	clock.Sleep(2 * time.Minute)
+	runNext(t, service, task.Name())
+	if task.executed != 1 || len(task.expired) != 1 {
+		t.Fatalf("executed %d times, expired %d times, want the retry dropped", task.executed, len(task.expired))
+	}
+	if size := service.queue.Size(task.Name()); size != 0 {
+		t.Fatalf("queue size = %d, want the expired job deleted", size)
+	}
+}
+
+func TestExpiredJobWithMissingBlob(t *testing.T) {
+	task := &expiringTask{}
+	service, clock, store := newExpiryTestQueue(t, task)
+
+	if err := service.Dispatch(task, strings.Repeat("large body ", 8), WithTTL(time.Minute)); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	job, exist, _ := service.manager.popJob(task.Name())
+	if !exist {
+		t.Fatal("popJob() exist = false, want true")
+	}
+	_ = store.Delete(context.Background(), job.Payload().BlobRef)
+	clock.Sleep(2 * time.Minute)
+	service.manager.runJob(job, inlineWorkerID)
+
+	// 任务参数还原失败时以nil回调
+	if len(task.expired) != 1 || task.expired[0] != nil {
+		t.Fatalf("OnExpired() called with %v, want nil", task.expired)
+	}
+}
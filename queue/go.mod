@@ -3,9 +3,12 @@ module github.com/jjonline/go-lib-backend/queue
 go 1.24
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
+	github.com/alicebob/miniredis/v2 v2.37.0
 	github.com/go-sql-driver/mysql v1.9.3
 	github.com/go-stack/stack v1.8.1
 	github.com/google/uuid v1.6.0
+	github.com/klauspost/compress v1.18.0
 	github.com/redis/go-redis/v9 v9.13.0
 	github.com/shirou/gopsutil/v4 v4.25.8
 )
@@ -20,6 +23,7 @@ require (
 	github.com/power-devops/perfstat v0.0.0-20240221224432-82ca36839d55 // indirect
 	github.com/tklauser/go-sysconf v0.3.15 // indirect
 	github.com/tklauser/numcpus v0.10.0 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	github.com/yusufpapurcu/wmi v1.2.4 // indirect
 	golang.org/x/sys v0.35.0 // indirect
 )
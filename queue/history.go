@@ -0,0 +1,138 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// 任务事件历史（按任务ID回溯任务执行过程）：
+// 一、原理
+//    开启 Config.JobHistory 后，任务投递、取出、开始执行、执行失败、释放重试、执行成功、最终失败
+//    均追加一条 JobEvent 至底层驱动，按任务ID（Payload.ID）存储
+// 二、有界
+//    单个任务最多保留 Config.JobHistoryMaxEvents 条事件，超出丢弃最早的事件；
+//    每次追加事件时重新计时，最后一条事件 Config.JobHistoryTTL 时长后整个事件历史过期
+// 三、存储
+//    Redis驱动使用List，MySQL驱动使用 queue_job_events 表，Memory与Sync驱动存储于进程内存，
+//    自定义驱动可实现 JobHistoryIFace 接口以支持事件历史
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// JobEventType 任务事件类型
+type JobEventType string
+
+// 任务事件类型常量
+const (
+	JobDispatched     JobEventType = "dispatched"      // 任务已投递
+	JobDispatchFailed JobEventType = "dispatch_failed" // 任务投递失败
+	JobPopped         JobEventType = "popped"          // 任务已被looper取出
+	JobAttemptStarted JobEventType = "attempt_started" // 第N次尝试开始执行
+	JobAttemptFailed  JobEventType = "attempt_failed"  // 第N次尝试执行失败：返回error、panic或超时
+	JobReleased       JobEventType = "released"        // 任务已释放，将在延迟时长后重试
+	JobSucceeded      JobEventType = "succeeded"       // 任务执行成功
+	JobFailed         JobEventType = "failed"          // 任务最终执行失败，不再重试
+	JobExpired        JobEventType = "expired"         // 任务已过期，丢弃不再执行
+)
+
+// historyPurgeInterval 进程内存实现清理已过期事件历史的间隔
+const historyPurgeInterval = time.Minute
+
+// ErrJobHistoryUnsupported 队列驱动不支持任务事件历史
+var ErrJobHistoryUnsupported = errors.New("queue.job.history.unsupported")
+
+// JobEvent 一条任务事件
+type JobEvent struct {
+	Type     JobEventType `json:"type"`            // 事件类型
+	Queue    string       `json:"queue"`           // 任务所属队列名称
+	Attempt  int64        `json:"attempt"`         // 事件发生时任务已尝试执行的次数
+	WorkerID int64        `json:"worker_id"`       // 执行任务的worker ID，投递、取出事件为0，同步执行为-1
+	Delay    int64        `json:"delay,omitempty"` // 投递的延迟任务、释放重试的任务距离可执行的秒数
+	Error    string       `json:"error,omitempty"` // 执行失败、投递失败的错误信息
+	Time     time.Time    `json:"time"`            // 事件发生的时刻
+}
+
+// JobHistoryIFace 可选实现的任务事件历史存储契约，内置驱动均已实现
+type JobHistoryIFace interface {
+	// AppendJobEvent 追加一条任务事件
+	//   - id        任务ID
+	//   - event     任务事件
+	//   - ttl       事件历史保留时长，每次追加事件时重新计时
+	//   - maxEvents 单个任务最多保留的事件条数，超出时丢弃最早的事件
+	AppendJobEvent(id string, event JobEvent, ttl time.Duration, maxEvents int64) (err error)
+	// JobHistory 按发生先后顺序获取任务事件历史，不存在或已过期时返回空
+	JobHistory(id string) (events []JobEvent, err error)
+}
+
+// region 进程内存实现
+
+// memoryJobHistory 存储于进程内存的任务事件历史，Memory与Sync驱动共用
+type memoryJobHistory struct {
+	historyLock sync.Mutex                    // 并发锁
+	histories   map[string]*memoryHistoryItem // 任务ID与事件历史映射map
+	clock       Clock                         // 时钟，事件历史过期判断基于该时钟
+	purgedAt    time.Time                     // 上次清理已过期事件历史的时刻
+}
+
+// memoryHistoryItem 单个任务的事件历史
+type memoryHistoryItem struct {
+	events   []JobEvent // 按发生先后顺序排列的事件
+	expireAt time.Time  // 过期时刻
+}
+
+// AppendJobEvent 追加一条任务事件，同时清理已过期的事件历史
+func (h *memoryJobHistory) AppendJobEvent(id string, event JobEvent, ttl time.Duration, maxEvents int64) (err error) {
+	h.historyLock.Lock()
+	defer h.historyLock.Unlock()
+
+	if h.histories == nil {
+		h.histories = make(map[string]*memoryHistoryItem)
+	}
+
+	// 间隔一定时长才清理一次已过期的事件历史，避免每次追加均遍历
+	now := h.now()
+	if now.Sub(h.purgedAt) >= historyPurgeInterval {
+		for key, item := range h.histories {
+			if !item.expireAt.After(now) {
+				delete(h.histories, key)
+			}
+		}
+		h.purgedAt = now
+	}
+
+	item, exist := h.histories[id]
+	if !exist {
+		item = &memoryHistoryItem{}
+		h.histories[id] = item
+	}
+	item.events = append(item.events, event)
+	if maxEvents > 0 && int64(len(item.events)) > maxEvents {
+		item.events = append([]JobEvent(nil), item.events[int64(len(item.events))-maxEvents:]...)
+	}
+	item.expireAt = now.Add(ttl)
+
+	return nil
+}
+
+// JobHistory 按发生先后顺序获取任务事件历史
+func (h *memoryJobHistory) JobHistory(id string) (events []JobEvent, err error) {
+	h.historyLock.Lock()
+	defer h.historyLock.Unlock()
+
+	item, exist := h.histories[id]
+	if !exist || !item.expireAt.After(h.now()) {
+		return nil, nil
+	}
+
+	return append([]JobEvent(nil), item.events...), nil
+}
+
+// now 获取当前时刻
+func (h *memoryJobHistory) now() time.Time {
+	if h.clock == nil {
+		return time.Now()
+	}
+	return h.clock.Now()
+}
+
+// endregion
@@ -0,0 +1,153 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// historyTask 记录任务ID的syncTask
+type historyTask struct {
+	*syncTask
+	id string
+}
+
+func (task *historyTask) Execute(ctx context.Context, job *RawBody) error {
+	task.id = job.ID
+	return task.syncTask.Execute(ctx, job)
+}
+
+// dispatchHistoryTask 同步执行一个前2次失败、第3次成功的任务，返回其事件历史
+func dispatchHistoryTask(t *testing.T, maxEvents int64) []JobEvent {
+	t.Helper()
+	clock := &sleepClock{now: time.Unix(1700000000, 0)}
+	task := &historyTask{syncTask: &syncTask{tries: 3, failures: 2, clock: clock}}
+	service := New(Sync, nil, nopTestLogger{}, Config{Clock: clock, JobHistory: true, JobHistoryMaxEvents: maxEvents})
+	if err := service.BootstrapOne(task); err != nil {
+		t.Fatalf("BootstrapOne() error = %v", err)
+	}
+	if err := service.Dispatch(task, "body"); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	events, err := service.JobHistory(task.id)
+	if err != nil {
+		t.Fatalf("JobHistory() error = %v", err)
+	}
+	return events
+}
+
+// eventTrace 事件类型与尝试次数，譬如：released@1
+func eventTrace(events []JobEvent) []string {
+	trace := make([]string, 0, len(events))
+	for _, event := range events {
+		trace = append(trace, string(event.Type)+"@"+strconv.FormatInt(event.Attempt, 10))
+	}
+	return trace
+}
+
+func TestQueueJobHistoryOrder(t *testing.T) {
+	events := dispatchHistoryTask(t, 0)
+	want := []string{
+		"dispatched@0",
+		"attempt_started@1", "attempt_failed@1", "released@1",
+		"attempt_started@2", "attempt_failed@2", "released@2",
+		"attempt_started@3", "succeeded@3",
+	}
+	trace := eventTrace(events)
+	if len(trace) != len(want) {
+		t.Fatalf("JobHistory() = %v, want %v", trace, want)
+	}
+	for i := range want {
+		if trace[i] != want[i] {
+			t.Fatalf("JobHistory() = %v, want %v", trace, want)
+		}
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Time.Before(events[i-1].Time) {
+			t.Fatalf("event %d at %v before event %d at %v", i, events[i].Time, i-1, events[i-1].Time)
+		}
+	}
+	if events[2].Error != errSyncExecute.Error() || events[3].Delay != 30 || events[1].WorkerID != -1 {
+		t.Fatalf("event fields %+v %+v %+v", events[1], events[2], events[3])
+	}
+}
+
+func TestQueueJobHistoryMaxEvents(t *testing.T) {
+	trace := eventTrace(dispatchHistoryTask(t, 3))
+	if len(trace) != 3 || trace[0] != "released@2" || trace[2] != "succeeded@3" {
+		t.Fatalf("JobHistory() = %v, want the last 3 events", trace)
+	}
+}
+
+func TestMemoryJobHistoryTTL(t *testing.T) {
+	clock := &sleepClock{now: time.Unix(1700000000, 0)}
+	h := &memoryJobHistory{clock: clock}
+	appendEvent := func(id string, event JobEventType, ttl time.Duration) {
+		if err := h.AppendJobEvent(id, JobEvent{Type: event, Time: clock.Now()}, ttl, 10); err != nil {
+			t.Fatalf("AppendJobEvent() error = %v", err)
+		}
+	}
+
+	appendEvent("a", JobDispatched, time.Minute)
+	clock.Sleep(50 * time.Second)
+	// 追加事件时重新计时
+	appendEvent("a", JobPopped, time.Minute)
+	clock.Sleep(50 * time.Second)
+	if events, _ := h.JobHistory("a"); len(events) != 2 {
+		t.Fatalf("JobHistory() = %d events before the reset TTL, want 2", len(events))
+	}
+	clock.Sleep(10 * time.Second)
+	if events, _ := h.JobHistory("a"); events != nil {
+		t.Fatalf("JobHistory() = %v after the TTL", events)
+	}
+
+	// 距上次清理 historyPurgeInterval 后追加事件时清理已过期的事件历史
+	appendEvent("b", JobDispatched, time.Minute)
+	if _, exist := h.histories["a"]; exist {
+		t.Fatal("expired history kept after historyPurgeInterval")
+	}
+	clock.Sleep(2 * time.Minute)
+	appendEvent("c", JobDispatched, 10*time.Second)
+	if _, exist := h.histories["b"]; exist {
+		t.Fatal("expired history kept after historyPurgeInterval")
+	}
+	clock.Sleep(30 * time.Second)
+	appendEvent("d", JobDispatched, time.Minute)
+	if _, exist := h.histories["c"]; !exist {
+		t.Fatal("expired history purged within historyPurgeInterval")
+	}
+	if events, _ := h.JobHistory("c"); events != nil {
+		t.Fatalf("JobHistory() = %v after the TTL", events)
+	}
+}
+
+func TestRedisJobHistory(t *testing.T) {
+	server, client := newTestRedis(t)
+	driver, _ := NewDriver(Redis, client, Config{})
+	history := driver.(JobHistoryIFace)
+
+	for i := int64(1); i <= 5; i++ {
+		if err := history.AppendJobEvent("a", JobEvent{Type: JobAttemptStarted, Attempt: i}, time.Minute, 3); err != nil {
+			t.Fatalf("AppendJobEvent() error = %v", err)
+		}
+	}
+	events, err := history.JobHistory("a")
+	if err != nil {
+		t.Fatalf("JobHistory() error = %v", err)
+	}
+	if trace := eventTrace(events); len(trace) != 3 || trace[0] != "attempt_started@3" || trace[2] != "attempt_started@5" {
+		t.Fatalf("JobHistory() = %v, want the last 3 events in order", trace)
+	}
+
+	server.FastForward(50 * time.Second)
+	_ = history.AppendJobEvent("a", JobEvent{Type: JobSucceeded, Attempt: 5}, time.Minute, 3)
+	server.FastForward(50 * time.Second)
+	if events, _ = history.JobHistory("a"); len(events) != 3 {
+		t.Fatalf("JobHistory() = %d events before the reset TTL, want 3", len(events))
+	}
+	server.FastForward(10 * time.Second)
+	if events, _ = history.JobHistory("a"); len(events) != 0 {
+		t.Fatalf("JobHistory() = %v after the TTL", events)
+	}
+}
@@ -2,6 +2,7 @@ package queue
 
 import (
 	"fmt"
+	"sync"
 	"time"
 )
 
@@ -11,16 +12,21 @@ import (
  */
 
 type JobMemory struct {
+	lock        *sync.Mutex // 所属队列的并发锁，保护延迟map与保留map
 	basic       queueBasic
 	delayed     map[string]map[string]*itemValue // 延迟map ref type
 	reserved    map[string]map[string]*itemValue // 保留map ref type
 	reservedJob Payload                          // 处理后的保留状态的job
+	clock       Clock                            // 所属队列的时钟
 	jobProperty
 }
 
 func (job *JobMemory) Release(delay int64) (err error) {
 	job.isReleased = true
 
+	job.lock.Lock()
+	defer job.lock.Unlock()
+
 	if _, exist := job.reserved[job.GetName()]; !exist {
 		return fmt.Errorf("queue %s do no exist", job.GetName())
 	}
@@ -39,7 +45,7 @@ func (job *JobMemory) Release(delay int64) (err error) {
 	// 移动到延迟队列
 	itemV := itemValue{
 		Payload: job.reservedJob,
-		TimeAt:  time.Now().Add(time.Duration(delay) * time.Second).Unix(),
+		TimeAt:  job.clock.Now().Add(time.Duration(delay) * time.Second).Unix(),
 	}
 	job.delayed[job.GetName()][job.payload.ID] = &itemV
 
@@ -49,6 +55,9 @@ func (job *JobMemory) Release(delay int64) (err error) {
 func (job *JobMemory) Delete() (err error) {
 	job.isDeleted = true
 
+	job.lock.Lock()
+	defer job.lock.Unlock()
+
 	if _, exist := job.reserved[job.GetName()]; !exist {
 		return fmt.Errorf("queue %s do no exist", job.GetName())
 	}
@@ -15,7 +15,7 @@ import (
 
 type JobRedis struct {
 	basic      queueBasic // 引入基础公用方法
-	redis      *redis.Client
+	redis      redis.UniversalClient
 	luaScripts *luaScripts
 	lock       sync.Mutex // 防幻读锁
 	jobProperty
@@ -36,6 +36,8 @@ func (job *JobRedis) Release(delay int64) (err error) {
 		[]string{job.basic.delayedName(job.name), job.basic.reservedName(job.name)},
 		job.reserved,
 		time.Now().Add(time.Duration(delay)*time.Second).Unix(),
+		job.basic.wakeupChannel(),
+		job.name,
 	).Err()
 
 	return err
@@ -48,7 +48,48 @@ redis.call('zrem', KEYS[2], ARGV[1])
 -- Add the job onto the "delayed" queue...
 redis.call('zadd', KEYS[1], ARGV[2], ARGV[1])
 
+-- Wake up the blocking consumers when the next due time moves earlier...
+local first = redis.call('zrange', KEYS[1], 0, 0, 'WITHSCORES')
+if(first[2] ~= nil and tonumber(first[2]) >= tonumber(ARGV[2])) then
+    redis.call('publish', ARGV[3], ARGV[4])
+end
+
 return true
+`)
+	later = redis.NewScript(`
+-- Add the job onto the "delayed" queue...
+redis.call('zadd', KEYS[1], ARGV[1], ARGV[2])
+
+-- Wake up the blocking consumers when the next due time moves earlier...
+local first = redis.call('zrange', KEYS[1], 0, 0, 'WITHSCORES')
+if(first[2] ~= nil and tonumber(first[2]) >= tonumber(ARGV[1])) then
+    redis.call('publish', ARGV[3], ARGV[4])
+end
+
+return true
+`)
+	prepare = redis.NewScript(`
+-- Migrate the expired "delayed" and "reserved" jobs onto the queue...
+for i = 2, 3 do
+    local val = redis.call('zrangebyscore', KEYS[i], '-inf', ARGV[1])
+    if(next(val) ~= nil) then
+        redis.call('zremrangebyrank', KEYS[i], 0, #val - 1)
+        for j = 1, #val, 100 do
+            redis.call('rpush', KEYS[1], unpack(val, j, math.min(j+99, #val)))
+        end
+    end
+end
+
+-- Get the size of the queue and the next due time of the "delayed" and "reserved" jobs...
+local nextAt = -1
+for i = 2, 3 do
+    local first = redis.call('zrange', KEYS[i], 0, 0, 'WITHSCORES')
+    if(first[2] ~= nil and (nextAt < 0 or tonumber(first[2]) < nextAt)) then
+        nextAt = tonumber(first[2])
+    end
+end
+
+return {redis.call('llen', KEYS[1]), nextAt}
 `)
 	migrate = redis.NewScript(`
 -- Get all of the jobs with an expired "score"...
@@ -66,6 +107,28 @@ if(next(val) ~= nil) then
 end
 
 return val
+`)
+	acquire = redis.NewScript(`
+-- Remove the expired slots of the semaphore...
+redis.call('zremrangebyscore', KEYS[1], '-inf', ARGV[1])
+
+-- Occupy one slot when the semaphore is not full...
+if redis.call('zcard', KEYS[1]) < tonumber(ARGV[2]) then
+    redis.call('zadd', KEYS[1], ARGV[3], ARGV[4])
+    redis.call('pexpireat', KEYS[1], ARGV[3])
+    return 1
+end
+
+return 0
+`)
+	prunePartition = redis.NewScript(`
+-- Get the size of the partition queue and remove the partition when it is empty...
+local size = redis.call('llen', KEYS[2]) + redis.call('zcard', KEYS[3]) + redis.call('zcard', KEYS[4])
+if size == 0 then
+    redis.call('srem', KEYS[1], ARGV[1])
+end
+
+return size
 `)
 )
 
@@ -105,6 +168,8 @@ func (lua *luaScripts) Pop() *redis.Script {
  * KEYS[2] - The queue the jobs are currently on, for example: queues:foo:reserved
  * ARGV[1] - The raw payload of the job to add to the "delayed" queue
  * ARGV[2] - The UNIX timestamp at which the job should become available
+ * ARGV[3] - The wake-up pub/sub channel of the blocking consumers
+ * ARGV[4] - The name of the queue, published as the wake-up message
  *
  * @return string
  */
@@ -125,3 +190,66 @@ func (lua *luaScripts) Release() *redis.Script {
 func (lua *luaScripts) MigrateExpiredJobs() *redis.Script {
 	return migrate
 }
+
+// Acquire
+/**
+ * Get the Lua script for acquiring one slot of the distributed semaphore.
+ *
+ * KEYS[1] - The sorted set of the semaphore slots, for example: queues:foo:semaphore
+ * ARGV[1] - The current UNIX timestamp in milliseconds
+ * ARGV[2] - The max slots of the semaphore
+ * ARGV[3] - The UNIX timestamp in milliseconds at which the slot expires
+ * ARGV[4] - The token of the slot
+ *
+ * @return int
+ */
+func (lua *luaScripts) Acquire() *redis.Script {
+	return acquire
+}
+
+// Later
+/**
+ * Get the Lua script for pushing a delayed job and waking up the blocking consumers.
+ *
+ * KEYS[1] - The "delayed" queue, for example: queues:foo:delayed
+ * ARGV[1] - The UNIX timestamp at which the job should become available
+ * ARGV[2] - The raw payload of the job
+ * ARGV[3] - The wake-up pub/sub channel of the blocking consumers
+ * ARGV[4] - The name of the queue, published as the wake-up message
+ *
+ * @return bool
+ */
+func (lua *luaScripts) Later() *redis.Script {
+	return later
+}
+
+// Prepare
+/**
+ * Get the Lua script for migrating the expired jobs before blocking wait.
+ *
+ * KEYS[1] - The name of the primary queue
+ * KEYS[2] - The name of the "delayed" queue
+ * KEYS[3] - The name of the "reserved" queue
+ * ARGV[1] - The current UNIX timestamp
+ *
+ * @return {size of the primary queue, next due UNIX timestamp or -1}
+ */
+func (lua *luaScripts) Prepare() *redis.Script {
+	return prepare
+}
+
+// PrunePartition
+/**
+ * Get the Lua script for computing the size of a partition queue and removing the partition when it is empty.
+ *
+ * KEYS[1] - The set of the partitions, for example: queues:foo:partitions
+ * KEYS[2] - The name of the partition queue, for example: queues:foo:partition:bar
+ * KEYS[3] - The name of the "delayed" partition queue
+ * KEYS[4] - The name of the "reserved" partition queue
+ * ARGV[1] - The partition, for example: bar
+ *
+ * @return int
+ */
+func (lua *luaScripts) PrunePartition() *redis.Script {
+	return prunePartition
+}
@@ -3,9 +3,11 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"runtime"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -25,6 +27,8 @@ const (
 	general                   = "general"              // 通用名称
 	jitterBase                = 450 * time.Millisecond // looper最小为450毫秒间隔，最大为1000毫秒间隔
 	memoryMaxPercentThreshold = 90                     // 系统内存使用率阈值后停止自动扩容
+	inlineWorkerID            = -1                     // 在调用方goroutine中直接执行job时使用的虚拟worker ID
+	isolatedWorkerIDBase      = 1 << 32                // 独立池worker ID起始值，与通用worker ID区间隔离
 )
 
 type atomicBool int32
@@ -35,25 +39,32 @@ func (b *atomicBool) setFalse()   { atomic.StoreInt32((*int32)(b), 0) }
 
 // manager 队列管理者，队列的调度执行和管理
 type manager struct {
-	queue            QueueIFace               // 队列底层实现实例
-	channel          chan JobIFace            // 任务类执行job的通道chan
-	logger           Logger                   // 实现 Logger 接口的结构体实例的指针对象
-	config           Config                   // 队列配置
-	concurrent       int64                    // 当前并发worker数
-	tasks            map[string]TaskIFace     // 队列名与任务类实例映射map，interface无需显式指定执指针类型，但实际传参需指针类型
-	failedJobHandler FailedJobHandler         // 失败任务[最大尝试次数后仍然尝试失败（Execute返回了Error 或 执行导致panic）的任务]处理器
-	lock             sync.Mutex               // 并发锁
-	doneChan         chan struct{}            // 关闭队列的信号控制chan
-	inShutdown       atomicBool               // 原子态标记：是否处于优雅关闭状态中
-	isChannelClosed  atomicBool               // 原子态标记：looper与worker之间channel是否已关闭，多个looper争抢关闭channel
-	inWorkingMap     sync.Map                 // map[string]int64  当前正work中的jobID与workerID映射map
-	workerStatus     map[int64]*atomicBool    // worker工作进程状态标记map
-	workerChannel    map[int64]chan struct{}  // worker停止信号通道映射map
-	jitter           map[string]time.Duration // 循环器抖动间隔，key为task或general，value为对应looper的循环间隔
-	allowTasks       map[string]struct{}      // 指定可以运行的队列
-	excludeTasks     map[string]struct{}      // 指定不可运行的队列
-	realTasksNum     int64                    // 可以运行的task数（综合计算task、allowTasks、canExecuteTask）
-	nextWorkerID     int64                    // 下一个worker ID
+	queue            QueueIFace                     // 队列底层实现实例
+	channel          chan JobIFace                  // 任务类执行job的通道chan
+	logger           Logger                         // 实现 Logger 接口的结构体实例的指针对象
+	config           Config                         // 队列配置
+	concurrent       int64                          // 当前并发worker数
+	tasks            map[string]TaskIFace           // 队列名与任务类实例映射map，interface无需显式指定执指针类型，但实际传参需指针类型
+	failedJobHandler FailedJobHandler               // 失败任务[最大尝试次数后仍然尝试失败（Execute返回了Error 或 执行导致panic）的任务]处理器
+	lock             sync.Mutex                     // 并发锁
+	doneChan         chan struct{}                  // 关闭队列的信号控制chan
+	inShutdown       atomicBool                     // 原子态标记：是否处于优雅关闭状态中
+	isChannelClosed  atomicBool                     // 原子态标记：looper与worker之间channel是否已关闭，多个looper争抢关闭channel
+	inWorkingMap     sync.Map                       // map[string]int64  当前正work中的jobID与workerID映射map
+	workerStatus     map[int64]*atomicBool          // worker工作进程状态标记map
+	workerChannel    map[int64]chan struct{}        // worker停止信号通道映射map
+	jitter           map[string]time.Duration       // 循环器抖动间隔，key为task或general，value为对应looper的循环间隔
+	allowTasks       map[string]struct{}            // 指定可以运行的队列
+	excludeTasks     map[string]struct{}            // 指定不可运行的队列
+	realTasksNum     int64                          // 可以运行的task数（综合计算task、allowTasks、canExecuteTask）
+	nextWorkerID     int64                          // 下一个通用worker ID，独立池worker ID自 isolatedWorkerIDBase 起按 isolatedWorkers 递增
+	slots            map[string]chan struct{}       // task与进程内并发槽位映射map，仅实现了 ConcurrencyTask 的task存在
+	pools            map[string]chan JobIFace       // task与独立worker池job通道映射map，仅开启了独立worker池的task存在
+	jobSlots         sync.Map                       // map[JobIFace]func() 已获取并发槽位的job与槽位释放方法映射map
+	isolatedWorkers  int64                          // 独立worker池的worker总数，独立池worker不参与自动扩缩容
+	codec            *payloadCodec                  // 任务参数转换器链，未配置转换器时为nil
+	partitions       map[string]*partitionScheduler // task与分区调度器映射map，仅底层驱动支持分区时存在
+	expiredJobs      int64                          // 因过期被丢弃的job数，原子操作
 }
 
 // newManager 实例化一个manager
@@ -76,6 +87,10 @@ func newManager(queue QueueIFace, logger Logger, config Config) *manager {
 		excludeTasks:  make(map[string]struct{}),
 		realTasksNum:  0,
 		nextWorkerID:  0,
+		slots:         make(map[string]chan struct{}),
+		pools:         make(map[string]chan JobIFace),
+		codec:         newPayloadCodec(config.PayloadTransformers),
+		partitions:    make(map[string]*partitionScheduler),
 	}
 }
 
@@ -117,13 +132,29 @@ func (m *manager) start() (err error) {
 		return ErrQueueClosed
 	}
 
-	// ① 启动通用looper
+	// ① 初始化task并发控制与分区调度器：需在looper启动之前完成，looper运行期间只读
+	m.lock.Lock()
+	for name, task := range m.tasks {
+		if m.allowRun(name) {
+			m.initConcurrency(name, task)
+			m.initPartition(name, task)
+		}
+	}
+	m.lock.Unlock()
+
+	if m.config.BlockingPop {
+		if _, ok := m.blockingQueue(); !ok {
+			m.logger.Info("queue driver do not support blocking pop, fallback to polling")
+		}
+	}
+
+	// ② 启动通用looper
 	go m.startGeneralLooper()
 
-	// ② 启动task专用looper
+	// ③ 启动task专用looper
 	m.startDedicatedLooper()
 
-	// ③ 启动task数量的worker
+	// ④ 启动task数量的worker，开启独立worker池的task启动其独立worker
 	m.lock.Lock()
 	m.startSingleWorker() // for general +1 worker
 	for name := range m.tasks {
@@ -132,18 +163,32 @@ func (m *manager) start() (err error) {
 			continue
 		}
 		m.realTasksNum++ // 记录真实运行运行的task数
+		if pool, isolated := m.pools[name]; isolated {
+			for range cap(m.slots[name]) {
+				m.startPoolWorker(pool)
+			}
+			continue
+		}
 		m.startSingleWorker()
 	}
 	m.lock.Unlock()
 
-	// ④ 启动自动扩缩容检测器
+	// ⑤ 启动自动扩缩容检测器
 	go m.startAutoScaleMonitor()
 
 	return err
 }
 
 // startGeneralLooper 启动通用looper，用于loop所有task
+//   - 阻塞等待模式下所有task均由专用looper阻塞等待调度，通用looper仅负责退出时关闭job通道
 func (m *manager) startGeneralLooper() {
+	if _, ok := m.blockingQueue(); ok {
+		<-m.getDoneChan()
+		m.logger.Info("shutdown, queue general looper exited")
+		m.closeChannel() // close job chan
+		return
+	}
+
 	for {
 		select {
 		case <-m.getDoneChan():
@@ -168,13 +213,18 @@ func (m *manager) generalLooper() {
 			continue
 		}
 
+		// 独立worker池的task仅由其专用looper调度
+		if _, isolated := m.pools[name]; isolated {
+			continue
+		}
+
 		// chan关闭则退出
 		if m.isChannelClosed.isSet() {
 			return
 		}
 
-		if job, exist := m.queue.Pop(name); exist {
-			m.channel <- job // push job to worker for control process
+		if job, exist, _ := m.popJob(name); exist {
+			m.dispatchJob(name, job) // push job to worker for control process
 			needSleep = false
 		}
 	}
@@ -202,6 +252,11 @@ func (m *manager) startDedicatedLooper() {
 
 // dedicatedLooper 专用轮询 && 速率控制所有队列的looper
 func (m *manager) dedicatedLooper(name string) {
+	// 专用looper是独立worker池job通道的唯一投递方，退出时负责关闭通道
+	defer m.closePool(name)
+
+	blocker, blocking := m.blockingQueue()
+
 	for {
 		select {
 		case <-m.getDoneChan():
@@ -217,8 +272,16 @@ func (m *manager) dedicatedLooper(name string) {
 			// range本身就是随机的
 			needSleep := true
 
-			if job, exist := m.queue.Pop(name); exist {
-				m.channel <- job // push job to worker for control process
+			job, exist, limited := m.popJob(name)
+			if exist {
+				m.dispatchJob(name, job) // push job to worker for control process
+				needSleep = false
+			}
+
+			// 阻塞等待模式下队列暂无job任务则阻塞等待，并发槽位已满或存在分区时仍随机休眠
+			if needSleep && blocking && !limited && !m.hasPartitions(name) {
+				blocker.Wait(name, m.config.BlockingTimeout)
+				m.expirePartitions(name) // 可能因新分区出现而被唤醒
 				needSleep = false
 			}
 
@@ -258,7 +321,7 @@ func (m *manager) allowRun(jobName string) bool {
 	return true
 }
 
-// startSingleWorker 启动单个worker进程（需要在持有锁的情况下调用）
+// startSingleWorker 启动单个通用worker进程（需要在持有锁的情况下调用）
 func (m *manager) startSingleWorker() {
 	workerID := m.nextWorkerID
 	m.nextWorkerID++
@@ -271,11 +334,26 @@ func (m *manager) startSingleWorker() {
 	m.workerStatus[workerID] = new(atomicBool)
 
 	// 启动worker goroutine
-	go m.startWorker(workerID, stopChan)
+	go m.startWorker(workerID, m.channel, stopChan)
+}
+
+// startPoolWorker 启动单个task独立worker池的worker进程（需要在持有锁的情况下调用）
+//   - 独立池worker没有停止信号通道，随独立池job通道关闭而退出
+func (m *manager) startPoolWorker(pool chan JobIFace) {
+	workerID := isolatedWorkerIDBase + m.isolatedWorkers
+	m.isolatedWorkers++
+
+	// 初始化worker状态
+	m.workerStatus[workerID] = new(atomicBool)
+
+	// 启动worker goroutine
+	go m.startWorker(workerID, pool, nil)
 }
 
 // startWorker 启动队列进程工作者
-func (m *manager) startWorker(workerID int64, stopChan chan struct{}) {
+//   - jobChan  worker消费的job通道：通用通道或task独立worker池通道
+//   - stopChan worker停止信号通道，为nil时仅随jobChan关闭而退出
+func (m *manager) startWorker(workerID int64, jobChan chan JobIFace, stopChan chan struct{}) {
 	defer func() {
 		// 清理worker相关资源
 		m.lock.Lock()
@@ -292,12 +370,12 @@ func (m *manager) startWorker(workerID int64, stopChan chan struct{}) {
 	// 阻塞消费job chan或等待停止信号
 	for {
 		select {
-		case job, ok := <-m.channel:
+		case job, ok := <-jobChan:
 			if !ok {
 				// channel已关闭，退出worker
 				return
 			}
-			m.runJob(job, workerID) // process run job
+			m.runJob(job, workerID) // process run job, release concurrency slot when handler finished
 		case <-stopChan:
 			// 收到停止信号，退出worker
 			return
@@ -306,10 +384,19 @@ func (m *manager) startWorker(workerID int64, stopChan chan struct{}) {
 }
 
 // runJob 执行队列job，超时控制 && 尝试次数控制，执行结果控制
+//   - job出队前获取的并发槽位在task执行结束后释放：执行超时时task仍在执行，槽位随执行goroutine结束才释放
 func (m *manager) runJob(job JobIFace, workerID int64) {
 	// set worker is true
 	m.setWorkerStatus(workerID, true)
 
+	// 未进入执行阶段即返回时由此释放并发槽位，否则交由执行goroutine释放
+	executing := false
+	defer func() {
+		if !executing {
+			m.releaseSlot(job)
+		}
+	}()
+
 	// step1、任务类执行捕获可能的panic
 	defer func() {
 		// set worker execute is false
@@ -338,11 +425,13 @@ func (m *manager) runJob(job JobIFace, workerID int64) {
 			}
 
 			// panic: 检查任务尝试执行次数 & 标记失败状态
-			m.markJobAsFailedIfWillExceedMaxAttempts(job, eErr)
+			m.jobEvent(job, JobAttemptFailed, workerID, eErr)
+			m.markJobAsFailedIfWillExceedMaxAttempts(job, workerID, eErr)
 		}
 	}()
 
-	task, ok := m.tasks[job.GetName()]
+	// 分区子队列中的job所属task名称以payload为准
+	task, ok := m.tasks[job.Payload().Name]
 	if !ok {
 		return
 	}
@@ -361,6 +450,14 @@ func (m *manager) runJob(job JobIFace, workerID int64) {
 		if payload, err := json.Marshal(job.Payload()); err == nil {
 			_ = job.Queue().Later(job.GetName(), time.Duration(job.Payload().RetryInterval)*time.Second, payload)
 		}
+		m.recordJobEvent(job.Payload().ID, JobEvent{
+			Type:     JobReleased,
+			Queue:    job.GetName(),
+			Attempt:  job.Attempts(),
+			WorkerID: workerID,
+			Delay:    job.Payload().RetryInterval,
+			Error:    ErrAbortForWaitingPrevJobFinish.Error(),
+		})
 
 		// 触发记录可能失败日志的记录，便于回溯
 		m.recordFailedJob(job, ErrAbortForWaitingPrevJobFinish)
@@ -371,10 +468,15 @@ func (m *manager) runJob(job JobIFace, workerID int64) {
 	// set in running map, need to be use lock
 	m.inWorkingMap.Store(job.Payload().ID, workerID)
 
-	// step3、检查任务尝试次数：超限标记任务失败后删除任务，未超限则执行
-	if m.markJobAsFailedIfAlreadyExceedsMaxAttempts(job) {
+	// step3、检查任务是否过期以及尝试次数：已过期的任务直接丢弃，尝试次数超限标记任务失败后删除任务，否则执行
+	if m.isJobExpired(job) {
+		m.expireJob(context.Background(), task, job, workerID)
 		return
 	}
+	if m.markJobAsFailedIfAlreadyExceedsMaxAttempts(job, workerID) {
+		return
+	}
+	m.jobEvent(job, JobAttemptStarted, workerID, nil)
 
 	// step4、execute job task with timeout control
 	m.logger.Info(
@@ -388,15 +490,28 @@ func (m *manager) runJob(job JobIFace, workerID int64) {
 	ctx, cancelFunc := context.WithTimeout(context.Background(), job.Timeout())
 	defer cancelFunc()
 
+	// 执行前即还原任务参数：外置的任务参数加载失败可重试，无法解码的任务重试也无法成功，直接标记失败
+	rawBody, retry, err := m.rawBody(ctx, job)
+	if err != nil {
+		m.jobEvent(job, JobAttemptFailed, workerID, err)
+		if retry {
+			m.markJobAsFailedIfWillExceedMaxAttempts(job, workerID, err)
+		} else {
+			m.failJob(job, workerID, err)
+		}
+		return
+	}
+
 	// 添加通信机制：done channel用于通知任务完成
 	done := make(chan struct{})
 
 	// goroutine execute task job
+	executing = true
 	go func() {
+		// 确保无论如何都要关闭done channel，关闭前释放并发槽位：超时后仍在执行的task继续占用槽位
+		defer close(done)
+		defer m.releaseSlot(job)
 		defer func() {
-			// 确保无论如何都要关闭done channel
-			close(done)
-
 			if r := recover(); r != nil {
 				m.logger.Error(
 					"queue.execute.panic",
@@ -416,10 +531,11 @@ func (m *manager) runJob(job JobIFace, workerID int64) {
 				}
 
 				// panic: 检查任务尝试执行次数 & 标记失败状态
-				m.markJobAsFailedIfWillExceedMaxAttempts(job, eErr)
+				m.jobEvent(job, JobAttemptFailed, workerID, eErr)
+				m.markJobAsFailedIfWillExceedMaxAttempts(job, workerID, eErr)
 			}
 		}()
-		err := task.Execute(ctx, job.Payload().RawBody())
+		err := task.Execute(ctx, rawBody)
 		if err == nil {
 			// step5、任务类执行成功：删除任务即可
 			m.logger.Info(
@@ -427,9 +543,11 @@ func (m *manager) runJob(job JobIFace, workerID int64) {
 				"queue", job.GetName(),
 				"worker_id", IFaceToString(workerID),
 				"payload", IFaceToString(job.Payload()),
-				"duration", IFaceToString(int64(time.Now().Sub(job.PopTime()))),
+				"duration", IFaceToString(int64(m.config.Clock.Now().Sub(job.PopTime()))),
 			)
 			_ = job.Delete()
+			m.forgetBlob(job)
+			m.jobEvent(job, JobSucceeded, workerID, nil)
 		} else {
 			// step6、任务类执行失败：依赖重试设置执行重试or最终执行失败处理
 			m.logger.Error(
@@ -437,9 +555,10 @@ func (m *manager) runJob(job JobIFace, workerID int64) {
 				"queue", job.GetName(),
 				"worker_id", IFaceToString(workerID),
 				"payload", IFaceToString(job.Payload()),
-				"duration", IFaceToString(int64(time.Now().Sub(job.PopTime()))),
+				"duration", IFaceToString(int64(m.config.Clock.Now().Sub(job.PopTime()))),
 			)
-			m.markJobAsFailedIfWillExceedMaxAttempts(job, err)
+			m.jobEvent(job, JobAttemptFailed, workerID, err)
+			m.markJobAsFailedIfWillExceedMaxAttempts(job, workerID, err)
 		}
 	}()
 
@@ -456,9 +575,348 @@ func (m *manager) runJob(job JobIFace, workerID int64) {
 			"payload", IFaceToString(job.Payload()),
 			"timeout", IFaceToString(int64(job.Timeout().Seconds())),
 		)
-		m.markJobAsFailedIfWillExceedMaxAttempts(job, ctx.Err())
+		m.jobEvent(job, JobAttemptFailed, workerID, ctx.Err())
+		m.markJobAsFailedIfWillExceedMaxAttempts(job, workerID, ctx.Err())
+		return
+	}
+}
+
+// initConcurrency 初始化task进程内并发槽位和独立worker池通道（需要在持有锁的情况下调用）
+func (m *manager) initConcurrency(name string, task TaskIFace) {
+	if dt, ok := task.(DistributedConcurrencyTask); ok && dt.DistributedConcurrency() > 0 && m.config.Semaphore == nil {
+		m.logger.Warn("queue.semaphore.unavailable, distributed concurrency ignored", "queue", name)
+	}
+
+	ct, ok := task.(ConcurrencyTask)
+	if !ok || ct.MaxConcurrency() <= 0 {
 		return
 	}
+
+	m.slots[name] = make(chan struct{}, ct.MaxConcurrency())
+	if ct.IsolatedPool() {
+		m.pools[name] = make(chan JobIFace) // no buffer channel, execute when pool worker received
+	}
+}
+
+// popJob 获取并发槽位后从指定task队列取出一条job
+//   - 并发槽位已满或队列暂无job时exist返回false，并发槽位已满时limited返回true
+func (m *manager) popJob(name string) (job JobIFace, exist bool, limited bool) {
+	// 需获取分布式信号量槽位的task先检查队列是否存在job，空轮询时不再获取、释放槽位
+	if m.distributedConcurrency(name) > 0 && !m.hasJobs(name) {
+		return nil, false, false
+	}
+
+	release, acquired := m.acquireSlot(name)
+	if !acquired {
+		return nil, false, true
+	}
+
+	// 按分区调度顺序依次尝试，未使用分区时仅为task队列本身
+	for _, queue := range m.partitionQueues(name) {
+		if job, exist = m.pop(queue); exist {
+			break
+		}
+	}
+	if !exist {
+		release()
+		return nil, false, false
+	}
+
+	job = m.decodeJob(job)
+	m.jobSlots.Store(job, release)
+	m.jobEvent(job, JobPopped, 0, nil)
+	return job, true, false
+}
+
+// pop 从队列取出一条job：底层驱动实现 ContextQueueIFace 时使用 PopCtx，取出失败输出日志
+func (m *manager) pop(queue string) (job JobIFace, exist bool) {
+	driver, ok := m.queue.(ContextQueueIFace)
+	if !ok {
+		return m.queue.Pop(queue)
+	}
+
+	job, exist, err := driver.PopCtx(context.Background(), queue)
+	if err != nil {
+		m.logger.Warn("queue.pop.failed", "queue", queue, "error", err.Error())
+		return nil, false
+	}
+	return job, exist
+}
+
+// blockingQueue 开启阻塞等待且底层驱动支持时返回阻塞等待驱动
+func (m *manager) blockingQueue() (blockingQueueIFace, bool) {
+	if !m.config.BlockingPop {
+		return nil, false
+	}
+	blocker, ok := m.queue.(blockingQueueIFace)
+	return blocker, ok
+}
+
+// dispatchJob 将job投递给worker：开启独立worker池的task投递至独立池，否则投递至通用通道
+func (m *manager) dispatchJob(name string, job JobIFace) {
+	if pool, isolated := m.pools[name]; isolated {
+		pool <- job
+		return
+	}
+	m.channel <- job
+}
+
+// closePool 关闭task独立worker池的job通道，继而独立池worker全部退出
+func (m *manager) closePool(name string) {
+	if pool, isolated := m.pools[name]; isolated {
+		close(pool)
+	}
+}
+
+// acquireSlot 获取task的进程内并发槽位以及跨节点分布式信号量槽位
+//   - 获取成功返回释放槽位的方法和true，任一槽位已满返回false
+func (m *manager) acquireSlot(name string) (release func(), acquired bool) {
+	slot := m.slots[name]
+	if slot != nil {
+		select {
+		case slot <- struct{}{}:
+		default:
+			return nil, false
+		}
+	}
+	releaseLocal := func() {
+		if slot != nil {
+			<-slot
+		}
+	}
+
+	limit := m.distributedConcurrency(name)
+	if limit <= 0 {
+		return releaseLocal, true
+	}
+
+	token, acquired, err := m.config.Semaphore.Acquire(name, limit, m.tasks[name].Timeout()+DefaultSemaphoreTTL)
+	if err != nil {
+		m.logger.Warn("queue.semaphore.acquire.failed", "queue", name, "error", err.Error())
+	}
+	if !acquired {
+		releaseLocal()
+		return nil, false
+	}
+
+	return func() {
+		if err := m.config.Semaphore.Release(name, token); err != nil {
+			m.logger.Warn("queue.semaphore.release.failed", "queue", name, "error", err.Error())
+		}
+		releaseLocal()
+	}, true
+}
+
+// distributedConcurrency 获取task跨节点的最大并发执行数，未限制或未配置 Semaphore 时返回0
+func (m *manager) distributedConcurrency(name string) int64 {
+	dt, ok := m.tasks[name].(DistributedConcurrencyTask)
+	if !ok || m.config.Semaphore == nil {
+		return 0
+	}
+	return max(dt.DistributedConcurrency(), 0)
+}
+
+// hasJobs 检查task队列（含各分区）是否存在job，含延迟任务与保留任务
+//   - 获取队列长度失败时返回true，由随后的 Pop 决定是否存在job
+func (m *manager) hasJobs(name string) bool {
+	for _, queue := range m.knownPartitionQueues(name) {
+		size, err := m.queueSize(context.Background(), queue)
+		if err != nil {
+			m.logger.Warn("queue.size.failed", "queue", queue, "error", err.Error())
+			return true
+		}
+		if size > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseSlot 释放job出队前获取的并发槽位
+func (m *manager) releaseSlot(job JobIFace) {
+	if release, ok := m.jobSlots.LoadAndDelete(job); ok {
+		release.(func())()
+	}
+}
+
+// encodePayload 投递任务时转换任务参数，转换后仍大于阈值的任务参数外置至 BlobStore
+func (m *manager) encodePayload(ctx context.Context, payload *Payload) error {
+	if err := m.codec.encode(payload); err != nil {
+		return err
+	}
+
+	if m.config.BlobStore == nil || len(payload.Payload) <= m.config.BlobThreshold {
+		return nil
+	}
+
+	ref := blobKey(payload)
+	if err := m.config.BlobStore.Put(ctx, ref, payload.Payload); err != nil {
+		return fmt.Errorf("payload blob store put failed: %w", err)
+	}
+	payload.Payload = nil
+	payload.BlobRef = ref
+
+	return nil
+}
+
+// forgetBlob 任务执行成功或最终失败后删除外置的任务参数
+func (m *manager) forgetBlob(job JobIFace) {
+	m.deleteBlob(job.GetName(), job.Payload().BlobRef)
+}
+
+// inlineBlob 将最终失败的job外置的任务参数读回任务结构，失败任务记录不再引用 BlobStore 中的对象
+//   - 写回的是转换后的任务参数，与未外置的任务参数一致；成功写回返回true，此后方可删除外置的任务参数
+//   - 读取失败时保留引用与外置的任务参数并输出日志
+func (m *manager) inlineBlob(job JobIFace) bool {
+	payload := job.Payload()
+	if payload.BlobRef == "" || m.config.BlobStore == nil {
+		return false
+	}
+
+	data, err := m.config.BlobStore.Get(context.Background(), payload.BlobRef)
+	if err != nil {
+		m.logger.Warn(
+			"queue.blob.inline.failed",
+			"queue", job.GetName(),
+			"blob_ref", payload.BlobRef,
+			"error", err.Error(),
+		)
+		return false
+	}
+	payload.Payload = data
+	payload.BlobRef = ""
+
+	// 执行前未加载任务参数的job，交由失败任务处理器的任务结构同样还原
+	if decoded, ok := job.(*decodedJob); ok && (decoded.decoded == nil || decoded.decoded.BlobRef != "") {
+		decoded.decoded, decoded.err = m.decodePayload(payload)
+	}
+	return true
+}
+
+// deleteBlob 删除外置的任务参数，删除失败仅输出日志
+func (m *manager) deleteBlob(queue, ref string) {
+	if ref == "" || m.config.BlobStore == nil {
+		return
+	}
+
+	if err := m.config.BlobStore.Delete(context.Background(), ref); err != nil {
+		m.logger.Warn(
+			"queue.blob.delete.failed",
+			"queue", queue,
+			"blob_ref", ref,
+			"error", err.Error(),
+		)
+	}
+}
+
+// decodedJob 出队时即已还原任务参数的job
+//   - 底层job的 Payload 仍为转换后的任务参数，重试、重新投递、MySQL失败任务表等写回存储的场景不会写入明文
+//   - 任务执行与失败任务处理器使用还原后的任务参数
+type decodedJob struct {
+	JobIFace
+	decoded *Payload // 还原后的任务结构副本，还原失败时为nil
+	err     error    // 还原任务参数的错误
+}
+
+// decodeJob 出队后还原job的任务参数，所有出队的job均经此处还原
+func (m *manager) decodeJob(job JobIFace) JobIFace {
+	if _, ok := job.(*decodedJob); ok {
+		return job
+	}
+	decoded, err := m.decodePayload(job.Payload())
+	return &decodedJob{JobIFace: job, decoded: decoded, err: err}
+}
+
+// decodePayload 按 Payload.Codec 逆序还原任务参数，返回还原后的任务结构副本，不修改payload本身
+//   - 外置于 BlobStore 的任务参数原样返回，执行前再由 loadBlob 加载后还原，避免looper等待 BlobStore
+func (m *manager) decodePayload(payload *Payload) (*Payload, error) {
+	decoded := *payload
+	if payload.BlobRef != "" || payload.Codec == "" {
+		return &decoded, nil
+	}
+
+	data, err := m.codec.decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	decoded.Payload = data
+	decoded.Codec = ""
+	return &decoded, nil
+}
+
+// loadBlob 从 BlobStore 加载外置的任务参数并还原，返回还原后的任务结构副本
+//   - retry 表示加载失败可重试，譬如对象存储暂时不可用；任务参数不存在、无法解码时重试也无法成功
+func (m *manager) loadBlob(ctx context.Context, payload *Payload) (decoded *Payload, retry bool, err error) {
+	if payload.BlobRef == "" {
+		return payload, false, nil
+	}
+	if m.config.BlobStore == nil {
+		return nil, false, ErrBlobStoreUnavailable
+	}
+
+	data, err := m.config.BlobStore.Get(ctx, payload.BlobRef)
+	if err != nil {
+		return nil, !errors.Is(err, ErrBlobNotFound), fmt.Errorf("payload blob store get failed: %w", err)
+	}
+
+	loaded := *payload
+	loaded.Payload = data
+	loaded.BlobRef = ""
+	decoded, err = m.decodePayload(&loaded)
+	return decoded, false, err
+}
+
+// rawBody 构造任务执行参数：使用出队时还原的任务参数，外置的任务参数此时从 BlobStore 加载
+//   - 加载成功后的任务参数同样交由失败任务处理器
+func (m *manager) rawBody(ctx context.Context, job JobIFace) (body *RawBody, retry bool, err error) {
+	decoded, ok := job.(*decodedJob)
+	if !ok {
+		decoded = m.decodeJob(job).(*decodedJob)
+	}
+	if decoded.err != nil {
+		return nil, false, decoded.err
+	}
+
+	payload, retry, err := m.loadBlob(ctx, decoded.decoded)
+	if err != nil {
+		return nil, retry, err
+	}
+	decoded.decoded = payload
+	return payload.RawBody(), false, nil
+}
+
+// failedPayload 获取交由失败任务处理器的任务结构：出队时已还原任务参数的job为还原后的任务结构
+func (m *manager) failedPayload(job JobIFace) *Payload {
+	if decoded, ok := job.(*decodedJob); ok && decoded.decoded != nil {
+		return decoded.decoded
+	}
+	return job.Payload()
+}
+
+// runInline 在当前goroutine中执行一条已取出的job，task未注册时返回error
+func (m *manager) runInline(job JobIFace) error {
+	if _, ok := m.tasks[job.Payload().Name]; !ok {
+		return fmt.Errorf("queue %s do not bootstrap", job.Payload().Name)
+	}
+
+	m.runJob(m.decodeJob(job), inlineWorkerID)
+	return nil
+}
+
+// runNext 从指定task队列取出一条job并在当前goroutine中执行
+func (m *manager) runNext(name string) bool {
+	if _, ok := m.tasks[name]; !ok {
+		return false
+	}
+
+	job, exist, _ := m.popJob(name)
+	if !exist {
+		return false
+	}
+
+	m.runJob(job, inlineWorkerID)
+
+	return true
 }
 
 // looperJitter looper循环器间隔抖动
@@ -484,9 +942,9 @@ func (m *manager) looperJitter(name string) time.Duration {
 // markJobAsFailedIfAlreadyExceedsMaxAttempts job执行`之前`检测尝试次数是否超限
 // 1、如果超限则方法体内部清理任务并返回true，表示该job需要停止执行
 // 2、如果未超限则返回false
-func (m *manager) markJobAsFailedIfAlreadyExceedsMaxAttempts(job JobIFace) (needSop bool) {
+func (m *manager) markJobAsFailedIfAlreadyExceedsMaxAttempts(job JobIFace, workerID int64) (needSop bool) {
 	// step1、执行时长检查，持续执行超过设置的超时时长则记录日志
-	if time.Now().Sub(job.PopTime()) >= job.Timeout() {
+	if m.config.Clock.Now().Sub(job.PopTime()) >= job.Timeout() {
 		m.logger.Warn(
 			textJobTooLong,
 			"queue", job.GetName(),
@@ -501,7 +959,7 @@ func (m *manager) markJobAsFailedIfAlreadyExceedsMaxAttempts(job JobIFace) (need
 	}
 
 	// step3、其他情况：执行job前检查就不通过，移除任务&&标记任务失败（最大尝试次数超过限制、持续执行超时、脏数据、意外中断的任务 等）
-	m.failJob(job, ErrMaxAttemptsExceeded)
+	m.failJob(job, workerID, ErrMaxAttemptsExceeded)
 
 	return true
 }
@@ -509,13 +967,13 @@ func (m *manager) markJobAsFailedIfAlreadyExceedsMaxAttempts(job JobIFace) (need
 // markJobAsFailedIfWillExceedMaxAttempts job执行`之后`检测尝试次数是否超限
 // 1、检查job执行是否超过基准时间以记录日志
 // 2、检查job执行尝试次数
-func (m *manager) markJobAsFailedIfWillExceedMaxAttempts(job JobIFace, err error) {
+func (m *manager) markJobAsFailedIfWillExceedMaxAttempts(job JobIFace, workerID int64, err error) {
 	if job.IsDeleted() {
 		return
 	}
 
 	// step1、执行时长检查：超时记录超时日志
-	if time.Now().Sub(job.PopTime()) >= job.Timeout() {
+	if m.config.Clock.Now().Sub(job.PopTime()) >= job.Timeout() {
 		m.logger.Warn(
 			textJobTooLong,
 			"queue", job.GetName(),
@@ -527,15 +985,22 @@ func (m *manager) markJobAsFailedIfWillExceedMaxAttempts(job JobIFace, err error
 	// step2、检查最大尝试执行次数是否超限
 	if job.Attempts() >= job.Payload().MaxTries {
 		// 超过最大重试次数：本次执行失败 && 任务类最终执行失败 && delete任务
-		m.failJob(job, err)
+		m.failJob(job, workerID, err)
 	} else {
 		// 任务可以重试：本次执行失败 && 任务类还可以重试 && release任务
 		_ = job.Release(job.Payload().RetryInterval)
+		m.recordJobEvent(job.Payload().ID, JobEvent{
+			Type:     JobReleased,
+			Queue:    job.GetName(),
+			Attempt:  job.Attempts(),
+			WorkerID: workerID,
+			Delay:    job.Payload().RetryInterval,
+		})
 	}
 }
 
 // failJob 失败的任务触发器
-func (m *manager) failJob(job JobIFace, err error) {
+func (m *manager) failJob(job JobIFace, workerID int64, err error) {
 	// -> 1、标记任务失败
 	job.MarkAsFailed()
 
@@ -545,6 +1010,15 @@ func (m *manager) failJob(job JobIFace, err error) {
 	}
 	_ = job.Delete()
 
+	// 外置的任务参数写回失败任务记录后再删除，失败任务可查看、重试、迁移
+	ref := job.Payload().BlobRef
+	inlined := m.inlineBlob(job)
+	defer func() {
+		if inlined {
+			m.deleteBlob(job.GetName(), ref)
+		}
+	}()
+
 	// tag log
 	m.logger.Error(
 		textJobFailedLog,
@@ -553,6 +1027,8 @@ func (m *manager) failJob(job JobIFace, err error) {
 		"error", err.Error(),
 	)
 
+	m.jobEvent(job, JobFailed, workerID, err)
+
 	// -> 3、设置任务执行失败
 	job.Failed(err)
 
@@ -560,10 +1036,46 @@ func (m *manager) failJob(job JobIFace, err error) {
 	m.recordFailedJob(job, err)
 }
 
+// jobEvent 记录job的一条任务事件
+func (m *manager) jobEvent(job JobIFace, eventType JobEventType, workerID int64, err error) {
+	event := JobEvent{
+		Type:     eventType,
+		Queue:    job.GetName(),
+		Attempt:  job.Attempts(),
+		WorkerID: workerID,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	m.recordJobEvent(job.Payload().ID, event)
+}
+
+// recordJobEvent 开启任务事件历史且底层驱动支持时记录一条任务事件，记录失败仅输出日志
+func (m *manager) recordJobEvent(id string, event JobEvent) {
+	if !m.config.JobHistory {
+		return
+	}
+	history, ok := m.queue.(JobHistoryIFace)
+	if !ok {
+		return
+	}
+
+	event.Time = m.config.Clock.Now()
+	if err := history.AppendJobEvent(id, event, m.config.JobHistoryTTL, m.config.JobHistoryMaxEvents); err != nil {
+		m.logger.Warn(
+			"queue.job.history.failed",
+			"queue", event.Queue,
+			"id", id,
+			"event", string(event.Type),
+			"error", err.Error(),
+		)
+	}
+}
+
 // recordFailedJob 触发记录可能的失败任务
 func (m *manager) recordFailedJob(job JobIFace, err error) {
 	if m.failedJobHandler != nil {
-		_ = m.failedJobHandler(job.Payload(), err)
+		_ = m.failedJobHandler(m.failedPayload(job), err)
 	}
 }
 
@@ -575,7 +1087,14 @@ func (m *manager) shutDown(ctx context.Context) (err error) {
 	m.inShutdown.setTrue()
 
 	// 关闭用于控制looper协程的`关闭chan`：这样looper就停止循环
+	m.lock.Lock()
 	m.closeDoneChanLocked()
+	m.lock.Unlock()
+
+	// 释放底层驱动资源：譬如唤醒阻塞等待中的looper
+	if driver, ok := m.queue.(driverShutdown); ok {
+		driver.shutdown()
+	}
 
 	// 优雅关闭等待时长逐步递增实现
 	pollIntervalBase := time.Millisecond
@@ -633,7 +1152,12 @@ func (m *manager) closeDoneChanLocked() {
 }
 
 // setWorkerStatus 设置标记工作进程当前执行中 or 执行完毕
+//   - 当前goroutine中直接执行job时没有worker，不记录状态
 func (m *manager) setWorkerStatus(workerID int64, isRun bool) {
+	if workerID == inlineWorkerID {
+		return
+	}
+
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
@@ -652,6 +1176,9 @@ func (m *manager) setWorkerStatus(workerID int64, isRun bool) {
 
 // isLooperAndWorkersDown 检查是否所有worker当前工作任务均处于down状态
 func (m *manager) isLooperAndWorkersDown() (down bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	// 所有worker退出
 	for _, node := range m.workerStatus {
 		if node.isSet() {
@@ -704,8 +1231,10 @@ func (m *manager) autoScaleWorkers() error {
 		shouldDecrease  = false
 		decreaseNumber  = 0
 		increaseNumber  = 0
-		maxWorkerNumber = int(int64(m.config.MaxConcurrency)*m.realTasksNum) + 1
-		minWorkerNumber = int(m.realTasksNum) + 1
+		generalTasksNum = m.realTasksNum - int64(len(m.pools))         // 使用通用worker的task数
+		generalWorkers  = len(m.workerStatus) - int(m.isolatedWorkers) // 通用worker数，独立池worker不参与扩缩容
+		maxWorkerNumber = int(int64(m.config.MaxConcurrency)*generalTasksNum) + 1
+		minWorkerNumber = int(generalTasksNum) + 1
 		oneWorkerMemory = memSta.GoMemoryTotal / uint64(m.realTasksNum)
 	)
 
@@ -713,9 +1242,9 @@ func (m *manager) autoScaleWorkers() error {
 	// 1. 待执行job数小于启动的Worker数
 	// 2. 启动的Worker数大于真实允许执行的task数（说明扩容过）
 	// ++++++++++++++++++++++++++++++++++++++++++++++++++
-	if int(jobSta.TotalJobs) < len(m.workerStatus) && len(m.workerStatus) > minWorkerNumber {
+	if int(jobSta.TotalJobs) < generalWorkers && generalWorkers > minWorkerNumber {
 		shouldDecrease = true
-		decreaseNumber = len(m.workerStatus) - minWorkerNumber
+		decreaseNumber = generalWorkers - minWorkerNumber
 	}
 
 	// ++++++++++++++++++++++++++++++++++++++++++++++++++
@@ -723,10 +1252,10 @@ func (m *manager) autoScaleWorkers() error {
 	// 2. Worker数没超过可允许的最大并发数
 	// 3. 按系统可用内存和go已申请内存与已使用内存计算扩容数
 	// ++++++++++++++++++++++++++++++++++++++++++++++++++
-	if jobSta.TotalJobs >= m.config.AutoScaleJobThreshold && len(m.workerStatus) < maxWorkerNumber {
+	if jobSta.TotalJobs >= m.config.AutoScaleJobThreshold && generalWorkers < maxWorkerNumber {
 		shouldIncrease = true
 		// 初步设定扩容一倍Worker 与 最大worker和当前worker差值的较小值
-		increaseNumber = min(int(m.realTasksNum), maxWorkerNumber-len(m.workerStatus))
+		increaseNumber = min(int(generalTasksNum), maxWorkerNumber-generalWorkers)
 		// 按系统可用内存计算最大可扩容worker数，取最小可扩容数
 		increaseNumber = min(int(memSta.SysMemoryAvailable/oneWorkerMemory), increaseNumber)
 	}
@@ -769,25 +1298,30 @@ func (m *manager) increaseWorkers(num int) error {
 }
 
 // decreaseWorkers 减少worker
+//   - 仅停止ID最大的num个通用worker，独立池worker没有停止信号通道不受影响
+//   - 已停止的worker ID不再复用，避免退出中的worker清理掉新worker的状态
 func (m *manager) decreaseWorkers(num int) error {
 	if m.shuttingDown() {
 		return ErrQueueClosed
 	}
 
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	if len(m.workerChannel) <= num {
 		return fmt.Errorf("exist worker num %d less then stop worker num %d", len(m.workerChannel), num)
 	}
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	for index, stopChan := range m.workerChannel {
-		if index > m.nextWorkerID-int64(num) {
-			close(stopChan)
-			m.logger.Info("stop.worker", "worker_id", IFaceToString(index))
-		}
+	ids := make([]int64, 0, len(m.workerChannel))
+	for id := range m.workerChannel {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids[len(ids)-num:] {
+		close(m.workerChannel[id])
+		delete(m.workerChannel, id)
+		m.logger.Info("stop.worker", "worker_id", IFaceToString(id))
 	}
-	m.nextWorkerID -= int64(num)
 
 	return nil
 }
@@ -856,16 +1390,26 @@ func (m *manager) getJobStatistics() JobStatistics {
 	// 统计允许执行的job待执行情况
 	totalJobs := int64(0)
 	jobsStatistics := make(map[string]int64)
+	partitionsStatistics := make(map[string]map[string]int64)
 	for jobName := range m.tasks {
 		if m.allowRun(jobName) {
-			jobsStatistics[jobName] = m.queue.Size(jobName)
-			totalJobs += jobsStatistics[jobName]
+			size, partitions, err := m.taskSize(context.Background(), jobName)
+			if err != nil {
+				m.logger.Warn("queue.size.failed", "queue", jobName, "error", err.Error())
+			}
+			jobsStatistics[jobName] = size
+			totalJobs += size
+			if len(partitions) > 0 {
+				partitionsStatistics[jobName] = partitions
+			}
 		}
 	}
 
 	return JobStatistics{
-		TotalJobs:      totalJobs,
-		JobsStatistics: jobsStatistics,
+		TotalJobs:            totalJobs,
+		JobsStatistics:       jobsStatistics,
+		PartitionsStatistics: partitionsStatistics,
+		ExpiredJobs:          atomic.LoadInt64(&m.expiredJobs),
 	}
 }
 
@@ -0,0 +1,323 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ctxFailingQueue 仅 ContextQueueIFace 方法返回error的队列驱动
+type ctxFailingQueue struct {
+	QueueIFace
+	err error
+}
+
+func (q *ctxFailingQueue) SizeCtx(ctx context.Context, queue string) (int64, error) {
+	return 0, q.err
+}
+
+func (q *ctxFailingQueue) PushCtx(ctx context.Context, queue string, payload interface{}) error {
+	return q.err
+}
+
+func (q *ctxFailingQueue) LaterAtCtx(ctx context.Context, queue string, timeAt time.Time, payload interface{}) error {
+	return q.err
+}
+
+func (q *ctxFailingQueue) PopCtx(ctx context.Context, queue string) (JobIFace, bool, error) {
+	return nil, false, q.err
+}
+
+// recordLogger 记录warn日志的日志记录器
+type recordLogger struct {
+	nopTestLogger
+	lock  sync.Mutex
+	warns []string
+}
+
+func (l *recordLogger) Warn(msg string, _ ...any) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.warns = append(l.warns, msg)
+}
+
+func TestManagerPopAndSizeUseContextDriver(t *testing.T) {
+	inner, _ := NewDriver(Memory, nil, Config{})
+	driver := &ctxFailingQueue{QueueIFace: inner, err: errors.New("connection refused")}
+	logger := &recordLogger{}
+	m := newQueue(Custom, driver, logger, Config{}).manager
+
+	if _, exist := m.pop("task"); exist {
+		t.Fatal("pop() exist = true, want false")
+	}
+	m.tasks["task"] = &syncTask{}
+	m.getJobStatistics()
+
+	want := []string{"queue.pop.failed", "queue.size.failed"}
+	if len(logger.warns) != len(want) || logger.warns[0] != want[0] || logger.warns[1] != want[1] {
+		t.Fatalf("warn logs = %v, want %v", logger.warns, want)
+	}
+}
+
+// blockTask 执行时阻塞至release关闭的并发控制task
+type blockTask struct {
+	DefaultTaskSetting
+	name        string
+	limit       int64
+	isolated    bool
+	distributed int64
+	timeout     time.Duration
+	started     chan string
+	release     chan struct{}
+}
+
+func newBlockTask(name string, limit int64) *blockTask {
+	return &blockTask{name: name, limit: limit, started: make(chan string, 16), release: make(chan struct{})}
+}
+
+func (task *blockTask) Name() string                  { return task.name }
+func (task *blockTask) MaxTries() int64               { return 1 }
+func (task *blockTask) Remark() string                { return "block task" }
+func (task *blockTask) MaxConcurrency() int64         { return task.limit }
+func (task *blockTask) IsolatedPool() bool            { return task.isolated }
+func (task *blockTask) DistributedConcurrency() int64 { return task.distributed }
+func (task *blockTask) Timeout() time.Duration {
+	if task.timeout > 0 {
+		return task.timeout
+	}
+	return task.DefaultTaskSetting.Timeout()
+}
+func (task *blockTask) Execute(ctx context.Context, job *RawBody) error {
+	task.started <- job.ID
+	<-task.release // 忽略ctx，模拟超时后仍在执行的task
+	return nil
+}
+
+// newConcurrencyTestQueue 初始化注册了task并完成并发控制初始化、但未启动looper与worker的内存队列
+func newConcurrencyTestQueue(t *testing.T, config Config, tasks ...*blockTask) *Queue {
+	service := New(Memory, nil, nopTestLogger{}, config)
+	for _, task := range tasks {
+		if err := service.BootstrapOne(task); err != nil {
+			t.Fatalf("BootstrapOne() error = %v", err)
+		}
+		service.manager.initConcurrency(task.Name(), task)
+	}
+	return service
+}
+
+func dispatchN(t *testing.T, service *Queue, task TaskIFace, n int) {
+	for i := 0; i < n; i++ {
+		if err := service.Dispatch(task, i); err != nil {
+			t.Fatalf("Dispatch() error = %v", err)
+		}
+	}
+}
+
+func TestManagerConcurrencyLimit(t *testing.T) {
+	task := newBlockTask("limited", 2)
+	service := newConcurrencyTestQueue(t, Config{}, task)
+	m := service.manager
+	dispatchN(t, service, task, 3)
+
+	var jobs []JobIFace
+	for i := 0; i < 2; i++ {
+		job, exist, limited := m.popJob(task.Name())
+		if !exist || limited {
+			t.Fatalf("popJob() #%d exist = %v, limited = %v, want a job", i+1, exist, limited)
+		}
+		jobs = append(jobs, job)
+	}
+	if _, exist, limited := m.popJob(task.Name()); exist || !limited {
+		t.Fatalf("popJob() over limit exist = %v, limited = %v, want limited", exist, limited)
+	}
+
+	m.releaseSlot(jobs[0])
+	if _, exist, _ := m.popJob(task.Name()); !exist {
+		t.Fatal("popJob() after release exist = false, want true")
+	}
+}
+
+func TestManagerEmptyQueueDoesNotHoldSlot(t *testing.T) {
+	task := newBlockTask("empty", 1)
+	m := newConcurrencyTestQueue(t, Config{}, task).manager
+
+	for i := 0; i < 3; i++ {
+		if _, exist, limited := m.popJob(task.Name()); exist || limited {
+			t.Fatalf("popJob() on empty queue exist = %v, limited = %v, want false, false", exist, limited)
+		}
+	}
+	if n := len(m.slots[task.Name()]); n != 0 {
+		t.Fatalf("slots in use = %d, want 0", n)
+	}
+}
+
+func TestManagerTimedOutJobHoldsSlot(t *testing.T) {
+	task := newBlockTask("slow", 1)
+	task.timeout = time.Second
+	service := newConcurrencyTestQueue(t, Config{}, task)
+	m := service.manager
+	dispatchN(t, service, task, 2)
+
+	// runNext在超时后返回，而task仍在执行
+	if !m.runNext(task.Name()) {
+		t.Fatal("runNext() = false, want true")
+	}
+	select {
+	case <-task.started:
+	default:
+		t.Fatal("task was not started")
+	}
+	if _, exist, limited := m.popJob(task.Name()); exist || !limited {
+		t.Fatalf("popJob() while timed out task runs exist = %v, limited = %v, want limited", exist, limited)
+	}
+
+	// task执行结束后释放槽位
+	close(task.release)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, exist, _ := m.popJob(task.Name()); exist {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("slot not released after the timed out task finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestManagerIsolatedPool(t *testing.T) {
+	isolated := newBlockTask("isolated", 2)
+	isolated.isolated = true
+	general := newBlockTask("general", 1)
+	service := newConcurrencyTestQueue(t, Config{}, isolated, general)
+	m := service.manager
+	dispatchN(t, service, isolated, 3)
+
+	if err := service.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer func() {
+		close(isolated.release)
+		close(general.release)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = service.ShutDown(ctx)
+	}()
+
+	// 独立池的两个worker同时执行，第三个job等待槽位
+	for i := 0; i < 2; i++ {
+		select {
+		case <-isolated.started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("isolated job #%d not started", i+1)
+		}
+	}
+	select {
+	case id := <-isolated.started:
+		t.Fatalf("job %s started over the concurrency limit", id)
+	case <-time.After(1500 * time.Millisecond):
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	pool := 0
+	for id := range m.workerStatus {
+		if id >= isolatedWorkerIDBase {
+			pool++
+			if _, ok := m.workerChannel[id]; ok {
+				t.Fatalf("isolated worker %d has a stop channel", id)
+			}
+		}
+	}
+	if pool != 2 {
+		t.Fatalf("isolated workers = %d, want 2", pool)
+	}
+}
+
+func TestManagerDecreaseWorkersKeepsIsolatedWorkers(t *testing.T) {
+	isolated := newBlockTask("isolated", 2)
+	isolated.isolated = true
+	general := newBlockTask("general", 1)
+	service := newConcurrencyTestQueue(t, Config{}, isolated, general)
+	m := service.manager
+
+	if err := service.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = service.ShutDown(ctx)
+	}()
+
+	if err := m.increaseWorkers(3); err != nil {
+		t.Fatalf("increaseWorkers() error = %v", err)
+	}
+	// 通用worker：启动时2个（通用+general），扩容3个，ID为0~4
+	if err := m.decreaseWorkers(3); err != nil {
+		t.Fatalf("decreaseWorkers() error = %v", err)
+	}
+
+	m.lock.Lock()
+	var ids []int64
+	for id := range m.workerChannel {
+		ids = append(ids, id)
+	}
+	isolatedWorkers := 0
+	for id := range m.workerStatus {
+		if id >= isolatedWorkerIDBase {
+			isolatedWorkers++
+		}
+	}
+	m.lock.Unlock()
+
+	slices.Sort(ids)
+	if !slices.Equal(ids, []int64{0, 1}) {
+		t.Fatalf("general workers after decrease = %v, want [0 1]", ids)
+	}
+	if isolatedWorkers != 2 {
+		t.Fatalf("isolated workers after decrease = %d, want 2", isolatedWorkers)
+	}
+
+	// 新扩容的worker不复用已停止worker的ID
+	if err := m.increaseWorkers(1); err != nil {
+		t.Fatalf("increaseWorkers() error = %v", err)
+	}
+	m.lock.Lock()
+	_, reused := m.workerChannel[2]
+	_, started := m.workerChannel[5]
+	m.lock.Unlock()
+	if reused || !started {
+		t.Fatalf("new worker id reused = %v, started as 5 = %v", reused, started)
+	}
+}
+
+func TestManagerDistributedConcurrency(t *testing.T) {
+	_, client := newTestRedis(t)
+	semaphore := NewRedisSemaphore(client)
+
+	// 两个消费节点共享同一信号量
+	var nodes []*manager
+	for range 2 {
+		task := newBlockTask("distributed", 0)
+		task.distributed = 1
+		service := newConcurrencyTestQueue(t, Config{Semaphore: semaphore}, task)
+		dispatchN(t, service, task, 1)
+		nodes = append(nodes, service.manager)
+	}
+
+	job, exist, _ := nodes[0].popJob("distributed")
+	if !exist {
+		t.Fatal("node 0 popJob() exist = false, want true")
+	}
+	if _, exist, limited := nodes[1].popJob("distributed"); exist || !limited {
+		t.Fatalf("node 1 popJob() exist = %v, limited = %v, want limited", exist, limited)
+	}
+	nodes[0].releaseSlot(job)
+	if _, exist, _ := nodes[1].popJob("distributed"); !exist {
+		t.Fatal("node 1 popJob() after release exist = false, want true")
+	}
+}
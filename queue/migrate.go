@@ -0,0 +1,323 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// 队列驱动之间迁移任务：
+// 一、原理
+//    分批从源驱动取出待执行任务与延迟任务，原样写入目标驱动，保留任务结构中的ID、已尝试次数以及可执行时刻
+//    执行中（reserved）的任务不迁移，执行结束后按原逻辑删除或重试，可在消费进程停止后迁移或迁移后再次执行迁移
+// 二、失败任务
+//    源驱动存储的失败任务移至目标驱动，目标驱动不存储失败任务时交由 MigrateOptions.FailedJobHandler 处理后删除，
+//    既不存储也未设置处理器时跳过并保留在源驱动
+// 三、安全
+//    每批任务写入目标驱动成功后才从源驱动移除：写入失败时任务仍在源驱动，进程中途退出不会丢失任务，
+//    写入成功但移除前退出时本批任务会在再次迁移时重复写入（至少一次）；
+//    迁移期间仍在消费的进程可能在写入与移除之间取出本批任务执行，同样导致重复，建议停止消费进程后迁移；
+//    DryRun 模式仅统计待迁移的任务数，不做任何修改
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// DefaultMigrateBatchSize 默认迁移任务每批任务数
+const DefaultMigrateBatchSize = 100
+
+// 迁移阶段常量
+const (
+	MigrateStageJobs   = "jobs"   // 迁移待执行任务与延迟任务
+	MigrateStageFailed = "failed" // 复制失败任务
+)
+
+// ErrMigrateUnsupported 队列驱动不支持迁移任务
+var ErrMigrateUnsupported = errors.New("queue.migrate.unsupported")
+
+// MigrationJob 一条迁移中的任务
+type MigrationJob struct {
+	Payload     []byte    // 任务结构JSON，已尝试次数以源驱动记录为准
+	AvailableAt time.Time // 任务可被执行的时刻
+}
+
+// FailedJob 一条失败任务记录
+type FailedJob struct {
+	Queue     string    // 队列名称
+	Payload   []byte    // 任务结构JSON
+	Exception string    // 失败原因
+	FailedAt  time.Time // 失败时刻
+}
+
+// MigratableQueueIFace 可选实现的可迁移任务的队列驱动契约，Redis、MySQL、Memory驱动均已实现
+type MigratableQueueIFace interface {
+	// Backlog 统计队列中未被取出执行的任务数：pending为已到可执行时刻的任务数，delayed为延迟任务数
+	Backlog(queue string) (pending, delayed int64, err error)
+	// Drain 取出队列中最多limit条未被取出执行的任务（含延迟任务）交由handler处理，handler返回nil后才从队列中移除
+	//   - 队列为空时不调用handler；handler返回error时任务保留在队列中并原样返回该error
+	//   - 执行中的任务不受影响，调用handler期间被消费进程取出的任务不再移除
+	Drain(queue string, limit int64, handler func(jobs []MigrationJob) error) (err error)
+	// Restore 原样写入任务，可执行时刻晚于当前时刻的写入为延迟任务
+	Restore(queue string, jobs []MigrationJob) (err error)
+}
+
+// FailedJobStoreIFace 可选实现的存储失败任务的队列驱动契约，MySQL驱动已实现
+type FailedJobStoreIFace interface {
+	// FailedJobs 按失败先后顺序分页获取队列的失败任务
+	FailedJobs(queue string, offset, limit int64) (jobs []FailedJob, err error)
+	// DrainFailedJobs 按失败先后顺序取出队列最多limit条失败任务交由handler处理，handler返回nil后才删除
+	//   - 没有失败任务时不调用handler；handler返回error时失败任务保留并原样返回该error
+	DrainFailedJobs(queue string, limit int64, handler func(jobs []FailedJob) error) (err error)
+	// AddFailedJobs 写入失败任务
+	AddFailedJobs(jobs []FailedJob) (err error)
+}
+
+// MigrateOptions 迁移任务选项
+type MigrateOptions struct {
+	// Queues 需迁移的队列名称，即task名称，源驱动支持分区时自动包含task的各分区子队列
+	Queues []string
+	// DryRun 仅统计待迁移的任务数，不做任何修改
+	DryRun bool
+	// BatchSize 每批迁移的任务数，默认值：DefaultMigrateBatchSize
+	BatchSize int64
+	// FailedJobHandler 目标驱动不存储失败任务时失败任务的处理器，为nil时跳过失败任务
+	FailedJobHandler FailedJobHandler
+	// Progress 迁移进度回调，每批任务迁移完成后调用，可使用 MigrateProgressWriter 输出至终端
+	Progress func(progress MigrateProgress)
+}
+
+// MigrateProgress 迁移进度
+type MigrateProgress struct {
+	Queue  string // 队列名称
+	Stage  string // 迁移阶段：MigrateStageJobs 或 MigrateStageFailed
+	Done   int64  // 当前队列当前阶段已迁移的任务数
+	Total  int64  // 当前队列当前阶段开始时待迁移的任务数，迁移期间新投递的任务同样会被迁移
+	DryRun bool   // 是否为DryRun模式
+}
+
+// MigrateResult 迁移结果
+type MigrateResult struct {
+	Pending       int64 // 已迁移的已到可执行时刻的任务数，DryRun模式为待迁移数
+	Delayed       int64 // 已迁移的延迟任务数，DryRun模式为待迁移数
+	Failed        int64 // 已迁移的失败任务数，DryRun模式为待迁移数
+	SkippedFailed int64 // 目标驱动不存储失败任务且未设置处理器而跳过的失败任务数
+}
+
+// MigrateProgressWriter 输出迁移进度至writer，譬如：os.Stdout
+func MigrateProgressWriter(writer io.Writer) func(progress MigrateProgress) {
+	return func(progress MigrateProgress) {
+		mode := ""
+		if progress.DryRun {
+			mode = "[dry-run] "
+		}
+		_, _ = fmt.Fprintf(writer, "%squeue %s %s: %d/%d\n", mode, progress.Queue, progress.Stage, progress.Done, progress.Total)
+	}
+}
+
+// Migrate 将队列任务从源驱动迁移至目标驱动
+//   - 源驱动与目标驱动均需实现 MigratableQueueIFace，可使用 NewDriver 创建
+//   - ctx 取消时在当前批次完成后停止迁移并返回已迁移的结果
+func Migrate(ctx context.Context, from, to QueueIFace, options MigrateOptions) (result MigrateResult, err error) {
+	source, ok := from.(MigratableQueueIFace)
+	if !ok {
+		return result, fmt.Errorf("%w: source driver", ErrMigrateUnsupported)
+	}
+	target, ok := to.(MigratableQueueIFace)
+	if !ok {
+		return result, fmt.Errorf("%w: target driver", ErrMigrateUnsupported)
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultMigrateBatchSize
+	}
+
+	queues, err := migrateQueues(from, options.Queues)
+	if err != nil {
+		return result, err
+	}
+
+	for _, queue := range queues {
+		if err = migrateJobs(ctx, source, target, queue, options, &result); err != nil {
+			return result, err
+		}
+		if err = migrateFailedJobs(ctx, from, to, queue, options, &result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// migrateQueues 获取需迁移的队列名称：task队列本身以及各分区子队列
+func migrateQueues(from QueueIFace, names []string) ([]string, error) {
+	partitionDriver, ok := from.(PartitionQueueIFace)
+
+	queues := make([]string, 0, len(names))
+	for _, name := range names {
+		queues = append(queues, name)
+		if !ok {
+			continue
+		}
+
+		partitions, err := partitionDriver.Partitions(name)
+		if err != nil {
+			return nil, fmt.Errorf("queue %s get partitions failed: %w", name, err)
+		}
+		for _, partition := range partitions {
+			queues = append(queues, partitionQueueName(name, partition))
+		}
+	}
+	return queues, nil
+}
+
+// migrateJobs 分批迁移单个队列的待执行任务与延迟任务
+func migrateJobs(ctx context.Context, source, target MigratableQueueIFace, queue string, options MigrateOptions, result *MigrateResult) error {
+	pending, delayed, err := source.Backlog(queue)
+	if err != nil {
+		return fmt.Errorf("queue %s backlog failed: %w", queue, err)
+	}
+
+	progress := MigrateProgress{Queue: queue, Stage: MigrateStageJobs, Total: pending + delayed, DryRun: options.DryRun}
+	if options.DryRun {
+		result.Pending += pending
+		result.Delayed += delayed
+		progress.Done = progress.Total
+		options.report(progress)
+		return nil
+	}
+
+	for ctx.Err() == nil {
+		var (
+			drained    []MigrationJob
+			restoreErr error
+		)
+		err = source.Drain(queue, options.BatchSize, func(jobs []MigrationJob) error {
+			// 写入目标驱动失败时本批任务保留在源驱动
+			if restoreErr = target.Restore(queue, jobs); restoreErr != nil {
+				return restoreErr
+			}
+			drained = jobs
+			return nil
+		})
+		if drained != nil {
+			// 已写入目标驱动的任务计入结果，即使随后从源驱动移除失败
+			now := time.Now()
+			for _, job := range drained {
+				if job.AvailableAt.After(now) {
+					result.Delayed++
+				} else {
+					result.Pending++
+				}
+			}
+			progress.Done += int64(len(drained))
+			options.report(progress)
+		}
+		if restoreErr != nil {
+			return fmt.Errorf("queue %s restore failed: %w", queue, restoreErr)
+		}
+		if err != nil {
+			if drained != nil {
+				return fmt.Errorf("queue %s drain failed after %d jobs were restored, they will be migrated again: %w", queue, len(drained), err)
+			}
+			return fmt.Errorf("queue %s drain failed: %w", queue, err)
+		}
+		if drained == nil {
+			break
+		}
+	}
+
+	return ctx.Err()
+}
+
+// migrateFailedJobs 分批迁移单个队列的失败任务
+//   - 目标驱动存储失败任务或设置了处理器时逐批移出源驱动，否则（以及DryRun模式）仅分页统计
+func migrateFailedJobs(ctx context.Context, from, to QueueIFace, queue string, options MigrateOptions, result *MigrateResult) error {
+	source, ok := from.(FailedJobStoreIFace)
+	if !ok {
+		return nil
+	}
+	target, targetOk := to.(FailedJobStoreIFace)
+
+	progress := MigrateProgress{Queue: queue, Stage: MigrateStageFailed, DryRun: options.DryRun}
+	if options.DryRun || (!targetOk && options.FailedJobHandler == nil) {
+		for offset := int64(0); ctx.Err() == nil; offset += options.BatchSize {
+			jobs, err := source.FailedJobs(queue, offset, options.BatchSize)
+			if err != nil {
+				return fmt.Errorf("queue %s get failed jobs failed: %w", queue, err)
+			}
+			if len(jobs) == 0 {
+				break
+			}
+
+			progress.Total += int64(len(jobs))
+			progress.Done = progress.Total
+			if options.DryRun {
+				result.Failed += int64(len(jobs))
+			} else {
+				result.SkippedFailed += int64(len(jobs))
+			}
+			options.report(progress)
+		}
+		return ctx.Err()
+	}
+
+	for ctx.Err() == nil {
+		var (
+			drained    int64
+			handlerErr error
+		)
+		err := source.DrainFailedJobs(queue, options.BatchSize, func(jobs []FailedJob) error {
+			if handlerErr = moveFailedJobs(queue, jobs, target, options.FailedJobHandler); handlerErr != nil {
+				return handlerErr
+			}
+			drained = int64(len(jobs))
+			return nil
+		})
+		if drained > 0 {
+			result.Failed += drained
+			progress.Total += drained
+			progress.Done = progress.Total
+			options.report(progress)
+		}
+		if handlerErr != nil {
+			return handlerErr
+		}
+		if err != nil {
+			return fmt.Errorf("queue %s drain failed jobs failed: %w", queue, err)
+		}
+		if drained == 0 {
+			break
+		}
+	}
+
+	return ctx.Err()
+}
+
+// moveFailedJobs 将一批失败任务写入目标驱动，目标驱动不存储失败任务时逐条交由处理器
+//   - 处理器中途返回error时本批失败任务保留在源驱动，再次迁移时已处理的任务会再次交由处理器
+func moveFailedJobs(queue string, jobs []FailedJob, target FailedJobStoreIFace, handler FailedJobHandler) error {
+	if target != nil {
+		if err := target.AddFailedJobs(jobs); err != nil {
+			return fmt.Errorf("queue %s add failed jobs failed: %w", queue, err)
+		}
+		return nil
+	}
+
+	for _, job := range jobs {
+		var payload Payload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("queue %s failed job payload invalid: %w", queue, err)
+		}
+		if err := handler(&payload, errors.New(job.Exception)); err != nil {
+			return fmt.Errorf("queue %s failed job handler failed: %w", queue, err)
+		}
+	}
+	return nil
+}
+
+// report 回调迁移进度
+func (options MigrateOptions) report(progress MigrateProgress) {
+	if options.Progress != nil {
+		options.Progress(progress)
+	}
+}
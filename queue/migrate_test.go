@@ -0,0 +1,326 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// failedStoreQueue 存储失败任务的内存驱动，仅支持单个队列
+type failedStoreQueue struct {
+	*memoryQueue
+	failed []FailedJob
+}
+
+func newFailedStoreQueue(n int) *failedStoreQueue {
+	q := &failedStoreQueue{memoryQueue: newMigrateTestQueue()}
+	for i := 0; i < n; i++ {
+		payload, _ := json.Marshal(Payload{Name: "task", ID: string(rune('a' + i))})
+		q.failed = append(q.failed, FailedJob{Queue: "task", Payload: payload, Exception: "boom", FailedAt: time.Unix(int64(i), 0)})
+	}
+	return q
+}
+
+func (q *failedStoreQueue) FailedJobs(queue string, offset, limit int64) (jobs []FailedJob, err error) {
+	if offset >= int64(len(q.failed)) {
+		return nil, nil
+	}
+	end := offset + limit
+	if end > int64(len(q.failed)) {
+		end = int64(len(q.failed))
+	}
+	return append([]FailedJob(nil), q.failed[offset:end]...), nil
+}
+
+func (q *failedStoreQueue) DrainFailedJobs(queue string, limit int64, handler func(jobs []FailedJob) error) (err error) {
+	jobs, _ := q.FailedJobs(queue, 0, limit)
+	if len(jobs) == 0 {
+		return nil
+	}
+	if err = handler(jobs); err != nil {
+		return err
+	}
+	q.failed = q.failed[len(jobs):]
+	return nil
+}
+
+func (q *failedStoreQueue) AddFailedJobs(jobs []FailedJob) (err error) {
+	q.failed = append(q.failed, jobs...)
+	return nil
+}
+
+// failingRestoreQueue 写入任务总是失败的内存驱动
+type failingRestoreQueue struct {
+	*memoryQueue
+}
+
+func (q failingRestoreQueue) Restore(queue string, jobs []MigrationJob) (err error) {
+	return errors.New("target down")
+}
+
+func newMigrateTestQueue() *memoryQueue {
+	driver, _ := NewDriver(Memory, nil, Config{})
+	return driver.(*memoryQueue)
+}
+
+// fillMigrateSource 投递3条待执行任务（其中一条已尝试2次）、2条延迟任务以及分区p中的1条待执行任务
+func fillMigrateSource(t *testing.T, q QueueIFace) {
+	t.Helper()
+	push := func(queue, id string, attempts int64, at time.Time) {
+		payload, _ := json.Marshal(Payload{Name: "task", ID: id, Attempts: attempts, Timeout: 60})
+		var err error
+		if at.IsZero() {
+			err = q.Push(queue, payload)
+		} else {
+			err = q.LaterAt(queue, at, payload)
+		}
+		if err != nil {
+			t.Fatalf("push %s error = %v", id, err)
+		}
+	}
+	push("task", "p1", 0, time.Time{})
+	push("task", "p2", 2, time.Time{})
+	push("task", "p3", 0, time.Time{})
+	push("task", "d1", 0, time.Now().Add(time.Hour))
+	push("task", "d2", 0, time.Now().Add(2*time.Hour))
+	push(partitionQueueName("task", "p"), "p4", 0, time.Time{})
+}
+
+func TestMigrateMovesJobs(t *testing.T) {
+	source := newMigrateTestQueue()
+	fillMigrateSource(t, source)
+	_, client := newTestRedis(t)
+	target, _ := NewDriver(Redis, client, Config{})
+
+	var reports []MigrateProgress
+	result, err := Migrate(context.Background(), source, target, MigrateOptions{
+		Queues:    []string{"task"},
+		BatchSize: 2,
+		Progress:  func(progress MigrateProgress) { reports = append(reports, progress) },
+	})
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if result.Pending != 4 || result.Delayed != 2 {
+		t.Fatalf("Migrate() = %+v, want 4 pending and 2 delayed", result)
+	}
+
+	for _, queue := range []string{"task", partitionQueueName("task", "p")} {
+		if pending, delayed, _ := source.Backlog(queue); pending+delayed != 0 {
+			t.Fatalf("source %s left %d pending and %d delayed", queue, pending, delayed)
+		}
+	}
+	migratable := target.(MigratableQueueIFace)
+	if pending, delayed, _ := migratable.Backlog("task"); pending != 3 || delayed != 2 {
+		t.Fatalf("target task backlog = %d pending, %d delayed", pending, delayed)
+	}
+	if pending, _, _ := migratable.Backlog(partitionQueueName("task", "p")); pending != 1 {
+		t.Fatalf("target partition backlog = %d pending", pending)
+	}
+
+	// 任务ID、已尝试次数保持不变
+	job, exist := target.Pop("task")
+	if !exist || job.Payload().ID != "p1" {
+		t.Fatalf("first job = %v, want p1", job)
+	}
+	job, _ = target.Pop("task")
+	if job.Payload().ID != "p2" || job.Attempts() != 3 {
+		t.Fatalf("second job %s attempts = %d, want p2 popped for the 3rd time", job.Payload().ID, job.Attempts())
+	}
+
+	// 每批回调一次进度，task共5条分3批
+	if len(reports) != 4 || reports[2].Queue != "task" || reports[2].Done != 5 || reports[2].Total != 5 {
+		t.Fatalf("progress reports = %+v", reports)
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	source := newMigrateTestQueue()
+	fillMigrateSource(t, source)
+	target := newMigrateTestQueue()
+
+	var out bytes.Buffer
+	result, err := Migrate(context.Background(), source, target, MigrateOptions{
+		Queues:   []string{"task"},
+		DryRun:   true,
+		Progress: MigrateProgressWriter(&out),
+	})
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if result.Pending != 4 || result.Delayed != 2 {
+		t.Fatalf("Migrate() = %+v, want the jobs to migrate counted", result)
+	}
+	if size := source.Size("task"); size != 5 {
+		t.Fatalf("source size = %d after a dry run", size)
+	}
+	if size := target.Size("task"); size != 0 {
+		t.Fatalf("target size = %d after a dry run", size)
+	}
+	if !strings.Contains(out.String(), "[dry-run] queue task jobs: 5/5\n") {
+		t.Fatalf("progress output %q", out.String())
+	}
+}
+
+func TestMigrateRestoreFailureKeepsJobs(t *testing.T) {
+	_, client := newTestRedis(t)
+	source, _ := NewDriver(Redis, client, Config{})
+	fillMigrateSource(t, source)
+
+	_, err := Migrate(context.Background(), source, failingRestoreQueue{newMigrateTestQueue()}, MigrateOptions{Queues: []string{"task"}})
+	if err == nil || !strings.Contains(err.Error(), "target down") {
+		t.Fatalf("Migrate() error = %v, want the restore error", err)
+	}
+	if pending, delayed, _ := source.(MigratableQueueIFace).Backlog("task"); pending != 3 || delayed != 2 {
+		t.Fatalf("source backlog = %d pending, %d delayed, want the batch kept", pending, delayed)
+	}
+}
+
+func TestMigrateUnsupported(t *testing.T) {
+	syncDriver, _ := NewDriver(Sync, nil, Config{})
+	if _, err := Migrate(context.Background(), syncDriver, newMigrateTestQueue(), MigrateOptions{}); !errors.Is(err, ErrMigrateUnsupported) {
+		t.Fatalf("Migrate() error = %v, want ErrMigrateUnsupported", err)
+	}
+}
+
+func TestMigrateFailedJobs(t *testing.T) {
+	options := MigrateOptions{Queues: []string{"task"}, BatchSize: 2}
+
+	// 目标驱动存储失败任务：移出源驱动，再次迁移不会重复
+	source, target := newFailedStoreQueue(5), newFailedStoreQueue(0)
+	result, err := Migrate(context.Background(), source, target, options)
+	if err != nil || result.Failed != 5 {
+		t.Fatalf("Migrate() = %+v, %v, want 5 failed jobs moved", result, err)
+	}
+	if len(source.failed) != 0 || len(target.failed) != 5 {
+		t.Fatalf("source keeps %d and target has %d failed jobs", len(source.failed), len(target.failed))
+	}
+	if result, _ = Migrate(context.Background(), source, target, options); result.Failed != 0 || len(target.failed) != 5 {
+		t.Fatalf("rerun moved %d failed jobs, target has %d", result.Failed, len(target.failed))
+	}
+
+	// DryRun 以及既不存储也无处理器时仅统计，失败任务保留在源驱动
+	source = newFailedStoreQueue(5)
+	dryRun := options
+	dryRun.DryRun = true
+	if result, _ = Migrate(context.Background(), source, newMigrateTestQueue(), dryRun); result.Failed != 5 || len(source.failed) != 5 {
+		t.Fatalf("dry run = %+v, source keeps %d", result, len(source.failed))
+	}
+	if result, _ = Migrate(context.Background(), source, newMigrateTestQueue(), options); result.SkippedFailed != 5 || len(source.failed) != 5 {
+		t.Fatalf("without handler = %+v, source keeps %d", result, len(source.failed))
+	}
+
+	// 处理器返回error时当前批次保留在源驱动
+	var handled []string
+	withHandler := options
+	withHandler.FailedJobHandler = func(payload *Payload, err error) error {
+		if len(handled) == 3 {
+			return errors.New("handler down")
+		}
+		handled = append(handled, payload.ID)
+		return nil
+	}
+	result, err = Migrate(context.Background(), source, newMigrateTestQueue(), withHandler)
+	if err == nil || result.Failed != 2 {
+		t.Fatalf("Migrate() = %+v, %v, want the first batch moved then the handler error", result, err)
+	}
+	if len(source.failed) != 3 || string(source.failed[0].Payload) != string(newFailedStoreQueue(3).failed[2].Payload) {
+		t.Fatalf("source keeps %d failed jobs, want the failing batch and the rest", len(source.failed))
+	}
+}
+
+func TestMemoryQueueDrainKeepsJobsPoppedByHandler(t *testing.T) {
+	q := newMigrateTestQueue()
+	fillMigrateSource(t, q)
+
+	err := q.Drain("task", 2, func(jobs []MigrationJob) error {
+		// 调用handler时不持有锁，期间被消费进程取出的任务不再移除
+		if job, exist := q.Pop("task"); !exist || job.Payload().ID != "p1" {
+			t.Fatalf("Pop() during drain = %v, %v", job, exist)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	if pending, delayed, _ := q.Backlog("task"); pending != 1 || delayed != 2 {
+		t.Fatalf("backlog = %d pending, %d delayed, want p3 and the delayed jobs left", pending, delayed)
+	}
+
+	if err = q.Drain("task", 10, func(jobs []MigrationJob) error { return errors.New("target down") }); err == nil {
+		t.Fatal("Drain() error = nil, want the handler error")
+	}
+	if pending, delayed, _ := q.Backlog("task"); pending != 1 || delayed != 2 {
+		t.Fatalf("backlog = %d pending, %d delayed after a failed handler", pending, delayed)
+	}
+}
+
+func TestMySQLDrainDeletesAfterHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+	driver, _ := NewDriver(MySQL, db, Config{})
+	q := driver.(*mysqlQueue)
+
+	selectJobs := regexp.QuoteMeta("SELECT id, payload, attempts, available_at FROM queue_jobs WHERE queue_name = ? AND reserved_at IS NULL ORDER BY available_at ASC, id ASC LIMIT ? FOR UPDATE")
+	jobRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "payload", "attempts", "available_at"}).
+			AddRow(1, `{"Name":"task","ID":"a"}`, 2, 100).
+			AddRow(2, `{"Name":"task","ID":"b"}`, 0, 200)
+	}
+
+	// handler成功：同一事务内删除后提交
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectJobs).WithArgs("task", 10).WillReturnRows(jobRows())
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM queue_jobs WHERE id IN (?, ?)")).WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	// handler失败：回滚，不删除
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectJobs).WithArgs("task", 10).WillReturnRows(jobRows())
+	mock.ExpectRollback()
+
+	var drained []MigrationJob
+	if err = q.Drain("task", 10, func(jobs []MigrationJob) error {
+		drained = jobs
+		return nil
+	}); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	var payload Payload
+	_ = json.Unmarshal(drained[0].Payload, &payload)
+	if len(drained) != 2 || payload.Attempts != 2 || drained[1].AvailableAt.Unix() != 200 {
+		t.Fatalf("drained %d jobs, first attempts %d", len(drained), payload.Attempts)
+	}
+	if err = q.Drain("task", 10, func(jobs []MigrationJob) error { return errors.New("target down") }); err == nil {
+		t.Fatal("Drain() error = nil, want the handler error")
+	}
+
+	// 失败任务同理
+	selectFailed := regexp.QuoteMeta("SELECT id, queue_name, payload, exception, failed_at FROM queue_failed_jobs WHERE queue_name = ? ORDER BY id ASC LIMIT ? FOR UPDATE")
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectFailed).WithArgs("task", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "queue_name", "payload", "exception", "failed_at"}).AddRow(7, "task", `{}`, "boom", 100))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM queue_failed_jobs WHERE id IN (?)")).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err = q.DrainFailedJobs("task", 10, func(jobs []FailedJob) error {
+		if len(jobs) != 1 || jobs[0].Exception != "boom" {
+			t.Fatalf("failed jobs = %+v", jobs)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("DrainFailedJobs() error = %v", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,238 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// 多租户分区公平调度：
+// 一、原理
+//    投递任务时指定分区（譬如租户ID），任务存储于task的分区子队列：`task名称:partition:分区`，
+//    未指定分区的任务仍存储于task队列本身（默认分区），分区子队列同样支持延迟任务、重试任务
+// 二、调度
+//    looper从task取出任务时按平滑加权轮询（smooth weighted round-robin）依次选择分区，
+//    被选中的分区暂无任务时依次尝试其他分区，单个分区堆积大量任务不会饿死其他分区
+//    task未实现 PartitionWeightTask 时各分区权重均为1，即轮询
+// 三、分区发现
+//    looper间隔 partitionRefreshInterval 时长从底层驱动重新获取存在任务的分区，驱动需实现 PartitionQueueIFace
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+const (
+	partitionSeparator       = ":partition:" // task队列名称与分区之间的分隔符
+	partitionRefreshInterval = time.Second   // looper重新获取task分区的间隔
+)
+
+// ErrPartitionUnsupported 队列驱动不支持分区
+var ErrPartitionUnsupported = errors.New("queue.partition.unsupported")
+
+// PartitionQueueIFace 可选实现的分区队列驱动契约，Redis、MySQL、Memory驱动均已实现
+type PartitionQueueIFace interface {
+	// Partitions 获取task当前存在任务（含延迟任务、保留任务）的分区，不含默认分区
+	// @param queue task队列的名称
+	Partitions(queue string) (partitions []string, err error)
+}
+
+// PartitionWeightTask 可选实现的task分区加权公平调度契约
+//   - 未实现该接口的task各分区权重均为1
+type PartitionWeightTask interface {
+	PartitionWeight(partition string) int64 // 分区调度权重，小于等于0视为1，默认分区的partition为空字符串
+}
+
+// partitionQueueName 获取task分区子队列名称，分区为空时为task队列本身
+func partitionQueueName(queue, partition string) string {
+	if partition == "" {
+		return queue
+	}
+	return queue + partitionSeparator + partition
+}
+
+// checkPartition 检查分区名称：不得包含英文冒号，避免与延迟、保留队列名称冲突
+func checkPartition(partition string) error {
+	if strings.Contains(partition, ":") {
+		return fmt.Errorf("queue partition %s can not contain ':'", partition)
+	}
+	return nil
+}
+
+// partitionScheduler 单个task的分区平滑加权轮询调度器
+type partitionScheduler struct {
+	lock        sync.Mutex       // 并发锁：通用looper与专用looper可能同时调度同一task
+	task        TaskIFace        // task实例，用于获取分区权重
+	partitions  []string         // 当前存在任务的分区，首个元素为默认分区
+	current     map[string]int64 // 平滑加权轮询各分区当前权重
+	refreshedAt time.Time        // 上次获取分区的时刻
+}
+
+// weight 获取分区调度权重
+func (s *partitionScheduler) weight(partition string) int64 {
+	if weightTask, ok := s.task.(PartitionWeightTask); ok {
+		if weight := weightTask.PartitionWeight(partition); weight > 0 {
+			return weight
+		}
+	}
+	return 1
+}
+
+// next 按平滑加权轮询选出本轮首先尝试的分区，返回依次尝试的分区子队列名称
+func (s *partitionScheduler) next() []string {
+	if len(s.partitions) == 1 {
+		return []string{s.task.Name()}
+	}
+
+	var (
+		total    int64
+		selected = 0
+	)
+	for i, partition := range s.partitions {
+		weight := s.weight(partition)
+		total += weight
+		s.current[partition] += weight
+		if s.current[partition] > s.current[s.partitions[selected]] {
+			selected = i
+		}
+	}
+	s.current[s.partitions[selected]] -= total
+
+	queues := make([]string, 0, len(s.partitions))
+	for i := range s.partitions {
+		partition := s.partitions[(selected+i)%len(s.partitions)]
+		queues = append(queues, partitionQueueName(s.task.Name(), partition))
+	}
+	return queues
+}
+
+// initPartition 初始化task分区调度器（需要在持有锁的情况下调用）
+func (m *manager) initPartition(name string, task TaskIFace) {
+	if _, ok := m.queue.(PartitionQueueIFace); !ok {
+		return
+	}
+	m.partitions[name] = &partitionScheduler{
+		task:       task,
+		partitions: []string{""},
+		current:    make(map[string]int64),
+	}
+}
+
+// partitionQueues 获取本次从task取出任务时依次尝试的队列名称
+//   - 底层驱动不支持分区时仅为task队列本身
+func (m *manager) partitionQueues(name string) []string {
+	scheduler, ok := m.partitions[name]
+	if !ok {
+		return []string{name}
+	}
+
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	if now := time.Now(); now.Sub(scheduler.refreshedAt) >= partitionRefreshInterval {
+		scheduler.refreshedAt = now
+		m.refreshPartitions(scheduler)
+	}
+
+	return scheduler.next()
+}
+
+// knownPartitionQueues 获取task当前已知的全部分区子队列名称，不推进分区调度顺序
+func (m *manager) knownPartitionQueues(name string) []string {
+	scheduler, ok := m.partitions[name]
+	if !ok {
+		return []string{name}
+	}
+
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	if now := time.Now(); now.Sub(scheduler.refreshedAt) >= partitionRefreshInterval {
+		scheduler.refreshedAt = now
+		m.refreshPartitions(scheduler)
+	}
+
+	queues := make([]string, 0, len(scheduler.partitions))
+	for _, partition := range scheduler.partitions {
+		queues = append(queues, partitionQueueName(name, partition))
+	}
+	return queues
+}
+
+// refreshPartitions 从底层驱动重新获取task存在任务的分区（需要在持有调度器锁的情况下调用）
+func (m *manager) refreshPartitions(scheduler *partitionScheduler) {
+	partitions, err := m.queue.(PartitionQueueIFace).Partitions(scheduler.task.Name())
+	if err != nil {
+		m.logger.Warn("queue.partition.refresh.failed", "queue", scheduler.task.Name(), "error", err.Error())
+		return
+	}
+
+	current := make(map[string]int64, len(partitions)+1)
+	current[""] = scheduler.current[""]
+	for _, partition := range partitions {
+		current[partition] = scheduler.current[partition]
+	}
+	scheduler.partitions = append([]string{""}, partitions...)
+	scheduler.current = current
+}
+
+// hasPartitions 检查task当前是否存在默认分区之外的分区
+func (m *manager) hasPartitions(name string) bool {
+	scheduler, ok := m.partitions[name]
+	if !ok {
+		return false
+	}
+
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	return len(scheduler.partitions) > 1
+}
+
+// expirePartitions 使task分区缓存失效，下次取出任务时重新获取分区
+func (m *manager) expirePartitions(name string) {
+	scheduler, ok := m.partitions[name]
+	if !ok {
+		return
+	}
+
+	scheduler.lock.Lock()
+	scheduler.refreshedAt = time.Time{}
+	scheduler.lock.Unlock()
+}
+
+// taskSize 获取task待执行的job数：默认分区与各分区之和，以及各分区待执行的job数
+//   - 底层驱动异常时返回error，获取分区失败时仅统计默认分区
+func (m *manager) taskSize(ctx context.Context, name string) (total int64, partitions map[string]int64, err error) {
+	if total, err = m.queueSize(ctx, name); err != nil {
+		return 0, nil, err
+	}
+
+	driver, ok := m.queue.(PartitionQueueIFace)
+	if !ok {
+		return total, nil, nil
+	}
+	names, err := driver.Partitions(name)
+	if err != nil || len(names) == 0 {
+		return total, nil, nil
+	}
+
+	partitions = make(map[string]int64, len(names))
+	for _, partition := range names {
+		if partitions[partition], err = m.queueSize(ctx, partitionQueueName(name, partition)); err != nil {
+			return 0, nil, err
+		}
+		total += partitions[partition]
+	}
+	return total, partitions, nil
+}
+
+// queueSize 获取队列长度：底层驱动实现 ContextQueueIFace 时返回底层驱动的错误
+func (m *manager) queueSize(ctx context.Context, queue string) (int64, error) {
+	if driver, ok := m.queue.(ContextQueueIFace); ok {
+		return driver.SizeCtx(ctx, queue)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return m.queue.Size(queue), nil
+}
@@ -2,10 +2,14 @@ package queue
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
 // queue队列支持的底层驱动名称常量
@@ -14,6 +18,8 @@ const (
 	Redis  = "redis"
 	Memory = "memory"
 	MySQL  = "mysql"
+	Sync   = "sync"   // 投递即在投递方goroutine中执行，仅用于本地开发调试、命令行工具
+	Custom = "custom" // 通过 NewWithDriver 使用的自定义驱动
 )
 
 // Queue 队列struct
@@ -32,27 +38,82 @@ type Queue struct {
 //		@param logger     实现 Logger 接口的结构体实例的指针对象
 //	    @param config     配置
 func New(driver string, conn interface{}, logger Logger, config Config) *Queue {
+	queue, err := NewDriver(driver, conn, config)
+	if nil != err {
+		panic(err.Error())
+	}
+
+	// set driver Semaphore Default
+	if config.Semaphore == nil {
+		switch driver {
+		case Redis:
+			config.Semaphore = newRedisSemaphore(conn.(redis.UniversalClient), config.KeyPrefix)
+		case MySQL:
+			config.Semaphore = NewMySQLSemaphore(conn.(*sql.DB), config.TablePrefix)
+		}
+	}
+
+	return newQueue(driver, queue, logger, config)
+}
+
+// NewWithDriver 使用自定义实现的 QueueIFace 驱动初始化一个队列
+//
+//	@param queue      已设置好连接器的 QueueIFace 驱动实例
+//	@param logger     实现 Logger 接口的结构体实例的指针对象
+//	@param config     配置
+func NewWithDriver(queue QueueIFace, logger Logger, config Config) *Queue {
+	return newQueue(Custom, queue, logger, config)
+}
+
+// NewDriver 初始化一个内置的队列底层驱动并设置连接器
+//
+//	@param driver     队列实现底层驱动，可选值见上方14行附近位置的常量
+//	@param conn       driver对应底层驱动连接器句柄，具体类型参考 QueueIFace 实体类
+//	@param config     配置
+func NewDriver(driver string, conn interface{}, config Config) (QueueIFace, error) {
 	var queue QueueIFace
 
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+
 	// init specify queue driver
 	switch driver {
 	case Memory:
-		queue = &memoryQueue{lock: sync.Mutex{}}
+		queue = &memoryQueue{lock: sync.Mutex{}, clock: config.Clock, memoryJobHistory: memoryJobHistory{clock: config.Clock}}
 	case Redis:
-		// queue = &redisQueue{connection: conn.(*redis.Client)}
-		queue = &redisQueue{luaScripts: &luaScripts{}}
+		queue = &redisQueue{
+			queueBasic: queueBasic{keyPrefix: config.KeyPrefix, hashTag: config.RedisHashTag},
+			luaScripts: &luaScripts{},
+			blocking:   redisBlocking{waiters: make(map[string]*redisWaiter)},
+		}
 	case MySQL:
-		queue = &mysqlQueue{tablePrefix: config.TablePrefix}
+		if config.MySQLPrefetch <= 0 {
+			config.MySQLPrefetch = DefaultMySQLPrefetch
+		}
+		if config.MySQLSweepInterval <= 0 {
+			config.MySQLSweepInterval = DefaultMySQLSweepInterval
+		}
+		queue = &mysqlQueue{
+			tablePrefix: config.TablePrefix,
+			prefetch:    mysqlPrefetch{size: config.MySQLPrefetch, sweepInterval: config.MySQLSweepInterval},
+		}
+	case Sync:
+		queue = &syncQueue{clock: config.Clock, memoryJobHistory: memoryJobHistory{clock: config.Clock}}
 	default:
-		panic("do not implement queue instance: " + driver)
+		return nil, fmt.Errorf("do not implement queue instance: %s", driver)
 	}
 
 	// set connection
-	err := queue.SetConnection(conn)
-	if nil != err {
-		panic(err.Error())
+	if err := queue.SetConnection(conn); nil != err {
+		return nil, err
 	}
 
+	return queue, nil
+}
+
+// newQueue 设置配置默认值并初始化队列
+func newQueue(driver string, queue QueueIFace, logger Logger, config Config) *Queue {
 	// set config Default
 	if config.MaxConcurrency <= 0 {
 		config.MaxConcurrency = DefaultMaxConcurrency
@@ -63,11 +124,33 @@ func New(driver string, conn interface{}, logger Logger, config Config) *Queue {
 	if config.AutoScaleInterval <= 0 {
 		config.AutoScaleInterval = DefaultAutoScaleInterval
 	}
+	if config.BlobThreshold <= 0 {
+		config.BlobThreshold = DefaultBlobThreshold
+	}
+	if config.BlockingTimeout <= 0 {
+		config.BlockingTimeout = DefaultBlockingTimeout
+	}
+	if config.JobHistoryTTL <= 0 {
+		config.JobHistoryTTL = DefaultJobHistoryTTL
+	}
+	if config.JobHistoryMaxEvents <= 0 {
+		config.JobHistoryMaxEvents = DefaultJobHistoryMaxEvents
+	}
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+
+	m := newManager(queue, logger, config)
+
+	// 同步驱动通过队列管理者执行任务
+	if syncDriver, ok := queue.(*syncQueue); ok {
+		syncDriver.manager = m
+	}
 
 	return &Queue{
 		driver:  driver,
 		queue:   queue,
-		manager: newManager(queue, logger, config),
+		manager: m,
 		logger:  logger,
 	}
 }
@@ -121,78 +204,218 @@ func (q *Queue) ShutDown(ctx context.Context) error {
 // region 投递任务相关方法
 
 // Dispatch 投递一个队列Job任务
-func (q *Queue) Dispatch(task TaskIFace, payload interface{}) error {
-	queuePayload, err := q.marshalPayload(task, payload)
-	if nil != err {
-		return fmt.Errorf("queue %s job param marshal failed: %s", task.Name(), err.Error())
-	}
-
-	return q.queue.Push(task.Name(), queuePayload)
+//   - options 可选项，譬如 WithTTL、WithDeadline 设置任务过期时刻
+func (q *Queue) Dispatch(task TaskIFace, payload interface{}, options ...DispatchOption) error {
+	return q.PartitionDispatchCtx(context.Background(), task, "", payload, options...)
 }
 
 // DelayAt 投递一个指定的将来时刻执行的延迟队列Job任务
-func (q *Queue) DelayAt(task TaskIFace, payload interface{}, delay time.Time) error {
-	queuePayload, err := q.marshalPayload(task, payload)
-	if nil != err {
-		return fmt.Errorf("queue %s job param marshal failed: %s", task.Name(), err.Error())
-	}
-
-	return q.queue.LaterAt(task.Name(), delay, queuePayload)
+func (q *Queue) DelayAt(task TaskIFace, payload interface{}, delay time.Time, options ...DispatchOption) error {
+	return q.PartitionDelayAtCtx(context.Background(), task, "", payload, delay, options...)
 }
 
 // Delay 投递一个指定延迟时长的延迟队列Job任务
-func (q *Queue) Delay(task TaskIFace, payload interface{}, duration time.Duration) error {
-	queuePayload, err := q.marshalPayload(task, payload)
+func (q *Queue) Delay(task TaskIFace, payload interface{}, duration time.Duration, options ...DispatchOption) error {
+	return q.PartitionDelayCtx(context.Background(), task, "", payload, duration, options...)
+}
+
+// PartitionDispatch 投递一个队列Job任务至task的指定分区
+//   - partition 分区，譬如租户ID，为空时投递至默认分区，不得包含英文冒号
+//   - looper在task的各分区之间公平调度，单个分区堆积大量任务不会饿死其他分区
+func (q *Queue) PartitionDispatch(task TaskIFace, partition string, payload interface{}, options ...DispatchOption) error {
+	return q.PartitionDispatchCtx(context.Background(), task, partition, payload, options...)
+}
+
+// PartitionDelayAt 投递一个指定的将来时刻执行的延迟队列Job任务至task的指定分区
+func (q *Queue) PartitionDelayAt(task TaskIFace, partition string, payload interface{}, delay time.Time, options ...DispatchOption) error {
+	return q.PartitionDelayAtCtx(context.Background(), task, partition, payload, delay, options...)
+}
+
+// PartitionDelay 投递一个指定延迟时长的延迟队列Job任务至task的指定分区
+func (q *Queue) PartitionDelay(task TaskIFace, partition string, payload interface{}, duration time.Duration, options ...DispatchOption) error {
+	return q.PartitionDelayCtx(context.Background(), task, partition, payload, duration, options...)
+}
+
+// DispatchCtx 投递一个队列Job任务，遵循ctx的超时与取消
+//   - 譬如在HTTP请求中投递时传入请求的ctx，请求超时或客户端断开时投递返回ctx的error
+//   - 底层驱动未实现 ContextQueueIFace 时仅在投递前检查ctx是否已结束
+func (q *Queue) DispatchCtx(ctx context.Context, task TaskIFace, payload interface{}, options ...DispatchOption) error {
+	return q.PartitionDispatchCtx(ctx, task, "", payload, options...)
+}
+
+// DelayAtCtx 投递一个指定的将来时刻执行的延迟队列Job任务，遵循ctx的超时与取消
+func (q *Queue) DelayAtCtx(ctx context.Context, task TaskIFace, payload interface{}, delay time.Time, options ...DispatchOption) error {
+	return q.PartitionDelayAtCtx(ctx, task, "", payload, delay, options...)
+}
+
+// DelayCtx 投递一个指定延迟时长的延迟队列Job任务，遵循ctx的超时与取消
+func (q *Queue) DelayCtx(ctx context.Context, task TaskIFace, payload interface{}, duration time.Duration, options ...DispatchOption) error {
+	return q.PartitionDelayCtx(ctx, task, "", payload, duration, options...)
+}
+
+// PartitionDispatchCtx 投递一个队列Job任务至task的指定分区，遵循ctx的超时与取消
+func (q *Queue) PartitionDispatchCtx(ctx context.Context, task TaskIFace, partition string, payload interface{}, options ...DispatchOption) error {
+	name := partitionQueueName(task.Name(), partition)
+	return q.dispatch(ctx, task, partition, payload, 0, options, func(queuePayload []byte) error {
+		if driver, ok := q.queue.(ContextQueueIFace); ok {
+			return driver.PushCtx(ctx, name, queuePayload)
+		}
+		return q.queue.Push(name, queuePayload)
+	})
+}
+
+// PartitionDelayAtCtx 投递一个指定的将来时刻执行的延迟队列Job任务至task的指定分区，遵循ctx的超时与取消
+func (q *Queue) PartitionDelayAtCtx(ctx context.Context, task TaskIFace, partition string, payload interface{}, delay time.Time, options ...DispatchOption) error {
+	name := partitionQueueName(task.Name(), partition)
+	return q.dispatch(ctx, task, partition, payload, delay.Sub(q.manager.config.Clock.Now()), options, func(queuePayload []byte) error {
+		if driver, ok := q.queue.(ContextQueueIFace); ok {
+			return driver.LaterAtCtx(ctx, name, delay, queuePayload)
+		}
+		return q.queue.LaterAt(name, delay, queuePayload)
+	})
+}
+
+// PartitionDelayCtx 投递一个指定延迟时长的延迟队列Job任务至task的指定分区，遵循ctx的超时与取消
+func (q *Queue) PartitionDelayCtx(ctx context.Context, task TaskIFace, partition string, payload interface{}, duration time.Duration, options ...DispatchOption) error {
+	name := partitionQueueName(task.Name(), partition)
+	return q.dispatch(ctx, task, partition, payload, duration, options, func(queuePayload []byte) error {
+		if driver, ok := q.queue.(ContextQueueIFace); ok {
+			return driver.LaterAtCtx(ctx, name, q.manager.config.Clock.Now().Add(duration), queuePayload)
+		}
+		return q.queue.Later(name, duration, queuePayload)
+	})
+}
+
+// dispatch 生成队列内部存储的payload后投递，并记录投递事件
+//   - 投递事件先于投递记录：同步执行驱动投递即执行，保障事件历史按发生先后顺序排列
+//   - ctx已结束时不投递，任务参数外置至 BlobStore 同样遵循ctx
+func (q *Queue) dispatch(ctx context.Context, task TaskIFace, partition string, payload interface{}, delay time.Duration, options []DispatchOption, push func(queuePayload []byte) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if partition != "" {
+		if _, ok := q.queue.(PartitionQueueIFace); !ok {
+			return ErrPartitionUnsupported
+		}
+		if err := checkPartition(partition); err != nil {
+			return err
+		}
+	}
+
+	var dispatchOption dispatchOptions
+	for _, option := range options {
+		option(&dispatchOption)
+	}
+
+	var id, blobRef string
+	name := partitionQueueName(task.Name(), partition)
+	queuePayload, err := q.marshalPayload(task, payload, func(payload *Payload) error {
+		id = payload.ID
+		payload.Partition = partition
+		payload.ExpireAt = dispatchOption.expireAt(q.manager.config.Clock.Now())
+		err := q.manager.encodePayload(ctx, payload)
+		blobRef = payload.BlobRef
+		return err
+	})
 	if nil != err {
-		return fmt.Errorf("queue %s job param marshal failed: %s", task.Name(), err.Error())
+		q.manager.deleteBlob(name, blobRef)
+		return fmt.Errorf("queue %s job param marshal failed: %w", task.Name(), err)
 	}
 
-	return q.queue.Later(task.Name(), duration, queuePayload)
+	q.manager.recordJobEvent(id, JobEvent{Type: JobDispatched, Queue: name, Delay: int64(delay.Seconds())})
+	if err = push(queuePayload); err != nil {
+		// 同步执行的任务已投递并执行，返回最终执行失败的错误
+		var failed *syncJobFailedError
+		if errors.As(err, &failed) {
+			return failed.err
+		}
+
+		// 投递失败的任务不会被执行，已外置的任务参数随之删除
+		q.manager.deleteBlob(name, blobRef)
+		q.manager.recordJobEvent(id, JobEvent{Type: JobDispatchFailed, Queue: name, Error: err.Error()})
+		return err
+	}
+
+	return nil
 }
 
 // DispatchByName 按任务name投递一个队列Job任务
 //   - 投递一个异步立即执行的任务
 //   - 重要:使用该方法则意味着投递任务之前必须bootstrap任务类，新项目请尽量使用Dispatch方法
-func (q *Queue) DispatchByName(name string, payload interface{}) error {
+func (q *Queue) DispatchByName(name string, payload interface{}, options ...DispatchOption) error {
 	task, exist := q.manager.tasks[name]
 	if !exist {
 		return fmt.Errorf("queue %s do not bootstrap", name)
 	}
 
-	return q.Dispatch(task, payload)
+	return q.Dispatch(task, payload, options...)
 }
 
 // DelayAtByName 按任务name投递一个延迟队列Job任务
 //   - 投递一个异步延迟执行的任务
 //   - 重要提示:使用该方法则意味着投递任务之前必须bootstrap任务类，新项目请尽量使用DelayAt方法
-func (q *Queue) DelayAtByName(name string, payload interface{}, delay time.Time) error {
+func (q *Queue) DelayAtByName(name string, payload interface{}, delay time.Time, options ...DispatchOption) error {
 	task, exist := q.manager.tasks[name]
 	if !exist {
 		return fmt.Errorf("queue %s do not bootstrap", name)
 	}
 
-	return q.DelayAt(task, payload, delay)
+	return q.DelayAt(task, payload, delay, options...)
 }
 
 // DelayByName 按任务name投递一个将来时刻执行的延迟队列Job任务
 //   - 投递一个异步延迟执行的任务
 //   - 重要提示:使用该方法则意味着投递任务之前必须bootstrap任务类，新项目请尽量使用Delay方法
-func (q *Queue) DelayByName(name string, payload interface{}, duration time.Duration) error {
+func (q *Queue) DelayByName(name string, payload interface{}, duration time.Duration, options ...DispatchOption) error {
 	task, exist := q.manager.tasks[name]
 	if !exist {
 		return fmt.Errorf("queue %s do not bootstrap", name)
 	}
 
-	return q.Delay(task, payload, duration)
+	return q.Delay(task, payload, duration, options...)
 }
 
-// Size 获取指定队列当前长度
+// Size 获取指定队列当前长度，含task各分区的长度
+//   - 底层驱动异常时返回0，需区分队列为空与底层驱动异常时使用 SizeCtx
 func (q *Queue) Size(task TaskIFace) int64 {
+	size, _ := q.SizeCtx(context.Background(), task)
+	return size
+}
+
+// SizeCtx 获取指定队列当前长度，含task各分区的长度，遵循ctx的超时与取消
+//   - 底层驱动异常时返回error，task未注册时返回0
+func (q *Queue) SizeCtx(ctx context.Context, task TaskIFace) (int64, error) {
 	if _, exist := q.manager.tasks[task.Name()]; !exist {
 		// 确保队列任务以注册
-		return 0
+		return 0, nil
+	}
+	size, _, err := q.manager.taskSize(ctx, task.Name())
+	return size, err
+}
+
+// JobHistory 按任务ID获取任务事件历史，按发生先后顺序排列
+//   - 需开启 Config.JobHistory，任务ID即 Payload.ID、RawBody.ID
+//   - 底层驱动未实现 JobHistoryIFace 时返回 ErrJobHistoryUnsupported
+func (q *Queue) JobHistory(id string) ([]JobEvent, error) {
+	history, ok := q.queue.(JobHistoryIFace)
+	if !ok {
+		return nil, ErrJobHistoryUnsupported
+	}
+	return history.JobHistory(id)
+}
+
+// DecodePayload 还原任务参数，用于读取自行从存储中取出的经转换或外置的任务参数
+//   - FailedJobHandler 收到的任务结构已在出队时还原，无需再调用该方法
+//   - 外置于 BlobStore 的任务参数使用ctx加载
+func (q *Queue) DecodePayload(ctx context.Context, payload *Payload) (*RawBody, error) {
+	decoded, err := q.manager.decodePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	if decoded, _, err = q.manager.loadBlob(ctx, decoded); err != nil {
+		return nil, err
 	}
-	return q.queue.Size(task.Name())
+	return decoded.RawBody(), nil
 }
 
 // SetAllowTasks 指定可以运行的任务
@@ -217,6 +440,14 @@ func (q *Queue) SetExcludeTasks(taskNames ...string) {
 	}
 }
 
+// RunNext 从指定task队列取出一条已到期的job并在当前goroutine中执行
+//   - 与消费进程的worker使用相同的执行逻辑：超时控制、panic捕获、重试与失败处理
+//   - 用于测试、命令行等不启动消费进程的场景，task需已bootstrap注册
+//   - 队列暂无到期job或task并发槽位已满时返回false
+func (q *Queue) RunNext(task TaskIFace) (exist bool) {
+	return q.manager.runNext(task.Name())
+}
+
 // endregion
 
 // region Worker动态管理相关方法
@@ -7,34 +7,71 @@ package queue
 
 import (
 	"encoding/json"
+	"strings"
 )
 
 // queueBasic 队列基础公用方法
-type queueBasic struct{}
+type queueBasic struct {
+	keyPrefix string // key命名空间前缀，用于隔离共用同一Redis的多个环境、多个服务
+	hashTag   bool   // 是否使用hash tag命名key：task的全部key落在同一hash slot，Redis Cluster下多key的lua脚本与事务方可执行
+}
 
 // region 获取队列相关名称私有方法
 
 // name 获取队列名称
+//   - 设置了命名空间前缀时以前缀开头，譬如：prod:task
+//   - 使用hash tag时以task名称为hash tag，分区子队列与所属task落在同一hash slot，譬如：{task}、{task}:partition:p
 func (r *queueBasic) name(queue string) string {
-	return queue
+	if !r.hashTag {
+		return r.keyPrefix + queue
+	}
+	if name, partition, ok := r.splitPartition(queue); ok {
+		return r.keyPrefix + "{" + name + "}" + partitionSeparator + partition
+	}
+	return r.keyPrefix + "{" + queue + "}"
 }
 
 // reservedName 获取队列执行中zSet名称
 func (r *queueBasic) reservedName(queue string) string {
-	return queue + ":reserved"
+	return r.name(queue) + ":reserved"
 }
 
 // delayedName 获取队列延迟zSet名称
 func (r *queueBasic) delayedName(queue string) string {
-	return queue + ":delayed"
+	return r.name(queue) + ":delayed"
+}
+
+// wakeupChannel 获取阻塞等待的消费者唤醒pub/sub频道名称
+func (r *queueBasic) wakeupChannel() string {
+	return r.keyPrefix + "queue:wakeup"
+}
+
+// partitionsName 获取task分区Set名称
+func (r *queueBasic) partitionsName(queue string) string {
+	return r.name(queue) + ":partitions"
+}
+
+// splitPartition 解析分区子队列名称为task队列名称与分区，非分区子队列ok返回false
+func (r *queueBasic) splitPartition(queue string) (name, partition string, ok bool) {
+	return strings.Cut(queue, partitionSeparator)
+}
+
+// historyName 获取任务事件历史List名称
+func (r *queueBasic) historyName(id string) string {
+	return r.keyPrefix + "queue:history:" + id
+}
+
+// semaphoreName 获取队列分布式信号量zSet名称
+func (r *queueBasic) semaphoreName(queue string) string {
+	return r.name(queue) + ":semaphore"
 }
 
 // marshalPayload 初始化创建生成队列内部存储的payload字符串
 // @task	  队列任务类实例
 // @taskParam 队列job参数
-// @ID	      队列job编号ID（延迟队列）
-func (r *queueBasic) marshalPayload(task TaskIFace, taskParam interface{}) ([]byte, error) {
-	return json.Marshal(Payload{
+// @encode    任务参数编码方法：转换任务参数以及按需外置大任务参数
+func (r *queueBasic) marshalPayload(task TaskIFace, taskParam interface{}, encode func(payload *Payload) error) ([]byte, error) {
+	payload := Payload{
 		Name:          task.Name(),
 		ID:            FakeUniqueID(),
 		MaxTries:      task.MaxTries(),
@@ -44,7 +81,12 @@ func (r *queueBasic) marshalPayload(task TaskIFace, taskParam interface{}) ([]by
 		PopTime:       0,                               // 首次被取出开始执行的时间戳，取出的时候才去设置
 		Timeout:       int64(task.Timeout().Seconds()), // 最大执行秒数
 		TimeoutAt:     0,                               // 超时时刻，被执行时刻才会去设置
-	})
+	}
+	if err := encode(&payload); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(payload)
 }
 
 // unmarshalPayload 解析生成队列内部存储的payload字符串为struct
@@ -7,6 +7,9 @@ package queue
 
 import (
 	"container/list"
+	"context"
+	"encoding/json"
+	"strings"
 	"sync"
 	"time"
 )
@@ -21,13 +24,18 @@ type itemValue struct {
 // implement QueueIFace
 type memoryQueue struct {
 	queueBasic
-	list     map[string]*list.List            // 原生链表模拟queue队列
-	delayed  map[string]map[string]*itemValue // 使用map模拟延迟队列
-	reserved map[string]map[string]*itemValue // 使用map模拟延迟队列
-	lock     sync.Mutex
+	list             map[string]*list.List            // 原生链表模拟queue队列
+	delayed          map[string]map[string]*itemValue // 使用map模拟延迟队列
+	reserved         map[string]map[string]*itemValue // 使用map模拟延迟队列
+	lock             sync.Mutex
+	clock            Clock // 时钟，延迟任务与保留任务到期判断均基于该时钟
+	memoryJobHistory       // 任务事件历史
 }
 
 func (m *memoryQueue) Size(queue string) (size int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	m.lazyInit(queue)
 
 	return int64(m.list[queue].Len() + len(m.delayed[queue]) + len(m.reserved[queue]))
@@ -39,6 +47,9 @@ func (m *memoryQueue) Push(queue string, payload interface{}) (err error) {
 		return err
 	}
 
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	m.lazyInit(queue)
 
 	item := &itemValue{
@@ -51,7 +62,7 @@ func (m *memoryQueue) Push(queue string, payload interface{}) (err error) {
 }
 
 func (m *memoryQueue) Later(queue string, durationTo time.Duration, payload interface{}) (err error) {
-	return m.LaterAt(queue, time.Now().Add(durationTo), payload)
+	return m.LaterAt(queue, m.clock.Now().Add(durationTo), payload)
 }
 
 func (m *memoryQueue) LaterAt(queue string, timeAt time.Time, payload interface{}) (err error) {
@@ -60,6 +71,9 @@ func (m *memoryQueue) LaterAt(queue string, timeAt time.Time, payload interface{
 		return err
 	}
 
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	m.lazyInit(queue)
 
 	item := &itemValue{
@@ -77,7 +91,7 @@ func (m *memoryQueue) Pop(queue string) (job JobIFace, exist bool) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	now := time.Now()
+	now := m.clock.Now()
 	// step1、调度延迟任务
 	if m.delayed[queue] != nil {
 		m.lazyInit(queue) // 延迟队列已初始化，但是保留队列可能未初始化
@@ -150,9 +164,11 @@ func (m *memoryQueue) Pop(queue string) (job JobIFace, exist bool) {
 
 	// 转换值构造job
 	return &JobMemory{
+		lock:        &m.lock,
 		reserved:    m.reserved,
 		delayed:     m.delayed,
 		reservedJob: node.Payload,
+		clock:       m.clock,
 		jobProperty: jobProperty{
 			handler:    m,
 			name:       queue,
@@ -169,6 +185,161 @@ func (m *memoryQueue) Pop(queue string) (job JobIFace, exist bool) {
 	}, true
 }
 
+// SizeCtx 获取队列长度，ctx已结束时返回ctx的error
+func (m *memoryQueue) SizeCtx(ctx context.Context, queue string) (size int64, err error) {
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	return m.Size(queue), nil
+}
+
+// PushCtx 投递一条任务到队列，ctx已结束时不投递
+func (m *memoryQueue) PushCtx(ctx context.Context, queue string, payload interface{}) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return m.Push(queue, payload)
+}
+
+// LaterAtCtx 投递一条指定执行时间的延迟任务，ctx已结束时不投递
+func (m *memoryQueue) LaterAtCtx(ctx context.Context, queue string, timeAt time.Time, payload interface{}) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return m.LaterAt(queue, timeAt, payload)
+}
+
+// PopCtx 取出一条待执行的任务，ctx已结束时不取出
+func (m *memoryQueue) PopCtx(ctx context.Context, queue string) (job JobIFace, exist bool, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	job, exist = m.Pop(queue)
+	return job, exist, nil
+}
+
+// Backlog 统计队列中未被取出执行的任务数
+func (m *memoryQueue) Backlog(queue string) (pending, delayed int64, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.clock.Now().Unix()
+	if items, ok := m.list[queue]; ok {
+		pending = int64(items.Len())
+	}
+	for _, item := range m.delayed[queue] {
+		if item.TimeAt <= now {
+			pending++
+		} else {
+			delayed++
+		}
+	}
+	return pending, delayed, nil
+}
+
+// Drain 取出最多limit条任务交由handler处理：先链表中的任务，再取延迟任务
+//   - 调用handler时不持有锁，handler返回nil后移除仍在原位置的任务，期间已被取出执行或已到期移入链表的任务不再移除
+func (m *memoryQueue) Drain(queue string, limit int64, handler func(jobs []MigrationJob) error) (err error) {
+	jobs, listed, delayed, err := m.peekMigrationJobs(queue, limit)
+	if err != nil || len(jobs) == 0 {
+		return err
+	}
+	if err = handler(jobs); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if items, ok := m.list[queue]; ok {
+		for element := items.Front(); element != nil && len(listed) > 0; {
+			next := element.Next()
+			if item := element.Value.(*itemValue); listed[item] {
+				items.Remove(element)
+				delete(listed, item)
+			}
+			element = next
+		}
+	}
+	for _, item := range delayed {
+		if m.delayed[queue][item.Payload.ID] == item {
+			delete(m.delayed[queue], item.Payload.ID)
+		}
+	}
+	return nil
+}
+
+// peekMigrationJobs 读取最多limit条任务但不移除，同时返回链表中与延迟map中对应的实体用于随后移除
+func (m *memoryQueue) peekMigrationJobs(queue string, limit int64) (jobs []MigrationJob, listed map[*itemValue]bool, delayed []*itemValue, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.clock.Now()
+	listed = make(map[*itemValue]bool)
+	if items, ok := m.list[queue]; ok {
+		for element := items.Front(); element != nil && int64(len(jobs)) < limit; element = element.Next() {
+			item := element.Value.(*itemValue)
+			data, err := json.Marshal(item.Payload)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			listed[item] = true
+			jobs = append(jobs, MigrationJob{Payload: data, AvailableAt: now})
+		}
+	}
+
+	for _, item := range m.delayed[queue] {
+		if int64(len(jobs)) >= limit {
+			break
+		}
+		data, err := json.Marshal(item.Payload)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		delayed = append(delayed, item)
+		jobs = append(jobs, MigrationJob{Payload: data, AvailableAt: time.Unix(item.TimeAt, 0)})
+	}
+
+	return jobs, listed, delayed, nil
+}
+
+// Restore 原样写入任务：已到可执行时刻的写入链表，否则写入延迟map
+func (m *memoryQueue) Restore(queue string, jobs []MigrationJob) (err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.lazyInit(queue)
+
+	now := m.clock.Now()
+	for _, job := range jobs {
+		var payload Payload
+		if err = m.unmarshalPayload(job.Payload, &payload); err != nil {
+			return err
+		}
+		if job.AvailableAt.After(now) {
+			m.delayed[queue][payload.ID] = &itemValue{Payload: payload, TimeAt: job.AvailableAt.Unix()}
+		} else {
+			m.list[queue].PushBack(&itemValue{Payload: payload, TimeAt: 0})
+		}
+	}
+	return nil
+}
+
+// Partitions 获取task当前存在任务的分区
+func (m *memoryQueue) Partitions(queue string) (partitions []string, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	prefix := queue + partitionSeparator
+	for name, items := range m.list {
+		partition, ok := strings.CutPrefix(name, prefix)
+		if ok && items.Len()+len(m.delayed[name])+len(m.reserved[name]) > 0 {
+			partitions = append(partitions, partition)
+		}
+	}
+	return partitions, nil
+}
+
 func (m *memoryQueue) SetConnection(connection interface{}) (err error) {
 	// no code
 	return nil
@@ -179,6 +350,7 @@ func (m *memoryQueue) GetConnection() (connection interface{}, err error) {
 	return nil, nil
 }
 
+// lazyInit 初始化队列的链表与map，调用方需持有m.lock
 func (m *memoryQueue) lazyInit(queue string) {
 	// lazy init map
 	if m.list == nil {
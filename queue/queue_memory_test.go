@@ -0,0 +1,37 @@
+package queue
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryQueueConcurrentAccess(t *testing.T) {
+	q := &memoryQueue{clock: systemClock{}}
+	payload, _ := json.Marshal(Payload{Name: "task", ID: "id", Timeout: 60})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := partitionQueueName("task", string(rune('a'+i)))
+			for j := 0; j < 100; j++ {
+				_ = q.Push(name, payload)
+				_ = q.LaterAt(name, time.Now().Add(time.Minute), payload)
+				_ = q.Size(name)
+				_, _ = q.Partitions("task")
+				if job, ok := q.Pop(name); ok {
+					_ = job.Delete()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	partitions, _ := q.Partitions("task")
+	if len(partitions) != 8 {
+		t.Fatalf("Partitions() = %v, want 8 partitions", partitions)
+	}
+}
@@ -1,9 +1,11 @@
 package queue
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,18 +20,19 @@ import (
 // 	  实时队列：往queue_jobs表插入数据，available_at为当前时间戳
 //    延时队列：往queue_jobs表插入数据，available_at为延迟执行时间戳
 // 三、consumer/worker步骤
-//    step1、查询available_at小于等于当前时间戳且reserved_at为NULL的任务
-//    step2、更新reserved_at字段为超时时间戳，并增加attempts计数
+//    step1、预取缓冲区有任务直接取出，否则在一个事务中查询最多 Config.MySQLPrefetch 条available_at小于等于当前时间戳且reserved_at为NULL的任务
+//    step2、批量更新reserved_at字段为超时时间戳，并增加attempts计数，首条任务返回，其余任务放入预取缓冲区
 //    step3、执行任务，成功删除记录，失败根据重试策略处理
+//    step4、后台定时回收reserved_at已过期的保留任务（消费进程崩溃、执行超时等），使其可被再次取出
 // ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
 
 // mysqlQueue 基于MySQL实现的队列
 // implement QueueIFace
 type mysqlQueue struct {
-	queueBasic             // 队列基础可公用方法
-	connection  *sql.DB    // MySQL数据库连接
-	lock        sync.Mutex // 并发锁
-	tablePrefix string     // 表前缀
+	queueBasic                // 队列基础可公用方法
+	connection  *sql.DB       // MySQL数据库连接
+	tablePrefix string        // 表前缀
+	prefetch    mysqlPrefetch // 批量保留任务的预取缓冲区与超时保留任务回收
 }
 
 // getJobsTableName 获取队列任务表名
@@ -40,6 +43,14 @@ func (m *mysqlQueue) getJobsTableName() string {
 	return "queue_jobs"
 }
 
+// getJobEventsTableName 获取任务事件历史表名
+func (m *mysqlQueue) getJobEventsTableName() string {
+	if m.tablePrefix != "" {
+		return m.tablePrefix + "queue_job_events"
+	}
+	return "queue_job_events"
+}
+
 // getFailedJobsTableName 获取失败任务表名
 func (m *mysqlQueue) getFailedJobsTableName() string {
 	if m.tablePrefix != "" {
@@ -50,24 +61,30 @@ func (m *mysqlQueue) getFailedJobsTableName() string {
 
 // Size 获取队列长度
 func (m *mysqlQueue) Size(queue string) (size int64) {
-	var count int64
-	query := `SELECT COUNT(*) FROM ` + m.getJobsTableName() + ` WHERE queue_name = ? AND (reserved_at IS NULL OR reserved_at <= ?)`
-	now := time.Now().Unix()
+	size, _ = m.SizeCtx(context.Background(), queue)
+	return size
+}
 
-	err := m.connection.QueryRow(query, queue, now).Scan(&count)
-	if err != nil {
-		return 0
+// SizeCtx 获取队列长度，数据库异常时返回error
+func (m *mysqlQueue) SizeCtx(ctx context.Context, queue string) (size int64, err error) {
+	query := `SELECT COUNT(*) FROM ` + m.getJobsTableName() + ` WHERE queue_name = ? AND (reserved_at IS NULL OR reserved_at <= ?)`
+	if err = m.connection.QueryRowContext(ctx, query, queue, time.Now().Unix()).Scan(&size); err != nil {
+		return 0, err
 	}
-
-	return count
+	return size, nil
 }
 
 // Push 投递一条任务到队列
 func (m *mysqlQueue) Push(queue string, payload interface{}) (err error) {
+	return m.PushCtx(context.Background(), queue, payload)
+}
+
+// PushCtx 投递一条任务到队列
+func (m *mysqlQueue) PushCtx(ctx context.Context, queue string, payload interface{}) (err error) {
 	now := time.Now().Unix()
 	query := `INSERT INTO ` + m.getJobsTableName() + ` (queue_name, payload, attempts, available_at, created_at) VALUES (?, ?, 0, ?, ?)`
 
-	_, err = m.connection.Exec(query, queue, string(payload.([]byte)), now, now)
+	_, err = m.connection.ExecContext(ctx, query, queue, string(payload.([]byte)), now, now)
 	return err
 }
 
@@ -78,122 +95,414 @@ func (m *mysqlQueue) Later(queue string, durationTo time.Duration, payload inter
 
 // LaterAt 指定时刻执行的延时任务
 func (m *mysqlQueue) LaterAt(queue string, timeAt time.Time, payload interface{}) (err error) {
+	return m.LaterAtCtx(context.Background(), queue, timeAt, payload)
+}
+
+// LaterAtCtx 指定时刻执行的延时任务
+func (m *mysqlQueue) LaterAtCtx(ctx context.Context, queue string, timeAt time.Time, payload interface{}) (err error) {
 	now := time.Now().Unix()
 	availableAt := timeAt.Unix()
 	query := `INSERT INTO ` + m.getJobsTableName() + ` (queue_name, payload, attempts, available_at, created_at) VALUES (?, ?, 0, ?, ?)`
 
-	_, err = m.connection.Exec(query, queue, payload.([]byte), availableAt, now)
+	_, err = m.connection.ExecContext(ctx, query, queue, payload.([]byte), availableAt, now)
 	return err
 }
 
 // Pop 取出弹出一条待执行的任务
+//   - 优先从预取缓冲区取出，缓冲区为空时批量保留任务，同一队列同一时刻仅一个goroutine批量保留
+//   - 不同队列之间互不阻塞，超时保留任务的回收由后台定时执行，不在 Pop 中进行
 func (m *mysqlQueue) Pop(queue string) (job JobIFace, exist bool) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+	job, exist, _ = m.PopCtx(context.Background(), queue)
+	return job, exist
+}
+
+// PopCtx 取出弹出一条待执行的任务，数据库异常时返回error
+func (m *mysqlQueue) PopCtx(ctx context.Context, queue string) (job JobIFace, exist bool, err error) {
+	m.startSweeper()
 
-	// 1. 延时任务结束延时，标记可被执行
-	// 2. 已达到超时仍然未release释放的reserved保留任务，标记可再次被执行
-	// 3. 读取一条需立即执行的数据并标记为reserved状态
-	// 4. 执行成功：移除reserved状态的数据；执行失败：移除reserved状态的数据并触发Failed方法
+	if item, ok := m.takePrefetched(queue); ok {
+		return m.makeJob(queue, item), true, nil
+	}
+
+	buffer := m.prefetchBuffer(queue)
+	buffer.refill.Lock()
+	defer buffer.refill.Unlock()
+
+	// 等待期间其他goroutine可能已批量保留
+	if item, ok := m.takePrefetched(queue); ok {
+		return m.makeJob(queue, item), true, nil
+	}
+
+	items, err := m.reserve(ctx, queue)
+	if err != nil || len(items) == 0 {
+		return nil, false, err
+	}
+	m.putPrefetched(queue, items[1:])
+
+	return m.makeJob(queue, items[0]), true, nil
+}
+
+// makeJob 使用已保留的任务构造job，执行超时时刻自取出时开始计算
+func (m *mysqlQueue) makeJob(queue string, item *mysqlPrefetched) *JobMySQL {
+	now := time.Now()
+	return &JobMySQL{
+		db:         m.connection,
+		lock:       sync.Mutex{},
+		tableID:    item.id,
+		mysqlQueue: m,
+		jobProperty: jobProperty{
+			handler:    m,
+			name:       queue,
+			job:        item.job,
+			reserved:   "",
+			payload:    &item.payload,
+			isReleased: false,
+			isDeleted:  false,
+			hasFailed:  false,
+			popTime:    time.Unix(item.payload.PopTime, 0),
+			timeout:    time.Duration(item.payload.Timeout) * time.Second,
+			timeoutAt:  now.Add(time.Duration(item.payload.Timeout) * time.Second),
+		},
+	}
+}
+
+// Partitions 获取task当前存在任务的分区
+func (m *mysqlQueue) Partitions(queue string) (partitions []string, err error) {
+	if m.connection == nil {
+		return nil, errors.New("null pointer connection instance")
+	}
+
+	prefix := queue + partitionSeparator
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	query := `SELECT DISTINCT queue_name FROM ` + m.getJobsTableName() + ` WHERE queue_name LIKE ?`
+	rows, err := m.connection.Query(query, escaper.Replace(prefix)+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, strings.TrimPrefix(name, prefix))
+	}
+	return partitions, rows.Err()
+}
+
+// Backlog 统计队列中未被取出执行的任务数
+func (m *mysqlQueue) Backlog(queue string) (pending, delayed int64, err error) {
+	if m.connection == nil {
+		return 0, 0, errors.New("null pointer connection instance")
+	}
+
+	query := `SELECT COALESCE(SUM(available_at <= ?), 0), COALESCE(SUM(available_at > ?), 0) FROM ` + m.getJobsTableName() + ` WHERE queue_name = ? AND reserved_at IS NULL`
+	now := time.Now().Unix()
+	err = m.connection.QueryRow(query, now, now, queue).Scan(&pending, &delayed)
+	return pending, delayed, err
+}
+
+// Drain 事务内锁定最多limit条未被保留的任务交由handler处理，handler返回nil后删除并提交，按可执行时刻先后排序
+//   - 已尝试次数以attempts字段为准写回任务结构
+//   - handler返回error或进程中途退出时事务回滚，任务保留在表中
+func (m *mysqlQueue) Drain(queue string, limit int64, handler func(jobs []MigrationJob) error) (err error) {
+	if m.connection == nil {
+		return errors.New("null pointer connection instance")
+	}
+
+	tx, err := m.connection.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	selectQuery := `SELECT id, payload, attempts, available_at FROM ` + m.getJobsTableName() + ` WHERE queue_name = ? AND reserved_at IS NULL ORDER BY available_at ASC, id ASC LIMIT ? FOR UPDATE`
+	rows, err := tx.Query(selectQuery, queue, limit)
+	if err != nil {
+		return err
+	}
 
 	var (
-		now                = time.Now()
-		nowUnix            = now.Unix()
-		transitionHasError error
+		ids  []interface{}
+		jobs []MigrationJob
 	)
+	for rows.Next() {
+		var (
+			id          int64
+			payloadStr  string
+			attempts    int64
+			availableAt int64
+			payload     Payload
+			data        []byte
+		)
+		if err = rows.Scan(&id, &payloadStr, &attempts, &availableAt); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if err = json.Unmarshal([]byte(payloadStr), &payload); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		payload.Attempts = attempts
 
-	// MySQL事务内执行
-	tx, err := m.connection.Begin()
+		if data, err = json.Marshal(payload); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		jobs = append(jobs, MigrationJob{Payload: data, AvailableAt: time.Unix(availableAt, 0)})
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return tx.Commit()
+	}
+
+	if err = handler(jobs); err != nil {
+		return err
+	}
+
+	deleteQuery := `DELETE FROM ` + m.getJobsTableName() + ` WHERE id IN (?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+	if _, err = tx.Exec(deleteQuery, ids...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Restore 原样写入任务，已尝试次数写入attempts字段
+func (m *mysqlQueue) Restore(queue string, jobs []MigrationJob) (err error) {
+	if m.connection == nil {
+		return errors.New("null pointer connection instance")
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	var (
+		now    = time.Now().Unix()
+		values = make([]string, 0, len(jobs))
+		args   = make([]interface{}, 0, len(jobs)*5)
+	)
+	for _, job := range jobs {
+		var payload Payload
+		if err = json.Unmarshal(job.Payload, &payload); err != nil {
+			return err
+		}
+		values = append(values, `(?, ?, ?, ?, ?)`)
+		args = append(args, queue, string(job.Payload), payload.Attempts, job.AvailableAt.Unix(), now)
+	}
+
+	query := `INSERT INTO ` + m.getJobsTableName() + ` (queue_name, payload, attempts, available_at, created_at) VALUES ` + strings.Join(values, `, `)
+	_, err = m.connection.Exec(query, args...)
+	return err
+}
+
+// FailedJobs 按失败先后顺序分页获取队列的失败任务
+func (m *mysqlQueue) FailedJobs(queue string, offset, limit int64) (jobs []FailedJob, err error) {
+	if m.connection == nil {
+		return nil, errors.New("null pointer connection instance")
+	}
+
+	query := `SELECT queue_name, payload, exception, failed_at FROM ` + m.getFailedJobsTableName() + ` WHERE queue_name = ? ORDER BY id ASC LIMIT ? OFFSET ?`
+	rows, err := m.connection.Query(query, queue, limit, offset)
 	if err != nil {
-		return nil, false
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			job      FailedJob
+			failedAt int64
+		)
+		if err = rows.Scan(&job.Queue, &job.Payload, &job.Exception, &failedAt); err != nil {
+			return nil, err
+		}
+		job.FailedAt = time.Unix(failedAt, 0)
+		jobs = append(jobs, job)
 	}
+	return jobs, rows.Err()
+}
 
-	// defer rollback
+// DrainFailedJobs 事务内锁定最多limit条失败任务交由handler处理，handler返回nil后删除并提交
+func (m *mysqlQueue) DrainFailedJobs(queue string, limit int64, handler func(jobs []FailedJob) error) (err error) {
+	if m.connection == nil {
+		return errors.New("null pointer connection instance")
+	}
+
+	tx, err := m.connection.Begin()
+	if err != nil {
+		return err
+	}
 	defer func() {
-		if transitionHasError != nil {
+		if err != nil {
 			_ = tx.Rollback()
 		}
 	}()
 
-	// step2: 释放超时的reserved任务（相当于Redis的migrated expired reserved jobs）
-	releaseQuery := `UPDATE ` + m.getJobsTableName() + ` SET reserved_at = NULL WHERE queue_name = ? AND reserved_at IS NOT NULL AND reserved_at <= ?`
-	if _, err = tx.Exec(releaseQuery, queue, nowUnix); err != nil {
-		transitionHasError = err
-		return nil, false
+	selectQuery := `SELECT id, queue_name, payload, exception, failed_at FROM ` + m.getFailedJobsTableName() + ` WHERE queue_name = ? ORDER BY id ASC LIMIT ? FOR UPDATE`
+	rows, err := tx.Query(selectQuery, queue, limit)
+	if err != nil {
+		return err
 	}
 
-	// step3: 查询可用任务
 	var (
-		id          int64
-		payloadStr  string
-		attempts    int64
-		selectQuery = `SELECT id, payload, attempts FROM ` + m.getJobsTableName() + ` WHERE queue_name = ? AND available_at <= ? AND reserved_at IS NULL ORDER BY id ASC LIMIT 1 FOR UPDATE`
+		ids  []interface{}
+		jobs []FailedJob
 	)
-	err = tx.QueryRow(selectQuery, queue, nowUnix).Scan(&id, &payloadStr, &attempts)
-	if err != nil {
-		transitionHasError = err
-		return nil, false
+	for rows.Next() {
+		var (
+			id       int64
+			job      FailedJob
+			failedAt int64
+		)
+		if err = rows.Scan(&id, &job.Queue, &job.Payload, &job.Exception, &failedAt); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		job.FailedAt = time.Unix(failedAt, 0)
+		ids = append(ids, id)
+		jobs = append(jobs, job)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return tx.Commit()
 	}
 
-	// step4： 解析payload，构造必要参数
-	var payloadData Payload
-	if err = json.Unmarshal([]byte(payloadStr), &payloadData); err != nil {
-		transitionHasError = err
-		return nil, false
+	if err = handler(jobs); err != nil {
+		return err
+	}
+
+	deleteQuery := `DELETE FROM ` + m.getFailedJobsTableName() + ` WHERE id IN (?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+	if _, err = tx.Exec(deleteQuery, ids...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddFailedJobs 写入失败任务
+func (m *mysqlQueue) AddFailedJobs(jobs []FailedJob) (err error) {
+	if m.connection == nil {
+		return errors.New("null pointer connection instance")
+	}
+	if len(jobs) == 0 {
+		return nil
 	}
 
-	// step5： 更新查询出的任务为reserved状态，并增加attempts
 	var (
-		reservedAt  = now.Add(time.Duration(payloadData.Timeout) * time.Second).Unix()
-		updateQuery = `UPDATE ` + m.getJobsTableName() + ` SET reserved_at = ?, attempts = attempts + 1 WHERE id = ?`
+		values = make([]string, 0, len(jobs))
+		args   = make([]interface{}, 0, len(jobs)*4)
 	)
-	if _, err = tx.Exec(updateQuery, reservedAt, id); err != nil {
-		transitionHasError = err
-		return nil, false
-	}
-
-	// 设置首次被取出时间
-	if payloadData.PopTime <= 0 {
-		payloadData.PopTime = nowUnix // 更新数据库中的payload
-		if updatedPayload, err1 := json.Marshal(payloadData); err1 == nil {
-			updatePayloadQuery := `UPDATE ` + m.getJobsTableName() + ` SET payload = ? WHERE id = ?`
-			if _, err2 := tx.Exec(updatePayloadQuery, string(updatedPayload), id); err2 != nil {
-				transitionHasError = err2
-				return nil, false
-			}
-		} else {
-			transitionHasError = err1
-			return nil, false
+	for _, job := range jobs {
+		values = append(values, `(?, ?, ?, ?)`)
+		args = append(args, job.Queue, string(job.Payload), job.Exception, job.FailedAt.Unix())
+	}
+
+	query := `INSERT INTO ` + m.getFailedJobsTableName() + ` (queue_name, payload, exception, failed_at) VALUES ` + strings.Join(values, `, `)
+	_, err = m.connection.Exec(query, args...)
+	return err
+}
+
+// AppendJobEvent 追加一条任务事件
+//   - 同一任务的全部事件过期时刻顺延至ttl时长后，超出maxEvents条时删除最早的事件，在同一事务中完成
+//   - 已过期的事件由回收goroutine定时清理，见 purgeJobEvents
+func (m *mysqlQueue) AppendJobEvent(id string, event JobEvent, ttl time.Duration, maxEvents int64) (err error) {
+	if m.connection == nil {
+		return errors.New("null pointer connection instance")
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	expiredAt := time.Now().Add(ttl).Unix()
+
+	tx, err := m.connection.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
 		}
+	}()
+
+	insertQuery := `INSERT INTO ` + m.getJobEventsTableName() + ` (job_id, event, expired_at) VALUES (?, ?, ?)`
+	if _, err = tx.Exec(insertQuery, id, data, expiredAt); err != nil {
+		return err
 	}
 
-	if err = tx.Commit(); err != nil {
-		transitionHasError = err
-		return nil, false
+	updateQuery := `UPDATE ` + m.getJobEventsTableName() + ` SET expired_at = ? WHERE job_id = ?`
+	if _, err = tx.Exec(updateQuery, expiredAt, id); err != nil {
+		return err
 	}
 
-	// 增加尝试次数
-	payloadData.Attempts = attempts + 1
+	if maxEvents > 0 {
+		trimQuery := `DELETE FROM ` + m.getJobEventsTableName() + ` WHERE job_id = ? AND id <= (
+			SELECT id FROM (
+				SELECT id FROM ` + m.getJobEventsTableName() + ` WHERE job_id = ? ORDER BY id DESC LIMIT 1 OFFSET ?
+			) AS t
+		)`
+		if _, err = tx.Exec(trimQuery, id, id, maxEvents); err != nil {
+			return err
+		}
+	}
 
-	return &JobMySQL{
-		db:         m.connection,
-		lock:       sync.Mutex{},
-		tableID:    id,
-		mysqlQueue: m,
-		jobProperty: jobProperty{
-			handler:    m,
-			name:       queue,
-			job:        payloadStr,
-			reserved:   "",
-			payload:    &payloadData,
-			isReleased: false,
-			isDeleted:  false,
-			hasFailed:  false,
-			popTime:    time.Unix(payloadData.PopTime, 0),
-			timeout:    time.Duration(payloadData.Timeout) * time.Second,
-			timeoutAt:  now.Add(time.Duration(payloadData.Timeout) * time.Second),
-		},
-	}, true
+	return tx.Commit()
+}
+
+// purgeJobEvents 分批删除已过期的任务事件，每批最多 mysqlEventPurgeBatch 条，避免单条语句长时间持有锁
+func (m *mysqlQueue) purgeJobEvents(now time.Time) {
+	query := `DELETE FROM ` + m.getJobEventsTableName() + ` WHERE expired_at <= ? LIMIT ?`
+	for {
+		result, err := m.connection.Exec(query, now.Unix(), mysqlEventPurgeBatch)
+		if err != nil {
+			return
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected < mysqlEventPurgeBatch {
+			return
+		}
+	}
+}
+
+// JobHistory 按发生先后顺序获取任务事件历史
+func (m *mysqlQueue) JobHistory(id string) (events []JobEvent, err error) {
+	if m.connection == nil {
+		return nil, errors.New("null pointer connection instance")
+	}
+
+	query := `SELECT event FROM ` + m.getJobEventsTableName() + ` WHERE job_id = ? AND expired_at > ? ORDER BY id ASC`
+	rows, err := m.connection.Query(query, id, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			data  []byte
+			event JobEvent
+		)
+		if err = rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
 }
 
 // SetConnection 设置MySQL队列的连接器：sql.DB实例指针
@@ -0,0 +1,317 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// MySQL驱动批量保留任务与超时保留任务回收：
+// 一、原理
+//    每次 Pop 一个事务仅保留1条任务且全进程共用一把锁时，所有task的所有looper均串行访问数据库；
+//    批量保留在一个事务中 SELECT ... FOR UPDATE 最多 Config.MySQLPrefetch 条任务并一次性更新为保留状态，
+//    首条任务直接返回，其余任务暂存于进程内预取缓冲区，后续 Pop 无需访问数据库
+// 二、并发
+//    预取缓冲区按队列划分，同一队列同一时刻仅一个goroutine批量保留，不同队列之间互不阻塞
+// 三、保留时长
+//    暂存于缓冲区的任务最多等待 mysqlPrefetchHold 时长，保留截止时刻为保留时刻 + mysqlPrefetchHold + 任务超时时长，
+//    保障任务自缓冲区取出后仍有完整的超时时长；缓冲区中等待超时的任务、优雅关闭时缓冲区中的任务均释放回数据库
+// 四、回收
+//    后台goroutine每隔 Config.MySQLSweepInterval 将reserved_at已过期的保留任务（消费进程崩溃、执行超时等）
+//    恢复为可取出状态并清理已过期的任务事件，首次 Pop 时启动，优雅关闭时停止
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+const (
+	mysqlPrefetchHold    = 10 * time.Second // 批量保留的任务在预取缓冲区中的最长等待时长
+	mysqlEventPurgeBatch = 1000             // 回收goroutine单条语句最多清理的已过期任务事件数
+	mysqlReservedForever = 4294967295       // 无法解析的任务无法移入失败任务表时的保留截止时刻，回收goroutine不会恢复
+)
+
+// mysqlPrefetch 批量保留任务的预取缓冲区与超时保留任务回收状态
+type mysqlPrefetch struct {
+	size          int                             // 单次事务最多保留的任务数
+	sweepInterval time.Duration                   // 回收超时保留任务的间隔
+	lock          sync.Mutex                      // 并发锁，仅保护缓冲区读写，不在持有期间访问数据库
+	buffers       map[string]*mysqlPrefetchBuffer // 队列名称与预取缓冲区映射map
+	once          sync.Once                       // 保障回收goroutine仅启动一次
+	done          chan struct{}                   // 停止回收goroutine的信号
+	closed        bool                            // 是否已优雅关闭
+}
+
+// mysqlPrefetchBuffer 单个队列的预取缓冲区
+type mysqlPrefetchBuffer struct {
+	refill sync.Mutex         // 保障同一队列同一时刻仅一个goroutine批量保留
+	items  []*mysqlPrefetched // 已保留待取出的任务，按id先后排列
+}
+
+// mysqlPrefetched 已保留待取出的任务
+type mysqlPrefetched struct {
+	id         int64     // 数据库表记录ID
+	job        string    // 数据库中存储的payload
+	payload    Payload   // 解析后的payload，Attempts 已包含本次保留
+	reservedAt int64     // 保留截止时间戳，释放时据此确认任务仍由本进程保留
+	fetchedAt  time.Time // 保留时刻
+}
+
+// prefetchBuffer 获取队列的预取缓冲区，不存在时创建
+func (m *mysqlQueue) prefetchBuffer(queue string) *mysqlPrefetchBuffer {
+	m.prefetch.lock.Lock()
+	defer m.prefetch.lock.Unlock()
+
+	if m.prefetch.buffers == nil {
+		m.prefetch.buffers = make(map[string]*mysqlPrefetchBuffer)
+	}
+	buffer, exist := m.prefetch.buffers[queue]
+	if !exist {
+		buffer = &mysqlPrefetchBuffer{}
+		m.prefetch.buffers[queue] = buffer
+	}
+	return buffer
+}
+
+// takePrefetched 从预取缓冲区取出一条任务，等待超时的任务释放回数据库
+func (m *mysqlQueue) takePrefetched(queue string) (item *mysqlPrefetched, ok bool) {
+	var expired []*mysqlPrefetched
+	defer func() {
+		if len(expired) > 0 {
+			m.releasePrefetched(expired)
+		}
+	}()
+
+	m.prefetch.lock.Lock()
+	defer m.prefetch.lock.Unlock()
+
+	buffer, exist := m.prefetch.buffers[queue]
+	if !exist || m.prefetch.closed {
+		return nil, false
+	}
+
+	now := time.Now()
+	for len(buffer.items) > 0 {
+		item = buffer.items[0]
+		buffer.items[0] = nil
+		buffer.items = buffer.items[1:]
+		if now.Sub(item.fetchedAt) <= mysqlPrefetchHold {
+			return item, true
+		}
+		expired = append(expired, item)
+	}
+	return nil, false
+}
+
+// putPrefetched 批量保留的任务放入预取缓冲区，已优雅关闭时直接释放回数据库
+func (m *mysqlQueue) putPrefetched(queue string, items []*mysqlPrefetched) {
+	if len(items) == 0 {
+		return
+	}
+
+	m.prefetch.lock.Lock()
+	if m.prefetch.closed {
+		m.prefetch.lock.Unlock()
+		m.releasePrefetched(items)
+		return
+	}
+	buffer := m.prefetch.buffers[queue]
+	buffer.items = append(buffer.items, items...)
+	m.prefetch.lock.Unlock()
+}
+
+// prefetchClosed 是否已优雅关闭
+func (m *mysqlQueue) prefetchClosed() bool {
+	m.prefetch.lock.Lock()
+	defer m.prefetch.lock.Unlock()
+	return m.prefetch.closed
+}
+
+// reserve 在一个事务中批量保留最多 Config.MySQLPrefetch 条可执行的任务
+//   - payload无法解析的任务在同一事务中移入失败任务表，避免每次保留均重复读取，见 discardBroken
+func (m *mysqlQueue) reserve(ctx context.Context, queue string) (items []*mysqlPrefetched, err error) {
+	if m.prefetchClosed() {
+		return nil, ErrQueueClosed
+	}
+
+	now := time.Now()
+	tx, err := m.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	selectQuery := `SELECT id, payload, attempts FROM ` + m.getJobsTableName() + ` WHERE queue_name = ? AND available_at <= ? AND reserved_at IS NULL ORDER BY id ASC LIMIT ? FOR UPDATE`
+	rows, err := tx.QueryContext(ctx, selectQuery, queue, now.Unix(), m.prefetch.size)
+	if err != nil {
+		return nil, err
+	}
+	var broken []int64
+	for rows.Next() {
+		var (
+			item     = &mysqlPrefetched{fetchedAt: now}
+			attempts int64
+		)
+		if err = rows.Scan(&item.id, &item.job, &attempts); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		if json.Unmarshal([]byte(item.job), &item.payload) != nil {
+			broken = append(broken, item.id)
+			continue
+		}
+		item.payload.Attempts = attempts + 1
+		item.reservedAt = now.Add(mysqlPrefetchHold + time.Duration(item.payload.Timeout)*time.Second).Unix()
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(broken) > 0 {
+		if err = m.discardBroken(ctx, tx, broken, now); err != nil {
+			return nil, err
+		}
+	}
+	if len(items) == 0 {
+		err = tx.Commit()
+		return nil, err
+	}
+
+	// 一条UPDATE更新全部保留状态，首次被取出的任务同时写入首次取出时间
+	var (
+		reserved = strings.Builder{}
+		payloads = strings.Builder{}
+		ids      = strings.Builder{}
+		args     = make([]interface{}, 0, len(items)*5)
+		popArgs  = make([]interface{}, 0, len(items)*2)
+		idArgs   = make([]interface{}, 0, len(items))
+	)
+	for i, item := range items {
+		reserved.WriteString(" WHEN ? THEN ?")
+		args = append(args, item.id, item.reservedAt)
+
+		if item.payload.PopTime <= 0 {
+			item.payload.PopTime = now.Unix()
+			updated, err := json.Marshal(item.payload)
+			if err != nil {
+				return nil, err
+			}
+			item.job = string(updated)
+			payloads.WriteString(" WHEN ? THEN ?")
+			popArgs = append(popArgs, item.id, item.job)
+		}
+
+		if i > 0 {
+			ids.WriteString(", ")
+		}
+		ids.WriteString("?")
+		idArgs = append(idArgs, item.id)
+	}
+
+	updateQuery := `UPDATE ` + m.getJobsTableName() + ` SET attempts = attempts + 1, reserved_at = CASE id` + reserved.String() + ` END`
+	if len(popArgs) > 0 {
+		updateQuery += `, payload = CASE id` + payloads.String() + ` ELSE payload END`
+		args = append(args, popArgs...)
+	}
+	updateQuery += ` WHERE id IN (` + ids.String() + `)`
+	if _, err = tx.ExecContext(ctx, updateQuery, append(args, idArgs...)...); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// discardBroken 将payload无法解析的任务移入失败任务表
+//   - 未创建失败任务表时保留截止时刻置为 mysqlReservedForever，不再被取出，留待人工处理
+//   - MySQL语句执行失败仅回滚该语句，不影响同一事务中的其他语句
+func (m *mysqlQueue) discardBroken(ctx context.Context, tx *sql.Tx, ids []int64, now time.Time) error {
+	var (
+		placeholders = strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+		idArgs       = make([]interface{}, 0, len(ids))
+	)
+	for _, id := range ids {
+		idArgs = append(idArgs, id)
+	}
+
+	insertQuery := `INSERT INTO ` + m.getFailedJobsTableName() + ` (queue_name, payload, exception, failed_at) SELECT queue_name, payload, ?, ? FROM ` + m.getJobsTableName() + ` WHERE id IN (` + placeholders + `)`
+	if _, err := tx.ExecContext(ctx, insertQuery, append([]interface{}{"queue job payload unmarshal failed", now.Unix()}, idArgs...)...); err == nil {
+		deleteQuery := `DELETE FROM ` + m.getJobsTableName() + ` WHERE id IN (` + placeholders + `)`
+		_, err = tx.ExecContext(ctx, deleteQuery, idArgs...)
+		return err
+	}
+
+	parkQuery := `UPDATE ` + m.getJobsTableName() + ` SET reserved_at = ? WHERE id IN (` + placeholders + `)`
+	_, err := tx.ExecContext(ctx, parkQuery, append([]interface{}{mysqlReservedForever}, idArgs...)...)
+	return err
+}
+
+// releasePrefetched 释放未执行的已保留任务：恢复为可取出状态并回退本次保留增加的尝试次数
+//   - 仅释放保留截止时刻未变的任务，已被回收并由其他消费者保留的任务不受影响
+func (m *mysqlQueue) releasePrefetched(items []*mysqlPrefetched) {
+	query := `UPDATE ` + m.getJobsTableName() + ` SET reserved_at = NULL, attempts = attempts - 1 WHERE id = ? AND reserved_at = ?`
+	for _, item := range items {
+		_, _ = m.connection.Exec(query, item.id, item.reservedAt)
+	}
+}
+
+// startSweeper 启动回收超时保留任务的后台goroutine
+func (m *mysqlQueue) startSweeper() {
+	m.prefetch.once.Do(func() {
+		m.prefetch.lock.Lock()
+		defer m.prefetch.lock.Unlock()
+
+		if m.prefetch.closed {
+			return
+		}
+		m.prefetch.done = make(chan struct{})
+		go m.sweep(m.prefetch.done)
+	})
+}
+
+// sweep 定时将保留截止时刻已过的任务恢复为可取出状态，并清理已过期的任务事件
+func (m *mysqlQueue) sweep(done <-chan struct{}) {
+	ticker := time.NewTicker(m.prefetch.sweepInterval)
+	defer ticker.Stop()
+
+	query := `UPDATE ` + m.getJobsTableName() + ` SET reserved_at = NULL WHERE reserved_at IS NOT NULL AND reserved_at <= ?`
+	for {
+		now := time.Now()
+		_, _ = m.connection.Exec(query, now.Unix())
+		m.purgeJobEvents(now)
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// shutdown 停止回收超时保留任务，预取缓冲区中的任务释放回数据库
+func (m *mysqlQueue) shutdown() {
+	m.prefetch.lock.Lock()
+	if m.prefetch.closed {
+		m.prefetch.lock.Unlock()
+		return
+	}
+	m.prefetch.closed = true
+	if m.prefetch.done != nil {
+		close(m.prefetch.done)
+	}
+
+	var items []*mysqlPrefetched
+	for _, buffer := range m.prefetch.buffers {
+		items = append(items, buffer.items...)
+		buffer.items = nil
+	}
+	m.prefetch.lock.Unlock()
+
+	m.releasePrefetched(items)
+}
@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 批量保留与逐条保留的对比基准测试，需要可用的MySQL：
+//
+//	QUEUE_MYSQL_DSN="root:secret@tcp(127.0.0.1:3306)/test" go test -run ^$ -bench MySQLPop
+//
+// 基准测试使用 bench_ 前缀的表，测试结束时删除。
+
+// benchMySQL 连接基准测试使用的MySQL并创建任务表与失败任务表，未设置 QUEUE_MYSQL_DSN 时跳过
+func benchMySQL(b *testing.B) *sql.DB {
+	dsn := os.Getenv("QUEUE_MYSQL_DSN")
+	if dsn == "" {
+		b.Skip("QUEUE_MYSQL_DSN not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		b.Fatalf("sql.Open() error = %v", err)
+	}
+	stub, err := os.ReadFile("stubs/mysql_queue_tables.sql")
+	if err != nil {
+		b.Fatalf("read stubs error = %v", err)
+	}
+	for _, table := range []string{"queue_jobs", "queue_failed_jobs"} {
+		start := strings.Index(string(stub), "CREATE TABLE `"+table+"`")
+		end := start + strings.Index(string(stub)[start:], ";")
+		create := strings.Replace(string(stub)[start:end], "`"+table+"`", "`bench_"+table+"`", 1)
+		if _, err = db.Exec("DROP TABLE IF EXISTS `bench_" + table + "`"); err != nil {
+			b.Fatalf("drop table error = %v", err)
+		}
+		if _, err = db.Exec(create); err != nil {
+			b.Fatalf("create table error = %v", err)
+		}
+	}
+	b.Cleanup(func() {
+		_, _ = db.Exec("DROP TABLE IF EXISTS `bench_queue_jobs`, `bench_queue_failed_jobs`")
+		_ = db.Close()
+	})
+	return db
+}
+
+// benchmarkMySQLPop 并发取出并删除b.N条任务，prefetch为单次事务保留的任务数
+func benchmarkMySQLPop(b *testing.B, prefetch int) {
+	db := benchMySQL(b)
+	driver, err := NewDriver(MySQL, db, Config{TablePrefix: "bench_", MySQLPrefetch: prefetch})
+	if err != nil {
+		b.Fatalf("NewDriver() error = %v", err)
+	}
+	defer driver.(*mysqlQueue).shutdown()
+
+	for i := 0; i < b.N; i++ {
+		payload, _ := json.Marshal(Payload{Name: "bench", ID: strconv.Itoa(i), MaxTries: 1, Timeout: 60})
+		if err = driver.Push("bench", payload); err != nil {
+			b.Fatalf("Push() error = %v", err)
+		}
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for {
+				job, exist := driver.Pop("bench")
+				if exist {
+					_ = job.Delete()
+					break
+				}
+			}
+		}
+	})
+}
+
+// BenchmarkMySQLPopPerJob 每次 Pop 一个事务仅保留1条任务
+func BenchmarkMySQLPopPerJob(b *testing.B) {
+	benchmarkMySQLPop(b, 1)
+}
+
+// BenchmarkMySQLPopBatched 一个事务批量保留 DefaultMySQLPrefetch 条任务
+func BenchmarkMySQLPopBatched(b *testing.B) {
+	benchmarkMySQLPop(b, DefaultMySQLPrefetch)
+}
+
+// BenchmarkMySQLPopBatched50 一个事务批量保留50条任务
+func BenchmarkMySQLPopBatched50(b *testing.B) {
+	benchmarkMySQLPop(b, 50)
+}
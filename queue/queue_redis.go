@@ -7,6 +7,7 @@ package queue
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"sync"
 	"time"
@@ -32,26 +33,38 @@ import (
 // redisQueue 基于Redis实现的队列
 // implement QueueIFace
 type redisQueue struct {
-	queueBasic               // 队列基础可公用方法
-	connection *redis.Client // connection redis客户端实例
-	luaScripts *luaScripts   // redis lua脚本生成器
+	queueBasic                       // 队列基础可公用方法
+	connection redis.UniversalClient // connection redis客户端实例：单机、哨兵、集群客户端均可
+	luaScripts *luaScripts           // redis lua脚本生成器
+	blocking   redisBlocking         // 阻塞等待任务状态
 }
 
 // Size 获取队列长度
 func (r *redisQueue) Size(queue string) (size int64) {
-	ctx := context.Background()
-	result, _ := r.luaScripts.Size().Run(
+	size, _ = r.SizeCtx(context.Background(), queue)
+	return size
+}
+
+// SizeCtx 获取队列长度，redis异常时返回error
+func (r *redisQueue) SizeCtx(ctx context.Context, queue string) (size int64, err error) {
+	return r.luaScripts.Size().Run(
 		ctx,
 		r.connection,
 		[]string{r.name(queue), r.delayedName(queue), r.reservedName(queue)},
 	).Int64()
-	return result
 }
 
 // Push 投递一条任务到队列
 func (r *redisQueue) Push(queue string, payload interface{}) (err error) {
-	ctx := context.Background()
-	return r.connection.RPush(ctx, queue, payload).Err()
+	return r.PushCtx(context.Background(), queue, payload)
+}
+
+// PushCtx 投递一条任务到队列
+func (r *redisQueue) PushCtx(ctx context.Context, queue string, payload interface{}) (err error) {
+	if err = r.connection.RPush(ctx, r.name(queue), payload).Err(); err != nil {
+		return err
+	}
+	return r.registerPartition(ctx, queue)
 }
 
 // Later 延迟指定时长后执行的延迟任务
@@ -61,16 +74,80 @@ func (r *redisQueue) Later(queue string, durationTo time.Duration, payload inter
 
 // LaterAt 指定时刻执行的延时任务
 func (r *redisQueue) LaterAt(queue string, timeAt time.Time, payload interface{}) (err error) {
-	item := redis.Z{
-		Score:  float64(timeAt.Unix()),
-		Member: payload,
+	return r.LaterAtCtx(context.Background(), queue, timeAt, payload)
+}
+
+// LaterAtCtx 指定时刻执行的延时任务
+//   - 延迟任务成为最早到期的任务时发布唤醒消息，阻塞等待中的消费者据此重新计算等待时长
+func (r *redisQueue) LaterAtCtx(ctx context.Context, queue string, timeAt time.Time, payload interface{}) (err error) {
+	err = r.luaScripts.Later().Run(
+		ctx,
+		r.connection,
+		[]string{r.delayedName(queue)},
+		timeAt.Unix(),
+		payload,
+		r.wakeupChannel(),
+		queue,
+	).Err()
+	if err != nil {
+		return err
+	}
+	return r.registerPartition(ctx, queue)
+}
+
+// registerPartition 分区子队列投递任务后记录分区，新出现的分区唤醒阻塞等待中的looper重新获取分区
+func (r *redisQueue) registerPartition(ctx context.Context, queue string) error {
+	name, partition, ok := r.splitPartition(queue)
+	if !ok {
+		return nil
 	}
+
+	added, err := r.connection.SAdd(ctx, r.partitionsName(name), partition).Result()
+	if err != nil || added == 0 {
+		return err
+	}
+	return r.connection.Publish(ctx, r.wakeupChannel(), name).Err()
+}
+
+// Partitions 获取task当前存在任务的分区，同时移除已无任务的分区
+//   - 分区在Go侧枚举，每个分区的key均通过KEYS传入lua脚本，统计长度与移除空分区在脚本中原子完成
+//   - 统计失败的分区视为存在任务，留待下次刷新
+func (r *redisQueue) Partitions(queue string) (partitions []string, err error) {
 	ctx := context.Background()
-	return r.connection.ZAdd(ctx, r.delayedName(queue), item).Err()
+	members, err := r.connection.SMembers(ctx, r.partitionsName(queue)).Result()
+	if err != nil || len(members) == 0 {
+		return nil, err
+	}
+
+	pipe := r.connection.Pipeline()
+	cmds := make([]*redis.Cmd, len(members))
+	for i, partition := range members {
+		name := partitionQueueName(queue, partition)
+		cmds[i] = r.luaScripts.PrunePartition().Eval(
+			ctx,
+			pipe,
+			[]string{r.partitionsName(queue), r.name(name), r.delayedName(name), r.reservedName(name)},
+			partition,
+		)
+	}
+	_, _ = pipe.Exec(ctx)
+
+	for i, cmd := range cmds {
+		if size, err := cmd.Int64(); err != nil || size > 0 {
+			partitions = append(partitions, members[i])
+		}
+	}
+	return partitions, nil
 }
 
 // Pop 取出弹出一条待执行的任务
 func (r *redisQueue) Pop(queue string) (job JobIFace, exist bool) {
+	job, exist, _ = r.PopCtx(context.Background(), queue)
+	return job, exist
+}
+
+// PopCtx 取出弹出一条待执行的任务，redis异常、任务数据无法解析时返回error
+func (r *redisQueue) PopCtx(ctx context.Context, queue string) (job JobIFace, exist bool, err error) {
 	// step1、调度延迟任务，从延迟有序集合（queueName:delayed）取出Score值小于等于当前时间戳的延迟任务丢到List队列
 	// step2、处理失败重试任务：从保留有序集合（queueName:reserved）取出Score值小于等于当前时间戳的保留任务丢到List队列
 	// step3、调度list尝试执行：从list取出1条，将字段Attempts自增1，Score值为任务执行超时的时间戳，丢到保留有序集合（queueName:reserved）
@@ -78,21 +155,26 @@ func (r *redisQueue) Pop(queue string) (job JobIFace, exist bool) {
 	now := time.Now()
 
 	// step1、migrate expired delay zSet data to queue list
-	ctx := context.Background()
-	r.luaScripts.MigrateExpiredJobs().Run(
+	err = r.luaScripts.MigrateExpiredJobs().Run(
 		ctx,
 		r.connection,
 		[]string{r.delayedName(queue), r.name(queue)},
 		now.Unix(),
-	)
+	).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, false, err
+	}
 
 	// step2、migrate expired reserved zSet data to queue list
-	r.luaScripts.MigrateExpiredJobs().Run(
+	err = r.luaScripts.MigrateExpiredJobs().Run(
 		ctx,
 		r.connection,
 		[]string{r.reservedName(queue), r.name(queue)},
 		now.Unix(),
-	)
+	).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, false, err
+	}
 
 	// step3、get one item from queue list
 	ret3, err := r.luaScripts.Pop().Run(
@@ -104,32 +186,33 @@ func (r *redisQueue) Pop(queue string) (job JobIFace, exist bool) {
 
 	if err != nil {
 		// redis pop lua execute error
-		return nil, false
+		return nil, false, err
 	}
 
 	// set payload
-	jobAndReserved := ret3.([]interface{})
-	if len(jobAndReserved) != 2 {
+	jobAndReserved, ok := ret3.([]interface{})
+	if !ok || len(jobAndReserved) != 2 {
 		// array result returned
-		return nil, false
+		return nil, false, nil
 	}
 	if jobAndReserved[0] == nil || jobAndReserved[1] == nil {
 		// job or reserved job is nil
-		return nil, false
+		return nil, false, nil
 	}
 
 	// transform type format
 	var rJob, reserved Payload
-	if r.unmarshalPayload([]byte(jobAndReserved[0].(string)), &rJob) != nil {
-		return nil, false
+	if err = r.unmarshalPayload([]byte(jobAndReserved[0].(string)), &rJob); err != nil {
+		return nil, false, err
 	}
-	if r.unmarshalPayload([]byte(jobAndReserved[1].(string)), &reserved) != nil {
-		return nil, false
+	if err = r.unmarshalPayload([]byte(jobAndReserved[1].(string)), &reserved); err != nil {
+		return nil, false, err
 	}
 
 	// set job timeoutAt
 	// rJob.TimeoutAt = now.Add(time.Duration(reserved.Timeout) * time.Second).Unix()
 	return &JobRedis{
+		basic:      r.queueBasic,
 		redis:      r.connection,
 		lock:       sync.Mutex{},
 		luaScripts: r.luaScripts,
@@ -146,22 +229,147 @@ func (r *redisQueue) Pop(queue string) (job JobIFace, exist bool) {
 			timeout:    time.Duration(reserved.Timeout) * time.Second,
 			timeoutAt:  now.Add(time.Duration(reserved.Timeout) * time.Second),
 		},
-	}, true
+	}, true, nil
+}
+
+// Backlog 统计队列中未被取出执行的任务数：List中的任务均已到可执行时刻
+func (r *redisQueue) Backlog(queue string) (pending, delayed int64, err error) {
+	ctx := context.Background()
+	if pending, err = r.connection.LLen(ctx, r.name(queue)).Result(); err != nil {
+		return 0, 0, err
+	}
+	if delayed, err = r.connection.ZCard(ctx, r.delayedName(queue)).Result(); err != nil {
+		return 0, 0, err
+	}
+	return pending, delayed, nil
+}
+
+// Drain 读取最多limit条任务交由handler处理：先List中的任务，再按可执行时刻先后取延迟任务
+//   - handler返回nil后按任务内容逐条LREM、ZREM移除，期间已被取出执行的任务不再移除
+func (r *redisQueue) Drain(queue string, limit int64, handler func(jobs []MigrationJob) error) (err error) {
+	ctx := context.Background()
+
+	items, err := r.connection.LRange(ctx, r.name(queue), 0, limit-1).Result()
+	if err != nil {
+		return err
+	}
+	var jobs []MigrationJob
+	for _, item := range items {
+		jobs = append(jobs, MigrationJob{Payload: []byte(item), AvailableAt: time.Now()})
+	}
+
+	var delayed []redis.Z
+	if remain := limit - int64(len(jobs)); remain > 0 {
+		if delayed, err = r.connection.ZRangeWithScores(ctx, r.delayedName(queue), 0, remain-1).Result(); err != nil {
+			return err
+		}
+		for _, item := range delayed {
+			jobs = append(jobs, MigrationJob{
+				Payload:     []byte(item.Member.(string)),
+				AvailableAt: time.Unix(int64(item.Score), 0),
+			})
+		}
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	if err = handler(jobs); err != nil {
+		return err
+	}
+
+	_, err = r.connection.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, item := range items {
+			pipe.LRem(ctx, r.name(queue), 1, item)
+		}
+		for _, item := range delayed {
+			pipe.ZRem(ctx, r.delayedName(queue), item.Member)
+		}
+		return nil
+	})
+	return err
+}
+
+// Restore 原样写入任务：已到可执行时刻的写入List，否则写入延迟有序集合
+func (r *redisQueue) Restore(queue string, jobs []MigrationJob) (err error) {
+	ctx := context.Background()
+	now := time.Now()
+
+	_, err = r.connection.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, job := range jobs {
+			if job.AvailableAt.After(now) {
+				pipe.ZAdd(ctx, r.delayedName(queue), redis.Z{Score: float64(job.AvailableAt.Unix()), Member: job.Payload})
+			} else {
+				pipe.RPush(ctx, r.name(queue), job.Payload)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return r.registerPartition(ctx, queue)
+}
+
+// AppendJobEvent 追加一条任务事件：rpush后裁剪至最多maxEvents条并重置过期时长
+func (r *redisQueue) AppendJobEvent(id string, event JobEvent, ttl time.Duration, maxEvents int64) (err error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	key := r.historyName(id)
+	_, err = r.connection.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, data)
+		if maxEvents > 0 {
+			pipe.LTrim(ctx, key, -maxEvents, -1)
+		}
+		pipe.PExpire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+// JobHistory 按发生先后顺序获取任务事件历史
+func (r *redisQueue) JobHistory(id string) (events []JobEvent, err error) {
+	items, err := r.connection.LRange(context.Background(), r.historyName(id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	events = make([]JobEvent, 0, len(items))
+	for _, item := range items {
+		var event JobEvent
+		if err = json.Unmarshal([]byte(item), &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
 }
 
 // SetConnection
-// 设置redis队列的连接器：redis client句柄指针
+// 设置redis队列的连接器：实现 redis.UniversalClient 的客户端，譬如 *redis.Client、*redis.ClusterClient
+//   - 使用 *redis.ClusterClient 时强制使用hash tag命名key
 func (r *redisQueue) SetConnection(connection interface{}) (err error) {
-	r.connection = connection.(*redis.Client)
+	client, ok := connection.(redis.UniversalClient)
+	if !ok {
+		return errors.New("redis connection must implement redis.UniversalClient")
+	}
+	if _, cluster := client.(*redis.ClusterClient); cluster {
+		r.hashTag = true
+	}
+	r.connection = client
 	return nil
 }
 
 // GetConnection
-// 获取redis队列的连接器：redis client句柄指针（interface）使用前需显式转换
+// 获取redis队列的连接器：redis.UniversalClient（interface）使用前需显式转换
 // example:
 //
 //	conn, _ := r.GetConnection()
-//	client := conn.(*redis.Client)
+//	client := conn.(redis.UniversalClient)
 //	client.Set("key", "values")
 func (r *redisQueue) GetConnection() (connection interface{}, err error) {
 	if r.connection == nil {
@@ -170,3 +378,23 @@ func (r *redisQueue) GetConnection() (connection interface{}, err error) {
 
 	return r.connection, nil
 }
+
+// MigrateRedisKeys 迁移Redis中按旧key命名存储的任务至新的key命名：修改 Config.KeyPrefix 或开启 Config.RedisHashTag 时使用
+//   - from 旧key命名的配置，仅 KeyPrefix、RedisHashTag 生效，譬如未设置前缀时传 queue.Config{}
+//   - to   新key命名的配置，仅 KeyPrefix、RedisHashTag 生效
+//   - options 同 Migrate，Queues 为需迁移的task名称，自动包含task的各分区子队列
+//
+// 基于 Migrate 实现：待执行任务与延迟任务逐批写入新key后再从旧key移除，执行中的任务不迁移，建议停止消费进程后执行；
+// 写入失败的批次保留在旧key，写入后移除前中断的批次再次执行时会重复写入；
+// 旧key的分区Set不删除，确认迁移完成后可自行删除
+func MigrateRedisKeys(ctx context.Context, client redis.UniversalClient, from, to Config, options MigrateOptions) (MigrateResult, error) {
+	source, err := NewDriver(Redis, client, Config{KeyPrefix: from.KeyPrefix, RedisHashTag: from.RedisHashTag})
+	if err != nil {
+		return MigrateResult{}, err
+	}
+	target, err := NewDriver(Redis, client, Config{KeyPrefix: to.KeyPrefix, RedisHashTag: to.RedisHashTag})
+	if err != nil {
+		return MigrateResult{}, err
+	}
+	return Migrate(ctx, source, target, options)
+}
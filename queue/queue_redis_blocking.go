@@ -0,0 +1,247 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// redis驱动阻塞等待任务（事件驱动模式，需redis 6.2+）：
+// 一、原理
+//    step1、lua脚本迁移已到期的延迟任务和保留任务至List队列，同时返回List长度和下一个延迟、保留任务的到期时刻
+//    step2、List不为空直接返回；否则使用 BLMOVE queue queue LEFT LEFT 阻塞等待List出现任务，
+//           源与目标为同一List的同一端，出现任务时不改变List，仅用于唤醒
+//    step3、阻塞时长取最大阻塞时长与下一个任务到期时长的较小值
+// 二、唤醒
+//    Later、Release 投递的延迟任务成为最早到期的任务时发布唤醒消息（消息体为队列名称），
+//    订阅者收到消息后对阻塞中的连接执行 CLIENT UNBLOCK，阻塞等待提前返回并重新计算等待时长
+// 三、连接
+//    每个阻塞等待的队列独占一条连接用于 BLMOVE，另有一条连接用于订阅唤醒频道，请合理设置连接池大小；
+//    Redis Cluster下独占连接取自队列所在hash slot的主节点，CLIENT UNBLOCK 亦发往该节点
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// redisWaiter 单个队列的阻塞等待者
+type redisWaiter struct {
+	node     *redis.Client // 队列所在节点的客户端
+	conn     *redis.Conn   // 阻塞等待独占的连接
+	clientID int64         // 阻塞等待连接的client id，用于 CLIENT UNBLOCK
+	blocking bool          // 是否正处于阻塞等待中
+	woken    bool          // 阻塞等待之前是否已收到唤醒消息
+}
+
+// redisBlocking redis驱动阻塞等待状态
+type redisBlocking struct {
+	lock    sync.Mutex              // 并发锁
+	once    sync.Once               // 保障唤醒频道仅订阅一次
+	pubSub  *redis.PubSub           // 唤醒频道订阅
+	waiters map[string]*redisWaiter // 队列名称与阻塞等待者映射map
+	closed  bool                    // 是否已关闭
+}
+
+// Wait 阻塞等待队列中出现可取出的任务，最长等待timeout时长
+//   - 返回时不保证队列中一定有任务，任务可能已被其他消费者取走
+func (r *redisQueue) Wait(queue string, timeout time.Duration) {
+	ctx := context.Background()
+	r.blocking.once.Do(func() {
+		r.subscribeWakeup(ctx)
+	})
+
+	// step1、迁移已到期的任务并获取List长度、下一个任务到期时刻
+	now := time.Now()
+	result, err := r.luaScripts.Prepare().Run(
+		ctx,
+		r.connection,
+		[]string{r.name(queue), r.delayedName(queue), r.reservedName(queue)},
+		now.Unix(),
+	).Int64Slice()
+	if err != nil || len(result) != 2 {
+		// redis异常时退化为短暂休眠，避免looper空转
+		time.Sleep(jitterBase)
+		return
+	}
+	if result[0] > 0 {
+		return
+	}
+
+	// step2、计算阻塞时长：最大阻塞时长与下一个任务到期时长的较小值，最小1秒
+	if result[1] >= 0 {
+		if due := time.Unix(result[1], 0).Sub(now); due < timeout {
+			timeout = max(due, time.Second)
+		}
+	}
+
+	// step3、阻塞等待
+	waiter := r.acquireWaiter(ctx, queue)
+	if waiter == nil {
+		return
+	}
+	_ = waiter.conn.BLMove(ctx, r.name(queue), r.name(queue), "LEFT", "LEFT", timeout).Err()
+	r.releaseWaiter(queue, waiter)
+}
+
+// acquireWaiter 获取队列的阻塞等待者并标记为阻塞中，已收到唤醒消息或已关闭时返回nil
+//   - 创建阻塞等待者需请求redis，在锁外执行，加锁后再次检查是否已关闭或已被其他goroutine创建
+func (r *redisQueue) acquireWaiter(ctx context.Context, queue string) *redisWaiter {
+	r.blocking.lock.Lock()
+	waiter, exist := r.blocking.waiters[queue]
+	closed := r.blocking.closed
+	r.blocking.lock.Unlock()
+
+	if closed {
+		return nil
+	}
+	if !exist {
+		created, err := r.newWaiter(ctx, queue)
+		if err != nil {
+			time.Sleep(jitterBase)
+			return nil
+		}
+		waiter = created
+	}
+
+	r.blocking.lock.Lock()
+	defer r.blocking.lock.Unlock()
+
+	current, installed := r.blocking.waiters[queue]
+	if r.blocking.closed || (installed && current != waiter) {
+		// 新建的连接未被使用，归还连接池
+		if !exist {
+			_ = waiter.conn.Close()
+		}
+		if r.blocking.closed {
+			return nil
+		}
+		waiter = current
+	} else if !installed {
+		r.blocking.waiters[queue] = waiter
+	}
+
+	if waiter.woken {
+		waiter.woken = false
+		return nil
+	}
+
+	waiter.blocking = true
+	return waiter
+}
+
+// newWaiter 创建队列的阻塞等待者：获取队列所在节点的独占连接及其client id
+func (r *redisQueue) newWaiter(ctx context.Context, queue string) (*redisWaiter, error) {
+	node, err := r.nodeClient(ctx, r.name(queue))
+	if err != nil {
+		return nil, err
+	}
+
+	conn := node.Conn()
+	clientID, err := conn.ClientID(ctx).Result()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &redisWaiter{node: node, conn: conn, clientID: clientID}, nil
+}
+
+// releaseWaiter 阻塞等待结束，已关闭时释放阻塞等待独占的连接
+func (r *redisQueue) releaseWaiter(queue string, waiter *redisWaiter) {
+	r.blocking.lock.Lock()
+	defer r.blocking.lock.Unlock()
+
+	waiter.blocking = false
+	waiter.woken = false
+
+	if r.blocking.closed {
+		_ = waiter.conn.Close()
+		delete(r.blocking.waiters, queue)
+	}
+}
+
+// nodeClient 获取key所在节点的客户端：单机、哨兵客户端即为自身，集群客户端为key所在hash slot的主节点
+func (r *redisQueue) nodeClient(ctx context.Context, key string) (*redis.Client, error) {
+	switch client := r.connection.(type) {
+	case *redis.Client:
+		return client, nil
+	case *redis.ClusterClient:
+		return client.MasterForKey(ctx, key)
+	default:
+		return nil, errors.New("redis client do not support blocking pop")
+	}
+}
+
+// subscribeWakeup 订阅唤醒频道，收到唤醒消息后唤醒对应队列的阻塞等待者
+//   - 订阅请求在锁外执行，期间已关闭则取消订阅
+func (r *redisQueue) subscribeWakeup(ctx context.Context) {
+	pubSub := r.connection.Subscribe(ctx, r.wakeupChannel())
+
+	r.blocking.lock.Lock()
+	closed := r.blocking.closed
+	if !closed {
+		r.blocking.pubSub = pubSub
+	}
+	r.blocking.lock.Unlock()
+
+	if closed {
+		_ = pubSub.Close()
+		return
+	}
+
+	go func(ch <-chan *redis.Message) {
+		for msg := range ch {
+			r.wakeup(msg.Payload)
+		}
+	}(pubSub.Channel())
+}
+
+// wakeup 唤醒指定队列的阻塞等待者，CLIENT UNBLOCK 在锁外执行
+func (r *redisQueue) wakeup(queue string) {
+	r.blocking.lock.Lock()
+	waiter, exist := r.blocking.waiters[queue]
+	if !exist {
+		r.blocking.lock.Unlock()
+		return
+	}
+	blocking := waiter.blocking
+	if !blocking {
+		waiter.woken = true
+	}
+	r.blocking.lock.Unlock()
+
+	if blocking {
+		_ = waiter.node.ClientUnblock(context.Background(), waiter.clientID).Err()
+	}
+}
+
+// shutdown 关闭阻塞等待：唤醒所有阻塞等待者并取消订阅唤醒频道，redis请求均在锁外执行
+func (r *redisQueue) shutdown() {
+	r.blocking.lock.Lock()
+	if r.blocking.closed {
+		r.blocking.lock.Unlock()
+		return
+	}
+	r.blocking.closed = true
+
+	var blocking []*redisWaiter
+	for queue, waiter := range r.blocking.waiters {
+		if waiter.blocking {
+			// 阻塞中的等待者由 releaseWaiter 释放连接
+			blocking = append(blocking, waiter)
+			continue
+		}
+		_ = waiter.conn.Close()
+		delete(r.blocking.waiters, queue)
+	}
+	pubSub := r.blocking.pubSub
+	r.blocking.lock.Unlock()
+
+	ctx := context.Background()
+	for _, waiter := range blocking {
+		_ = waiter.node.ClientUnblock(ctx, waiter.clientID).Err()
+	}
+	if pubSub != nil {
+		_ = pubSub.Close()
+	}
+}
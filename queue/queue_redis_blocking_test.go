@@ -0,0 +1,249 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// unblockHook miniredis未实现 CLIENT ID、CLIENT UNBLOCK：前者固定返回clientID，后者记录被唤醒的client id
+type unblockHook struct {
+	clientID int64
+	unblocks chan int64
+}
+
+func (h unblockHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h unblockHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		args := cmd.Args()
+		if len(args) < 2 || !strings.EqualFold(fmt.Sprint(args[0]), "client") {
+			return next(ctx, cmd)
+		}
+		switch strings.ToLower(fmt.Sprint(args[1])) {
+		case "id":
+			cmd.(*redis.IntCmd).SetVal(h.clientID)
+			return nil
+		case "unblock":
+			h.unblocks <- args[2].(int64)
+			cmd.(*redis.IntCmd).SetVal(1)
+			return nil
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h unblockHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func newBlockingTestQueue(t *testing.T) (*redisQueue, unblockHook) {
+	t.Helper()
+	_, client := newTestRedis(t)
+	hook := unblockHook{clientID: 42, unblocks: make(chan int64, 16)}
+	client.AddHook(hook)
+	driver, err := NewDriver(Redis, client, Config{})
+	if err != nil {
+		t.Fatalf("NewDriver() error = %v", err)
+	}
+	r := driver.(*redisQueue)
+	t.Cleanup(r.shutdown)
+	return r, hook
+}
+
+// waitAsync 异步调用 Wait，返回其结束时关闭的channel
+func waitAsync(r *redisQueue, queue string, timeout time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Wait(queue, timeout)
+	}()
+	return done
+}
+
+// waitBlocking 等待队列的阻塞等待者进入阻塞
+func waitBlocking(t *testing.T, r *redisQueue, queue string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r.blocking.lock.Lock()
+		waiter, exist := r.blocking.waiters[queue]
+		blocking := exist && waiter.blocking
+		r.blocking.lock.Unlock()
+		if blocking {
+			// BLMOVE 请求发出前已标记为阻塞中，稍作等待
+			time.Sleep(50 * time.Millisecond)
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("waiter not blocking in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func testRedisPayload(id string) []byte {
+	payload, _ := json.Marshal(Payload{Name: "task", ID: id, MaxTries: 3, Timeout: 60})
+	return payload
+}
+
+func TestRedisWaitWakesOnPush(t *testing.T) {
+	r, _ := newBlockingTestQueue(t)
+
+	start := time.Now()
+	done := waitAsync(r, "task", 10*time.Second)
+	waitBlocking(t, r, "task")
+	if err := r.Push("task", testRedisPayload("a")); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wait() still blocking after a push")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Wait() returned after %v", elapsed)
+	}
+	// 唤醒不改变List，任务仍可取出
+	if job, exist := r.Pop("task"); !exist || job.Payload().ID != "a" {
+		t.Fatalf("Pop() = %v, %v, want the pushed job", job, exist)
+	}
+}
+
+func TestRedisWaitWakesOnRelease(t *testing.T) {
+	r, hook := newBlockingTestQueue(t)
+	if err := r.Push("task", testRedisPayload("a")); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	job, exist := r.Pop("task")
+	if !exist {
+		t.Fatal("Pop() found no job")
+	}
+
+	// CLIENT UNBLOCK 仅被记录，阻塞等待至最大阻塞时长后返回
+	done := waitAsync(r, "task", time.Second)
+	waitBlocking(t, r, "task")
+
+	// 重试的任务成为最早到期的延迟任务：发布唤醒消息，订阅者对阻塞中的连接执行 CLIENT UNBLOCK
+	if err := job.Release(1); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	select {
+	case id := <-hook.unblocks:
+		if id != hook.clientID {
+			t.Fatalf("CLIENT UNBLOCK %d, want the waiter's client id %d", id, hook.clientID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Release() did not unblock the waiter")
+	}
+	<-done
+
+	// 较晚到期的延迟任务不再唤醒
+	if err := r.Later("task", time.Hour, testRedisPayload("b")); err != nil {
+		t.Fatalf("Later() error = %v", err)
+	}
+	select {
+	case id := <-hook.unblocks:
+		t.Fatalf("CLIENT UNBLOCK %d for a job due after the earliest one", id)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRedisWaitWokenBeforeBlocking(t *testing.T) {
+	r, hook := newBlockingTestQueue(t)
+
+	// 首次 Wait 创建阻塞等待者并订阅唤醒频道
+	r.Wait("task", time.Second)
+
+	// 未阻塞时收到的唤醒消息使下一次 Wait 不再阻塞
+	r.wakeup("task")
+	start := time.Now()
+	r.Wait("task", 10*time.Second)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Wait() blocked %v after a wake-up", elapsed)
+	}
+	if len(hook.unblocks) != 0 {
+		t.Fatal("CLIENT UNBLOCK sent to a waiter that was not blocking")
+	}
+}
+
+func TestRedisWaitTimeout(t *testing.T) {
+	r, _ := newBlockingTestQueue(t)
+
+	// 没有任务时阻塞至最大阻塞时长
+	start := time.Now()
+	r.Wait("task", time.Second)
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 3*time.Second {
+		t.Fatalf("Wait() returned after %v, want the 1s timeout", elapsed)
+	}
+
+	// 延迟任务早于最大阻塞时长到期：阻塞至其到期（最少1秒）
+	// 投递时该队列尚无阻塞等待者，唤醒消息被忽略
+	if err := r.LaterAt("other", time.Now().Add(time.Second), testRedisPayload("a")); err != nil {
+		t.Fatalf("LaterAt() error = %v", err)
+	}
+	start = time.Now()
+	r.Wait("other", time.Minute)
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 3*time.Second {
+		t.Fatalf("Wait() returned after %v, want the delayed job's due time", elapsed)
+	}
+
+	// 已有任务时立即返回
+	if err := r.Push("task", testRedisPayload("b")); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	start = time.Now()
+	r.Wait("task", time.Minute)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("Wait() blocked %v with a job in the queue", elapsed)
+	}
+}
+
+func TestRedisWaitFallsBackWhenRedisFails(t *testing.T) {
+	server, client := newTestRedis(t)
+	driver, _ := NewDriver(Redis, client, Config{})
+	r := driver.(*redisQueue)
+	t.Cleanup(r.shutdown)
+
+	// miniredis 未实现 CLIENT ID：无法创建阻塞等待者时短暂休眠后返回
+	start := time.Now()
+	r.Wait("task", time.Minute)
+	if elapsed := time.Since(start); elapsed < jitterBase || elapsed > 2*time.Second {
+		t.Fatalf("Wait() returned after %v without a waiter, want about %v", elapsed, jitterBase)
+	}
+
+	// redis不可用时同样退化为短暂休眠
+	server.Close()
+	start = time.Now()
+	r.Wait("task", time.Minute)
+	if elapsed := time.Since(start); elapsed < jitterBase || elapsed > 2*time.Second {
+		t.Fatalf("Wait() returned after %v with redis down, want about %v", elapsed, jitterBase)
+	}
+}
+
+func TestRedisShutdownUnblocksWaiters(t *testing.T) {
+	r, hook := newBlockingTestQueue(t)
+
+	done := waitAsync(r, "task", 2*time.Second)
+	waitBlocking(t, r, "task")
+	r.shutdown()
+	if id := <-hook.unblocks; id != hook.clientID {
+		t.Fatalf("CLIENT UNBLOCK %d", id)
+	}
+	<-done
+
+	// 关闭后 Wait 不再阻塞
+	start := time.Now()
+	r.Wait("task", time.Minute)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("Wait() blocked %v after shutdown", elapsed)
+	}
+}
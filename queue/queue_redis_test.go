@@ -0,0 +1,234 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// keySlot 按Redis Cluster规范计算key所在的hash slot：存在非空hash tag时仅对其计算CRC16
+func keySlot(key string) uint16 {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc % 16384
+}
+
+// queueKeys 获取task及其分区p的全部key
+func queueKeys(r *queueBasic, task string) []string {
+	partition := partitionQueueName(task, "p")
+	return []string{
+		r.name(task), r.delayedName(task), r.reservedName(task), r.partitionsName(task),
+		r.name(partition), r.delayedName(partition), r.reservedName(partition),
+	}
+}
+
+func TestKeySlot(t *testing.T) {
+	// CLUSTER KEYSLOT 的已知结果
+	for key, want := range map[string]uint16{"foo": 12182, "123456789": 12739, "{foo}bar": 12182} {
+		if got := keySlot(key); got != want {
+			t.Errorf("keySlot(%q) = %d, want %d", key, got, want)
+		}
+	}
+	if keySlot("{user1000}.following") != keySlot("{user1000}.followers") {
+		t.Error("keys with the same hash tag in different slots")
+	}
+}
+
+func TestRedisHashTagKeysShareSlot(t *testing.T) {
+	for _, prefix := range []string{"", "prod:order:"} {
+		r := &queueBasic{keyPrefix: prefix, hashTag: true}
+		for _, task := range []string{"send_email", "report", "a:b"} {
+			keys := queueKeys(r, task)
+			slot := keySlot(keys[0])
+			for _, key := range keys {
+				if !strings.HasPrefix(key, prefix+"{"+task+"}") {
+					t.Errorf("key %q, want it tagged with {%s}", key, task)
+				}
+				if keySlot(key) != slot {
+					t.Errorf("key %q in slot %d, want %d like %q", key, keySlot(key), slot, keys[0])
+				}
+			}
+		}
+	}
+
+	// 不使用hash tag时key名称与此前保持一致，分区子队列可能落在不同slot
+	r := &queueBasic{}
+	if keys := queueKeys(r, "task"); keys[0] != "task" || keys[1] != "task:delayed" || keys[4] != "task:partition:p" {
+		t.Fatalf("keys without hash tag = %v", keys)
+	}
+}
+
+func TestRedisClusterClientForcesHashTag(t *testing.T) {
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"127.0.0.1:0"}})
+	defer client.Close()
+	driver, err := NewDriver(Redis, client, Config{})
+	if err != nil {
+		t.Fatalf("NewDriver() error = %v", err)
+	}
+	if !driver.(*redisQueue).hashTag {
+		t.Fatal("hash tag not enabled for a cluster client")
+	}
+
+	singleClient := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	defer singleClient.Close()
+	single, _ := NewDriver(Redis, singleClient, Config{})
+	if single.(*redisQueue).hashTag {
+		t.Fatal("hash tag enabled for a single node client by default")
+	}
+}
+
+func TestRedisHashTagQueue(t *testing.T) {
+	server, client := newTestRedis(t)
+	driver, _ := NewDriver(Redis, client, Config{RedisHashTag: true})
+	partition := partitionQueueName("task", "p")
+
+	payload := testRedisPayload("a")
+	if err := driver.Push(partition, payload); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	if err := driver.Later("task", time.Hour, testRedisPayload("b")); err != nil {
+		t.Fatalf("Later() error = %v", err)
+	}
+	job, exist := driver.Pop(partition)
+	if !exist || job.Payload().ID != "a" {
+		t.Fatalf("Pop() = %v, %v", job, exist)
+	}
+
+	// 实际写入的key均带有task的hash tag
+	keys := server.Keys()
+	sort.Strings(keys)
+	want := []string{"{task}:delayed", "{task}:partition:p:reserved", "{task}:partitions"}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+}
+
+func TestRedisKeyPrefixNames(t *testing.T) {
+	r := &queueBasic{keyPrefix: "prod:order:"}
+	for got, want := range map[string]string{
+		r.name("task"):                          "prod:order:task",
+		r.delayedName("task"):                   "prod:order:task:delayed",
+		r.reservedName("task"):                  "prod:order:task:reserved",
+		r.partitionsName("task"):                "prod:order:task:partitions",
+		r.semaphoreName("task"):                 "prod:order:task:semaphore",
+		r.name(partitionQueueName("task", "p")): "prod:order:task:partition:p",
+		r.historyName("id"):                     "prod:order:queue:history:id",
+		r.wakeupChannel():                       "prod:order:queue:wakeup",
+	} {
+		if got != want {
+			t.Errorf("name %q, want %q", got, want)
+		}
+	}
+
+	tagged := &queueBasic{keyPrefix: "prod:order:", hashTag: true}
+	if got := tagged.delayedName(partitionQueueName("task", "p")); got != "prod:order:{task}:partition:p:delayed" {
+		t.Errorf("tagged name %q", got)
+	}
+}
+
+func TestRedisKeyPrefixIsolatesQueues(t *testing.T) {
+	server, client := newTestRedis(t)
+	prod, _ := NewDriver(Redis, client, Config{KeyPrefix: "prod:"})
+	staging, _ := NewDriver(Redis, client, Config{KeyPrefix: "staging:"})
+
+	if err := prod.Push("task", testRedisPayload("a")); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	if err := prod.Later("task", time.Hour, testRedisPayload("b")); err != nil {
+		t.Fatalf("Later() error = %v", err)
+	}
+	if size := staging.Size("task"); size != 0 {
+		t.Fatalf("staging size = %d, want the prod jobs invisible", size)
+	}
+	if _, exist := staging.Pop("task"); exist {
+		t.Fatal("staging popped a prod job")
+	}
+	if size := prod.Size("task"); size != 2 {
+		t.Fatalf("prod size = %d, want 2", size)
+	}
+	for _, key := range server.Keys() {
+		if !strings.HasPrefix(key, "prod:") {
+			t.Fatalf("key %q without the prefix", key)
+		}
+	}
+}
+
+func TestMigrateRedisKeys(t *testing.T) {
+	server, client := newTestRedis(t)
+	from := Config{}
+	to := Config{KeyPrefix: "prod:", RedisHashTag: true}
+	old, _ := NewDriver(Redis, client, from)
+	fillMigrateSource(t, old)
+	oldKeys := server.Keys()
+
+	// DryRun 仅统计
+	result, err := MigrateRedisKeys(context.Background(), client, from, to, MigrateOptions{Queues: []string{"task"}, DryRun: true})
+	if err != nil || result.Pending != 4 || result.Delayed != 2 {
+		t.Fatalf("dry run = %+v, %v", result, err)
+	}
+	if keys := server.Keys(); len(keys) != len(oldKeys) {
+		t.Fatalf("dry run changed the keys: %v", keys)
+	}
+
+	result, err = MigrateRedisKeys(context.Background(), client, from, to, MigrateOptions{Queues: []string{"task"}, BatchSize: 2})
+	if err != nil || result.Pending != 4 || result.Delayed != 2 {
+		t.Fatalf("MigrateRedisKeys() = %+v, %v", result, err)
+	}
+
+	// 旧key仅剩分区Set，新key带前缀与hash tag
+	keys := server.Keys()
+	sort.Strings(keys)
+	want := []string{"prod:{task}", "prod:{task}:delayed", "prod:{task}:partition:p", "prod:{task}:partitions", "task:partitions"}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+
+	// 延迟任务的可执行时刻保持不变
+	oldScore := time.Now().Add(time.Hour).Unix()
+	score, _ := server.ZScore("prod:{task}:delayed", string(mustMemberByID(t, server, "prod:{task}:delayed", "d1")))
+	if int64(score) < oldScore-5 || int64(score) > oldScore+5 {
+		t.Fatalf("delayed job due at %d, want about %d", int64(score), oldScore)
+	}
+
+	migrated, _ := NewDriver(Redis, client, to)
+	if job, exist := migrated.Pop(partitionQueueName("task", "p")); !exist || job.Payload().ID != "p4" {
+		t.Fatalf("Pop() partition = %v, %v", job, exist)
+	}
+}
+
+// mustMemberByID 获取有序集合中指定任务ID的成员
+func mustMemberByID(t *testing.T, server *miniredis.Miniredis, key, id string) []byte {
+	t.Helper()
+	members, err := server.ZMembers(key)
+	if err != nil {
+		t.Fatalf("ZMembers() error = %v", err)
+	}
+	for _, member := range members {
+		var payload Payload
+		if json.Unmarshal([]byte(member), &payload) == nil && payload.ID == id {
+			return []byte(member)
+		}
+	}
+	t.Fatalf("job %s not in %s", id, key)
+	return nil
+}
@@ -0,0 +1,249 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// syncJobFailedError 同步执行的任务已投递并执行，最终执行失败的错误
+type syncJobFailedError struct {
+	err error
+}
+
+func (e *syncJobFailedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *syncJobFailedError) Unwrap() error {
+	return e.err
+}
+
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// 同步执行的队列驱动：
+// 一、原理
+//    投递任务时不存储任务，直接在投递方goroutine中通过队列管理者的runJob执行任务，无需启动looper和worker
+// 二、语义
+//    超时控制、panic捕获、尝试次数、失败处理器等与消费进程完全一致
+//    执行失败可重试的任务在当前goroutine中等待重试间隔后重试，延迟任务等待延迟时长后执行，等待均基于配置的时钟
+//    任务最终执行失败时投递方法返回最后一次执行的错误，尝试次数超限时为 ErrMaxAttemptsExceeded
+// 三、适用场景
+//    本地开发调试、命令行工具等需要投递即执行的场景，task需已bootstrap注册
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// syncQueue 在投递方goroutine中同步执行任务的队列
+// implement QueueIFace
+type syncQueue struct {
+	queueBasic
+	manager          *manager // 队列管理者，用于执行任务
+	clock            Clock    // 时钟
+	memoryJobHistory          // 任务事件历史
+}
+
+// Partitions 同步执行的队列不存储任务，分区恒为空
+func (s *syncQueue) Partitions(queue string) (partitions []string, err error) {
+	return nil, nil
+}
+
+// Size 同步执行的队列不存储任务，长度恒为0
+func (s *syncQueue) Size(queue string) (size int64) {
+	return 0
+}
+
+// Push 立即在当前goroutine中执行任务
+func (s *syncQueue) Push(queue string, payload interface{}) (err error) {
+	var originPayload Payload
+	if err = s.unmarshalPayload(payload.([]byte), &originPayload); err != nil {
+		return err
+	}
+
+	if s.manager == nil {
+		return errors.New("sync queue do not bind manager")
+	}
+
+	job := &JobSync{
+		jobProperty: jobProperty{
+			handler: s,
+			name:    queue,
+			payload: &originPayload,
+		},
+	}
+
+	// 执行失败可重试的任务会被release，等待重试间隔后再次执行直至成功或最终失败
+	for {
+		job.reserve(s.clock.Now())
+		if err = s.manager.runInline(job); err != nil {
+			return err
+		}
+		if job.IsReleased() {
+			s.sleep(time.Duration(job.releaseDelay()) * time.Second)
+			continue
+		}
+		if job.HasFailed() {
+			return &syncJobFailedError{err: job.failedError()}
+		}
+		return nil
+	}
+}
+
+// Later 等待延迟时长后在当前goroutine中执行任务
+func (s *syncQueue) Later(queue string, durationTo time.Duration, payload interface{}) (err error) {
+	return s.LaterAt(queue, s.clock.Now().Add(durationTo), payload)
+}
+
+// LaterAt 等待至执行时刻后在当前goroutine中执行任务
+func (s *syncQueue) LaterAt(queue string, timeAt time.Time, payload interface{}) (err error) {
+	s.sleep(timeAt.Sub(s.clock.Now()))
+	return s.Push(queue, payload)
+}
+
+// sleep 基于时钟等待指定时长
+func (s *syncQueue) sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	if sleeper, ok := s.clock.(Sleeper); ok {
+		sleeper.Sleep(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+// Pop 同步执行的队列不存储任务，没有可取出的任务
+func (s *syncQueue) Pop(queue string) (job JobIFace, exist bool) {
+	return nil, false
+}
+
+func (s *syncQueue) SetConnection(connection interface{}) (err error) {
+	// no code
+	return nil
+}
+
+func (s *syncQueue) GetConnection() (connection interface{}, err error) {
+	// no code
+	return nil, nil
+}
+
+// JobSync 同步执行的job
+type JobSync struct {
+	lock sync.Mutex // 防幻读锁：超时后任务goroutine仍可能在运行
+	jobProperty
+	delay int64 // 本次执行失败后的重试间隔，单位：秒
+	err   error // 最终执行失败的错误
+}
+
+// reserve 开始一次执行：累加尝试次数并重置本次执行的状态
+func (job *JobSync) reserve(now time.Time) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+
+	job.payload.Attempts++
+	if job.payload.PopTime <= 0 {
+		job.payload.PopTime = now.Unix()
+	}
+	job.isReleased = false
+	job.isDeleted = false
+	job.popTime = time.Unix(job.payload.PopTime, 0)
+	job.timeout = time.Duration(job.payload.Timeout) * time.Second
+	job.timeoutAt = now.Add(job.timeout)
+}
+
+// Release 标记任务需重试，投递方goroutine等待重试间隔后重试
+func (job *JobSync) Release(delay int64) (err error) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.isReleased = true
+	job.delay = delay
+	return nil
+}
+
+// releaseDelay 获取本次执行失败后的重试间隔
+func (job *JobSync) releaseDelay() int64 {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	return job.delay
+}
+
+// failedError 获取最终执行失败的错误
+func (job *JobSync) failedError() error {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	if job.err == nil {
+		return ErrMaxAttemptsExceeded
+	}
+	return job.err
+}
+
+// Delete 标记任务不再执行
+func (job *JobSync) Delete() (err error) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.isDeleted = true
+	return nil
+}
+
+func (job *JobSync) IsDeleted() (deleted bool) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	return job.isDeleted
+}
+
+func (job *JobSync) IsReleased() (released bool) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	return job.isReleased
+}
+
+// Attempts 获取当前job已被尝试执行的次数（含本次）
+func (job *JobSync) Attempts() (attempt int64) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	return job.payload.Attempts
+}
+
+// PopTime 任务job首次被执行的时刻
+func (job *JobSync) PopTime() (time time.Time) {
+	return job.popTime
+}
+
+// Timeout 任务超时时长
+func (job *JobSync) Timeout() (time time.Duration) {
+	return job.jobProperty.timeout
+}
+
+// TimeoutAt 任务job执行超时的时刻
+func (job *JobSync) TimeoutAt() (time time.Time) {
+	return job.jobProperty.timeoutAt
+}
+
+func (job *JobSync) HasFailed() (hasFail bool) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	return job.hasFailed
+}
+
+func (job *JobSync) MarkAsFailed() {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.hasFailed = true
+}
+
+func (job *JobSync) Failed(err error) {
+	// 同步执行的队列仅记录失败原因，由投递方法返回
+	// 任务失败外部记录通过初始化队列时调用 SetFailedJobHandler 设置
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.err = err
+}
+
+func (job *JobSync) GetName() (queueName string) {
+	return job.name
+}
+
+func (job *JobSync) Queue() (queue QueueIFace) {
+	return job.handler
+}
+
+func (job *JobSync) Payload() (payload *Payload) {
+	return job.payload
+}
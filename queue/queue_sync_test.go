@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sleepClock 休眠即快进的时钟
+type sleepClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *sleepClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *sleepClock) Sleep(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+var errSyncExecute = errors.New("execute failed")
+
+// syncTask 前failures次执行返回error的task
+type syncTask struct {
+	DefaultTaskSetting
+	tries    int64
+	failures int
+	executed []time.Time
+	clock    Clock
+}
+
+func (task *syncTask) Name() string         { return "sync_task" }
+func (task *syncTask) MaxTries() int64      { return task.tries }
+func (task *syncTask) RetryInterval() int64 { return 30 }
+func (task *syncTask) Remark() string       { return "sync task" }
+func (task *syncTask) Execute(ctx context.Context, job *RawBody) error {
+	task.executed = append(task.executed, task.clock.Now())
+	if len(task.executed) <= task.failures {
+		return errSyncExecute
+	}
+	return nil
+}
+
+func newSyncTestQueue(t *testing.T, task *syncTask) (*Queue, *sleepClock) {
+	clock := &sleepClock{now: time.Unix(1700000000, 0)}
+	task.clock = clock
+	service := New(Sync, nil, nopTestLogger{}, Config{Clock: clock})
+	if err := service.BootstrapOne(task); err != nil {
+		t.Fatalf("BootstrapOne() error = %v", err)
+	}
+	return service, clock
+}
+
+func TestSyncQueueRetryInterval(t *testing.T) {
+	task := &syncTask{tries: 3, failures: 2}
+	service, clock := newSyncTestQueue(t, task)
+	start := clock.Now()
+
+	if err := service.Dispatch(task, "body"); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if len(task.executed) != 3 {
+		t.Fatalf("executed %d times, want 3", len(task.executed))
+	}
+	for i, at := range task.executed {
+		if want := start.Add(time.Duration(i*30) * time.Second); !at.Equal(want) {
+			t.Fatalf("attempt %d executed at %v, want %v", i+1, at, want)
+		}
+	}
+}
+
+func TestSyncQueueFailedError(t *testing.T) {
+	task := &syncTask{tries: 2, failures: 2}
+	service, _ := newSyncTestQueue(t, task)
+
+	if err := service.Dispatch(task, "body"); !errors.Is(err, errSyncExecute) {
+		t.Fatalf("Dispatch() error = %v, want %v", err, errSyncExecute)
+	}
+	if len(task.executed) != 2 {
+		t.Fatalf("executed %d times, want 2", len(task.executed))
+	}
+}
+
+func TestSyncQueueDelay(t *testing.T) {
+	task := &syncTask{tries: 1}
+	service, clock := newSyncTestQueue(t, task)
+	start := clock.Now()
+
+	if err := service.Delay(task, "body", 10*time.Minute); err != nil {
+		t.Fatalf("Delay() error = %v", err)
+	}
+	if want := start.Add(10 * time.Minute); len(task.executed) != 1 || !task.executed[0].Equal(want) {
+		t.Fatalf("executed at %v, want %v", task.executed, want)
+	}
+}
+
+// nopTestLogger 不输出任何日志的日志记录器
+type nopTestLogger struct{}
+
+func (nopTestLogger) Debug(string, ...any) {}
+func (nopTestLogger) Info(string, ...any)  {}
+func (nopTestLogger) Warn(string, ...any)  {}
+func (nopTestLogger) Error(string, ...any) {}
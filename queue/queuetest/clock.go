@@ -0,0 +1,48 @@
+package queuetest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 可控时钟，实现 queue.Clock
+//   - 测试中通过 Advance 快进时间，使延迟任务、重试任务立即到期
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock 创建一个可控时钟
+//   - start 时钟初始时刻，零值时使用当前系统时刻
+func NewClock(start time.Time) *Clock {
+	if start.IsZero() {
+		start = time.Now()
+	}
+	return &Clock{now: start}
+}
+
+// Now 获取时钟当前时刻
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance 时钟快进指定时长
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Sleep 快进时钟指定时长，实现 queue.Sleeper，同步执行的重试任务与延迟任务无需真实等待
+func (c *Clock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// Set 设置时钟为指定时刻
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
@@ -0,0 +1,167 @@
+package queuetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jjonline/go-lib-backend/queue"
+)
+
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// 队列测试工具：
+// 一、Fake
+//    基于 Memory 驱动的队列，记录所有投递的任务，无需真实的Redis、MySQL即可测试投递任务的业务代码
+// 二、断言
+//    AssertDispatched、AssertNotDispatched、AssertDispatchedTimes 断言任务的投递情况
+// 三、执行
+//    RunNext 通过与消费进程完全相同的执行逻辑在当前goroutine中同步执行一条job，
+//    配合可控时钟 Clock 快进时间，延迟任务与重试任务可确定性地立即到期执行
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// Matcher 任务参数匹配方法，为nil时匹配任意任务参数
+type Matcher func(body *queue.RawBody) bool
+
+// Fake 测试用队列
+//   - 内嵌 *queue.Queue，可直接替换业务代码中的队列实例
+type Fake struct {
+	*queue.Queue
+	Clock    *Clock              // 可控时钟
+	recorder *recorder           // 投递任务记录器
+	lock     sync.Mutex          // 并发锁
+	booted   map[string]struct{} // 已注册的task
+}
+
+// New 创建一个测试用队列
+//   - logger 日志记录器，为nil时不输出日志
+//   - config 队列配置，Clock 为空时使用以当前时刻为起点的可控时钟
+func New(logger queue.Logger, config queue.Config) *Fake {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+
+	clock, ok := config.Clock.(*Clock)
+	if !ok {
+		clock = NewClock(time.Time{})
+		config.Clock = clock
+	}
+
+	inner, err := queue.NewDriver(queue.Memory, nil, config)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	rec := &recorder{inner: inner, clock: clock}
+	fake := &Fake{
+		Queue:    queue.NewWithDriver(rec, logger, config),
+		Clock:    clock,
+		recorder: rec,
+		booted:   map[string]struct{}{},
+	}
+	rec.decode = func(payload *queue.Payload) (*queue.RawBody, error) {
+		return fake.DecodePayload(context.Background(), payload)
+	}
+	return fake
+}
+
+// Dispatched 获取指定task已投递的任务记录
+func (f *Fake) Dispatched(task queue.TaskIFace) []Dispatched {
+	return f.recorder.records(task.Name())
+}
+
+// Reset 清空已投递的任务记录，已投递的任务仍可被执行
+func (f *Fake) Reset() {
+	f.recorder.reset()
+}
+
+// Advance 时钟快进指定时长
+func (f *Fake) Advance(d time.Duration) {
+	f.Clock.Advance(d)
+}
+
+// RunNext 同步执行指定task的一条已到期job，task未注册时自动注册，已注册的task不再重复注册
+//   - 队列暂无到期job时返回false
+//   - task注册失败时返回error
+func (f *Fake) RunNext(task queue.TaskIFace) (bool, error) {
+	if err := f.bootstrap(task); err != nil {
+		return false, err
+	}
+	return f.Queue.RunNext(task), nil
+}
+
+// RunAll 同步执行指定task所有已到期的job（包括执行过程中新产生的已到期job），返回执行的job数
+//   - task注册失败时返回error
+func (f *Fake) RunAll(task queue.TaskIFace) (ran int, err error) {
+	for {
+		exist, err := f.RunNext(task)
+		if err != nil || !exist {
+			return ran, err
+		}
+		ran++
+	}
+}
+
+// bootstrap 注册尚未注册的task，每个task仅注册一次
+func (f *Fake) bootstrap(task queue.TaskIFace) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, ok := f.booted[task.Name()]; ok {
+		return nil
+	}
+	if err := f.BootstrapOne(task); err != nil {
+		return fmt.Errorf("queuetest bootstrap task %s failed: %w", task.Name(), err)
+	}
+	f.booted[task.Name()] = struct{}{}
+	return nil
+}
+
+// AssertDispatched 断言指定task至少投递过一条匹配的任务
+func (f *Fake) AssertDispatched(t testing.TB, task queue.TaskIFace, matcher Matcher) bool {
+	t.Helper()
+	if f.countMatched(task, matcher) == 0 {
+		t.Errorf("expected task %s to be dispatched, but it was not", task.Name())
+		return false
+	}
+	return true
+}
+
+// AssertNotDispatched 断言指定task没有投递过匹配的任务
+func (f *Fake) AssertNotDispatched(t testing.TB, task queue.TaskIFace, matcher Matcher) bool {
+	t.Helper()
+	if count := f.countMatched(task, matcher); count > 0 {
+		t.Errorf("expected task %s not to be dispatched, but it was dispatched %d times", task.Name(), count)
+		return false
+	}
+	return true
+}
+
+// AssertDispatchedTimes 断言指定task匹配的任务恰好投递了times次
+func (f *Fake) AssertDispatchedTimes(t testing.TB, task queue.TaskIFace, matcher Matcher, times int) bool {
+	t.Helper()
+	if count := f.countMatched(task, matcher); count != times {
+		t.Errorf("expected task %s to be dispatched %d times, but it was dispatched %d times", task.Name(), times, count)
+		return false
+	}
+	return true
+}
+
+// countMatched 统计指定task匹配的任务投递次数
+func (f *Fake) countMatched(task queue.TaskIFace, matcher Matcher) (count int) {
+	for _, item := range f.recorder.records(task.Name()) {
+		if matcher == nil || matcher(item.RawBody()) {
+			count++
+		}
+	}
+	return count
+}
+
+// nopLogger 不输出任何日志的日志记录器
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
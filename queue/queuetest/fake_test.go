@@ -0,0 +1,185 @@
+package queuetest
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jjonline/go-lib-backend/queue"
+)
+
+// countTask 记录执行次数的task，前failures次执行返回error
+type countTask struct {
+	queue.DefaultTaskSetting
+	name     string
+	tries    int64
+	interval int64
+	failures int64
+	executed int64
+	bodies   []string
+}
+
+func (task *countTask) Name() string         { return task.name }
+func (task *countTask) MaxTries() int64      { return task.tries }
+func (task *countTask) RetryInterval() int64 { return task.interval }
+func (task *countTask) Remark() string       { return "count task" }
+func (task *countTask) Execute(ctx context.Context, job *queue.RawBody) error {
+	task.bodies = append(task.bodies, job.String())
+	if atomic.AddInt64(&task.executed, 1) <= task.failures {
+		return errors.New("execute failed")
+	}
+	return nil
+}
+
+func TestFakeAssertDispatched(t *testing.T) {
+	fake := New(nil, queue.Config{})
+	task := &countTask{name: "assert_task", tries: 1}
+	other := &countTask{name: "other_task", tries: 1}
+
+	if err := fake.Dispatch(task, "first"); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if err := fake.Delay(task, "second", time.Minute); err != nil {
+		t.Fatalf("Delay() error = %v", err)
+	}
+
+	fake.AssertDispatched(t, task, func(body *queue.RawBody) bool { return body.String() == "second" })
+	fake.AssertDispatchedTimes(t, task, nil, 2)
+	fake.AssertNotDispatched(t, other, nil)
+
+	records := fake.Dispatched(task)
+	if len(records) != 2 || records[0].Delayed || !records[1].Delayed {
+		t.Fatalf("Dispatched() = %+v", records)
+	}
+	if want := fake.Clock.Now().Add(time.Minute); !records[1].AvailableAt.Equal(want) {
+		t.Fatalf("Dispatched()[1].AvailableAt = %v, want %v", records[1].AvailableAt, want)
+	}
+
+	fake.Reset()
+	fake.AssertDispatchedTimes(t, task, nil, 0)
+}
+
+func TestFakeAssertFailure(t *testing.T) {
+	fake := New(nil, queue.Config{})
+	task := &countTask{name: "failure_task", tries: 1}
+	if err := fake.Dispatch(task, "body"); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+
+	inner := &testing.T{}
+	if fake.AssertNotDispatched(inner, task, nil) || fake.AssertDispatchedTimes(inner, task, nil, 2) ||
+		fake.AssertDispatched(inner, &countTask{name: "missing_task"}, nil) {
+		t.Fatal("failed assertion returned true")
+	}
+}
+
+func TestFakeRunAllDelayed(t *testing.T) {
+	fake := New(nil, queue.Config{})
+	task := &countTask{name: "delayed_task", tries: 1}
+
+	if err := fake.Dispatch(task, "now"); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if err := fake.Delay(task, "later", 10*time.Minute); err != nil {
+		t.Fatalf("Delay() error = %v", err)
+	}
+
+	if ran, err := fake.RunAll(task); err != nil || ran != 1 {
+		t.Fatalf("RunAll() = %d, %v, want 1", ran, err)
+	}
+	fake.Advance(10 * time.Minute)
+	if ran, err := fake.RunAll(task); err != nil || ran != 1 {
+		t.Fatalf("RunAll() after Advance() = %d, %v, want 1", ran, err)
+	}
+	if len(task.bodies) != 2 || task.bodies[0] != "now" || task.bodies[1] != "later" {
+		t.Fatalf("executed bodies = %v", task.bodies)
+	}
+}
+
+func TestFakeRunNextRetry(t *testing.T) {
+	fake := New(nil, queue.Config{})
+	task := &countTask{name: "retry_task", tries: 3, interval: 30, failures: 2}
+
+	if err := fake.Dispatch(task, "body"); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		exist, err := fake.RunNext(task)
+		if err != nil || !exist {
+			t.Fatalf("RunNext() attempt %d = %v, %v", i, exist, err)
+		}
+		// 重试间隔未到时job不可执行
+		if exist, _ = fake.RunNext(task); exist {
+			t.Fatalf("RunNext() attempt %d ran before retry interval", i)
+		}
+		fake.Advance(30 * time.Second)
+	}
+
+	if exist, err := fake.RunNext(task); err != nil || exist {
+		t.Fatalf("RunNext() after success = %v, %v", exist, err)
+	}
+	if task.executed != 3 {
+		t.Fatalf("executed = %d, want 3", task.executed)
+	}
+}
+
+func TestFakeRunNextBootstrapsOnce(t *testing.T) {
+	fake := New(nil, queue.Config{})
+	first := &countTask{name: "once_task", tries: 1}
+	second := &countTask{name: "once_task", tries: 1}
+
+	if err := fake.Dispatch(first, "a"); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if err := fake.Dispatch(first, "b"); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+
+	if _, err := fake.RunNext(first); err != nil {
+		t.Fatalf("RunNext() error = %v", err)
+	}
+	// 同名task不再重复注册，仍由首次注册的实例执行
+	if _, err := fake.RunNext(second); err != nil {
+		t.Fatalf("RunNext() error = %v", err)
+	}
+	if first.executed != 2 || second.executed != 0 {
+		t.Fatalf("executed = %d, %d, want 2, 0", first.executed, second.executed)
+	}
+}
+
+func TestFakeDecodedPayload(t *testing.T) {
+	codec, err := queue.NewAESGCMTransformer("k1", map[string][]byte{"k1": []byte("0123456789abcdef")})
+	if err != nil {
+		t.Fatalf("NewAESGCMTransformer() error = %v", err)
+	}
+	fake := New(nil, queue.Config{PayloadTransformers: []queue.PayloadTransformer{codec}})
+	task := &countTask{name: "decoded_task", tries: 1}
+
+	if err = fake.Dispatch(task, "secret"); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	fake.AssertDispatched(t, task, func(body *queue.RawBody) bool { return body.String() == "secret" })
+
+	if ran, err := fake.RunAll(task); err != nil || ran != 1 || task.bodies[0] != "secret" {
+		t.Fatalf("RunAll() = %d, %v, bodies %v", ran, err, task.bodies)
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	clock.Advance(time.Hour)
+	if got := clock.Now(); !got.Equal(start.Add(time.Hour)) {
+		t.Fatalf("Now() after Advance() = %v", got)
+	}
+	clock.Set(start)
+	if got := clock.Now(); !got.Equal(start) {
+		t.Fatalf("Now() after Set() = %v", got)
+	}
+	if NewClock(time.Time{}).Now().IsZero() {
+		t.Fatal("NewClock(zero).Now() is zero")
+	}
+}
@@ -0,0 +1,138 @@
+package queuetest
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/jjonline/go-lib-backend/queue"
+)
+
+// Dispatched 一条已投递的任务记录
+type Dispatched struct {
+	Queue       string         // 队列名称，投递至分区时为分区子队列名称
+	Payload     queue.Payload  // 投递时的任务结构
+	AvailableAt time.Time      // 任务可被执行的时刻
+	Delayed     bool           // 是否为延迟任务
+	body        *queue.RawBody // 还原后的任务执行参数
+}
+
+// RawBody 构造任务执行参数，便于断言任务参数
+//   - 配置了 PayloadTransformers 或 BlobStore 时为还原后的任务参数，与任务执行时读取的一致
+func (d Dispatched) RawBody() *queue.RawBody {
+	if d.body != nil {
+		return d.body
+	}
+	return d.Payload.RawBody()
+}
+
+// recorder 记录投递任务的队列驱动，实际存储委托给内层驱动
+// implement queue.QueueIFace
+type recorder struct {
+	inner      queue.QueueIFace                                     // 内层实际存储任务的驱动
+	clock      queue.Clock                                          // 时钟
+	decode     func(payload *queue.Payload) (*queue.RawBody, error) // 还原任务参数的方法
+	lock       sync.Mutex                                           // 并发锁
+	dispatched []Dispatched                                         // 已投递的任务记录
+}
+
+// record 记录一条投递的任务
+func (r *recorder) record(name string, payload interface{}, availableAt time.Time, delayed bool) error {
+	var item Dispatched
+	if err := json.Unmarshal(payload.([]byte), &item.Payload); err != nil {
+		return err
+	}
+	item.Queue = name
+	item.AvailableAt = availableAt
+	item.Delayed = delayed
+	if r.decode != nil {
+		if body, err := r.decode(&item.Payload); err == nil {
+			item.body = body
+		}
+	}
+
+	r.lock.Lock()
+	r.dispatched = append(r.dispatched, item)
+	r.lock.Unlock()
+
+	return nil
+}
+
+// records 获取指定队列已投递的任务记录
+func (r *recorder) records(name string) []Dispatched {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var result []Dispatched
+	for _, item := range r.dispatched {
+		if item.Payload.Name == name {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// reset 清空已投递的任务记录
+func (r *recorder) reset() {
+	r.lock.Lock()
+	r.dispatched = nil
+	r.lock.Unlock()
+}
+
+func (r *recorder) Size(name string) (size int64) {
+	return r.inner.Size(name)
+}
+
+func (r *recorder) Push(name string, payload interface{}) (err error) {
+	if err = r.record(name, payload, r.clock.Now(), false); err != nil {
+		return err
+	}
+	return r.inner.Push(name, payload)
+}
+
+func (r *recorder) Later(name string, durationTo time.Duration, payload interface{}) (err error) {
+	return r.LaterAt(name, r.clock.Now().Add(durationTo), payload)
+}
+
+func (r *recorder) LaterAt(name string, timeAt time.Time, payload interface{}) (err error) {
+	if err = r.record(name, payload, timeAt, true); err != nil {
+		return err
+	}
+	return r.inner.LaterAt(name, timeAt, payload)
+}
+
+func (r *recorder) Pop(name string) (job queue.JobIFace, exist bool) {
+	return r.inner.Pop(name)
+}
+
+func (r *recorder) SetConnection(connection interface{}) (err error) {
+	return r.inner.SetConnection(connection)
+}
+
+func (r *recorder) GetConnection() (connection interface{}, err error) {
+	return r.inner.GetConnection()
+}
+
+func (r *recorder) AppendJobEvent(id string, event queue.JobEvent, ttl time.Duration, maxEvents int64) (err error) {
+	history, ok := r.inner.(queue.JobHistoryIFace)
+	if !ok {
+		return queue.ErrJobHistoryUnsupported
+	}
+	return history.AppendJobEvent(id, event, ttl, maxEvents)
+}
+
+func (r *recorder) JobHistory(id string) (events []queue.JobEvent, err error) {
+	history, ok := r.inner.(queue.JobHistoryIFace)
+	if !ok {
+		return nil, queue.ErrJobHistoryUnsupported
+	}
+	return history.JobHistory(id)
+}
+
+func (r *recorder) Partitions(name string) (partitions []string, err error) {
+	driver, ok := r.inner.(queue.PartitionQueueIFace)
+	if !ok {
+		return nil, queue.ErrPartitionUnsupported
+	}
+	return driver.Partitions(name)
+}
@@ -0,0 +1,138 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// 基于MySQL实现的分布式信号量：
+// 一、原理
+//    queue_semaphores表每行记录一个已被占用的槽位，expired_at字段为槽位过期的毫秒时间戳
+//    queue_semaphore_locks表每个信号量一行，仅用于加锁使同名信号量的获取操作串行
+// 二、获取槽位
+//    事务内先 SELECT ... FOR UPDATE 锁定信号量所在行，再删除已过期的槽位、统计已占用的槽位，未满则插入新槽位
+//    锁定的是唯一索引上确定存在的一行，不会像对槽位范围加锁那样产生间隙锁，并发获取时只会排队等待而不会死锁
+// 三、释放槽位
+//    按token删除对应行即可，进程崩溃未释放的槽位到达过期时刻后自动回收
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// mysqlSemaphore 基于MySQL实现的分布式信号量
+// implement Semaphore
+type mysqlSemaphore struct {
+	connection  *sql.DB  // MySQL数据库连接
+	tablePrefix string   // 表前缀
+	locks       sync.Map // map[string]struct{} 已确保存在锁定行的信号量名称
+}
+
+// NewMySQLSemaphore 创建一个基于MySQL实现的分布式信号量
+//   - connection  MySQL数据库连接
+//   - tablePrefix 数据表前缀，与 Config.TablePrefix 保持一致
+func NewMySQLSemaphore(connection *sql.DB, tablePrefix string) Semaphore {
+	return &mysqlSemaphore{connection: connection, tablePrefix: tablePrefix}
+}
+
+// getSemaphoresTableName 获取信号量表名
+func (m *mysqlSemaphore) getSemaphoresTableName() string {
+	if m.tablePrefix != "" {
+		return m.tablePrefix + "queue_semaphores"
+	}
+	return "queue_semaphores"
+}
+
+// getSemaphoreLocksTableName 获取信号量锁定行表名
+func (m *mysqlSemaphore) getSemaphoreLocksTableName() string {
+	if m.tablePrefix != "" {
+		return m.tablePrefix + "queue_semaphore_locks"
+	}
+	return "queue_semaphore_locks"
+}
+
+// ensureLock 确保信号量的锁定行存在，已存在时不做任何修改
+//   - 在事务之外执行，同一信号量每个进程仅执行一次
+func (m *mysqlSemaphore) ensureLock(name string) error {
+	if _, ok := m.locks.Load(name); ok {
+		return nil
+	}
+
+	query := `INSERT IGNORE INTO ` + m.getSemaphoreLocksTableName() + ` (name) VALUES (?)`
+	if _, err := m.connection.Exec(query, name); err != nil {
+		return err
+	}
+	m.locks.Store(name, struct{}{})
+	return nil
+}
+
+// Acquire 尝试获取一个信号量槽位
+func (m *mysqlSemaphore) Acquire(name string, limit int64, ttl time.Duration) (token string, acquired bool, err error) {
+	if m.connection == nil {
+		return "", false, errors.New("null pointer connection instance")
+	}
+
+	var (
+		now    = time.Now()
+		count  int64
+		locked string
+	)
+
+	if err = m.ensureLock(name); err != nil {
+		return "", false, err
+	}
+
+	tx, err := m.connection.Begin()
+	if err != nil {
+		return "", false, err
+	}
+
+	// step1: 锁定信号量所在行，同名信号量的获取操作在此排队
+	lockQuery := `SELECT name FROM ` + m.getSemaphoreLocksTableName() + ` WHERE name = ? FOR UPDATE`
+	if err = tx.QueryRow(lockQuery, name).Scan(&locked); err != nil {
+		_ = tx.Rollback()
+		return "", false, err
+	}
+
+	// step2: 回收已过期的槽位
+	deleteQuery := `DELETE FROM ` + m.getSemaphoresTableName() + ` WHERE name = ? AND expired_at <= ?`
+	if _, err = tx.Exec(deleteQuery, name, now.UnixMilli()); err != nil {
+		_ = tx.Rollback()
+		return "", false, err
+	}
+
+	// step3: 统计已占用的槽位，已持有信号量锁定行无需再对槽位加锁
+	countQuery := `SELECT COUNT(*) FROM ` + m.getSemaphoresTableName() + ` WHERE name = ?`
+	if err = tx.QueryRow(countQuery, name).Scan(&count); err != nil {
+		_ = tx.Rollback()
+		return "", false, err
+	}
+	if count >= limit {
+		_ = tx.Rollback()
+		return "", false, nil
+	}
+
+	// step4: 占用一个槽位
+	token = FakeUniqueID()
+	insertQuery := `INSERT INTO ` + m.getSemaphoresTableName() + ` (name, token, expired_at) VALUES (?, ?, ?)`
+	if _, err = tx.Exec(insertQuery, name, token, now.Add(ttl).UnixMilli()); err != nil {
+		_ = tx.Rollback()
+		return "", false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", false, err
+	}
+
+	return token, true, nil
+}
+
+// Release 释放一个已获取的信号量槽位
+func (m *mysqlSemaphore) Release(name, token string) (err error) {
+	if m.connection == nil {
+		return errors.New("null pointer connection instance")
+	}
+
+	query := `DELETE FROM ` + m.getSemaphoresTableName() + ` WHERE name = ? AND token = ?`
+	_, err = m.connection.Exec(query, name, token)
+	return err
+}
@@ -0,0 +1,66 @@
+package queue
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectSemaphoreAcquire 期望一次获取槽位的事务，used为已占用的槽位数
+func expectSemaphoreAcquire(mock sqlmock.Sqlmock, used int64) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name FROM t_queue_semaphore_locks WHERE name = ? FOR UPDATE")).
+		WithArgs("task").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("task"))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM t_queue_semaphores WHERE name = ? AND expired_at <= ?")).
+		WithArgs("task", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM t_queue_semaphores WHERE name = ?")).
+		WithArgs("task").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(used))
+}
+
+func TestMySQLSemaphore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+	semaphore := NewMySQLSemaphore(db, "t_")
+
+	// 锁定行每个进程仅确保一次
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO t_queue_semaphore_locks (name) VALUES (?)")).
+		WithArgs("task").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// 未满：占用一个槽位
+	expectSemaphoreAcquire(mock, 1)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO t_queue_semaphores (name, token, expired_at) VALUES (?, ?, ?)")).
+		WithArgs("task", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	// 已满：回滚且不占用槽位
+	expectSemaphoreAcquire(mock, 2)
+	mock.ExpectRollback()
+
+	token, acquired, err := semaphore.Acquire("task", 2, time.Minute)
+	if err != nil || !acquired || token == "" {
+		t.Fatalf("Acquire() = %q, %v, %v, want a token", token, acquired, err)
+	}
+	if _, acquired, err = semaphore.Acquire("task", 2, time.Minute); err != nil || acquired {
+		t.Fatalf("Acquire() over limit = %v, %v, want not acquired", acquired, err)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM t_queue_semaphores WHERE name = ? AND token = ?")).
+		WithArgs("task", token).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err = semaphore.Release("task", token); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// 基于redis实现的分布式信号量：
+// 一、原理
+//    每个信号量使用一个有序集合（queueName:semaphore）存储，成员为槽位token，分值为槽位过期的毫秒时间戳
+// 二、获取槽位
+//    lua脚本内先移除已过期的槽位，剩余槽位数小于上限时写入新槽位
+// 三、释放槽位
+//    从有序集合中移除对应token即可，进程崩溃未释放的槽位到达过期时刻后自动回收
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// redisSemaphore 基于Redis实现的分布式信号量
+// implement Semaphore
+type redisSemaphore struct {
+	queueBasic                       // 队列基础可公用方法
+	connection redis.UniversalClient // connection redis客户端实例
+	luaScripts *luaScripts           // redis lua脚本生成器
+}
+
+// NewRedisSemaphore 创建一个基于Redis实现的分布式信号量
+//   - connection redis客户端实例，单机、哨兵、集群客户端均可
+func NewRedisSemaphore(connection redis.UniversalClient) Semaphore {
+	return newRedisSemaphore(connection, "")
+}
+
+// newRedisSemaphore 创建一个指定key命名空间前缀的基于Redis实现的分布式信号量
+func newRedisSemaphore(connection redis.UniversalClient, keyPrefix string) Semaphore {
+	return &redisSemaphore{queueBasic: queueBasic{keyPrefix: keyPrefix}, connection: connection, luaScripts: &luaScripts{}}
+}
+
+// Acquire 尝试获取一个信号量槽位
+func (r *redisSemaphore) Acquire(name string, limit int64, ttl time.Duration) (token string, acquired bool, err error) {
+	if r.connection == nil {
+		return "", false, errors.New("null pointer connection instance")
+	}
+
+	var (
+		now = time.Now()
+		ctx = context.Background()
+	)
+
+	token = FakeUniqueID()
+	result, err := r.luaScripts.Acquire().Run(
+		ctx,
+		r.connection,
+		[]string{r.semaphoreName(name)},
+		now.UnixMilli(),
+		limit,
+		now.Add(ttl).UnixMilli(),
+		token,
+	).Int64()
+	if err != nil {
+		return "", false, err
+	}
+
+	return token, result == 1, nil
+}
+
+// Release 释放一个已获取的信号量槽位
+func (r *redisSemaphore) Release(name, token string) (err error) {
+	if r.connection == nil {
+		return errors.New("null pointer connection instance")
+	}
+
+	ctx := context.Background()
+	return r.connection.ZRem(ctx, r.semaphoreName(name), token).Err()
+}
@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedis 启动一个进程内的miniredis及其客户端，测试结束时关闭
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, redis.UniversalClient) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return server, client
+}
+
+func TestRedisSemaphoreLimit(t *testing.T) {
+	server, client := newTestRedis(t)
+	semaphore := newRedisSemaphore(client, "prod:")
+
+	var tokens []string
+	for i := 0; i < 2; i++ {
+		token, acquired, err := semaphore.Acquire("task", 2, time.Minute)
+		if err != nil || !acquired {
+			t.Fatalf("Acquire() #%d = %v, %v, want acquired", i+1, acquired, err)
+		}
+		tokens = append(tokens, token)
+	}
+	if _, acquired, err := semaphore.Acquire("task", 2, time.Minute); err != nil || acquired {
+		t.Fatalf("Acquire() over limit = %v, %v, want not acquired", acquired, err)
+	}
+	if !server.Exists("prod:task:semaphore") {
+		t.Fatalf("semaphore key missing, keys = %v", server.Keys())
+	}
+
+	if err := semaphore.Release("task", tokens[0]); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, acquired, err := semaphore.Acquire("task", 2, time.Minute); err != nil || !acquired {
+		t.Fatalf("Acquire() after release = %v, %v, want acquired", acquired, err)
+	}
+}
+
+func TestRedisSemaphoreExpiredSlot(t *testing.T) {
+	_, client := newTestRedis(t)
+	semaphore := NewRedisSemaphore(client)
+
+	// 进程崩溃未释放的槽位到达过期时刻后回收
+	if _, acquired, err := semaphore.Acquire("task", 1, time.Millisecond); err != nil || !acquired {
+		t.Fatalf("Acquire() = %v, %v, want acquired", acquired, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, acquired, err := semaphore.Acquire("task", 1, time.Minute); err != nil || !acquired {
+		t.Fatalf("Acquire() after expiry = %v, %v, want acquired", acquired, err)
+	}
+}
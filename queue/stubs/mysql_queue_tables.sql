@@ -11,10 +11,12 @@ CREATE TABLE `queue_jobs` (
     `available_at` int(10) unsigned NOT NULL COMMENT '可执行时间戳',
     `created_at` int(10) unsigned NOT NULL COMMENT '创建时间戳',
     PRIMARY KEY (`id`),
-    KEY `idx_queue_name` (`queue_name`),
+    KEY `idx_queue_reserved_available` (`queue_name`, `reserved_at`, `available_at`),
     KEY `idx_available_at` (`available_at`),
     KEY `idx_reserved_at` (`reserved_at`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='队列任务表';
+-- 取出任务按 queue_name、reserved_at IS NULL、available_at 过滤，已有的表可执行以下语句替换 idx_queue_name：
+-- ALTER TABLE `queue_jobs` DROP INDEX `idx_queue_name`, ADD INDEX `idx_queue_reserved_available` (`queue_name`, `reserved_at`, `available_at`);
 
 -- 失败任务表（可选，用于存储失败的任务）
 CREATE TABLE `queue_failed_jobs` (
@@ -27,3 +29,43 @@ CREATE TABLE `queue_failed_jobs` (
     KEY `idx_queue_name` (`queue_name`),
     KEY `idx_failed_at` (`failed_at`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='失败任务表';
+
+-- 分布式信号量表（可选，用于跨节点限定task并发数）
+CREATE TABLE `queue_semaphores` (
+    `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+    `name` varchar(191) NOT NULL COMMENT '信号量名称',
+    `token` varchar(64) NOT NULL COMMENT '槽位token',
+    `expired_at` bigint(20) unsigned NOT NULL COMMENT '槽位过期毫秒时间戳',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uk_token` (`token`),
+    KEY `idx_name_expired_at` (`name`, `expired_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='分布式信号量表';
+
+-- 分布式信号量锁定行表（可选，与 queue_semaphores 表配套使用，每个信号量一行，使同名信号量的获取操作串行）
+CREATE TABLE `queue_semaphore_locks` (
+    `name` varchar(191) NOT NULL COMMENT '信号量名称',
+    PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='分布式信号量锁定行表';
+
+-- 任务事件历史表（可选，开启 Config.JobHistory 时使用）
+CREATE TABLE `queue_job_events` (
+    `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+    `job_id` varchar(64) NOT NULL COMMENT '任务ID',
+    `event` text NOT NULL COMMENT '任务事件JSON',
+    `expired_at` int(10) unsigned NOT NULL COMMENT '过期时间戳',
+    PRIMARY KEY (`id`),
+    KEY `idx_job_id` (`job_id`),
+    KEY `idx_expired_at` (`expired_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='任务事件历史表';
+
+-- 工作流运行状态表（可选，使用 workflow.NewMySQLStore 时使用）
+CREATE TABLE `queue_workflow_runs` (
+    `id` varchar(64) NOT NULL COMMENT '工作流运行实例ID',
+    `workflow` varchar(191) NOT NULL COMMENT '工作流名称',
+    `status` varchar(32) NOT NULL COMMENT '运行状态',
+    `state` longtext NOT NULL COMMENT '运行状态JSON',
+    `created_at` int(10) unsigned NOT NULL COMMENT '创建时间戳',
+    `updated_at` int(10) unsigned NOT NULL COMMENT '更新时间戳',
+    PRIMARY KEY (`id`),
+    KEY `idx_workflow_status` (`workflow`, `status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='工作流运行状态表';
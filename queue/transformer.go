@@ -0,0 +1,328 @@
+package queue
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// 任务参数转换器：
+// 一、原理
+//    投递任务时按 Config.PayloadTransformers 的顺序依次转换 Payload.Payload 字段（压缩、加密等），
+//    每个实际生效的转换器将其转换标记追加记录到 Payload.Codec 字段，任务执行前按相反顺序依次解码
+// 二、兼容
+//    Payload.Codec 为空的任务参数视为明文，升级前投递的未转换任务可正常执行
+// 三、转换标记
+//    格式为`转换器名称`或`转换器名称:参数`，多个转换标记使用英文逗号分隔，譬如：gzip,aes-gcm:k2
+// 四、安全
+//    AES-GCM 将任务所属task名称与任务ID作为附加认证数据，一条任务的密文移入另一条任务时解密失败
+//    解压缩后的任务参数不超过 MaxDecodedPayloadSize，避免小体积的压缩炸弹耗尽worker内存
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+const (
+	codecSeparator      = ","       // Payload.Codec 多个转换标记分隔符
+	codecParamSeparator = ":"       // 转换标记中转换器名称与参数分隔符
+	transformerGzip     = "gzip"    // gzip压缩转换器名称
+	transformerZstd     = "zstd"    // zstd压缩转换器名称
+	transformerAESGCM   = "aes-gcm" // AES-GCM加密转换器名称
+)
+
+// MaxDecodedPayloadSize 解压缩后任务参数的最大字节数
+const MaxDecodedPayloadSize = 64 * 1024 * 1024
+
+var (
+	// ErrUnknownPayloadCodec 任务参数转换标记没有对应的转换器
+	ErrUnknownPayloadCodec = errors.New("queue.unknown.payload.codec")
+	// ErrPayloadTooLarge 解压缩后的任务参数超过 MaxDecodedPayloadSize
+	ErrPayloadTooLarge = errors.New("queue.payload.too.large")
+)
+
+// PayloadIdentity 任务参数所属的任务，转换器可据此将转换结果与任务绑定
+type PayloadIdentity struct {
+	Name string // 任务所属task名称，即 Payload.Name
+	ID   string // 任务ID，即 Payload.ID
+}
+
+// PayloadTransformer 任务参数转换器契约
+type PayloadTransformer interface {
+	// Name 转换器名称，需唯一，记录于转换标记中用于解码时查找转换器
+	Name() string
+	// Encode 转换任务参数
+	//   - identity 任务参数所属的任务
+	//   - data     待转换的任务参数
+	//   - 返回转换后的任务参数、记录于转换标记中的参数（可为空）以及是否实际作了转换
+	//   - applied 为false时忽略返回的encoded和param，任务参数保持原样（譬如未达到压缩阈值）
+	Encode(identity PayloadIdentity, data []byte) (encoded []byte, param string, applied bool, err error)
+	// Decode 按编码时记录的参数还原任务参数
+	//   - identity 任务参数所属的任务，与 Encode 时相同
+	//   - param    Encode 返回的参数
+	//   - data     已转换的任务参数
+	Decode(identity PayloadIdentity, param string, data []byte) (decoded []byte, err error)
+}
+
+// payloadCodec 任务参数转换器链
+type payloadCodec struct {
+	transformers []PayloadTransformer // 按投递时应用顺序排列的转换器
+}
+
+// newPayloadCodec 创建任务参数转换器链，未配置转换器时返回nil
+func newPayloadCodec(transformers []PayloadTransformer) *payloadCodec {
+	if len(transformers) == 0 {
+		return nil
+	}
+	return &payloadCodec{transformers: transformers}
+}
+
+// encode 依次转换任务参数并记录转换标记
+func (c *payloadCodec) encode(payload *Payload) error {
+	if c == nil {
+		return nil
+	}
+
+	var (
+		data     = payload.Payload
+		identity = payload.identity()
+		tags     []string
+	)
+	for _, transformer := range c.transformers {
+		encoded, param, applied, err := transformer.Encode(identity, data)
+		if err != nil {
+			return fmt.Errorf("payload transformer %s encode failed: %w", transformer.Name(), err)
+		}
+		if !applied {
+			continue
+		}
+
+		data = encoded
+		if param == "" {
+			tags = append(tags, transformer.Name())
+		} else {
+			tags = append(tags, transformer.Name()+codecParamSeparator+param)
+		}
+	}
+
+	payload.Payload = data
+	payload.Codec = strings.Join(tags, codecSeparator)
+	return nil
+}
+
+// decode 按转换标记逆序还原任务参数，不修改payload本身
+func (c *payloadCodec) decode(payload *Payload) ([]byte, error) {
+	if payload.Codec == "" {
+		return payload.Payload, nil
+	}
+
+	var (
+		data     = payload.Payload
+		identity = payload.identity()
+		tags     = strings.Split(payload.Codec, codecSeparator)
+		err      error
+	)
+	for i := len(tags) - 1; i >= 0; i-- {
+		name, param, _ := strings.Cut(tags[i], codecParamSeparator)
+		transformer := c.lookup(name)
+		if transformer == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownPayloadCodec, name)
+		}
+		if data, err = transformer.Decode(identity, param, data); err != nil {
+			return nil, fmt.Errorf("payload transformer %s decode failed: %w", name, err)
+		}
+	}
+
+	return data, nil
+}
+
+// identity 任务参数所属的任务
+func (payload *Payload) identity() PayloadIdentity {
+	return PayloadIdentity{Name: payload.Name, ID: payload.ID}
+}
+
+// lookup 按名称查找转换器
+func (c *payloadCodec) lookup(name string) PayloadTransformer {
+	if c == nil {
+		return nil
+	}
+	for _, transformer := range c.transformers {
+		if transformer.Name() == name {
+			return transformer
+		}
+	}
+	return nil
+}
+
+// region 压缩转换器
+
+// gzipTransformer gzip压缩转换器
+type gzipTransformer struct {
+	threshold int // 压缩阈值，任务参数字节数大于该值才压缩
+}
+
+// NewGzipTransformer 创建gzip压缩转换器
+//   - threshold 压缩阈值，任务参数字节数大于该值才压缩，小于等于0表示总是压缩
+func NewGzipTransformer(threshold int) PayloadTransformer {
+	return &gzipTransformer{threshold: threshold}
+}
+
+// Name 转换器名称
+func (g *gzipTransformer) Name() string {
+	return transformerGzip
+}
+
+// Encode gzip压缩任务参数
+func (g *gzipTransformer) Encode(_ PayloadIdentity, data []byte) (encoded []byte, param string, applied bool, err error) {
+	if len(data) <= g.threshold {
+		return nil, "", false, nil
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err = writer.Write(data); err != nil {
+		return nil, "", false, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, "", false, err
+	}
+
+	return buf.Bytes(), "", true, nil
+}
+
+// Decode gzip解压任务参数，解压后超过 MaxDecodedPayloadSize 返回 ErrPayloadTooLarge
+func (g *gzipTransformer) Decode(_ PayloadIdentity, _ string, data []byte) (decoded []byte, err error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	if decoded, err = io.ReadAll(io.LimitReader(reader, MaxDecodedPayloadSize+1)); err != nil {
+		return nil, err
+	}
+	if len(decoded) > MaxDecodedPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	return decoded, nil
+}
+
+// zstdTransformer zstd压缩转换器
+type zstdTransformer struct {
+	threshold int           // 压缩阈值，任务参数字节数大于该值才压缩
+	encoder   *zstd.Encoder // 并发安全的EncodeAll编码器
+	decoder   *zstd.Decoder // 并发安全的DecodeAll解码器
+}
+
+// NewZstdTransformer 创建zstd压缩转换器
+//   - threshold 压缩阈值，任务参数字节数大于该值才压缩，小于等于0表示总是压缩
+func NewZstdTransformer(threshold int) PayloadTransformer {
+	// nil writer/reader 仅用于 EncodeAll/DecodeAll，选项固定不会返回error
+	encoder, _ := zstd.NewWriter(nil)
+	decoder, _ := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxDecodedPayloadSize))
+	return &zstdTransformer{threshold: threshold, encoder: encoder, decoder: decoder}
+}
+
+// Name 转换器名称
+func (z *zstdTransformer) Name() string {
+	return transformerZstd
+}
+
+// Encode zstd压缩任务参数
+func (z *zstdTransformer) Encode(_ PayloadIdentity, data []byte) (encoded []byte, param string, applied bool, err error) {
+	if len(data) <= z.threshold {
+		return nil, "", false, nil
+	}
+	return z.encoder.EncodeAll(data, nil), "", true, nil
+}
+
+// Decode zstd解压任务参数，解压后超过 MaxDecodedPayloadSize 返回 ErrPayloadTooLarge
+func (z *zstdTransformer) Decode(_ PayloadIdentity, _ string, data []byte) (decoded []byte, err error) {
+	decoded, err = z.decoder.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, ErrPayloadTooLarge
+	}
+	return decoded, err
+}
+
+// endregion
+
+// region 加密转换器
+
+// aesGCMTransformer AES-GCM加密转换器
+type aesGCMTransformer struct {
+	activeKeyID string                 // 加密使用的密钥ID
+	aeads       map[string]cipher.AEAD // 密钥ID与AEAD实例映射map
+}
+
+// NewAESGCMTransformer 创建AES-GCM加密转换器，支持密钥轮换
+//   - activeKeyID 加密新任务参数使用的密钥ID，记录于转换标记中
+//   - keys        密钥ID与密钥映射map，密钥长度须为16、24或32字节
+//   - 轮换密钥时新增密钥并切换 activeKeyID，旧密钥需保留至使用旧密钥加密的任务全部消费完毕
+func NewAESGCMTransformer(activeKeyID string, keys map[string][]byte) (PayloadTransformer, error) {
+	if _, exist := keys[activeKeyID]; !exist {
+		return nil, fmt.Errorf("aes-gcm active key %s do not exist", activeKeyID)
+	}
+
+	aeads := make(map[string]cipher.AEAD, len(keys))
+	for keyID, key := range keys {
+		if strings.Contains(keyID, codecSeparator) || strings.Contains(keyID, codecParamSeparator) {
+			return nil, fmt.Errorf("aes-gcm key id %s can not contain %s or %s", keyID, codecSeparator, codecParamSeparator)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("aes-gcm key %s invalid: %w", keyID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("aes-gcm key %s invalid: %w", keyID, err)
+		}
+		aeads[keyID] = aead
+	}
+
+	return &aesGCMTransformer{activeKeyID: activeKeyID, aeads: aeads}, nil
+}
+
+// Name 转换器名称
+func (a *aesGCMTransformer) Name() string {
+	return transformerAESGCM
+}
+
+// Encode 使用当前密钥加密任务参数，密文格式：nonce + ciphertext
+//   - 任务所属task名称与任务ID作为附加认证数据
+func (a *aesGCMTransformer) Encode(identity PayloadIdentity, data []byte) (encoded []byte, param string, applied bool, err error) {
+	aead := a.aeads[a.activeKeyID]
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, "", false, err
+	}
+
+	return aead.Seal(nonce, nonce, data, additionalData(identity)), a.activeKeyID, true, nil
+}
+
+// Decode 使用转换标记中记录的密钥ID对应的密钥解密任务参数
+func (a *aesGCMTransformer) Decode(identity PayloadIdentity, keyID string, data []byte) (decoded []byte, err error) {
+	aead, exist := a.aeads[keyID]
+	if !exist {
+		return nil, fmt.Errorf("aes-gcm key %s do not exist", keyID)
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("aes-gcm ciphertext too short")
+	}
+
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additionalData(identity))
+}
+
+// additionalData AES-GCM附加认证数据：task名称 + 0x00 + 任务ID
+func additionalData(identity PayloadIdentity) []byte {
+	return []byte(identity.Name + "\x00" + identity.ID)
+}
+
+// endregion
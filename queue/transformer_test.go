@@ -0,0 +1,139 @@
+package queue
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func testAESKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func newTestAES(t *testing.T, active string, keys map[string][]byte) PayloadTransformer {
+	t.Helper()
+	transformer, err := NewAESGCMTransformer(active, keys)
+	if err != nil {
+		t.Fatalf("NewAESGCMTransformer() error = %v", err)
+	}
+	return transformer
+}
+
+func TestPayloadCodecRoundTrip(t *testing.T) {
+	aes := newTestAES(t, "k1", map[string][]byte{"k1": testAESKey(1)})
+	body := []byte(strings.Repeat(`{"order":"1001"}`, 64))
+
+	tests := []struct {
+		name         string
+		transformers []PayloadTransformer
+		codec        string
+	}{
+		{"gzip", []PayloadTransformer{NewGzipTransformer(0)}, "gzip"},
+		{"zstd", []PayloadTransformer{NewZstdTransformer(0)}, "zstd"},
+		{"aes-gcm", []PayloadTransformer{aes}, "aes-gcm:k1"},
+		{"zstd then aes-gcm", []PayloadTransformer{NewZstdTransformer(0), aes}, "zstd,aes-gcm:k1"},
+		{"below threshold", []PayloadTransformer{NewGzipTransformer(len(body)), aes}, "aes-gcm:k1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec := newPayloadCodec(tt.transformers)
+			payload := &Payload{Name: "task", ID: "id", Payload: body}
+			if err := codec.encode(payload); err != nil {
+				t.Fatalf("encode() error = %v", err)
+			}
+			if payload.Codec != tt.codec {
+				t.Fatalf("Codec = %q, want %q", payload.Codec, tt.codec)
+			}
+			if bytes.Equal(payload.Payload, body) {
+				t.Fatal("encode() left the payload unchanged")
+			}
+			decoded, err := codec.decode(payload)
+			if err != nil || !bytes.Equal(decoded, body) {
+				t.Fatalf("decode() = %q, %v", decoded, err)
+			}
+		})
+	}
+}
+
+func TestPayloadCodecPlaintext(t *testing.T) {
+	// 未配置转换器时投递的明文任务，配置转换器后仍可执行
+	codec := newPayloadCodec([]PayloadTransformer{NewGzipTransformer(0)})
+	payload := &Payload{Name: "task", ID: "id", Payload: []byte(`"plain"`)}
+	decoded, err := codec.decode(payload)
+	if err != nil || string(decoded) != `"plain"` {
+		t.Fatalf("decode() = %q, %v", decoded, err)
+	}
+
+	// 转换标记没有对应的转换器
+	payload.Codec = "brotli"
+	if _, err = codec.decode(payload); !errors.Is(err, ErrUnknownPayloadCodec) {
+		t.Fatalf("decode() error = %v, want ErrUnknownPayloadCodec", err)
+	}
+}
+
+func TestAESGCMKeyRotation(t *testing.T) {
+	before := newPayloadCodec([]PayloadTransformer{newTestAES(t, "k1", map[string][]byte{"k1": testAESKey(1)})})
+	old := &Payload{Name: "task", ID: "old", Payload: []byte(`"old"`)}
+	if err := before.encode(old); err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+
+	// 轮换至k2并保留k1：新任务使用k2加密，k1加密的任务仍可解密
+	after := newPayloadCodec([]PayloadTransformer{newTestAES(t, "k2", map[string][]byte{"k1": testAESKey(1), "k2": testAESKey(2)})})
+	fresh := &Payload{Name: "task", ID: "new", Payload: []byte(`"new"`)}
+	if err := after.encode(fresh); err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+	if fresh.Codec != "aes-gcm:k2" {
+		t.Fatalf("Codec = %q, want aes-gcm:k2", fresh.Codec)
+	}
+	for _, payload := range []*Payload{old, fresh} {
+		if _, err := after.decode(payload); err != nil {
+			t.Fatalf("decode(%s) error = %v", payload.ID, err)
+		}
+	}
+
+	// 移除k1后k1加密的任务无法解密
+	removed := newPayloadCodec([]PayloadTransformer{newTestAES(t, "k2", map[string][]byte{"k2": testAESKey(2)})})
+	if _, err := removed.decode(old); err == nil {
+		t.Fatal("decode() with removed key error = nil")
+	}
+}
+
+func TestAESGCMBindsJob(t *testing.T) {
+	codec := newPayloadCodec([]PayloadTransformer{newTestAES(t, "k1", map[string][]byte{"k1": testAESKey(1)})})
+	payload := &Payload{Name: "refund", ID: "a", Payload: []byte(`{"amount":1}`)}
+	if err := codec.encode(payload); err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+
+	// 密文移入其他任务或其他task后无法解密
+	for _, target := range []Payload{{Name: "refund", ID: "b"}, {Name: "payout", ID: "a"}} {
+		swapped := target
+		swapped.Payload, swapped.Codec = payload.Payload, payload.Codec
+		if _, err := codec.decode(&swapped); err == nil {
+			t.Fatalf("decode() of ciphertext swapped into %s/%s error = nil", target.Name, target.ID)
+		}
+	}
+}
+
+func TestDecompressionLimit(t *testing.T) {
+	bomb := make([]byte, MaxDecodedPayloadSize+1)
+
+	var gz bytes.Buffer
+	writer := gzip.NewWriter(&gz)
+	_, _ = writer.Write(bomb)
+	_ = writer.Close()
+	if _, err := NewGzipTransformer(0).Decode(PayloadIdentity{}, "", gz.Bytes()); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("gzip Decode() error = %v, want ErrPayloadTooLarge", err)
+	}
+
+	encoder, _ := zstd.NewWriter(nil)
+	if _, err := NewZstdTransformer(0).Decode(PayloadIdentity{}, "", encoder.EncodeAll(bomb, nil)); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("zstd Decode() error = %v, want ErrPayloadTooLarge", err)
+	}
+}
@@ -0,0 +1,450 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jjonline/go-lib-backend/queue"
+)
+
+// errStepObsolete job对应的节点已结束、已取消或已被重新投递，丢弃该job
+var errStepObsolete = errors.New("workflow.step.obsolete")
+
+// stepPayload 节点包装task的任务参数
+type stepPayload struct {
+	RunID      string `json:"run_id"`               // 工作流运行实例ID
+	Node       string `json:"node"`                 // 节点名称
+	Token      string `json:"token"`                // 投递令牌
+	Compensate bool   `json:"compensate,omitempty"` // 是否为补偿
+}
+
+// Engine 工作流引擎：注册工作流、启动工作流、查询工作流运行状态
+type Engine struct {
+	queue     *queue.Queue         // 承载节点执行的队列
+	store     Store                // 工作流运行状态存储
+	lock      sync.RWMutex         // 并发锁
+	workflows map[string]*Workflow // 工作流名称与工作流映射map
+	tasks     map[string]*stepTask // 包装task名称与包装task映射map
+}
+
+// NewEngine 创建工作流引擎
+//   - 生产端与消费端均需 Register 相同的工作流
+//   - 消费端需将 Engine.FailedJobHandler 设置为队列的失败任务处理器，节点job被队列判定最终失败时同步节点状态
+func NewEngine(q *queue.Queue, store Store) *Engine {
+	return &Engine{
+		queue:     q,
+		store:     store,
+		workflows: make(map[string]*Workflow),
+		tasks:     make(map[string]*stepTask),
+	}
+}
+
+// Register 注册工作流：为每个节点及其补偿task注册一个包装task至队列，需在队列 Start 之前调用
+func (e *Engine) Register(wf *Workflow) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if _, exist := e.workflows[wf.name]; exist {
+		return fmt.Errorf("workflow %s already registered", wf.name)
+	}
+
+	tasks := make([]queue.TaskIFace, 0, len(wf.order)*2)
+	for _, name := range wf.order {
+		node := wf.nodes[name]
+		tasks = append(tasks, &stepTask{engine: e, workflow: wf, node: node, task: node.Task})
+		if node.Compensation != nil {
+			tasks = append(tasks, &stepTask{engine: e, workflow: wf, node: node, task: node.Compensation, compensate: true})
+		}
+	}
+	if err := e.queue.Bootstrap(tasks); err != nil {
+		return err
+	}
+
+	e.workflows[wf.name] = wf
+	for _, task := range tasks {
+		e.tasks[task.Name()] = task.(*stepTask)
+	}
+	return nil
+}
+
+// Start 启动一个工作流运行实例，返回运行实例ID
+//   - input 工作流输入，JSON编码后各节点通过 Step.BindInput 或 RawBody.Unmarshal 读取
+func (e *Engine) Start(name string, input interface{}) (runID string, err error) {
+	e.lock.RLock()
+	wf, exist := e.workflows[name]
+	e.lock.RUnlock()
+	if !exist {
+		return "", ErrWorkflowNotFound
+	}
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+
+	run, queued := newRun(wf, queue.FakeUniqueID(), data, time.Now())
+	if err = e.store.Create(run); err != nil {
+		return "", err
+	}
+
+	if err = e.dispatch(wf, run, queued, false); err != nil {
+		// 入口节点投递失败：工作流无法推进，直接标记失败
+		_, _ = e.store.Update(run.ID, func(run *Run) error {
+			run.Status = RunFailed
+			run.Error = err.Error()
+			run.UpdatedAt = time.Now()
+			return nil
+		})
+		return run.ID, err
+	}
+	return run.ID, nil
+}
+
+// Status 获取工作流运行状态
+func (e *Engine) Status(runID string) (*Run, error) {
+	return e.store.Get(runID)
+}
+
+// FailedJobHandler 包装队列的失败任务处理器：节点job被队列判定最终失败时
+// （譬如消费进程崩溃后尝试次数超限）标记节点失败并触发补偿，随后调用next
+//   - next 原有的失败任务处理器，可为nil
+//
+// example:
+//
+//	q.SetFailedJobHandler(engine.FailedJobHandler(failedJobHandler))
+func (e *Engine) FailedJobHandler(next queue.FailedJobHandler) queue.FailedJobHandler {
+	return func(payload *queue.Payload, err error) error {
+		e.lock.RLock()
+		task, exist := e.tasks[payload.Name]
+		e.lock.RUnlock()
+
+		if exist {
+			var step stepPayload
+			if payload.RawBody().Unmarshal(&step) == nil {
+				task.giveUp(step, err)
+			}
+		}
+
+		if next != nil {
+			return next(payload, err)
+		}
+		return nil
+	}
+}
+
+// dispatch 投递节点或节点补偿的包装task
+func (e *Engine) dispatch(wf *Workflow, run *Run, nodes []string, compensate bool) error {
+	for _, name := range nodes {
+		state := run.Nodes[name]
+		task := e.taskName(wf.name, name, compensate)
+
+		e.lock.RLock()
+		wrapper := e.tasks[task]
+		e.lock.RUnlock()
+
+		err := e.queue.Dispatch(wrapper, stepPayload{
+			RunID:      run.ID,
+			Node:       name,
+			Token:      state.Token,
+			Compensate: compensate,
+		})
+		if err != nil {
+			return fmt.Errorf("workflow %s node %s dispatch failed: %w", wf.name, name, err)
+		}
+	}
+	return nil
+}
+
+// taskName 获取节点包装task名称
+func (e *Engine) taskName(workflow, node string, compensate bool) string {
+	if compensate {
+		return "workflow:" + workflow + ":" + node + ":compensate"
+	}
+	return "workflow:" + workflow + ":" + node
+}
+
+// region 节点包装task
+
+// stepTask 节点包装task：推进工作流运行状态并执行节点task或补偿task
+//   - 重试次数、重试间隔、超时时长沿用被包装的task
+type stepTask struct {
+	engine     *Engine         // 所属引擎
+	workflow   *Workflow       // 所属工作流
+	node       *Node           // 所属节点
+	task       queue.TaskIFace // 被包装的节点task或补偿task
+	compensate bool            // 是否为补偿
+}
+
+func (t *stepTask) MaxTries() int64 {
+	return t.task.MaxTries()
+}
+
+func (t *stepTask) RetryInterval() int64 {
+	return t.task.RetryInterval()
+}
+
+func (t *stepTask) Timeout() time.Duration {
+	return t.task.Timeout()
+}
+
+func (t *stepTask) Name() string {
+	return t.engine.taskName(t.workflow.name, t.node.Name, t.compensate)
+}
+
+func (t *stepTask) Remark() string {
+	return t.task.Remark()
+}
+
+// Execute 执行节点或节点补偿，执行失败返回的error交由队列按重试设置处理
+func (t *stepTask) Execute(ctx context.Context, job *queue.RawBody) error {
+	var payload stepPayload
+	if err := job.Unmarshal(&payload); err != nil {
+		return err
+	}
+
+	if t.compensate {
+		return t.executeCompensation(ctx, payload)
+	}
+	return t.executeNode(ctx, payload)
+}
+
+// executeNode 执行节点：成功后推进依赖本节点的节点，最终失败后触发补偿
+func (t *stepTask) executeNode(ctx context.Context, payload stepPayload) error {
+	var (
+		step       *Step
+		redispatch []string
+	)
+	run, err := t.engine.store.Update(payload.RunID, func(run *Run) error {
+		step, redispatch = nil, nil
+		state, err := t.state(run, payload)
+		if err != nil {
+			return err
+		}
+
+		switch state.Status {
+		case NodeQueued, NodeRunning:
+			state.Status = NodeRunning
+			state.Attempts++
+			if state.StartedAt == nil {
+				now := time.Now()
+				state.StartedAt = &now
+			}
+			step = newStep(run, t.node.Name, state.Attempts, dependencyOutputs(t.workflow, run, t.node.Name))
+		case NodeSucceeded:
+			// 已执行成功但投递依赖本节点的节点失败后的重试：重新投递仍待执行的节点，令牌更新后旧job将被丢弃
+			if run.Status != RunRunning {
+				return errStepObsolete
+			}
+			for _, dependent := range t.workflow.dependents(t.node.Name) {
+				if dependentState := run.Nodes[dependent]; dependentState.Status == NodeQueued {
+					dependentState.Token = queue.FakeUniqueID()
+					redispatch = append(redispatch, dependent)
+				}
+			}
+		default:
+			return errStepObsolete
+		}
+
+		run.UpdatedAt = time.Now()
+		return nil
+	})
+	if errors.Is(err, errStepObsolete) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if step == nil {
+		return t.engine.dispatch(t.workflow, run, redispatch, false)
+	}
+
+	if err = t.call(ctx, step); err != nil {
+		t.nodeFailed(payload, step.Attempt >= t.task.MaxTries(), err)
+		return err
+	}
+	return t.nodeSucceeded(payload, step.output)
+}
+
+// nodeSucceeded 节点执行成功：记录输出并推进工作流
+//   - 工作流补偿中执行成功的节点若配置了补偿task则投递补偿
+func (t *stepTask) nodeSucceeded(payload stepPayload, output json.RawMessage) error {
+	var queued, compensations []string
+	run, err := t.engine.store.Update(payload.RunID, func(run *Run) error {
+		queued, compensations = nil, nil
+		state, err := t.state(run, payload)
+		if err != nil {
+			return err
+		}
+		if state.Status != NodeRunning {
+			return errStepObsolete
+		}
+
+		now := time.Now()
+		state.Status = NodeSucceeded
+		state.Output = output
+		state.Error = ""
+		state.FinishedAt = &now
+		run.UpdatedAt = now
+
+		if run.Status == RunRunning {
+			queued = advance(t.workflow, run, now)
+		} else if compensate(t.workflow, run, t.node.Name) {
+			compensations = append(compensations, t.node.Name)
+		}
+		settle(run)
+		return nil
+	})
+	if errors.Is(err, errStepObsolete) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(compensations) > 0 {
+		t.dispatchCompensations(run, compensations)
+	}
+	return t.engine.dispatch(t.workflow, run, queued, false)
+}
+
+// nodeFailed 节点执行失败：可重试时等待队列重试，最终失败时标记工作流失败并投递补偿
+func (t *stepTask) nodeFailed(payload stepPayload, final bool, cause error) {
+	var compensations []string
+	run, err := t.engine.store.Update(payload.RunID, func(run *Run) error {
+		compensations = nil
+		state, err := t.state(run, payload)
+		if err != nil {
+			return err
+		}
+		if state.Status != NodeRunning && state.Status != NodeQueued {
+			return errStepObsolete
+		}
+
+		now := time.Now()
+		run.UpdatedAt = now
+		if !final {
+			state.Status = NodeQueued
+			state.Error = cause.Error()
+			return nil
+		}
+		compensations = fail(t.workflow, run, t.node.Name, cause.Error(), now)
+		return nil
+	})
+	if err == nil && len(compensations) > 0 {
+		t.dispatchCompensations(run, compensations)
+	}
+}
+
+// executeCompensation 执行节点补偿
+func (t *stepTask) executeCompensation(ctx context.Context, payload stepPayload) error {
+	var step *Step
+	_, err := t.engine.store.Update(payload.RunID, func(run *Run) error {
+		step = nil
+		state, err := t.state(run, payload)
+		if err != nil {
+			return err
+		}
+		if state.Compensation != CompensationQueued && state.Compensation != CompensationRunning {
+			return errStepObsolete
+		}
+
+		state.Compensation = CompensationRunning
+		state.CompensationAttempts++
+		run.UpdatedAt = time.Now()
+		step = newStep(run, t.node.Name, state.CompensationAttempts, map[string]json.RawMessage{t.node.Name: state.Output})
+		return nil
+	})
+	if errors.Is(err, errStepObsolete) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = t.call(ctx, step)
+	if err != nil && step.Attempt < t.task.MaxTries() {
+		t.compensationFinished(payload, CompensationQueued)
+		return err
+	}
+	if err != nil {
+		t.compensationFinished(payload, CompensationFailed)
+		return err
+	}
+	t.compensationFinished(payload, CompensationSucceeded)
+	return nil
+}
+
+// compensationFinished 更新节点补偿状态，全部补偿结束后工作流置为最终状态
+func (t *stepTask) compensationFinished(payload stepPayload, status string) {
+	_, _ = t.engine.store.Update(payload.RunID, func(run *Run) error {
+		state, err := t.state(run, payload)
+		if err != nil {
+			return err
+		}
+		if state.Compensation != CompensationQueued && state.Compensation != CompensationRunning {
+			return errStepObsolete
+		}
+
+		state.Compensation = status
+		run.UpdatedAt = time.Now()
+		settle(run)
+		return nil
+	})
+}
+
+// dispatchCompensations 投递节点补偿，投递失败的补偿直接标记补偿失败
+func (t *stepTask) dispatchCompensations(run *Run, compensations []string) {
+	for _, name := range compensations {
+		if err := t.engine.dispatch(t.workflow, run, []string{name}, true); err != nil {
+			failed := &stepTask{engine: t.engine, workflow: t.workflow, node: t.workflow.nodes[name], compensate: true}
+			failed.compensationFinished(stepPayload{RunID: run.ID, Node: name, Token: run.Nodes[name].Token, Compensate: true}, CompensationFailed)
+		}
+	}
+}
+
+// giveUp 队列判定节点job最终失败
+func (t *stepTask) giveUp(payload stepPayload, cause error) {
+	if payload.Node != t.node.Name {
+		return
+	}
+	if cause == nil {
+		cause = queue.ErrMaxAttemptsExceeded
+	}
+
+	if t.compensate {
+		t.compensationFinished(payload, CompensationFailed)
+	} else {
+		t.nodeFailed(payload, true, cause)
+	}
+}
+
+// state 获取job对应的节点状态，令牌不一致时job已过时
+func (t *stepTask) state(run *Run, payload stepPayload) (*NodeState, error) {
+	state, exist := run.Nodes[payload.Node]
+	if !exist || payload.Node != t.node.Name || state.Token != payload.Token {
+		return nil, errStepObsolete
+	}
+	return state, nil
+}
+
+// call 执行被包装的task：RawBody 为工作流输入，ctx携带节点执行上下文；
+// panic转换为error，超时后才返回的执行结果视为执行失败
+func (t *stepTask) call(ctx context.Context, step *Step) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("workflow %s node %s panic: %v", t.workflow.name, t.node.Name, r)
+		}
+	}()
+
+	body := queue.MakeRawBody(t.Name(), step.RunID+":"+step.Node, step.Input)
+	if err = t.task.Execute(context.WithValue(ctx, stepContextKey{}, step), body); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// endregion
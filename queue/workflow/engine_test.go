@@ -0,0 +1,225 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/jjonline/go-lib-backend/queue"
+)
+
+// recordTask 记录执行情况的节点task：输出本节点名称，记录读取到的依赖节点输出，仅执行1次
+type recordTask struct {
+	queue.DefaultTaskSetting
+	name  string
+	fail  bool
+	order *[]string
+	lock  *sync.Mutex
+	seen  map[string]json.RawMessage
+}
+
+func (task *recordTask) Name() string         { return task.name }
+func (task *recordTask) Remark() string       { return task.name }
+func (task *recordTask) MaxTries() int64      { return 1 }
+func (task *recordTask) RetryInterval() int64 { return 0 }
+func (task *recordTask) Execute(ctx context.Context, job *queue.RawBody) error {
+	step, ok := StepFromContext(ctx)
+	if !ok {
+		return errors.New("step not found")
+	}
+
+	task.lock.Lock()
+	*task.order = append(*task.order, task.name)
+	task.seen = step.Outputs
+	task.lock.Unlock()
+
+	if task.fail {
+		return errors.New(task.name + " failed")
+	}
+	return step.SetOutput(task.name)
+}
+
+// engineTest 基于 Memory 驱动的工作流测试环境，逐条执行队列中的job
+type engineTest struct {
+	t      *testing.T
+	queue  *queue.Queue
+	engine *Engine
+	lock   sync.Mutex
+	order  []string
+}
+
+func newEngineTest(t *testing.T) *engineTest {
+	q := queue.New(queue.Memory, nil, nopLogger{}, queue.Config{})
+	return &engineTest{t: t, queue: q, engine: NewEngine(q, NewMemoryStore())}
+}
+
+func (et *engineTest) task(name string, fail bool) *recordTask {
+	return &recordTask{name: name, fail: fail, order: &et.order, lock: &et.lock}
+}
+
+// run 注册并启动工作流，执行至队列中无可执行的job，返回工作流最终运行状态
+func (et *engineTest) run(nodes ...Node) *Run {
+	et.t.Helper()
+	wf, err := New("test", nodes...)
+	if err != nil {
+		et.t.Fatalf("New() error = %v", err)
+	}
+	if err = et.engine.Register(wf); err != nil {
+		et.t.Fatalf("Register() error = %v", err)
+	}
+	runID, err := et.engine.Start("test", map[string]string{"order": "1"})
+	if err != nil {
+		et.t.Fatalf("Start() error = %v", err)
+	}
+
+	names := make([]string, 0, len(et.engine.tasks))
+	for name := range et.engine.tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for ran := true; ran; {
+		ran = false
+		for _, name := range names {
+			for et.queue.RunNext(et.engine.tasks[name]) {
+				ran = true
+			}
+		}
+	}
+
+	run, err := et.engine.Status(runID)
+	if err != nil {
+		et.t.Fatalf("Status() error = %v", err)
+	}
+	return run
+}
+
+func (et *engineTest) assertNodes(run *Run, want map[string]string) {
+	et.t.Helper()
+	for name, status := range want {
+		if got := run.Nodes[name].Status; got != status {
+			et.t.Errorf("node %s status = %s, want %s", name, got, status)
+		}
+	}
+}
+
+func (et *engineTest) executed(name string) int {
+	count := 0
+	for _, item := range et.order {
+		if item == name {
+			count++
+		}
+	}
+	return count
+}
+
+func TestEngineFanIn(t *testing.T) {
+	et := newEngineTest(t)
+	join := et.task("join", false)
+	run := et.run(
+		Node{Name: "start", Task: et.task("start", false)},
+		Node{Name: "left", Task: et.task("left", false), DependsOn: []string{"start"}},
+		Node{Name: "right", Task: et.task("right", false), DependsOn: []string{"start"}},
+		Node{Name: "join", Task: join, DependsOn: []string{"left", "right"}},
+	)
+
+	if run.Status != RunSucceeded {
+		t.Fatalf("run status = %s, want %s", run.Status, RunSucceeded)
+	}
+	et.assertNodes(run, map[string]string{"start": NodeSucceeded, "left": NodeSucceeded, "right": NodeSucceeded, "join": NodeSucceeded})
+	if et.executed("join") != 1 || et.order[len(et.order)-1] != "join" {
+		t.Fatalf("execution order = %v, want join once and last", et.order)
+	}
+	if string(join.seen["left"]) != `"left"` || string(join.seen["right"]) != `"right"` {
+		t.Fatalf("join outputs = %v, want left and right outputs", join.seen)
+	}
+}
+
+func TestEngineSkip(t *testing.T) {
+	et := newEngineTest(t)
+	run := et.run(
+		Node{Name: "start", Task: et.task("start", false)},
+		Node{Name: "optional", Task: et.task("optional", false), DependsOn: []string{"start"},
+			Condition: func(step *Step) bool { return false }},
+		Node{Name: "after", Task: et.task("after", false), DependsOn: []string{"optional"}},
+		Node{Name: "merge", Task: et.task("merge", false), DependsOn: []string{"start", "optional"}, Trigger: TriggerNoneFailed},
+	)
+
+	if run.Status != RunSucceeded {
+		t.Fatalf("run status = %s, want %s", run.Status, RunSucceeded)
+	}
+	et.assertNodes(run, map[string]string{"start": NodeSucceeded, "optional": NodeSkipped, "after": NodeSkipped, "merge": NodeSucceeded})
+	if et.executed("optional") != 0 || et.executed("after") != 0 || et.executed("merge") != 1 {
+		t.Fatalf("execution order = %v", et.order)
+	}
+}
+
+func TestEngineCompensation(t *testing.T) {
+	et := newEngineTest(t)
+	undoReserve := et.task("undo_reserve", false)
+	run := et.run(
+		Node{Name: "reserve", Task: et.task("reserve", false), Compensation: undoReserve},
+		Node{Name: "notify", Task: et.task("notify", false), DependsOn: []string{"reserve"}},
+		Node{Name: "pay", Task: et.task("pay", true), DependsOn: []string{"reserve"}, Compensation: et.task("refund", false)},
+		Node{Name: "ship", Task: et.task("ship", false), DependsOn: []string{"pay"}},
+	)
+
+	if run.Status != RunFailed {
+		t.Fatalf("run status = %s, want %s", run.Status, RunFailed)
+	}
+	et.assertNodes(run, map[string]string{"reserve": NodeSucceeded, "pay": NodeFailed, "ship": NodeCancelled})
+	if got := run.Nodes["reserve"].Compensation; got != CompensationSucceeded {
+		t.Fatalf("reserve compensation = %s, want %s", got, CompensationSucceeded)
+	}
+	if got := run.Nodes["pay"].Compensation; got != "" {
+		t.Fatalf("failed node compensation = %s, want none", got)
+	}
+	if et.executed("undo_reserve") != 1 || et.executed("refund") != 0 || et.executed("ship") != 0 {
+		t.Fatalf("execution order = %v", et.order)
+	}
+	if string(undoReserve.seen["reserve"]) != `"reserve"` {
+		t.Fatalf("compensation outputs = %v, want reserve output", undoReserve.seen)
+	}
+}
+
+func TestEngineCompensationFailed(t *testing.T) {
+	et := newEngineTest(t)
+	run := et.run(
+		Node{Name: "reserve", Task: et.task("reserve", false), Compensation: et.task("undo_reserve", true)},
+		Node{Name: "pay", Task: et.task("pay", true), DependsOn: []string{"reserve"}},
+	)
+
+	if run.Status != RunCompensationFailed {
+		t.Fatalf("run status = %s, want %s", run.Status, RunCompensationFailed)
+	}
+	if got := run.Nodes["reserve"].Compensation; got != CompensationFailed {
+		t.Fatalf("reserve compensation = %s, want %s", got, CompensationFailed)
+	}
+}
+
+func TestRedisStoreKey(t *testing.T) {
+	tests := []struct {
+		config RedisStoreConfig
+		want   string
+	}{
+		{RedisStoreConfig{}, "workflow:run:id"},
+		{RedisStoreConfig{KeyPrefix: "prod:"}, "prod:workflow:run:id"},
+		{RedisStoreConfig{KeyPrefix: "prod:", HashTag: true}, "prod:workflow:run:{id}"},
+	}
+	for _, tt := range tests {
+		store := NewRedisStoreWithConfig(nil, tt.config).(*redisStore)
+		if got := store.key("id"); got != tt.want {
+			t.Errorf("key() with %+v = %s, want %s", tt.config, got, tt.want)
+		}
+	}
+}
+
+// nopLogger 不输出任何日志的日志记录器
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
@@ -0,0 +1,290 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jjonline/go-lib-backend/queue"
+)
+
+// 工作流运行状态常量
+const (
+	RunRunning            = "running"             // 运行中
+	RunSucceeded          = "succeeded"           // 全部节点执行成功或被跳过
+	RunCompensating       = "compensating"        // 存在节点最终执行失败，补偿中
+	RunFailed             = "failed"              // 存在节点最终执行失败，补偿已完成或无需补偿
+	RunCompensationFailed = "compensation_failed" // 存在节点最终执行失败，且部分补偿最终执行失败
+)
+
+// 节点状态常量
+const (
+	NodePending   = "pending"   // 等待依赖节点结束
+	NodeQueued    = "queued"    // 已投递至队列等待执行
+	NodeRunning   = "running"   // 执行中
+	NodeSucceeded = "succeeded" // 执行成功
+	NodeSkipped   = "skipped"   // 因执行条件或触发规则被跳过
+	NodeFailed    = "failed"    // 最终执行失败
+	NodeCancelled = "cancelled" // 工作流失败后不再执行
+)
+
+// 节点补偿状态常量，节点无需补偿时为空
+const (
+	CompensationQueued    = "queued"    // 已投递补偿task
+	CompensationRunning   = "running"   // 补偿执行中
+	CompensationSucceeded = "succeeded" // 补偿执行成功
+	CompensationFailed    = "failed"    // 补偿最终执行失败
+)
+
+// Run 工作流运行实例状态
+type Run struct {
+	ID        string                `json:"id"`         // 运行实例ID
+	Workflow  string                `json:"workflow"`   // 工作流名称
+	Status    string                `json:"status"`     // 运行状态
+	Input     json.RawMessage       `json:"input"`      // 工作流输入
+	Nodes     map[string]*NodeState `json:"nodes"`      // 节点名称与节点状态映射map
+	Error     string                `json:"error"`      // 导致工作流失败的错误信息
+	CreatedAt time.Time             `json:"created_at"` // 创建时刻
+	UpdatedAt time.Time             `json:"updated_at"` // 最后更新时刻
+}
+
+// NodeState 节点状态
+type NodeState struct {
+	Status               string          `json:"status"`                          // 节点状态
+	Attempts             int64           `json:"attempts"`                        // 已尝试执行次数
+	Output               json.RawMessage `json:"output,omitempty"`                // 节点输出
+	Error                string          `json:"error,omitempty"`                 // 最近一次执行失败的错误信息
+	Compensation         string          `json:"compensation,omitempty"`          // 补偿状态
+	Token                string          `json:"token,omitempty"`                 // 最近一次投递的令牌，仅令牌一致的job可推进节点状态，用于丢弃重复投递的job
+	CompensationAttempts int64           `json:"compensation_attempts,omitempty"` // 补偿已尝试执行次数
+	StartedAt            *time.Time      `json:"started_at,omitempty"`            // 首次开始执行时刻
+	FinishedAt           *time.Time      `json:"finished_at,omitempty"`           // 结束时刻
+}
+
+// Finished 工作流是否已结束
+func (run *Run) Finished() bool {
+	return run.Status == RunSucceeded || run.Status == RunFailed || run.Status == RunCompensationFailed
+}
+
+// nodeFinished 节点是否已结束
+func nodeFinished(status string) bool {
+	return status == NodeSucceeded || status == NodeSkipped || status == NodeFailed || status == NodeCancelled
+}
+
+// region 节点执行上下文
+
+// stepContextKey 节点执行上下文在context中的key
+type stepContextKey struct{}
+
+// Step 节点执行上下文
+type Step struct {
+	RunID    string                     // 工作流运行实例ID
+	Workflow string                     // 工作流名称
+	Node     string                     // 节点名称
+	Attempt  int64                      // 当前第几次尝试执行，补偿时为补偿的尝试次数
+	Input    json.RawMessage            // 工作流输入
+	Outputs  map[string]json.RawMessage // 已执行成功的依赖节点的输出，补偿时为本节点的输出
+	output   json.RawMessage            // 本节点输出
+}
+
+// StepFromContext 在节点task的 Execute 方法中获取节点执行上下文
+func StepFromContext(ctx context.Context) (*Step, bool) {
+	step, ok := ctx.Value(stepContextKey{}).(*Step)
+	return step, ok
+}
+
+// SetOutput 设置本节点的输出，JSON编码后传递给依赖本节点的节点
+func (step *Step) SetOutput(output interface{}) error {
+	data, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	step.output = data
+	return nil
+}
+
+// BindInput 将工作流输入解码至result
+func (step *Step) BindInput(result interface{}) error {
+	return json.Unmarshal(step.Input, result)
+}
+
+// BindOutput 将指定依赖节点的输出解码至result，依赖节点未执行成功或无输出时返回false
+func (step *Step) BindOutput(node string, result interface{}) (bool, error) {
+	data, exist := step.Outputs[node]
+	if !exist || len(data) == 0 {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, result)
+}
+
+// endregion
+
+// region 状态流转
+
+// newRun 创建工作流运行实例，入口节点状态为queued
+func newRun(wf *Workflow, id string, input json.RawMessage, now time.Time) (*Run, []string) {
+	run := &Run{
+		ID:        id,
+		Workflow:  wf.name,
+		Status:    RunRunning,
+		Input:     input,
+		Nodes:     make(map[string]*NodeState, len(wf.nodes)),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for _, name := range wf.order {
+		run.Nodes[name] = &NodeState{Status: NodePending}
+	}
+	return run, advance(wf, run, now)
+}
+
+// advance 推进工作流：依赖均已结束的pending节点按触发规则与执行条件置为queued或skipped，
+// 全部节点结束时工作流执行成功，返回需投递的节点
+func advance(wf *Workflow, run *Run, now time.Time) (queued []string) {
+	if run.Status != RunRunning {
+		return nil
+	}
+
+	// 拓扑序遍历一次即可传递跳过状态
+	for _, name := range wf.order {
+		state := run.Nodes[name]
+		if state.Status != NodePending {
+			continue
+		}
+
+		var (
+			node    = wf.nodes[name]
+			ready   = true
+			skipped = false
+		)
+		for _, dependency := range node.DependsOn {
+			switch run.Nodes[dependency].Status {
+			case NodeSucceeded:
+			case NodeSkipped:
+				skipped = skipped || node.Trigger == TriggerAllSucceeded
+			default:
+				ready = false
+			}
+		}
+		if !ready {
+			continue
+		}
+
+		if !skipped && node.Condition != nil {
+			skipped = !node.Condition(newStep(run, name, 0, dependencyOutputs(wf, run, name)))
+		}
+		if skipped {
+			state.Status = NodeSkipped
+			state.FinishedAt = &now
+			continue
+		}
+
+		state.Status = NodeQueued
+		state.Token = queue.FakeUniqueID()
+		queued = append(queued, name)
+	}
+
+	for _, state := range run.Nodes {
+		if !nodeFinished(state.Status) {
+			return queued
+		}
+	}
+	run.Status = RunSucceeded
+	return queued
+}
+
+// fail 节点最终执行失败：取消未开始执行的节点，工作流进入补偿状态，返回需投递补偿task的节点
+func fail(wf *Workflow, run *Run, name, errMsg string, now time.Time) (compensations []string) {
+	state := run.Nodes[name]
+	state.Status = NodeFailed
+	state.Error = errMsg
+	state.FinishedAt = &now
+
+	if run.Status != RunRunning {
+		// 补偿中的工作流：仍在执行的节点结束后方可置为最终状态
+		settle(run)
+		return nil
+	}
+	run.Status = RunCompensating
+	run.Error = "node " + name + ": " + errMsg
+
+	// 逆拓扑序投递补偿，后执行的节点先补偿
+	for i := len(wf.order) - 1; i >= 0; i-- {
+		candidate := wf.order[i]
+		candidateState := run.Nodes[candidate]
+		switch candidateState.Status {
+		case NodePending, NodeQueued:
+			candidateState.Status = NodeCancelled
+			candidateState.FinishedAt = &now
+		case NodeSucceeded:
+			if compensate(wf, run, candidate) {
+				compensations = append(compensations, candidate)
+			}
+		}
+	}
+
+	settle(run)
+	return compensations
+}
+
+// compensate 执行成功的节点配置了补偿task时置为待补偿
+func compensate(wf *Workflow, run *Run, name string) bool {
+	if wf.nodes[name].Compensation == nil {
+		return false
+	}
+	state := run.Nodes[name]
+	state.Compensation = CompensationQueued
+	state.Token = queue.FakeUniqueID()
+	return true
+}
+
+// settle 补偿中的工作流在全部节点结束且全部补偿结束后置为最终状态
+func settle(run *Run) {
+	if run.Status != RunCompensating {
+		return
+	}
+
+	compensationFailed := false
+	for _, state := range run.Nodes {
+		if !nodeFinished(state.Status) {
+			return
+		}
+		switch state.Compensation {
+		case CompensationQueued, CompensationRunning:
+			return
+		case CompensationFailed:
+			compensationFailed = true
+		}
+	}
+
+	if compensationFailed {
+		run.Status = RunCompensationFailed
+	} else {
+		run.Status = RunFailed
+	}
+}
+
+// dependencyOutputs 获取节点已执行成功的依赖节点的输出
+func dependencyOutputs(wf *Workflow, run *Run, name string) map[string]json.RawMessage {
+	outputs := make(map[string]json.RawMessage)
+	for _, dependency := range wf.nodes[name].DependsOn {
+		if state := run.Nodes[dependency]; state.Status == NodeSucceeded {
+			outputs[dependency] = state.Output
+		}
+	}
+	return outputs
+}
+
+// newStep 构造节点执行上下文
+func newStep(run *Run, node string, attempt int64, outputs map[string]json.RawMessage) *Step {
+	return &Step{
+		RunID:    run.ID,
+		Workflow: run.Workflow,
+		Node:     node,
+		Attempt:  attempt,
+		Input:    run.Input,
+		Outputs:  outputs,
+	}
+}
+
+// endregion
@@ -0,0 +1,83 @@
+package workflow
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Store 工作流运行状态存储契约
+//   - 多个消费节点并发推进同一工作流，Update 必须是原子的读-改-写
+type Store interface {
+	// Create 创建工作流运行实例
+	Create(run *Run) error
+	// Get 获取工作流运行实例，不存在时返回 ErrRunNotFound
+	Get(id string) (*Run, error)
+	// Update 原子的读取、修改、写回工作流运行实例，fn返回error时放弃修改并原样返回该error
+	Update(id string, fn func(run *Run) error) (*Run, error)
+}
+
+// memoryStore 存储于进程内存的工作流运行状态，仅适用于单进程或测试
+type memoryStore struct {
+	lock sync.Mutex
+	runs map[string][]byte // 工作流运行实例ID与JSON编码的运行状态映射map，编码存储避免调用方修改共享状态
+}
+
+// NewMemoryStore 创建进程内存工作流运行状态存储
+func NewMemoryStore() Store {
+	return &memoryStore{runs: make(map[string][]byte)}
+}
+
+// Create 创建工作流运行实例
+func (s *memoryStore) Create(run *Run) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.runs[run.ID] = data
+	return nil
+}
+
+// Get 获取工作流运行实例
+func (s *memoryStore) Get(id string) (*Run, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.load(id)
+}
+
+// Update 持有锁期间读取、修改、写回工作流运行实例
+func (s *memoryStore) Update(id string, fn func(run *Run) error) (*Run, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	run, err := s.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if err = fn(run); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(run)
+	if err != nil {
+		return nil, err
+	}
+	s.runs[id] = data
+	return run, nil
+}
+
+// load 解码工作流运行实例（需要在持有锁的情况下调用）
+func (s *memoryStore) load(id string) (*Run, error) {
+	data, exist := s.runs[id]
+	if !exist {
+		return nil, ErrRunNotFound
+	}
+
+	run := &Run{}
+	if err := json.Unmarshal(data, run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
@@ -0,0 +1,101 @@
+package workflow
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// mysqlStore 基于MySQL存储的工作流运行状态
+//   - 运行状态JSON编码存储于 queue_workflow_runs 表，Update 在事务中 SELECT ... FOR UPDATE 加行锁
+type mysqlStore struct {
+	connection  *sql.DB // 数据库连接
+	tablePrefix string  // 数据表前缀
+}
+
+// NewMySQLStore 创建基于MySQL的工作流运行状态存储
+//   - tablePrefix 数据表前缀，与队列 Config.TablePrefix 保持一致，表结构参见 stubs/mysql_queue_tables.sql
+func NewMySQLStore(connection *sql.DB, tablePrefix string) Store {
+	return &mysqlStore{connection: connection, tablePrefix: tablePrefix}
+}
+
+// Create 创建工作流运行实例
+func (s *mysqlStore) Create(run *Run) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO ` + s.tableName() + ` (id, workflow, status, state, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err = s.connection.Exec(query, run.ID, run.Workflow, run.Status, data, run.CreatedAt.Unix(), run.UpdatedAt.Unix())
+	return err
+}
+
+// Get 获取工作流运行实例
+func (s *mysqlStore) Get(id string) (*Run, error) {
+	var data []byte
+	if err := s.connection.QueryRow(`SELECT state FROM `+s.tableName()+` WHERE id = ?`, id).Scan(&data); err != nil {
+		return nil, s.notFound(err)
+	}
+	return s.decode(data)
+}
+
+// Update 事务中加行锁读取、修改、写回工作流运行实例
+func (s *mysqlStore) Update(id string, fn func(run *Run) error) (result *Run, err error) {
+	tx, err := s.connection.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var data []byte
+	if err = tx.QueryRow(`SELECT state FROM `+s.tableName()+` WHERE id = ? FOR UPDATE`, id).Scan(&data); err != nil {
+		return nil, s.notFound(err)
+	}
+	run, err := s.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = fn(run); err != nil {
+		return nil, err
+	}
+
+	if data, err = json.Marshal(run); err != nil {
+		return nil, err
+	}
+	query := `UPDATE ` + s.tableName() + ` SET status = ?, state = ?, updated_at = ? WHERE id = ?`
+	if _, err = tx.Exec(query, run.Status, data, time.Now().Unix(), id); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// decode 解码查询到的运行状态
+func (s *mysqlStore) decode(data []byte) (*Run, error) {
+	run := &Run{}
+	if err := json.Unmarshal(data, run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// notFound 查询无结果转换为 ErrRunNotFound
+func (s *mysqlStore) notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRunNotFound
+	}
+	return err
+}
+
+// tableName 获取工作流运行状态表名
+func (s *mysqlStore) tableName() string {
+	return s.tablePrefix + "queue_workflow_runs"
+}
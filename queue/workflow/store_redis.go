@@ -0,0 +1,125 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisStoreMaxRetries 乐观锁冲突时 Update 的最大重试次数
+const redisStoreMaxRetries = 32
+
+// RedisStoreConfig 基于Redis的工作流运行状态存储配置
+type RedisStoreConfig struct {
+	// TTL 运行状态保留时长，每次写入时重新计时，为0表示永不过期
+	TTL time.Duration
+	// KeyPrefix key命名空间前缀，与队列的 queue.Config.KeyPrefix 含义一致，譬如 "prod:"，为空表示不使用前缀
+	KeyPrefix string
+	// HashTag 是否使用hash tag命名key，与队列的 queue.Config.RedisHashTag 含义一致，以运行实例ID为hash tag
+	HashTag bool
+}
+
+// redisStore 基于Redis存储的工作流运行状态
+//   - 运行状态JSON编码存储于String，Update 使用WATCH乐观锁，冲突时重试
+type redisStore struct {
+	connection redis.UniversalClient // redis客户端实例
+	config     RedisStoreConfig      // 存储配置
+}
+
+// NewRedisStore 创建基于Redis的工作流运行状态存储
+//   - connection redis客户端实例，单机、哨兵、集群客户端均可
+//   - ttl 运行状态保留时长，每次写入时重新计时，为0表示永不过期
+func NewRedisStore(connection redis.UniversalClient, ttl time.Duration) Store {
+	return NewRedisStoreWithConfig(connection, RedisStoreConfig{TTL: ttl})
+}
+
+// NewRedisStoreWithConfig 创建指定配置的基于Redis的工作流运行状态存储
+//   - 与队列共用同一Redis时建议使用与队列相同的 KeyPrefix、HashTag 配置
+func NewRedisStoreWithConfig(connection redis.UniversalClient, config RedisStoreConfig) Store {
+	return &redisStore{connection: connection, config: config}
+}
+
+// Create 创建工作流运行实例
+func (s *redisStore) Create(run *Run) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	return s.connection.Set(context.Background(), s.key(run.ID), data, s.config.TTL).Err()
+}
+
+// Get 获取工作流运行实例
+func (s *redisStore) Get(id string) (*Run, error) {
+	return s.load(context.Background(), s.connection, id)
+}
+
+// Update WATCH运行状态后读取、修改，在事务中写回，期间运行状态被修改则重试
+func (s *redisStore) Update(id string, fn func(run *Run) error) (*Run, error) {
+	var (
+		ctx    = context.Background()
+		key    = s.key(id)
+		result *Run
+	)
+
+	txFn := func(tx *redis.Tx) error {
+		run, err := s.load(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err = fn(run); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, s.config.TTL)
+			return nil
+		})
+		if err == nil {
+			result = run
+		}
+		return err
+	}
+
+	for i := 0; i < redisStoreMaxRetries; i++ {
+		err := s.connection.Watch(ctx, txFn, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return result, err
+	}
+	return nil, redis.TxFailedErr
+}
+
+// load 读取并解码工作流运行实例
+func (s *redisStore) load(ctx context.Context, cmd redis.Cmdable, id string) (*Run, error) {
+	data, err := cmd.Get(ctx, s.key(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrRunNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	run := &Run{}
+	if err = json.Unmarshal(data, run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// key 获取工作流运行状态的key
+//   - 设置了命名空间前缀时以前缀开头，譬如：prod:workflow:run:id
+//   - 使用hash tag时以运行实例ID为hash tag，譬如：workflow:run:{id}
+func (s *redisStore) key(id string) string {
+	if s.config.HashTag {
+		return s.config.KeyPrefix + "workflow:run:{" + id + "}"
+	}
+	return s.config.KeyPrefix + "workflow:run:" + id
+}
@@ -0,0 +1,167 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jjonline/go-lib-backend/queue"
+)
+
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// 基于queue队列的工作流（DAG）编排：
+// 一、原理
+//    工作流由多个节点组成有向无环图，每个节点为一个 queue.TaskIFace，节点之间通过 DependsOn 声明依赖
+//    Engine 为每个节点注册一个包装task，节点的每次执行均为队列中的一个job，重试、超时沿用节点task的设置
+// 二、调度
+//    节点的全部依赖结束后按 Trigger 与 Condition 决定执行或跳过：
+//    扇出（fan-out）多个节点依赖同一节点；扇入（fan-in）一个节点依赖多个节点，最后结束的依赖节点负责投递
+// 三、数据
+//    节点执行时通过 StepFromContext 获取工作流输入与依赖节点的输出，通过 Step.SetOutput 设置本节点输出
+//    工作流运行状态持久化于 Store（Redis、MySQL、Memory），每次状态变更均为原子的读-改-写
+// 四、补偿
+//    节点最终执行失败后工作流进入补偿状态，已执行成功的节点若配置了 Compensation 则投递其补偿task
+// ++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// 节点触发规则常量
+const (
+	TriggerAllSucceeded = "all_succeeded" // 默认：依赖节点全部执行成功才执行，任一依赖节点被跳过则跳过
+	TriggerNoneFailed   = "none_failed"   // 依赖节点执行成功或被跳过均可执行，用于条件分支之后的汇合节点
+)
+
+var (
+	// ErrWorkflowNotFound 工作流未注册
+	ErrWorkflowNotFound = errors.New("workflow.not.found")
+	// ErrRunNotFound 工作流运行实例不存在
+	ErrRunNotFound = errors.New("workflow.run.not.found")
+)
+
+// Condition 节点执行条件，返回false时跳过该节点
+//   - step 与节点执行时相同的上下文，可读取工作流输入与依赖节点的输出
+type Condition func(step *Step) bool
+
+// Node 工作流节点
+type Node struct {
+	Name         string          // 节点名称，工作流内唯一，不得包含英文冒号
+	Task         queue.TaskIFace // 节点执行的task，重试次数、重试间隔、超时时长沿用其设置，Name 方法返回值不使用
+	DependsOn    []string        // 依赖的节点名称，为空表示入口节点
+	Trigger      string          // 触发规则，默认：TriggerAllSucceeded
+	Condition    Condition       // 执行条件，为nil表示无条件执行
+	Compensation queue.TaskIFace // 补偿task，工作流失败时对已执行成功的本节点执行，为nil表示无需补偿
+}
+
+// Workflow 工作流定义
+type Workflow struct {
+	name  string           // 工作流名称
+	nodes map[string]*Node // 节点名称与节点映射map
+	order []string         // 节点拓扑排序
+}
+
+// New 创建并校验工作流定义：节点名称唯一、依赖节点存在、无环
+func New(name string, nodes ...Node) (*Workflow, error) {
+	if name == "" || strings.Contains(name, ":") {
+		return nil, fmt.Errorf("workflow name %q invalid", name)
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("workflow %s has no node", name)
+	}
+
+	wf := &Workflow{name: name, nodes: make(map[string]*Node, len(nodes))}
+	for i := range nodes {
+		node := nodes[i]
+		if node.Name == "" || strings.Contains(node.Name, ":") {
+			return nil, fmt.Errorf("workflow %s node name %q invalid", name, node.Name)
+		}
+		if node.Task == nil {
+			return nil, fmt.Errorf("workflow %s node %s has no task", name, node.Name)
+		}
+		if _, exist := wf.nodes[node.Name]; exist {
+			return nil, fmt.Errorf("workflow %s node %s duplicated", name, node.Name)
+		}
+		switch node.Trigger {
+		case "":
+			node.Trigger = TriggerAllSucceeded
+		case TriggerAllSucceeded, TriggerNoneFailed:
+		default:
+			return nil, fmt.Errorf("workflow %s node %s trigger %s invalid", name, node.Name, node.Trigger)
+		}
+		wf.nodes[node.Name] = &node
+	}
+
+	for _, node := range wf.nodes {
+		for _, dependency := range node.DependsOn {
+			if _, exist := wf.nodes[dependency]; !exist {
+				return nil, fmt.Errorf("workflow %s node %s depends on unknown node %s", name, node.Name, dependency)
+			}
+		}
+	}
+
+	order, err := wf.topologicalSort()
+	if err != nil {
+		return nil, err
+	}
+	wf.order = order
+
+	return wf, nil
+}
+
+// Name 工作流名称
+func (wf *Workflow) Name() string {
+	return wf.name
+}
+
+// topologicalSort 按依赖关系对节点拓扑排序，存在环时返回error
+func (wf *Workflow) topologicalSort() ([]string, error) {
+	var (
+		order    = make([]string, 0, len(wf.nodes))
+		visiting = make(map[string]bool, len(wf.nodes))
+		visited  = make(map[string]bool, len(wf.nodes))
+		visit    func(name string) error
+	)
+	visit = func(name string) error {
+		if visited[name] {
+			return nil
+		}
+		if visiting[name] {
+			return fmt.Errorf("workflow %s has a cycle through node %s", wf.name, name)
+		}
+		visiting[name] = true
+		for _, dependency := range wf.nodes[name].DependsOn {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+		visited[name] = true
+		order = append(order, name)
+		return nil
+	}
+
+	// 按声明顺序无关的确定性顺序遍历
+	names := make([]string, 0, len(wf.nodes))
+	for name := range wf.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// dependents 获取直接依赖指定节点的节点名称
+func (wf *Workflow) dependents(name string) []string {
+	var result []string
+	for _, candidate := range wf.order {
+		for _, dependency := range wf.nodes[candidate].DependsOn {
+			if dependency == name {
+				result = append(result, candidate)
+				break
+			}
+		}
+	}
+	return result
+}